@@ -0,0 +1,453 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	kube_util "k8s.io/contrib/cluster-autoscaler/utils/kubernetes"
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/labels"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+// ScanDependencies are the clients, listers and settings used by all scans. They are safe for
+// concurrent use, so a scan abandoned by ScanRunner may keep using them while the next scans run.
+type ScanDependencies struct {
+	kubeClient             *kube_client.Client
+	unschedulablePodLister *kube_util.UnschedulablePodLister
+	scheduledPodLister     *kube_util.ScheduledPodLister
+	nodeLister             *kube_util.ReadyNodeLister
+	nodeSelector           labels.Selector
+	ignoredTaints          map[string]bool
+	nodeGroupDetails       *NodeGroupDetailsEndpoint
+	errorLog               *LogDeduplicator
+	// baseContext is the configuration of the autoscaling context of every Autoscaler. Its trackers
+	// that aren't safe for concurrent use are replaced by new ones in every Autoscaler.
+	baseContext AutoscalingContext
+}
+
+// Autoscaler runs the scans of the cluster. It holds the state carried from one scan to the next.
+// A scan abandoned by ScanRunner may still be running, e.g. waiting for a hanging api call, and
+// modifying the state of its Autoscaler, so the following scans are run by a new Autoscaler.
+type Autoscaler struct {
+	*ScanDependencies
+	// context is the autoscaling context with the trackers of this Autoscaler.
+	context                  AutoscalingContext
+	lastScaleUpTime          time.Time
+	lastScaleDownFailedTrial time.Time
+	lastScaleDownTime        time.Time
+	lastScaleDownFailTime    time.Time
+	lastConsistencyCheckTime time.Time
+	delays                   scaleDownDelays
+	unneededNodes            map[string]time.Time
+	podLocationHints         map[string]string
+	nodeUtilizationMap       map[string]float64
+	usageTracker             *simulator.UsageTracker
+	nodeTemplates            map[string]*kube_api.Node
+	// Trackers updated on every scan. Nil if disabled.
+	nodeGroupGracePeriod        *NodeGroupGracePeriod
+	nodeGroupDivergenceDetector *NodeGroupDivergenceDetector
+	phantomCapacityReconciler   *PhantomCapacityReconciler
+}
+
+// NewAutoscaler builds an Autoscaler with new state, as after a restart. The trackers of the
+// autoscaling context that are safe for concurrent use, e.g. NodeDeletionTracker, are shared with
+// the previous Autoscalers, so nodes deleted by an abandoned scan, and a node still drained in the
+// background, are still known.
+func NewAutoscaler(dependencies *ScanDependencies) *Autoscaler {
+	a := &Autoscaler{
+		ScanDependencies:   dependencies,
+		context:            dependencies.baseContext,
+		unneededNodes:      make(map[string]time.Time),
+		podLocationHints:   make(map[string]string),
+		nodeUtilizationMap: make(map[string]float64),
+		usageTracker:       simulator.NewUsageTracker(),
+		nodeTemplates:      make(map[string]*kube_api.Node),
+		delays: scaleDownDelays{
+			afterAdd:     *scaleDownDelayAfterAdd,
+			afterDelete:  *scaleDownDelayAfterDelete,
+			afterFailure: *scaleDownDelayAfterFailure,
+		},
+	}
+	// The deprecated flag overrides the new one also when it is explicitly set to 0.
+	if isFlagSet("scale-down-delay") {
+		a.delays.afterAdd = *scaleDownDelay
+	}
+	a.context.ScaleUpTracker = NewScaleUpTracker(*maxNodeProvisionTime, *maxWarmNodeProvisionTime, *scaleUpFailureBackoff)
+	a.context.ScaleUpHistory = NewScaleUpHistory()
+	a.context.NodeTemplates = a.nodeTemplates
+	if *nodeDeletionBatching > 0 {
+		a.context.NodeDeletionBatcher = NewNodeDeletionBatcher(*nodeDeletionBatching)
+	}
+	a.lastScaleUpTime = a.context.Now()
+	a.lastScaleDownFailedTrial = a.context.Now()
+	if *newNodeGroupGracePeriod > 0 {
+		a.nodeGroupGracePeriod = NewNodeGroupGracePeriod(*newNodeGroupGracePeriod)
+	}
+	if *maxDivergentScans > 0 {
+		a.nodeGroupDivergenceDetector = NewNodeGroupDivergenceDetector(*maxDivergentScans)
+	}
+	if *phantomCapacityTimeout > 0 {
+		a.phantomCapacityReconciler = NewPhantomCapacityReconciler(*phantomCapacityTimeout)
+	}
+	return a
+}
+
+// RunOnce runs a single scan of the cluster: it checks the node groups and scales the cluster up
+// or down if needed. Scale operations are not started once ctx is done.
+func (a *Autoscaler) RunOnce(ctx context.Context) {
+	autoscalingContext := &a.context
+	cloudProvider := autoscalingContext.CloudProvider
+
+	loopStart := autoscalingContext.Now()
+	mainStart := time.Now()
+	updateLastTime("main")
+
+	// The status is written on every scan, including the ones that stop early.
+	var nodes []*kube_api.Node
+	var allUnschedulablePods []*kube_api.Pod
+	defer func() {
+		// An abandoned scan would overwrite the status written by the scans that followed it.
+		if ctx.Err() == nil {
+			a.writeStatus(loopStart, nodes, len(allUnschedulablePods))
+		}
+	}()
+
+	nodes, err := a.nodeLister.List()
+	if err != nil {
+		a.errorLog.Errorf("Failed to list nodes: %v", err)
+		return
+	}
+	nodes = FilterNodesBySelector(nodes, a.nodeSelector)
+	nodes, unreadyGpuNodes := FilterOutNodesWithUnreadyGpus(nodes, *gpuNodeLabel)
+	for _, node := range unreadyGpuNodes {
+		glog.V(1).Infof("Node %s is treated as unready until its GPUs are allocatable", node.Name)
+	}
+	if len(nodes) == 0 {
+		a.errorLog.Errorf("No nodes in the cluster")
+		return
+	}
+
+	if err := cloudProvider.Refresh(); err != nil {
+		a.errorLog.Errorf("Failed to refresh cloud provider: %v", err)
+	}
+	if err := cloudProvider.RefreshSizes(); err != nil {
+		a.errorLog.Errorf("Failed to refresh node group sizes: %v", err)
+		return
+	}
+
+	// Requested nodes that never registered would keep the node group out of sync forever,
+	// so they have to be given up before the check below.
+	timedOut, err := autoscalingContext.ScaleUpTracker.Update(nodes, cloudProvider, autoscalingContext.Now())
+	if err != nil {
+		a.errorLog.Errorf("Failed to update scale up requests: %v", err)
+	}
+	for id, missing := range timedOut {
+		recordSummaryEvent(autoscalingContext, kube_api.EventTypeWarning, ReasonScaleUpTimedOut,
+			"scale up of group %s failed: %d nodes didn't register in time or failed to launch, target size decreased", id, missing)
+	}
+
+	allUnschedulablePods, err = a.unschedulablePodLister.List()
+	if err != nil {
+		a.errorLog.Errorf("Failed to list unscheduled pods: %v", err)
+		return
+	}
+
+	allScheduled, err := a.scheduledPodLister.List()
+	if err != nil {
+		a.errorLog.Errorf("Failed to list scheduled pods: %v", err)
+		return
+	}
+
+	allNodes, err := a.nodeLister.ListAll()
+	if err != nil {
+		a.errorLog.Errorf("Failed to list all nodes: %v", err)
+		return
+	}
+	allNodes = FilterNodesBySelector(allNodes, a.nodeSelector)
+
+	// Scale up and scale down work on the same view of the cluster, taken once per scan.
+	snapshot := NewClusterSnapshot(nodes, allNodes, allScheduled, allUnschedulablePods, cloudProvider, loopStart)
+	autoscalingContext.ClusterSnapshot = snapshot
+
+	// Unready nodes are registered as well. Nodes deleted by the autoscaler are already subtracted
+	// from the target sizes while they are terminating, so they are not counted.
+	sizes, err := GetNodeGroupSizes(autoscalingContext,
+		autoscalingContext.NodeDeletionTracker.FilterOutNodesBeingDeleted(snapshot.AllNodes))
+	if err != nil {
+		a.errorLog.Errorf("Failed to get node group sizes: %v", err)
+	}
+
+	if *consistencyCheckInterval > 0 && a.lastConsistencyCheckTime.Add(*consistencyCheckInterval).Before(autoscalingContext.Now()) {
+		a.lastConsistencyCheckTime = autoscalingContext.Now()
+		for id, discrepancy := range GetSizeDiscrepancies(sizes) {
+			nodeGroupSizeDiscrepancy.WithLabelValues(id).Set(float64(discrepancy))
+			if discrepancy != 0 {
+				glog.Warningf("Node group %s size discrepancy: %+d instances on the cloud provider side compared to registered nodes",
+					id, discrepancy)
+			}
+		}
+	}
+
+	a.phantomCapacityReconciler.Update(autoscalingContext, sizes, autoscalingContext.Now())
+
+	// Nodes deleted by the autoscaler are already subtracted from the target sizes while they
+	// are terminating, so they are not counted as registered either.
+	unreadyNodeGroups, err := CheckGroupsAndNodes(
+		autoscalingContext.NodeDeletionTracker.FilterOutNodesBeingDeleted(nodes), cloudProvider)
+	if err != nil {
+		a.errorLog.Errorf("Failed to check node groups: %v", err)
+		return
+	}
+	autoscalingContext.UnreadyNodeGroups = unreadyNodeGroups
+	autoscalingContext.NewNodeGroups = a.nodeGroupGracePeriod.Update(cloudProvider, autoscalingContext.Now())
+	autoscalingContext.UnhealthyNodeGroups = a.nodeGroupDivergenceDetector.Update(autoscalingContext, sizes, timedOut)
+
+	if err := UpdateNodeTemplates(nodes, cloudProvider, a.nodeTemplates); err != nil {
+		a.errorLog.Errorf("Failed to update node templates: %v", err)
+	}
+
+	if details, err := BuildNodeGroupDetails(snapshot, cloudProvider, autoscalingContext.UnreadyNodeGroups); err != nil {
+		a.errorLog.Errorf("Failed to build node group details: %v", err)
+	} else {
+		a.nodeGroupDetails.Update(details)
+	}
+
+	autoscalingContext.NodeDeletionTracker.Update(snapshot.AllNodes)
+
+	unmanagedNodes, err := GetUnmanagedNodes(snapshot.AllNodes, cloudProvider)
+	if err != nil {
+		a.errorLog.Errorf("Failed to find unmanaged nodes: %v", err)
+	} else {
+		unmanagedNodesCount.Set(float64(len(unmanagedNodes)))
+		for _, node := range unmanagedNodes {
+			glog.Warningf("Node %s is unmanaged: it doesn't belong to any configured node group", node.Name)
+		}
+	}
+	healthy, unreadyCount := IsClusterHealthy(snapshot.AllNodes, *maxTotalUnreadyPercentage, *okTotalUnreadyCount)
+	unreadyNodesCount.Set(float64(unreadyCount))
+	if !healthy {
+		glog.Warningf("Safety brake engaged: %d of %d nodes are unready, skipping scale up and scale down",
+			unreadyCount, len(snapshot.AllNodes))
+		safetyBrakeEngaged.Set(1)
+		return
+	}
+	safetyBrakeEngaged.Set(0)
+
+	if !autoscalingContext.CircuitBreaker.Allow(autoscalingContext.Now()) {
+		glog.Warningf("Circuit breaker open, skipping scale up and scale down")
+		return
+	}
+
+	// We need to reset all pods that have been marked as unschedulable not after
+	// the newest node became available for the scheduler.
+	allNodesAvailableTime := GetAllNodesAvailableTime(snapshot.Nodes)
+	podsToReset, unschedulablePodsToHelp := SlicePodsByPodScheduledTime(snapshot.UnschedulablePods, allNodesAvailableTime)
+	ResetPodScheduledCondition(a.kubeClient, podsToReset)
+
+	// We need to check whether pods marked as unschedulable are actually unschedulable.
+	// This should prevent from adding unnecessary nodes. Example of such situation:
+	// - CA and Scheduler has slightly different configuration
+	// - Scheduler can't schedule a pod and marks it as unschedulable
+	// - CA added a node which should help the pod
+	// - Scheduler doesn't schedule the pod on the new node
+	//   because according to it logic it doesn't fit there
+	// - CA see the pod is still unschedulable, so it adds another node to help it
+	//
+	// With the check enabled the last point won't happen because CA will ignore a pod
+	// which is supposed to schedule on an existing node.
+	//
+	// Without below check cluster might be unnecessary scaled up to the max allowed size
+	// in the describe situation.
+	schedulablePodsPresent := false
+	if *verifyUnschedulablePods {
+		newUnschedulablePodsToHelp := FilterOutSchedulable(unschedulablePodsToHelp, snapshot.Nodes, snapshot.ScheduledPods, autoscalingContext.PredicateChecker)
+
+		if len(newUnschedulablePodsToHelp) != len(unschedulablePodsToHelp) {
+			glog.V(2).Info("Schedulable pods present")
+			schedulablePodsPresent = true
+		}
+		unschedulablePodsToHelp = newUnschedulablePodsToHelp
+	}
+
+	// The hints are needed by scale down as well, so they are fetched even if scale up is skipped.
+	UpdateMinSizeHints(autoscalingContext)
+	if len(unschedulablePodsToHelp) == 0 && len(autoscalingContext.MinSizeHints) == 0 {
+		glog.V(1).Info("No unschedulable pods")
+	} else if *maxNodesTotal > 0 && len(snapshot.Nodes) >= *maxNodesTotal {
+		glog.V(1).Info("Max total nodes in cluster reached")
+	} else {
+		scaleUpStart := time.Now()
+		updateLastTime("scaleup")
+		nodeInfos, err := GetNodeInfosForGroups(snapshot.Nodes, cloudProvider, a.kubeClient, a.nodeTemplates, a.ignoredTaints)
+		if err != nil {
+			a.errorLog.Errorf("Failed to build node infos for node groups: %v", err)
+			return
+		}
+		if scanAbandoned(ctx) {
+			return
+		}
+		scaledUp, err := ScaleUp(autoscalingContext, unschedulablePodsToHelp, snapshot.Nodes, nodeInfos)
+
+		updateDuration("scaleup", scaleUpStart)
+
+		if err != nil {
+			a.errorLog.Errorf("Failed to scale up: %v", err)
+			return
+		} else {
+			if scaledUp {
+				a.lastScaleUpTime = autoscalingContext.Now()
+				// No scale down in this iteration.
+				return
+			}
+		}
+	}
+
+	if *scaleDownEnabled {
+		unneededStart := time.Now()
+
+		// In dry run only utilization is updated
+		calculateUnneededOnly := scaleDownDelayed(a.delays, a.lastScaleUpTime, a.lastScaleDownTime, a.lastScaleDownFailTime, autoscalingContext.Now()) ||
+			a.lastScaleDownFailedTrial.Add(*scaleDownTrialInterval).After(autoscalingContext.Now()) ||
+			schedulablePodsPresent
+
+		glog.V(4).Infof("Scale down status: unneededOnly=%v a.lastScaleUpTime=%s a.lastScaleDownTime=%s "+
+			"a.lastScaleDownFailTime=%s lastScaleDownFailedTrail=%s schedulablePodsPresent=%v", calculateUnneededOnly,
+			a.lastScaleUpTime, a.lastScaleDownTime, a.lastScaleDownFailTime, a.lastScaleDownFailedTrial, schedulablePodsPresent)
+
+		updateLastTime("findUnneeded")
+		glog.V(4).Infof("Calculating unneeded nodes")
+
+		a.usageTracker.CleanUp(autoscalingContext.Now().Add(-(*scaleDownUnneededTime)))
+		a.unneededNodes, a.podLocationHints, a.nodeUtilizationMap = FindUnneededNodes(
+			autoscalingContext.NodeDeletionTracker.FilterOutNodesBeingDeleted(snapshot.Nodes),
+			a.unneededNodes,
+			*scaleDownUtilizationThreshold,
+			*ignoreDaemonSetsUtilization,
+			snapshot.ScheduledPods,
+			autoscalingContext.PredicateChecker,
+			a.podLocationHints,
+			a.usageTracker, autoscalingContext.Now(), autoscalingContext.ScaleDownReports)
+
+		updateDuration("findUnneeded", unneededStart)
+
+		for key, val := range a.unneededNodes {
+			if glog.V(4) {
+				glog.V(4).Infof("%s is unneeded since %s duration %s", key, val.String(), autoscalingContext.Now().Sub(val).String())
+			}
+		}
+
+		if !calculateUnneededOnly && !scanAbandoned(ctx) {
+			glog.V(4).Infof("Starting scale down")
+
+			scaleDownStart := time.Now()
+			updateLastTime("scaledown")
+
+			result, err := ScaleDown(
+				autoscalingContext,
+				snapshot.Nodes,
+				a.nodeUtilizationMap,
+				a.unneededNodes,
+				snapshot.ScheduledPods,
+				a.podLocationHints,
+				a.usageTracker)
+
+			updateDuration("scaledown", scaleDownStart)
+
+			// TODO: revisit result handling
+			if err != nil {
+				a.errorLog.Errorf("Failed to scale down: %v", err)
+				a.lastScaleDownFailTime = autoscalingContext.Now()
+			} else {
+				if result == ScaleDownError || result == ScaleDownNoNodeDeleted {
+					a.lastScaleDownFailedTrial = autoscalingContext.Now()
+				}
+				if result == ScaleDownError {
+					a.lastScaleDownFailTime = autoscalingContext.Now()
+				}
+				if result == ScaleDownNodeDeleted || result == ScaleDownNodeDeleteStarted {
+					a.lastScaleDownTime = autoscalingContext.Now()
+				}
+			}
+		}
+	}
+	updateDuration("main", mainStart)
+}
+
+// writeStatus writes the status of the scan started at loopStart to the status ConfigMap.
+func (a *Autoscaler) writeStatus(loopStart time.Time, nodes []*kube_api.Node, pendingPods int) {
+	nodeGroupStatuses, err := BuildNodeGroupStatuses(nodes, a.context.CloudProvider, a.context.ScaleActivity)
+	if err != nil {
+		a.errorLog.Errorf("Failed to build node group statuses: %v", err)
+		return
+	}
+	status := &ClusterStatus{
+		LastScanTime:      loopStart,
+		LastScaleUpTime:   a.lastScaleUpTime,
+		LastScaleDownTime: a.lastScaleDownTime,
+		PendingPods:       pendingPods,
+		NodeGroups:        nodeGroupStatuses,
+		EstimationReports: a.context.EstimationReports.Reports(),
+		ScaleDownReasons:  a.context.ScaleDownReports.ReasonCounts(),
+	}
+	if err := WriteStatusConfigMap(a.kubeClient, *statusNamespace, status); err != nil {
+		a.errorLog.Errorf("Failed to write status: %v", err)
+	}
+}
+
+// evaluateScaleUp ranks the expansion options for the currently pending pods without scaling up.
+func (a *Autoscaler) evaluateScaleUp() (ScaleUpEvaluation, error) {
+	now := a.context.Now()
+	cloudProvider := a.context.CloudProvider
+	nodes, err := a.nodeLister.List()
+	if err != nil {
+		return ScaleUpEvaluation{}, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	nodes = FilterNodesBySelector(nodes, a.nodeSelector)
+	nodes, _ = FilterOutNodesWithUnreadyGpus(nodes, *gpuNodeLabel)
+	allNodes, err := a.nodeLister.ListAll()
+	if err != nil {
+		return ScaleUpEvaluation{}, fmt.Errorf("failed to list all nodes: %v", err)
+	}
+	allNodes = FilterNodesBySelector(allNodes, a.nodeSelector)
+	unschedulablePods, err := a.unschedulablePodLister.List()
+	if err != nil {
+		return ScaleUpEvaluation{}, fmt.Errorf("failed to list unscheduled pods: %v", err)
+	}
+	scheduledPods, err := a.scheduledPodLister.List()
+	if err != nil {
+		return ScaleUpEvaluation{}, fmt.Errorf("failed to list scheduled pods: %v", err)
+	}
+	snapshot := NewClusterSnapshot(nodes, allNodes, scheduledPods, unschedulablePods, cloudProvider, now)
+	_, podsToHelp := SlicePodsByPodScheduledTime(snapshot.UnschedulablePods, GetAllNodesAvailableTime(snapshot.Nodes))
+	if *verifyUnschedulablePods {
+		podsToHelp = FilterOutSchedulable(podsToHelp, snapshot.Nodes, snapshot.ScheduledPods, a.context.PredicateChecker)
+	}
+	nodeInfos, err := GetNodeInfosForGroups(snapshot.Nodes, cloudProvider, a.kubeClient, a.nodeTemplates, a.ignoredTaints)
+	if err != nil {
+		return ScaleUpEvaluation{}, fmt.Errorf("failed to build node infos for node groups: %v", err)
+	}
+	evaluationContext := a.context
+	evaluationContext.ClusterSnapshot = snapshot
+	return EvaluateScaleUp(&evaluationContext, podsToHelp, snapshot.Nodes, nodeInfos), nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+// DefaultBalancingIgnoredLabels are node labels that naturally differ between otherwise similar node
+// groups, e.g. node groups of the same instance type in different zones. They are always ignored by
+// NodeGroupsSimilar, in addition to the labels passed with --balancing-ignore-label.
+var DefaultBalancingIgnoredLabels = []string{
+	"kubernetes.io/hostname",
+	"failure-domain.beta.kubernetes.io/zone",
+	"topology.kubernetes.io/zone",
+	"alpha.eksctl.io/instance-id",
+}
+
+// NodeGroupsSimilar returns true if the template nodes of two node groups have the same capacity,
+// allocatable resources and labels, apart from the labels in ignoredLabels.
+func NodeGroupsSimilar(n1, n2 *schedulercache.NodeInfo, ignoredLabels map[string]bool) bool {
+	node1, node2 := n1.Node(), n2.Node()
+	if node1 == nil || node2 == nil {
+		return false
+	}
+	if !resourceListsEqual(node1.Status.Capacity, node2.Status.Capacity) ||
+		!resourceListsEqual(simulator.NodeAllocatable(node1), simulator.NodeAllocatable(node2)) {
+		return false
+	}
+	return labelsEqual(node1.Labels, node2.Labels, ignoredLabels)
+}
+
+func resourceListsEqual(r1, r2 kube_api.ResourceList) bool {
+	if len(r1) != len(r2) {
+		return false
+	}
+	for name, q1 := range r1 {
+		q2, found := r2[name]
+		if !found || q1.Cmp(q2) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func labelsEqual(l1, l2 map[string]string, ignoredLabels map[string]bool) bool {
+	for key, value := range l1 {
+		if ignoredLabels[key] {
+			continue
+		}
+		if other, found := l2[key]; !found || other != value {
+			return false
+		}
+	}
+	for key := range l2 {
+		if ignoredLabels[key] {
+			continue
+		}
+		if _, found := l1[key]; !found {
+			return false
+		}
+	}
+	return true
+}
+
+// similarNodeGroups returns the node groups of the expansion options, other than bestOption, that
+// are similar to the node group of bestOption.
+func similarNodeGroups(context *AutoscalingContext, bestOption *ExpansionOption, options []ExpansionOption,
+	nodeInfos map[string]*schedulercache.NodeInfo) []cloudprovider.NodeGroup {
+	result := make([]cloudprovider.NodeGroup, 0)
+	bestNodeInfo, found := nodeInfos[bestOption.nodeGroup.Id()]
+	if !found {
+		return result
+	}
+	for _, option := range options {
+		id := option.nodeGroup.Id()
+		if id == bestOption.nodeGroup.Id() {
+			continue
+		}
+		nodeInfo, found := nodeInfos[id]
+		if found && NodeGroupsSimilar(bestNodeInfo, nodeInfo, context.BalancingIgnoredLabels) {
+			glog.V(2).Infof("Node group %s is similar to %s", id, bestOption.nodeGroup.Id())
+			result = append(result, option.nodeGroup)
+		}
+	}
+	return result
+}
+
+// balanceScaleUp splits delta new nodes between the node groups, adding every node to the smallest
+// group below its max size. Groups with instances left in their warm pool get nodes first, so that
+// as few new instances as possible have to be launched. Ties go to the earlier group, so a single
+// node group gets all nodes up to its max size. Returns the increase of every node group, keyed by
+// node group id.
+func balanceScaleUp(context *AutoscalingContext, nodeGroups []cloudprovider.NodeGroup, delta int) (map[string]int, error) {
+	sizes := make([]int, len(nodeGroups))
+	warm := make([]int, len(nodeGroups))
+	for i, nodeGroup := range nodeGroups {
+		size, err := targetSize(context, nodeGroup)
+		if err != nil {
+			return nil, err
+		}
+		sizes[i] = size
+		warm[i] = warmPoolSize(nodeGroup)
+	}
+	result := make(map[string]int)
+	for ; delta > 0; delta-- {
+		smallest := -1
+		for i, nodeGroup := range nodeGroups {
+			if sizes[i] >= nodeGroup.MaxSize() {
+				continue
+			}
+			if smallest == -1 || (warm[i] > 0 && warm[smallest] == 0) ||
+				((warm[i] > 0) == (warm[smallest] > 0) && sizes[i] < sizes[smallest]) {
+				smallest = i
+			}
+		}
+		if smallest == -1 {
+			break
+		}
+		sizes[smallest]++
+		if warm[smallest] > 0 {
+			warm[smallest]--
+		}
+		result[nodeGroups[smallest].Id()]++
+	}
+	return result, nil
+}
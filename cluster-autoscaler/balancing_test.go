@@ -0,0 +1,227 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func defaultBalancingIgnoredLabels() map[string]bool {
+	result := make(map[string]bool)
+	for _, key := range DefaultBalancingIgnoredLabels {
+		result[key] = true
+	}
+	return result
+}
+
+func buildBalancingTestNode(name, zone, rack string, cpu int64) *kube_api.Node {
+	node := BuildTestNode(name, cpu, 1000)
+	node.Labels = map[string]string{
+		"kubernetes.io/hostname":                 name,
+		"failure-domain.beta.kubernetes.io/zone": zone,
+		"example.com/rack":                       rack,
+	}
+	return node
+}
+
+func TestNodeGroupsSimilar(t *testing.T) {
+	n1 := buildTestNodeInfo(buildBalancingTestNode("n1", "zone-a", "r1", 1000))
+	n2 := buildTestNodeInfo(buildBalancingTestNode("n2", "zone-b", "r1", 1000))
+	n3 := buildTestNodeInfo(buildBalancingTestNode("n3", "zone-b", "r2", 1000))
+	n4 := buildTestNodeInfo(buildBalancingTestNode("n4", "zone-b", "r1", 2000))
+
+	ignored := defaultBalancingIgnoredLabels()
+	assert.True(t, NodeGroupsSimilar(n1, n2, ignored))
+	assert.False(t, NodeGroupsSimilar(n1, n3, ignored))
+	assert.False(t, NodeGroupsSimilar(n1, n4, ignored))
+	assert.False(t, NodeGroupsSimilar(n1, n2, map[string]bool{}))
+
+	ignored["example.com/rack"] = true
+	assert.True(t, NodeGroupsSimilar(n1, n3, ignored))
+}
+
+func TestScaleUpBalancesSimilarNodeGroups(t *testing.T) {
+	n1 := buildBalancingTestNode("n1", "zone-a", "r1", 1000)
+	n2 := buildBalancingTestNode("n2", "zone-b", "r2", 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+
+	context := &AutoscalingContext{
+		CloudProvider:            provider,
+		PredicateChecker:         simulator.NewTestPredicateChecker(),
+		Recorder:                 kube_record.NewFakeRecorder(20),
+		EstimatorName:            BinpackingEstimatorName,
+		BalanceSimilarNodeGroups: true,
+		BalancingIgnoredLabels:   defaultBalancingIgnoredLabels(),
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+		"ng2": buildTestNodeInfo(n2),
+	}
+	pods := []*kube_api.Pod{
+		BuildTestPod("p1", 800, 0),
+		BuildTestPod("p2", 800, 0),
+		BuildTestPod("p3", 800, 0),
+		BuildTestPod("p4", 800, 0),
+	}
+
+	// The node groups differ by the rack label, so all nodes go to one of them.
+	scaledUp, err := ScaleUp(context, pods, []*kube_api.Node{n1, n2}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 4}, scaledGroups)
+
+	provider.AddNodeGroup("ng3", 1, 10, 1)
+	provider.AddNodeGroup("ng4", 1, 10, 1)
+	n3 := buildBalancingTestNode("n3", "zone-a", "r1", 1000)
+	n4 := buildBalancingTestNode("n4", "zone-b", "r2", 1000)
+	provider.AddNode("ng3", n3)
+	provider.AddNode("ng4", n4)
+	nodeInfos = map[string]*schedulercache.NodeInfo{
+		"ng3": buildTestNodeInfo(n3),
+		"ng4": buildTestNodeInfo(n4),
+	}
+	context.DisabledNodeGroups = map[string]bool{"ng1": true, "ng2": true}
+	context.BalancingIgnoredLabels["example.com/rack"] = true
+	scaledGroups = make(map[string]int)
+
+	recorder := kube_record.NewFakeRecorder(20)
+	context.Recorder = recorder
+	scaledUp, err = ScaleUp(context, pods, []*kube_api.Node{n1, n2, n3, n4}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng3": 2, "ng4": 2}, scaledGroups)
+
+	// Every pod gets one event listing both node groups.
+	assert.Equal(t, len(pods), len(recorder.Events))
+	for range pods {
+		event := <-recorder.Events
+		assert.Contains(t, event, "TriggeredScaleUp")
+		assert.Contains(t, event, "group: ng3, sizes (current/new): 1/3")
+		assert.Contains(t, event, "group: ng4, sizes (current/new): 1/3")
+	}
+}
+
+func TestScaleUpBalancesSimilarNodeGroupsWithinResourceLimits(t *testing.T) {
+	n1 := buildBalancingTestNode("n1", "zone-a", "r1", 1000)
+	n2 := buildBalancingTestNode("n2", "zone-b", "r1", 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+	nodes := []*kube_api.Node{n1, n2}
+
+	// The cores total leaves room for 3 of the 4 needed nodes, split between both node groups.
+	context := &AutoscalingContext{
+		CloudProvider:            provider,
+		PredicateChecker:         simulator.NewTestPredicateChecker(),
+		Recorder:                 kube_record.NewFakeRecorder(20),
+		EstimatorName:            BinpackingEstimatorName,
+		BalanceSimilarNodeGroups: true,
+		BalancingIgnoredLabels:   defaultBalancingIgnoredLabels(),
+		ResourceLimits:           &ResourceLimits{MaxCores: 5},
+		ClusterSnapshot:          NewClusterSnapshot(nodes, nodes, nil, nil, provider, time.Now()),
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+		"ng2": buildTestNodeInfo(n2),
+	}
+	pods := []*kube_api.Pod{
+		BuildTestPod("p1", 800, 0),
+		BuildTestPod("p2", 800, 0),
+		BuildTestPod("p3", 800, 0),
+		BuildTestPod("p4", 800, 0),
+	}
+
+	scaledUp, err := ScaleUp(context, pods, nodes, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, 3, scaledGroups["ng1"]+scaledGroups["ng2"])
+	assert.True(t, scaledGroups["ng1"] >= 1 && scaledGroups["ng2"] >= 1, "%v", scaledGroups)
+}
+
+func TestBalanceScaleUp(t *testing.T) {
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 5)
+	provider.AddNodeGroup("ng2", 1, 4, 1)
+	provider.AddNodeGroup("ng3", 1, 10, 2)
+	context := &AutoscalingContext{CloudProvider: provider}
+	nodeGroups := make(map[string]cloudprovider.NodeGroup)
+	for _, nodeGroup := range provider.NodeGroups() {
+		nodeGroups[nodeGroup.Id()] = nodeGroup
+	}
+
+	increases, err := balanceScaleUp(context,
+		[]cloudprovider.NodeGroup{nodeGroups["ng1"], nodeGroups["ng2"], nodeGroups["ng3"]}, 7)
+	assert.NoError(t, err)
+	// ng2 is capped at its max size.
+	assert.Equal(t, map[string]int{"ng1": 1, "ng2": 3, "ng3": 3}, increases)
+}
+
+func TestBalanceScaleUpWithWarmPool(t *testing.T) {
+	testProvider := test.NewTestCloudProvider(nil, nil)
+	testProvider.AddNodeGroup("ng1", 1, 10, 2)
+	testProvider.AddNodeGroup("ng2", 1, 10, 3)
+	testProvider.AddNodeGroup("ng3", 1, 10, 2)
+	warm := map[string]int{"ng2": 2, "ng3": 1}
+	provider := &warmPoolCloudProvider{TestCloudProvider: testProvider, warm: warm}
+	context := &AutoscalingContext{CloudProvider: provider}
+	nodeGroups := make(map[string]cloudprovider.NodeGroup)
+	for _, nodeGroup := range provider.NodeGroups() {
+		nodeGroups[nodeGroup.Id()] = nodeGroup
+	}
+
+	increases, err := balanceScaleUp(context,
+		[]cloudprovider.NodeGroup{nodeGroups["ng1"], nodeGroups["ng2"], nodeGroups["ng3"]}, 4)
+	assert.NoError(t, err)
+	// Without warm pools ng1 would get 2 nodes, ng2 and ng3 one each, launching 2 new instances.
+	// Warmed instances are used up first, so only a single new instance is launched.
+	assert.Equal(t, map[string]int{"ng1": 1, "ng2": 2, "ng3": 1}, increases)
+	launched := 0
+	for id, increase := range increases {
+		if increase > warm[id] {
+			launched += increase - warm[id]
+		}
+	}
+	assert.Equal(t, 1, launched)
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// CircuitBreakerState is the state of CircuitBreaker.
+type CircuitBreakerState int
+
+const (
+	// CircuitBreakerClosed - scale operations are issued as usual.
+	CircuitBreakerClosed CircuitBreakerState = iota
+	// CircuitBreakerOpen - scale operations are not issued until the cooldown passes.
+	CircuitBreakerOpen CircuitBreakerState = iota
+	// CircuitBreakerHalfOpen - the cooldown passed and scale operations are issued to test whether
+	// the cloud provider recovered. The next failure opens the breaker again.
+	CircuitBreakerHalfOpen CircuitBreakerState = iota
+)
+
+func (state CircuitBreakerState) String() string {
+	switch state {
+	case CircuitBreakerClosed:
+		return "closed"
+	case CircuitBreakerOpen:
+		return "open"
+	case CircuitBreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("unknown(%d)", int(state))
+}
+
+// CircuitBreaker stops scaling after maxFailures consecutive failed scale operations on the cloud
+// provider, so that a consistently failing cloud api (bad credentials, outage) isn't called on every
+// scan. All methods are safe to call on a nil CircuitBreaker, which never opens.
+type CircuitBreaker struct {
+	maxFailures int
+	cooldown    time.Duration
+
+	mutex    sync.Mutex
+	state    CircuitBreakerState
+	failures int
+	openedAt time.Time
+}
+
+// NewCircuitBreaker builds new CircuitBreaker.
+func NewCircuitBreaker(maxFailures int, cooldown time.Duration) *CircuitBreaker {
+	circuitBreakerState.Set(float64(CircuitBreakerClosed))
+	return &CircuitBreaker{
+		maxFailures: maxFailures,
+		cooldown:    cooldown,
+	}
+}
+
+// Allow returns true if scale operations may be issued at the given time. An open breaker
+// half-opens once the cooldown passes.
+func (breaker *CircuitBreaker) Allow(now time.Time) bool {
+	if breaker == nil {
+		return true
+	}
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	if breaker.state == CircuitBreakerOpen {
+		if now.Before(breaker.openedAt.Add(breaker.cooldown)) {
+			return false
+		}
+		glog.Infof("Circuit breaker half-open, testing whether the cloud provider recovered")
+		breaker.setState(CircuitBreakerHalfOpen)
+	}
+	return true
+}
+
+// RecordSuccess records a successful scale operation, which closes the breaker.
+func (breaker *CircuitBreaker) RecordSuccess() {
+	if breaker == nil {
+		return
+	}
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	if breaker.state != CircuitBreakerClosed {
+		glog.Infof("Circuit breaker closed")
+	}
+	breaker.failures = 0
+	breaker.setState(CircuitBreakerClosed)
+}
+
+// RecordFailure records a failed scale operation. The breaker opens after maxFailures consecutive
+// failures or after any failure while half-open.
+func (breaker *CircuitBreaker) RecordFailure(now time.Time) {
+	if breaker == nil {
+		return
+	}
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	breaker.failures++
+	if breaker.state == CircuitBreakerHalfOpen || breaker.failures >= breaker.maxFailures {
+		if breaker.state != CircuitBreakerOpen {
+			glog.Warningf("Circuit breaker open after %d consecutive failed scale operations, scaling is stopped for %v",
+				breaker.failures, breaker.cooldown)
+		}
+		breaker.openedAt = now
+		breaker.setState(CircuitBreakerOpen)
+	}
+}
+
+// RecordResult records the result of a scale operation, err being nil on success.
+func (breaker *CircuitBreaker) RecordResult(err error, now time.Time) {
+	if err == nil {
+		breaker.RecordSuccess()
+	} else {
+		breaker.RecordFailure(now)
+	}
+}
+
+// State returns the current state of the breaker.
+func (breaker *CircuitBreaker) State() CircuitBreakerState {
+	if breaker == nil {
+		return CircuitBreakerClosed
+	}
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+	return breaker.state
+}
+
+// ServeHTTP serves the health check. It responds with 503 while the breaker is open.
+func (breaker *CircuitBreaker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	state := breaker.State()
+	if state == CircuitBreakerOpen {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	fmt.Fprintf(w, "circuit breaker: %s\n", state)
+}
+
+func (breaker *CircuitBreaker) setState(state CircuitBreakerState) {
+	breaker.state = state
+	circuitBreakerState.Set(float64(state))
+}
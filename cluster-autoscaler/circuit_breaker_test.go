@@ -0,0 +1,78 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(3, time.Minute)
+	assert.True(t, breaker.Allow(now))
+
+	breaker.RecordFailure(now)
+	breaker.RecordFailure(now)
+	breaker.RecordSuccess()
+	breaker.RecordFailure(now)
+	breaker.RecordFailure(now)
+	assert.Equal(t, CircuitBreakerClosed, breaker.State())
+	assert.True(t, breaker.Allow(now))
+
+	breaker.RecordResult(fmt.Errorf("cloud failure"), now)
+	assert.Equal(t, CircuitBreakerOpen, breaker.State())
+	assert.False(t, breaker.Allow(now.Add(30*time.Second)))
+
+	// A failure while half-open opens the breaker for another cooldown.
+	assert.True(t, breaker.Allow(now.Add(time.Minute)))
+	assert.Equal(t, CircuitBreakerHalfOpen, breaker.State())
+	breaker.RecordFailure(now.Add(time.Minute))
+	assert.Equal(t, CircuitBreakerOpen, breaker.State())
+	assert.False(t, breaker.Allow(now.Add(90*time.Second)))
+
+	assert.True(t, breaker.Allow(now.Add(2*time.Minute)))
+	breaker.RecordResult(nil, now.Add(2*time.Minute))
+	assert.Equal(t, CircuitBreakerClosed, breaker.State())
+	breaker.RecordFailure(now.Add(2 * time.Minute))
+	assert.True(t, breaker.Allow(now.Add(2*time.Minute)))
+}
+
+func TestCircuitBreakerHealthCheck(t *testing.T) {
+	breaker := NewCircuitBreaker(1, time.Minute)
+	recorder := httptest.NewRecorder()
+	breaker.ServeHTTP(recorder, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	breaker.RecordFailure(time.Now())
+	recorder = httptest.NewRecorder()
+	breaker.ServeHTTP(recorder, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "open")
+
+	var disabled *CircuitBreaker
+	disabled.RecordFailure(time.Now())
+	assert.True(t, disabled.Allow(time.Now()))
+	recorder = httptest.NewRecorder()
+	disabled.ServeHTTP(recorder, nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
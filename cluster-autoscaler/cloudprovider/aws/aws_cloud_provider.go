@@ -21,11 +21,19 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
 	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+
+	"github.com/golang/glog"
 )
 
+// resourceEphemeralStorage is the name of the local ephemeral storage resource, which the vendored
+// api predates.
+const resourceEphemeralStorage kube_api.ResourceName = "ephemeral-storage"
+
 // AwsCloudProvider implements CloudProvider interface.
 type AwsCloudProvider struct {
 	awsManager *AwsManager
@@ -43,6 +51,9 @@ func BuildAwsCloudProvider(awsManager *AwsManager, specs []string) (*AwsCloudPro
 			return nil, err
 		}
 	}
+	if err := cloudprovider.CheckDuplicateNodeGroups(aws.NodeGroups()); err != nil {
+		return nil, err
+	}
 	return aws, nil
 }
 
@@ -63,17 +74,27 @@ func (aws *AwsCloudProvider) Name() string {
 	return "aws"
 }
 
-// NodeGroups returns all node groups configured for this cloud provider.
+// NodeGroups returns all node groups configured for this cloud provider, followed by the
+// ASGs discovered by tags.
 func (aws *AwsCloudProvider) NodeGroups() []cloudprovider.NodeGroup {
 	result := make([]cloudprovider.NodeGroup, 0, len(aws.asgs))
 	for _, asg := range aws.asgs {
 		result = append(result, asg)
 	}
+	for _, asg := range aws.awsManager.DiscoveredAsgs() {
+		result = append(result, asg)
+	}
 	return result
 }
 
 // NodeGroupForNode returns the node group for the given node.
 func (aws *AwsCloudProvider) NodeGroupForNode(node *kube_api.Node) (cloudprovider.NodeGroup, error) {
+	if node.Spec.ProviderID == "" {
+		// ProviderID is set by the cloud controller shortly after the node registers. Until then
+		// the node cannot be matched with a node group, so it is ignored.
+		glog.V(2).Infof("Node %s has no ProviderID yet, skipping", node.Name)
+		return nil, nil
+	}
 	ref, err := AwsRefFromProviderId(node.Spec.ProviderID)
 	if err != nil {
 		return nil, err
@@ -82,6 +103,50 @@ func (aws *AwsCloudProvider) NodeGroupForNode(node *kube_api.Node) (cloudprovide
 	return asg, err
 }
 
+// NodeGroupsForNodes returns the node groups of the given nodes, keyed by node name.
+func (aws *AwsCloudProvider) NodeGroupsForNodes(nodes []*kube_api.Node) (map[string]cloudprovider.NodeGroup, error) {
+	refs := make(map[string]*AwsRef)
+	instances := make([]*AwsRef, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Spec.ProviderID == "" {
+			glog.V(2).Infof("Node %s has no ProviderID yet, skipping", node.Name)
+			continue
+		}
+		ref, err := AwsRefFromProviderId(node.Spec.ProviderID)
+		if err != nil {
+			return nil, err
+		}
+		refs[node.Name] = ref
+		instances = append(instances, ref)
+	}
+	asgs, err := aws.awsManager.GetAsgsForInstances(instances)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]cloudprovider.NodeGroup)
+	for name, ref := range refs {
+		if asg, found := asgs[*ref]; found {
+			result[name] = asg
+		}
+	}
+	return result, nil
+}
+
+// Refresh regenerates the cache of ASG instances if it is due.
+func (aws *AwsCloudProvider) Refresh() error {
+	return aws.awsManager.Refresh()
+}
+
+// Cleanup stops the discovery of ASGs.
+func (aws *AwsCloudProvider) Cleanup() error {
+	return aws.awsManager.Cleanup()
+}
+
+// RefreshSizes fetches the sizes of all ASGs in bulk and caches them until the next refresh.
+func (aws *AwsCloudProvider) RefreshSizes() error {
+	return aws.awsManager.RefreshSizes()
+}
+
 // AwsRef contains a reference to some entity in AWS/GKE world.
 type AwsRef struct {
 	Name string
@@ -112,12 +177,37 @@ type Asg struct {
 
 // MaxSize returns maximum size of the node group.
 func (asg *Asg) MaxSize() int {
-	return asg.maxSize
+	_, maxSize := asg.bounds()
+	return maxSize
 }
 
 // MinSize returns minimum size of the node group.
 func (asg *Asg) MinSize() int {
-	return asg.minSize
+	minSize, _ := asg.bounds()
+	return minSize
+}
+
+// bounds returns the configured min and max size of the Asg, clamped to its bounds in AWS if they
+// are reconciled.
+func (asg *Asg) bounds() (int, int) {
+	if asg.awsManager == nil {
+		return asg.minSize, asg.maxSize
+	}
+	cloudMin, cloudMax, found := asg.awsManager.GetAsgBounds(asg)
+	if !found {
+		return asg.minSize, asg.maxSize
+	}
+	return clamp(asg.minSize, cloudMin, cloudMax), clamp(asg.maxSize, cloudMin, cloudMax)
+}
+
+func clamp(value, low, high int) int {
+	if value < low {
+		return low
+	}
+	if value > high {
+		return high
+	}
+	return value
 }
 
 // TargetSize returns the current TARGET size of the node group. It is possible that the
@@ -132,6 +222,9 @@ func (asg *Asg) IncreaseSize(delta int) error {
 	if delta <= 0 {
 		return fmt.Errorf("size increase must be positive")
 	}
+	if err := asg.checkProcessNotSuspended(launchProcess); err != nil {
+		return err
+	}
 	size, err := asg.awsManager.GetAsgSize(asg)
 	if err != nil {
 		return err
@@ -142,6 +235,28 @@ func (asg *Asg) IncreaseSize(delta int) error {
 	return asg.awsManager.SetAsgSize(asg, size+int64(delta))
 }
 
+// DecreaseTargetSize decreases the target size of the ASG. It fails instead of decreasing the size
+// below the number of instances of the ASG, as AWS would terminate some of them. Delta should be
+// negative.
+func (asg *Asg) DecreaseTargetSize(delta int) error {
+	if delta >= 0 {
+		return fmt.Errorf("size decrease must be negative")
+	}
+	size, err := asg.awsManager.GetAsgSize(asg)
+	if err != nil {
+		return err
+	}
+	instances, err := asg.awsManager.GetAsgInstanceCount(asg)
+	if err != nil {
+		return err
+	}
+	if int(size)+delta < instances {
+		return fmt.Errorf("attempt to delete existing nodes targetSize:%d delta:%d existingNodes: %d",
+			size, delta, instances)
+	}
+	return asg.awsManager.SetAsgSize(asg, size+int64(delta))
+}
+
 // Belongs returns true if the given node belongs to the NodeGroup.
 func (asg *Asg) Belongs(node *kube_api.Node) (bool, error) {
 	ref, err := AwsRefFromProviderId(node.Spec.ProviderID)
@@ -161,8 +276,16 @@ func (asg *Asg) Belongs(node *kube_api.Node) (bool, error) {
 	return true, nil
 }
 
-// DeleteNodes deletes the nodes from the group.
+// DeleteNodes deletes the nodes from the group. If any of the nodes doesn't belong to the group,
+// none of them is deleted.
 func (asg *Asg) DeleteNodes(nodes []*kube_api.Node) error {
+	if err := asg.checkProcessNotSuspended(terminateProcess); err != nil {
+		return err
+	}
+	refs, err := asg.instancesToTerminate(nodes)
+	if err != nil {
+		return err
+	}
 	size, err := asg.awsManager.GetAsgSize(asg)
 	if err != nil {
 		return err
@@ -170,22 +293,105 @@ func (asg *Asg) DeleteNodes(nodes []*kube_api.Node) error {
 	if int(size) <= asg.MinSize() {
 		return fmt.Errorf("min size reached, nodes will not be deleted")
 	}
+	return asg.awsManager.DeleteInstances(refs)
+}
+
+// instancesToTerminate returns the instances of the nodes that still have to be terminated, or an
+// error if any of the nodes doesn't belong to the Asg.
+func (asg *Asg) instancesToTerminate(nodes []*kube_api.Node) ([]*AwsRef, error) {
 	refs := make([]*AwsRef, 0, len(nodes))
 	for _, node := range nodes {
-		belongs, err := asg.Belongs(node)
+		awsref, err := AwsRefFromProviderId(node.Spec.ProviderID)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if belongs != true {
-			return fmt.Errorf("%s belongs to a different asg than %s", node.Name, asg.Id())
+		if asg.awsManager.IsTerminatedExternally(awsref) {
+			// The ASG already replaced the instance, terminating it again would decrement the
+			// desired capacity twice.
+			glog.V(1).Infof("Instance of %s was already terminated outside of the autoscaler, skipping", node.Name)
+			continue
 		}
-		awsref, err := AwsRefFromProviderId(node.Spec.ProviderID)
+		targetAsg, err := asg.awsManager.GetAsgForInstance(awsref)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if targetAsg == nil {
+			return nil, fmt.Errorf("cannot delete %s from asg %s: instance %s doesn't belong to a known asg",
+				node.Name, asg.Id(), awsref.Name)
+		}
+		if targetAsg.Id() != asg.Id() {
+			return nil, fmt.Errorf("cannot delete %s from asg %s: instance %s belongs to asg %s",
+				node.Name, asg.Id(), awsref.Name, targetAsg.Id())
 		}
 		refs = append(refs, awsref)
 	}
-	return asg.awsManager.DeleteInstances(refs)
+	return refs, nil
+}
+
+// checkProcessNotSuspended returns an error if the given process is suspended in the Asg, in which
+// case the Asg is not scalable in that direction.
+func (asg *Asg) checkProcessNotSuspended(process string) error {
+	if asg.awsManager.IsProcessSuspended(asg, process) {
+		glog.Warningf("Asg %s has the %s process suspended, it won't be scaled", asg.Id(), process)
+		return fmt.Errorf("asg %s is not scalable: %s process is suspended", asg.Id(), process)
+	}
+	return nil
+}
+
+// Priority returns the priority of the Asg set with PriorityTag, if any.
+func (asg *Asg) Priority() (int, bool) {
+	return asg.awsManager.GetAsgPriority(asg)
+}
+
+// TemplateLabels returns the OS and architecture labels of the Asg instances, derived from their
+// instance template, and the labels declared with TemplateLabelTagPrefix tags of the Asg, which
+// take precedence.
+func (asg *Asg) TemplateLabels() map[string]string {
+	platform := asg.awsManager.GetAsgPlatformLabels(asg)
+	declared := asg.awsManager.GetAsgTemplateLabels(asg)
+	labels := make(map[string]string, len(platform)+len(declared))
+	for key, value := range platform {
+		labels[key] = value
+	}
+	for key, value := range declared {
+		labels[key] = value
+	}
+	return labels
+}
+
+// TemplateCapacity returns the CPU and memory of the Asg instances, derived from their instance type,
+// their ephemeral storage, derived from their root volume, and their max pods, set with kubelet
+// --max-pods in their user data.
+func (asg *Asg) TemplateCapacity() kube_api.ResourceList {
+	capacity := kube_api.ResourceList{}
+	if cpu, memory := asg.awsManager.GetAsgInstanceResources(asg); cpu > 0 {
+		capacity[kube_api.ResourceCPU] = *resource.NewQuantity(cpu, resource.DecimalSI)
+		capacity[kube_api.ResourceMemory] = *resource.NewQuantity(memory, resource.BinarySI)
+	}
+	if storage := asg.awsManager.GetAsgEphemeralStorage(asg); storage > 0 {
+		capacity[resourceEphemeralStorage] = *resource.NewQuantity(storage, resource.BinarySI)
+	}
+	if maxPods := asg.awsManager.GetAsgMaxPods(asg); maxPods > 0 {
+		capacity[kube_api.ResourcePods] = *resource.NewQuantity(maxPods, resource.DecimalSI)
+	}
+	return capacity
+}
+
+// TemplateTaints returns the taints declared with TemplateTaintTagPrefix tags of the Asg.
+func (asg *Asg) TemplateTaints() []kube_api.Taint {
+	return asg.awsManager.GetAsgTemplateTaints(asg)
+}
+
+// WarmPoolSize returns the number of warmed instances in the warm pool of the Asg. Their number
+// doesn't count towards the desired capacity, the Asg takes them first when the capacity grows.
+func (asg *Asg) WarmPoolSize() int {
+	return int(asg.awsManager.GetAsgWarmPoolSize(asg))
+}
+
+// ScaleUpFailure returns the reason why instances requested since the given time failed to launch,
+// taken from the failed scaling activities of the Asg, or an empty string if none failed.
+func (asg *Asg) ScaleUpFailure(since time.Time) (string, error) {
+	return asg.awsManager.GetAsgScaleUpFailure(asg, since)
 }
 
 // Id returns asg id.
@@ -208,8 +414,8 @@ func buildAsg(value string, awsManager *AwsManager) (*Asg, error) {
 		awsManager: awsManager,
 	}
 	if size, err := strconv.Atoi(tokens[0]); err == nil {
-		if size <= 0 {
-			return nil, fmt.Errorf("min size must be >= 1")
+		if size < 0 {
+			return nil, fmt.Errorf("min size must be >= 0")
 		}
 		asg.minSize = size
 	} else {
@@ -226,7 +432,7 @@ func buildAsg(value string, awsManager *AwsManager) (*Asg, error) {
 	}
 
 	if tokens[2] == "" {
-		return nil, fmt.Errorf("asg name must not be blank: %s got error: %v", tokens[2])
+		return nil, fmt.Errorf("asg name must not be blank: %s", value)
 	}
 
 	asg.Name = tokens[2]
@@ -17,7 +17,10 @@ limitations under the License.
 package aws
 
 import (
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
@@ -28,23 +31,100 @@ import (
 
 type AutoScalingMock struct {
 	mock.Mock
+	// describeMutex guards the describe call counters, ASGs are described concurrently.
+	describeMutex       sync.Mutex
+	describeCalls       int
+	describeInFlight    int
+	maxDescribeInFlight int
+	// describeDelay is how long each DescribeAutoScalingGroups call takes.
+	describeDelay time.Duration
+	// failingAsgs are the ASGs whose DescribeAutoScalingGroups calls fail.
+	failingAsgs        map[string]bool
+	suspendedProcesses map[string][]string
+	// instanceIds, if set, replaces the default instances of described ASGs.
+	instanceIds []string
+	// asgInstanceIds, if set, replaces the instances of the given ASGs.
+	asgInstanceIds map[string][]string
+	// tags holds the tags of each ASG, returned by DescribeTags and DescribeAutoScalingGroups.
+	tags map[string]map[string]string
+	// warmPools holds the lifecycle states of the warm pool instances of each ASG with a warm pool.
+	warmPools     map[string][]string
+	warmPoolCalls int
+	// maxSizes, if set, replaces the default max size of the given ASGs.
+	maxSizes map[string]int64
+	// desiredCapacities, if set, replaces the default desired capacity of the given ASGs.
+	desiredCapacities map[string]int64
+	// activities holds the scaling activities of each ASG, the most recent first.
+	activities map[string][]*autoscaling.Activity
 }
 
 func (a *AutoScalingMock) DescribeAutoScalingGroups(i *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
-	return &autoscaling.DescribeAutoScalingGroupsOutput{
-		AutoScalingGroups: []*autoscaling.Group{
+	a.describeMutex.Lock()
+	a.describeCalls++
+	a.describeInFlight++
+	if a.describeInFlight > a.maxDescribeInFlight {
+		a.maxDescribeInFlight = a.describeInFlight
+	}
+	a.describeMutex.Unlock()
+	time.Sleep(a.describeDelay)
+	defer func() {
+		a.describeMutex.Lock()
+		a.describeInFlight--
+		a.describeMutex.Unlock()
+	}()
+	for _, name := range i.AutoScalingGroupNames {
+		if a.failingAsgs[*name] {
+			return nil, fmt.Errorf("failed to describe %s", *name)
+		}
+	}
+	groups := make([]*autoscaling.Group, 0, len(i.AutoScalingGroupNames))
+	for _, name := range i.AutoScalingGroupNames {
+		suspended := make([]*autoscaling.SuspendedProcess, 0)
+		for _, process := range a.suspendedProcesses[*name] {
+			suspended = append(suspended, &autoscaling.SuspendedProcess{ProcessName: aws.String(process)})
+		}
+		instances := []*autoscaling.Instance{
 			{
-				DesiredCapacity: aws.Int64(2),
-				Instances: []*autoscaling.Instance{
-					{
-						InstanceId: aws.String("test-instance-id"),
-					},
-					{
-						InstanceId: aws.String("second-test-instance-id"),
-					},
-				},
+				InstanceId: aws.String("test-instance-id"),
 			},
-		},
+			{
+				InstanceId: aws.String("second-test-instance-id"),
+			},
+		}
+		instanceIds := a.instanceIds
+		if ids, found := a.asgInstanceIds[*name]; found {
+			instanceIds = ids
+		}
+		if instanceIds != nil {
+			instances = make([]*autoscaling.Instance, 0, len(instanceIds))
+			for _, id := range instanceIds {
+				instances = append(instances, &autoscaling.Instance{InstanceId: aws.String(id)})
+			}
+		}
+		tags := make([]*autoscaling.TagDescription, 0)
+		for key, value := range a.tags[*name] {
+			tags = append(tags, &autoscaling.TagDescription{Key: aws.String(key), Value: aws.String(value)})
+		}
+		maxSize := int64(5)
+		if size, found := a.maxSizes[*name]; found {
+			maxSize = size
+		}
+		desiredCapacity := int64(2)
+		if capacity, found := a.desiredCapacities[*name]; found {
+			desiredCapacity = capacity
+		}
+		groups = append(groups, &autoscaling.Group{
+			AutoScalingGroupName: name,
+			Tags:                 tags,
+			SuspendedProcesses:   suspended,
+			DesiredCapacity:      aws.Int64(desiredCapacity),
+			MinSize:              aws.Int64(1),
+			MaxSize:              aws.Int64(maxSize),
+			Instances:            instances,
+		})
+	}
+	return &autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: groups,
 	}, nil
 }
 
@@ -58,6 +138,76 @@ func (a *AutoScalingMock) TerminateInstanceInAutoScalingGroup(input *autoscaling
 	return args.Get(0).(*autoscaling.TerminateInstanceInAutoScalingGroupOutput), nil
 }
 
+func (a *AutoScalingMock) CompleteInstanceLifecycleAction(input *completeInstanceLifecycleActionInput) error {
+	args := a.Called(input)
+	return args.Error(0)
+}
+
+func (a *AutoScalingMock) DescribeLaunchConfigurations(input *autoscaling.DescribeLaunchConfigurationsInput) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
+	args := a.Called(input)
+	return args.Get(0).(*autoscaling.DescribeLaunchConfigurationsOutput), nil
+}
+
+func (a *AutoScalingMock) DescribeAsgLaunchSource(name string) (*asgLaunchSource, error) {
+	args := a.Called(name)
+	return args.Get(0).(*asgLaunchSource), nil
+}
+
+func (a *AutoScalingMock) DescribeTags(input *autoscaling.DescribeTagsInput) (*autoscaling.DescribeTagsOutput, error) {
+	keys := make(map[string]bool)
+	for _, filter := range input.Filters {
+		for _, value := range filter.Values {
+			keys[*value] = true
+		}
+	}
+	tags := make([]*autoscaling.TagDescription, 0)
+	for name, asgTags := range a.tags {
+		for key := range asgTags {
+			if keys[key] {
+				tags = append(tags, &autoscaling.TagDescription{
+					ResourceId:   aws.String(name),
+					ResourceType: aws.String("auto-scaling-group"),
+					Key:          aws.String(key),
+				})
+			}
+		}
+	}
+	return &autoscaling.DescribeTagsOutput{Tags: tags}, nil
+}
+
+func (a *AutoScalingMock) DescribeWarmPool(input *describeWarmPoolInput) (*describeWarmPoolOutput, error) {
+	a.describeMutex.Lock()
+	defer a.describeMutex.Unlock()
+	a.warmPoolCalls++
+	states, found := a.warmPools[*input.AutoScalingGroupName]
+	if !found {
+		return &describeWarmPoolOutput{}, nil
+	}
+	output := &describeWarmPoolOutput{
+		WarmPoolConfiguration: &warmPoolConfiguration{PoolState: aws.String("Stopped")},
+	}
+	for i, state := range states {
+		output.Instances = append(output.Instances, &warmPoolInstance{
+			InstanceId:     aws.String(fmt.Sprintf("warm-%d", i)),
+			LifecycleState: aws.String(state),
+		})
+	}
+	return output, nil
+}
+
+func (a *AutoScalingMock) DescribeScalingActivities(input *autoscaling.DescribeScalingActivitiesInput) (*autoscaling.DescribeScalingActivitiesOutput, error) {
+	return &autoscaling.DescribeScalingActivitiesOutput{Activities: a.activities[*input.AutoScalingGroupName]}, nil
+}
+
+type EC2Mock struct {
+	mock.Mock
+}
+
+func (e *EC2Mock) DescribeLaunchTemplateVersions(input *describeLaunchTemplateVersionsInput) (*describeLaunchTemplateVersionsOutput, error) {
+	args := e.Called(input)
+	return args.Get(0).(*describeLaunchTemplateVersionsOutput), nil
+}
+
 var testAwsManager = &AwsManager{
 	asgs:     make([]*asgInformation, 0),
 	service:  &AutoScalingMock{},
@@ -79,6 +229,18 @@ func TestBuildAwsCloudProvider(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestBuildAwsCloudProviderDuplicateAsg(t *testing.T) {
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  &AutoScalingMock{},
+		asgCache: make(map[AwsRef]*Asg),
+	}
+	_, err := BuildAwsCloudProvider(m, []string{"1:5:test-asg", "2:10:other-asg", "1:3:test-asg"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "test-asg")
+	assert.NotContains(t, err.Error(), "other-asg")
+}
+
 func TestAddNodeGroup(t *testing.T) {
 	provider := testProvider(t, testAwsManager)
 	err := provider.addNodeGroup("bad spec")
@@ -131,6 +293,76 @@ func TestNodeGroupForNode(t *testing.T) {
 	assert.Nil(t, group)
 }
 
+func TestNodeGroupForNodeWithoutProviderId(t *testing.T) {
+	provider := testProvider(t, testAwsManager)
+	err := provider.addNodeGroup("1:5:test-asg")
+	assert.NoError(t, err)
+
+	nodes := []*kube_api.Node{
+		{
+			ObjectMeta: kube_api.ObjectMeta{Name: "initialized"},
+			Spec: kube_api.NodeSpec{
+				ProviderID: "aws:///us-east-1a/test-instance-id",
+			},
+		},
+		{
+			ObjectMeta: kube_api.ObjectMeta{Name: "bootstrapping"},
+		},
+	}
+	groups := make(map[string]string)
+	for _, node := range nodes {
+		group, err := provider.NodeGroupForNode(node)
+		assert.NoError(t, err)
+		if group != nil {
+			groups[node.Name] = group.Id()
+		}
+	}
+	assert.Equal(t, map[string]string{"initialized": "test-asg"}, groups)
+}
+
+func TestNodeGroupsForNodes(t *testing.T) {
+	service := &AutoScalingMock{}
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+	}
+	provider := testProvider(t, m)
+	assert.NoError(t, provider.addNodeGroup("1:5:test-asg"))
+
+	nodes := []*kube_api.Node{
+		{
+			ObjectMeta: kube_api.ObjectMeta{Name: "n1"},
+			Spec:       kube_api.NodeSpec{ProviderID: "aws:///us-east-1a/test-instance-id"},
+		},
+		{
+			ObjectMeta: kube_api.ObjectMeta{Name: "n2"},
+			Spec:       kube_api.NodeSpec{ProviderID: "aws:///us-east-1a/second-test-instance-id"},
+		},
+		{
+			ObjectMeta: kube_api.ObjectMeta{Name: "not-in-group"},
+			Spec:       kube_api.NodeSpec{ProviderID: "aws:///us-east-1a/test-instance-id-not-in-group"},
+		},
+		{
+			ObjectMeta: kube_api.ObjectMeta{Name: "bootstrapping"},
+		},
+	}
+	groups, err := provider.NodeGroupsForNodes(nodes)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, service.describeCalls)
+	assert.Equal(t, 2, len(groups))
+
+	for _, node := range nodes {
+		group, err := provider.NodeGroupForNode(node)
+		assert.NoError(t, err)
+		if group == nil || group.(*Asg) == nil {
+			assert.NotContains(t, groups, node.Name)
+		} else {
+			assert.Equal(t, group, groups[node.Name])
+		}
+	}
+}
+
 func TestAwsRefFromProviderId(t *testing.T) {
 	_, err := AwsRefFromProviderId("aws123")
 	assert.Error(t, err)
@@ -167,6 +399,74 @@ func TestTargetSize(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestRefreshSizes(t *testing.T) {
+	service := &AutoScalingMock{}
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+	}
+	provider := testProvider(t, m)
+	for i := 0; i < 120; i++ {
+		err := provider.addNodeGroup(fmt.Sprintf("1:5:test-asg-%d", i))
+		assert.NoError(t, err)
+	}
+
+	err := provider.RefreshSizes()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, service.describeCalls)
+
+	for _, asg := range provider.asgs {
+		targetSize, err := asg.TargetSize()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, targetSize)
+	}
+	assert.Equal(t, 3, service.describeCalls)
+
+	// Resizing compares the cached size with the current one and drops it.
+	service.On("SetDesiredCapacity", &autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: aws.String("test-asg-0"),
+		DesiredCapacity:      aws.Int64(3),
+		HonorCooldown:        aws.Bool(false),
+	}).Return(&autoscaling.SetDesiredCapacityOutput{})
+	err = provider.asgs[0].IncreaseSize(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, service.describeCalls)
+	_, err = provider.asgs[0].TargetSize()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, service.describeCalls)
+}
+
+func TestSetAsgSizeChangedExternally(t *testing.T) {
+	service := &AutoScalingMock{}
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+	}
+	provider, err := BuildAwsCloudProvider(m, []string{"1:5:test-asg"})
+	assert.NoError(t, err)
+	assert.NoError(t, provider.RefreshSizes())
+
+	// Another system scales the ASG from 2 to 3 after the refresh, the increase by 1 is applied on top.
+	service.desiredCapacities = map[string]int64{"test-asg": 3}
+	service.On("SetDesiredCapacity", &autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: aws.String("test-asg"),
+		DesiredCapacity:      aws.Int64(4),
+		HonorCooldown:        aws.Bool(false),
+	}).Return(&autoscaling.SetDesiredCapacityOutput{})
+	assert.NoError(t, provider.asgs[0].IncreaseSize(1))
+	service.AssertNumberOfCalls(t, "SetDesiredCapacity", 1)
+
+	// An increase that no longer fits below the max size is not made.
+	assert.NoError(t, provider.RefreshSizes())
+	service.desiredCapacities = map[string]int64{"test-asg": 5}
+	err = provider.asgs[0].IncreaseSize(1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "changed outside of the autoscaler")
+	service.AssertNumberOfCalls(t, "SetDesiredCapacity", 1)
+}
+
 func TestIncreaseSize(t *testing.T) {
 	service := &AutoScalingMock{}
 	m := &AwsManager{
@@ -190,6 +490,60 @@ func TestIncreaseSize(t *testing.T) {
 	service.AssertNumberOfCalls(t, "SetDesiredCapacity", 1)
 }
 
+func TestIncreaseSizeSuspendedLaunch(t *testing.T) {
+	service := &AutoScalingMock{
+		suspendedProcesses: map[string][]string{"test-asg": {"AZRebalance", "Launch"}},
+	}
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+	}
+	provider := testProvider(t, m)
+	err := provider.addNodeGroup("1:5:test-asg")
+	assert.NoError(t, err)
+	err = provider.addNodeGroup("1:5:other-asg")
+	assert.NoError(t, err)
+	assert.NoError(t, provider.RefreshSizes())
+
+	assert.True(t, m.IsProcessSuspended(provider.asgs[0], launchProcess))
+	assert.False(t, m.IsProcessSuspended(provider.asgs[0], terminateProcess))
+	assert.False(t, m.IsProcessSuspended(provider.asgs[1], launchProcess))
+
+	err = provider.asgs[0].IncreaseSize(1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Launch")
+	service.AssertNotCalled(t, "SetDesiredCapacity", mock.Anything)
+}
+
+func TestDecreaseTargetSize(t *testing.T) {
+	service := &AutoScalingMock{
+		asgInstanceIds:    map[string][]string{"test-asg": {"i-1"}},
+		desiredCapacities: map[string]int64{"test-asg": 3},
+	}
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+	}
+	provider := testProvider(t, m)
+	err := provider.addNodeGroup("0:5:test-asg")
+	assert.NoError(t, err)
+
+	// Decreasing the size below the number of instances would terminate some of them.
+	err = provider.asgs[0].DecreaseTargetSize(-3)
+	assert.Error(t, err)
+	service.AssertNotCalled(t, "SetDesiredCapacity", mock.Anything)
+
+	service.On("SetDesiredCapacity", &autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: aws.String("test-asg"),
+		DesiredCapacity:      aws.Int64(1),
+		HonorCooldown:        aws.Bool(false),
+	}).Return(&autoscaling.SetDesiredCapacityOutput{})
+	assert.NoError(t, provider.asgs[0].DecreaseTargetSize(-2))
+	service.AssertNumberOfCalls(t, "SetDesiredCapacity", 1)
+}
+
 func TestBelongs(t *testing.T) {
 	provider := testProvider(t, testAwsManager)
 	err := provider.addNodeGroup("1:5:test-asg")
@@ -242,6 +596,171 @@ func TestDeleteNodes(t *testing.T) {
 	service.AssertNumberOfCalls(t, "TerminateInstanceInAutoScalingGroup", 1)
 }
 
+func TestDeleteNodesFromDifferentAsgs(t *testing.T) {
+	service := &AutoScalingMock{
+		asgInstanceIds: map[string][]string{
+			"test-asg":  {"i-1", "i-2"},
+			"other-asg": {"i-3"},
+		},
+	}
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+	}
+	provider, err := BuildAwsCloudProvider(m, []string{"1:5:test-asg", "1:5:other-asg"})
+	assert.NoError(t, err)
+	assert.NoError(t, m.RefreshSizes())
+
+	nodes := make([]*kube_api.Node, 0)
+	for _, id := range []string{"i-1", "i-3", "i-2"} {
+		nodes = append(nodes, &kube_api.Node{
+			ObjectMeta: kube_api.ObjectMeta{Name: id},
+			Spec:       kube_api.NodeSpec{ProviderID: "aws:///us-east-1a/" + id},
+		})
+	}
+	err = provider.asgs[0].DeleteNodes(nodes)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "instance i-3 belongs to asg other-asg")
+
+	// Nodes of no known ASG are rejected as well.
+	unknown := &kube_api.Node{
+		ObjectMeta: kube_api.ObjectMeta{Name: "i-9"},
+		Spec:       kube_api.NodeSpec{ProviderID: "aws:///us-east-1a/i-9"},
+	}
+	err = provider.asgs[0].DeleteNodes([]*kube_api.Node{nodes[0], unknown})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "doesn't belong to a known asg")
+	service.AssertNotCalled(t, "TerminateInstanceInAutoScalingGroup", mock.Anything)
+}
+
+func TestDeleteNodesTerminatedExternally(t *testing.T) {
+	service := &AutoScalingMock{instanceIds: []string{"i-1", "i-2", "i-3"}}
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+	}
+	service.On("TerminateInstanceInAutoScalingGroup", &autoscaling.TerminateInstanceInAutoScalingGroupInput{
+		InstanceId:                     aws.String("i-1"),
+		ShouldDecrementDesiredCapacity: aws.Bool(true),
+	}).Return(&autoscaling.TerminateInstanceInAutoScalingGroupOutput{
+		Activity: &autoscaling.Activity{Description: aws.String("Deleted instance")},
+	})
+	provider := testProvider(t, m)
+	assert.NoError(t, provider.addNodeGroup("1:5:test-asg"))
+	asg := provider.asgs[0]
+	assert.NoError(t, m.RefreshSizes())
+
+	nodes := make(map[string]*kube_api.Node)
+	for _, id := range []string{"i-1", "i-2", "i-3"} {
+		nodes[id] = &kube_api.Node{
+			ObjectMeta: kube_api.ObjectMeta{Name: id},
+			Spec:       kube_api.NodeSpec{ProviderID: "aws:///us-east-1a/" + id},
+		}
+	}
+	assert.NoError(t, asg.DeleteNodes([]*kube_api.Node{nodes["i-1"]}))
+
+	// i-1 disappears after being terminated by the autoscaler, i-2 is reclaimed by a spot interruption.
+	service.instanceIds = []string{"i-3", "i-4"}
+	assert.NoError(t, m.RefreshSizes())
+	group, err := provider.NodeGroupForNode(&kube_api.Node{
+		Spec: kube_api.NodeSpec{ProviderID: "aws:///us-east-1a/i-4"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, asg, group)
+	assert.False(t, m.IsTerminatedExternally(&AwsRef{Name: "i-1"}))
+	assert.True(t, m.IsTerminatedExternally(&AwsRef{Name: "i-2"}))
+	_, found := m.sizeCache[asg.Name]
+	assert.False(t, found)
+
+	// The interrupted instance is not terminated again.
+	assert.NoError(t, asg.DeleteNodes([]*kube_api.Node{nodes["i-2"]}))
+	service.AssertNumberOfCalls(t, "TerminateInstanceInAutoScalingGroup", 1)
+	size, err := asg.TargetSize()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, size)
+}
+
+func TestDeleteNodesCompletesLifecycleHooks(t *testing.T) {
+	service := &AutoScalingMock{
+		tags: map[string]map[string]string{
+			"test-asg": {TerminationLifecycleHookTag: "drain-hook, gone-hook"},
+		},
+	}
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+	}
+
+	service.On("TerminateInstanceInAutoScalingGroup", &autoscaling.TerminateInstanceInAutoScalingGroupInput{
+		InstanceId:                     aws.String("test-instance-id"),
+		ShouldDecrementDesiredCapacity: aws.Bool(true),
+	}).Return(&autoscaling.TerminateInstanceInAutoScalingGroupOutput{
+		Activity: &autoscaling.Activity{Description: aws.String("Deleted instance")},
+	})
+	service.On("CompleteInstanceLifecycleAction", &completeInstanceLifecycleActionInput{
+		AutoScalingGroupName:  aws.String("test-asg"),
+		InstanceId:            aws.String("test-instance-id"),
+		LifecycleActionResult: aws.String("CONTINUE"),
+		LifecycleHookName:     aws.String("drain-hook"),
+	}).Return(nil)
+	// The instance was terminated before the action of the second hook was completed.
+	service.On("CompleteInstanceLifecycleAction", &completeInstanceLifecycleActionInput{
+		AutoScalingGroupName:  aws.String("test-asg"),
+		InstanceId:            aws.String("test-instance-id"),
+		LifecycleActionResult: aws.String("CONTINUE"),
+		LifecycleHookName:     aws.String("gone-hook"),
+	}).Return(fmt.Errorf("ValidationError: No active Lifecycle Action found with instance ID test-instance-id"))
+
+	provider := testProvider(t, m)
+	err := provider.addNodeGroup("1:5:test-asg")
+	assert.NoError(t, err)
+	assert.NoError(t, provider.RefreshSizes())
+
+	node := &kube_api.Node{
+		Spec: kube_api.NodeSpec{
+			ProviderID: "aws:///us-east-1a/test-instance-id",
+		},
+	}
+	err = provider.asgs[0].DeleteNodes([]*kube_api.Node{node})
+	assert.NoError(t, err)
+	service.AssertNumberOfCalls(t, "TerminateInstanceInAutoScalingGroup", 1)
+	service.AssertNumberOfCalls(t, "CompleteInstanceLifecycleAction", 2)
+}
+
+func TestDeleteNodesWithoutLifecycleHookTag(t *testing.T) {
+	service := &AutoScalingMock{}
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+	}
+
+	service.On("TerminateInstanceInAutoScalingGroup", &autoscaling.TerminateInstanceInAutoScalingGroupInput{
+		InstanceId:                     aws.String("test-instance-id"),
+		ShouldDecrementDesiredCapacity: aws.Bool(true),
+	}).Return(&autoscaling.TerminateInstanceInAutoScalingGroupOutput{
+		Activity: &autoscaling.Activity{Description: aws.String("Deleted instance")},
+	})
+
+	provider := testProvider(t, m)
+	err := provider.addNodeGroup("1:5:test-asg")
+	assert.NoError(t, err)
+	assert.NoError(t, provider.RefreshSizes())
+
+	node := &kube_api.Node{
+		Spec: kube_api.NodeSpec{
+			ProviderID: "aws:///us-east-1a/test-instance-id",
+		},
+	}
+	err = provider.asgs[0].DeleteNodes([]*kube_api.Node{node})
+	assert.NoError(t, err)
+	// Hooks owned by other tools are left alone.
+	service.AssertNumberOfCalls(t, "CompleteInstanceLifecycleAction", 0)
+}
+
 func TestId(t *testing.T) {
 	provider := testProvider(t, testAwsManager)
 	err := provider.addNodeGroup("1:5:test-asg")
@@ -276,3 +795,256 @@ func TestBuildAsg(t *testing.T) {
 	assert.Equal(t, 222, asg.MaxSize())
 	assert.Equal(t, "test-name", asg.Name)
 }
+
+func TestDiscoverAsgs(t *testing.T) {
+	service := &AutoScalingMock{
+		tags: map[string]map[string]string{
+			"discovered-asg": {"k8s.io/cluster-autoscaler": "", "k8s.io/cluster/test": ""},
+			"other-cluster":  {"k8s.io/cluster-autoscaler": ""},
+			"static-asg":     {"k8s.io/cluster-autoscaler": "", "k8s.io/cluster/test": ""},
+		},
+	}
+	m := &AwsManager{
+		asgs:          make([]*asgInformation, 0),
+		service:       service,
+		asgCache:      make(map[AwsRef]*Asg),
+		discoveryTags: []string{"k8s.io/cluster-autoscaler", "k8s.io/cluster/test"},
+	}
+	provider, err := BuildAwsCloudProvider(m, []string{"1:10:static-asg"})
+	assert.NoError(t, err)
+
+	nodeGroupIds := func() []string {
+		ids := make([]string, 0)
+		for _, nodeGroup := range provider.NodeGroups() {
+			ids = append(ids, nodeGroup.Id())
+		}
+		return ids
+	}
+
+	assert.NoError(t, m.DiscoverAsgs())
+	assert.Equal(t, []string{"static-asg", "discovered-asg"}, nodeGroupIds())
+	discovered := provider.NodeGroups()[1]
+	assert.Equal(t, 1, discovered.MinSize())
+	assert.Equal(t, 5, discovered.MaxSize())
+
+	// A newly tagged ASG is picked up after a discovery refresh.
+	service.tags["new-asg"] = map[string]string{"k8s.io/cluster/test": "", "k8s.io/cluster-autoscaler": ""}
+	assert.NoError(t, m.DiscoverAsgs())
+	assert.Equal(t, 3, len(provider.NodeGroups()))
+	assert.Contains(t, nodeGroupIds(), "new-asg")
+
+	group, err := m.GetAsgForInstance(&AwsRef{Name: "test-instance-id"})
+	assert.NoError(t, err)
+	assert.NotNil(t, group)
+
+	// Untagged ASGs are removed, together with their cached instances.
+	delete(service.tags, "discovered-asg")
+	delete(service.tags, "new-asg")
+	assert.NoError(t, m.DiscoverAsgs())
+	assert.Equal(t, []string{"static-asg"}, nodeGroupIds())
+	for _, config := range m.asgCache {
+		assert.Equal(t, "static-asg", config.Name)
+	}
+}
+
+func TestDiscoverAsgsOverMaxAsgs(t *testing.T) {
+	service := &AutoScalingMock{
+		tags: map[string]map[string]string{
+			"discovered-asg": {"k8s.io/cluster-autoscaler": ""},
+		},
+	}
+	m := &AwsManager{
+		asgs:          make([]*asgInformation, 0),
+		service:       service,
+		asgCache:      make(map[AwsRef]*Asg),
+		discoveryTags: []string{"k8s.io/cluster-autoscaler"},
+		maxAsgs:       2,
+	}
+	provider, err := BuildAwsCloudProvider(m, []string{"1:10:static-asg"})
+	assert.NoError(t, err)
+	assert.NoError(t, m.DiscoverAsgs())
+	assert.Equal(t, 2, len(provider.NodeGroups()))
+
+	// A misconfigured tag matching more ASGs than allowed registers none of them.
+	service.tags["other-asg-1"] = map[string]string{"k8s.io/cluster-autoscaler": ""}
+	service.tags["other-asg-2"] = map[string]string{"k8s.io/cluster-autoscaler": ""}
+	err = m.DiscoverAsgs()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "more than the maximum of 2")
+	assert.Equal(t, 2, len(provider.NodeGroups()))
+}
+
+func TestPriority(t *testing.T) {
+	service := &AutoScalingMock{
+		tags: map[string]map[string]string{
+			"spot-asg":      {PriorityTag: "20"},
+			"on-demand-asg": {PriorityTag: "10"},
+			"invalid-asg":   {PriorityTag: "high"},
+		},
+	}
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+	}
+	provider, err := BuildAwsCloudProvider(m, []string{"1:5:spot-asg", "1:5:on-demand-asg", "1:5:invalid-asg", "1:5:untagged-asg"})
+	assert.NoError(t, err)
+
+	// Priorities are cached by RefreshSizes.
+	_, found := provider.asgs[0].Priority()
+	assert.False(t, found)
+
+	assert.NoError(t, provider.RefreshSizes())
+	priority, found := provider.asgs[0].Priority()
+	assert.True(t, found)
+	assert.Equal(t, 20, priority)
+	priority, found = provider.asgs[1].Priority()
+	assert.True(t, found)
+	assert.Equal(t, 10, priority)
+	_, found = provider.asgs[2].Priority()
+	assert.False(t, found)
+	_, found = provider.asgs[3].Priority()
+	assert.False(t, found)
+}
+
+func TestWarmPoolSize(t *testing.T) {
+	service := &AutoScalingMock{
+		warmPools: map[string][]string{
+			"warm-asg": {"Warmed:Stopped", "Warmed:Running", "Warmed:Pending", "Warmed:Terminating"},
+		},
+	}
+	m := &AwsManager{
+		asgs:      make([]*asgInformation, 0),
+		service:   service,
+		asgCache:  make(map[AwsRef]*Asg),
+		warmPools: true,
+	}
+	provider, err := BuildAwsCloudProvider(m, []string{"1:5:warm-asg", "1:5:cold-asg"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, provider.RefreshSizes())
+	assert.Equal(t, 2, service.warmPoolCalls)
+	// Only the instances that finished warming up are counted.
+	assert.Equal(t, 2, provider.asgs[0].WarmPoolSize())
+	assert.Equal(t, 0, provider.asgs[1].WarmPoolSize())
+	// Warm pool instances don't count towards the desired capacity.
+	size, err := provider.asgs[0].TargetSize()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, size)
+
+	// Warm pools aren't described unless enabled.
+	m.warmPools = false
+	assert.NoError(t, provider.RefreshSizes())
+	assert.Equal(t, 2, service.warmPoolCalls)
+	assert.Equal(t, 0, provider.asgs[0].WarmPoolSize())
+}
+
+func TestReconcileBounds(t *testing.T) {
+	service := &AutoScalingMock{}
+	m := &AwsManager{
+		asgs:            make([]*asgInformation, 0),
+		service:         service,
+		asgCache:        make(map[AwsRef]*Asg),
+		reconcileBounds: true,
+	}
+	provider, err := BuildAwsCloudProvider(m, []string{"0:10:test-asg"})
+	assert.NoError(t, err)
+
+	// Bounds are not clamped before they are read from AWS.
+	assert.Equal(t, 0, provider.asgs[0].MinSize())
+	assert.Equal(t, 10, provider.asgs[0].MaxSize())
+
+	assert.NoError(t, provider.RefreshSizes())
+	assert.Equal(t, 1, provider.asgs[0].MinSize())
+	assert.Equal(t, 5, provider.asgs[0].MaxSize())
+
+	// A lowered max size in AWS caps the following scale ups.
+	service.maxSizes = map[string]int64{"test-asg": 3}
+	assert.NoError(t, provider.RefreshSizes())
+	assert.Equal(t, 3, provider.asgs[0].MaxSize())
+	err = provider.asgs[0].IncreaseSize(2)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "max:3")
+	service.AssertNotCalled(t, "SetDesiredCapacity", mock.Anything)
+
+	// Without reconciliation the configured bounds are used.
+	m.reconcileBounds = false
+	assert.NoError(t, provider.RefreshSizes())
+	assert.Equal(t, 0, provider.asgs[0].MinSize())
+	assert.Equal(t, 10, provider.asgs[0].MaxSize())
+}
+
+func TestTemplateTaints(t *testing.T) {
+	service := &AutoScalingMock{
+		tags: map[string]map[string]string{
+			"gpu-asg": {
+				TemplateTaintTagPrefix + "dedicated":   "gpu:NoSchedule",
+				TemplateTaintTagPrefix + "accelerator": ":PreferNoSchedule",
+				TemplateTaintTagPrefix + "invalid":     "NoExecute",
+				PriorityTag:                            "10",
+			},
+		},
+	}
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+	}
+	provider, err := BuildAwsCloudProvider(m, []string{"0:5:gpu-asg", "0:5:untagged-asg"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, provider.RefreshSizes())
+	assert.Equal(t, []kube_api.Taint{
+		{Key: "accelerator", Value: "", Effect: kube_api.TaintEffectPreferNoSchedule},
+		{Key: "dedicated", Value: "gpu", Effect: kube_api.TaintEffectNoSchedule},
+	}, provider.asgs[0].TemplateTaints())
+	assert.Empty(t, provider.asgs[1].TemplateTaints())
+}
+
+func TestTemplateLabels(t *testing.T) {
+	service := &AutoScalingMock{
+		tags: map[string]map[string]string{
+			"gpu-asg": {
+				TemplateLabelTagPrefix + "accelerator": "gpu",
+				TemplateLabelTagPrefix + "pool":        "batch",
+				TemplateLabelTagPrefix:                 "invalid",
+			},
+		},
+	}
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+	}
+	provider, err := BuildAwsCloudProvider(m, []string{"0:5:gpu-asg", "0:5:untagged-asg"})
+	assert.NoError(t, err)
+	service.On("DescribeAsgLaunchSource", "gpu-asg").Return(&asgLaunchSource{
+		AutoScalingGroupName:    aws.String("gpu-asg"),
+		LaunchConfigurationName: aws.String("gpu-config"),
+	})
+	service.On("DescribeLaunchConfigurations", &autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String("gpu-config")},
+	}).Return(&autoscaling.DescribeLaunchConfigurationsOutput{
+		LaunchConfigurations: []*autoscaling.LaunchConfiguration{
+			{
+				LaunchConfigurationName: aws.String("gpu-config"),
+				InstanceType:            aws.String("g5g.xlarge"),
+			},
+		},
+	})
+	// The instance template of untagged-asg is unknown.
+	service.On("DescribeAsgLaunchSource", "untagged-asg").Return(&asgLaunchSource{
+		AutoScalingGroupName: aws.String("untagged-asg"),
+	})
+
+	assert.NoError(t, provider.RefreshSizes())
+	assert.Equal(t, map[string]string{
+		"accelerator":             "gpu",
+		"pool":                    "batch",
+		"kubernetes.io/os":        "linux",
+		"beta.kubernetes.io/os":   "linux",
+		"kubernetes.io/arch":      "arm64",
+		"beta.kubernetes.io/arch": "arm64",
+	}, provider.asgs[0].TemplateLabels())
+	assert.Empty(t, provider.asgs[1].TemplateLabels())
+}
@@ -0,0 +1,388 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/golang/glog"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+const (
+	// defaultLaunchTemplateVersion is used when the ASG doesn't specify a launch template version.
+	defaultLaunchTemplateVersion = "$Default"
+	// launchTemplatesApiVersion is the first EC2 api version supporting launch templates.
+	launchTemplatesApiVersion = "2016-11-15"
+	// instanceTemplateCacheTTL is how long resolved ASG instance templates are cached.
+	instanceTemplateCacheTTL = 10 * time.Minute
+	// gibibyte is the unit of EBS volume sizes.
+	gibibyte = 1024 * 1024 * 1024
+	// labelOS and labelArch are the GA versions of unversioned.LabelOS and unversioned.LabelArch,
+	// set by newer kubelets.
+	labelOS   = "kubernetes.io/os"
+	labelArch = "kubernetes.io/arch"
+)
+
+// rootDeviceNames are the device names of the root volume in the common AMIs.
+var rootDeviceNames = map[string]bool{"/dev/xvda": true, "/dev/sda1": true}
+
+// maxPodsRegexp matches the kubelet --max-pods flag in the user data of instances.
+var maxPodsRegexp = regexp.MustCompile(`--max-pods[= ]+([0-9]+)`)
+
+// armInstanceFamilyRegexp matches the instance families with AWS Graviton processors, e.g. m6g,
+// c6gn or t4g. The first generation a1 family is matched separately.
+var armInstanceFamilyRegexp = regexp.MustCompile(`^[a-z]+[0-9]+g[a-z]*$`)
+
+// AsgInstanceTemplate describes the instances launched by an ASG.
+type AsgInstanceTemplate struct {
+	InstanceType string
+	// VolumeSizes are the sizes, in GiB, of the EBS volumes attached to the launched instances.
+	VolumeSizes []int64
+	// RootVolumeSize is the size, in GiB, of the root EBS volume, 0 if unknown.
+	RootVolumeSize int64
+	// MaxPods is the kubelet --max-pods set in the user data of the launched instances, 0 if unknown.
+	MaxPods int64
+	// Windows is true if the user data of the launched instances is a Windows PowerShell script.
+	Windows bool
+}
+
+type cachedInstanceTemplate struct {
+	template  *AsgInstanceTemplate
+	fetchTime time.Time
+}
+
+// GetAsgEphemeralStorage returns the ephemeral storage, in bytes, of the instances launched by the
+// ASG, i.e. the size of their root volume, or 0 if it's unknown.
+func (m *AwsManager) GetAsgEphemeralStorage(asg *Asg) int64 {
+	template := m.cachedInstanceTemplate(asg)
+	if template == nil {
+		return 0
+	}
+	return template.RootVolumeSize * gibibyte
+}
+
+// GetAsgInstanceResources returns the vCPUs and the memory, in bytes, of the instances launched by
+// the ASG, derived from their instance type, or zeros if they're unknown.
+func (m *AwsManager) GetAsgInstanceResources(asg *Asg) (int64, int64) {
+	template := m.cachedInstanceTemplate(asg)
+	if template == nil {
+		return 0, 0
+	}
+	resources, found := getInstanceTypeResources(template.InstanceType)
+	if !found {
+		return 0, 0
+	}
+	return resources.vcpus, resources.memory * mebibyte
+}
+
+// GetAsgMaxPods returns the maximum number of pods on the instances launched by the ASG, as set
+// with kubelet --max-pods in their user data, or 0 if it's unknown.
+func (m *AwsManager) GetAsgMaxPods(asg *Asg) int64 {
+	template := m.cachedInstanceTemplate(asg)
+	if template == nil {
+		return 0
+	}
+	return template.MaxPods
+}
+
+// GetAsgPlatformLabels returns the OS and architecture labels of the nodes of the ASG, derived from
+// the instance type and the user data of the instances it launches, or nil if they're unknown.
+// Both the GA and the beta labels are returned, as pods may select either of them.
+func (m *AwsManager) GetAsgPlatformLabels(asg *Asg) map[string]string {
+	template := m.cachedInstanceTemplate(asg)
+	if template == nil || template.InstanceType == "" {
+		return nil
+	}
+	os := "linux"
+	if template.Windows {
+		os = "windows"
+	}
+	arch := instanceTypeArch(template.InstanceType)
+	return map[string]string{
+		labelOS:               os,
+		unversioned.LabelOS:   os,
+		labelArch:             arch,
+		unversioned.LabelArch: arch,
+	}
+}
+
+// instanceTypeArch returns the architecture of the instance type, as named by Kubernetes.
+func instanceTypeArch(instanceType string) string {
+	family := strings.SplitN(instanceType, ".", 2)[0]
+	if family == "a1" || armInstanceFamilyRegexp.MatchString(family) {
+		return "arm64"
+	}
+	return "amd64"
+}
+
+// cachedInstanceTemplate returns the instance template of the ASG, cached for
+// instanceTemplateCacheTTL, or nil if it couldn't be fetched.
+func (m *AwsManager) cachedInstanceTemplate(asg *Asg) *AsgInstanceTemplate {
+	now := m.now()
+	m.templateMutex.Lock()
+	defer m.templateMutex.Unlock()
+	cached, found := m.instanceTemplates[asg.Name]
+	if !found || cached.fetchTime.Add(instanceTemplateCacheTTL).Before(now) {
+		template, err := m.GetAsgInstanceTemplate(asg)
+		if err != nil {
+			glog.Warningf("Failed to get instance template of ASG %s: %v", asg.Name, err)
+			return nil
+		}
+		if m.instanceTemplates == nil {
+			m.instanceTemplates = make(map[string]*cachedInstanceTemplate)
+		}
+		cached = &cachedInstanceTemplate{template: template, fetchTime: now}
+		m.instanceTemplates[asg.Name] = cached
+	}
+	return cached.template
+}
+
+// GetAsgInstanceTemplate resolves the launch configuration or the launch template version used
+// by the ASG and returns the template of the instances it launches. Launch templates referenced
+// with $Latest, $Default or no version at all are resolved to the matching version.
+func (m *AwsManager) GetAsgInstanceTemplate(asg *Asg) (*AsgInstanceTemplate, error) {
+	source, err := m.service.DescribeAsgLaunchSource(asg.Name)
+	if err != nil {
+		return nil, err
+	}
+	if source.LaunchConfigurationName != nil {
+		return m.instanceTemplateFromLaunchConfiguration(*source.LaunchConfigurationName)
+	}
+	if source.LaunchTemplate != nil {
+		return m.instanceTemplateFromLaunchTemplate(source.LaunchTemplate)
+	}
+	return nil, fmt.Errorf("ASG %s has neither a launch configuration nor a launch template", asg.Name)
+}
+
+func (m *AwsManager) instanceTemplateFromLaunchConfiguration(name string) (*AsgInstanceTemplate, error) {
+	params := &autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String(name)},
+	}
+	output, err := m.service.DescribeLaunchConfigurations(params)
+	if err != nil {
+		return nil, err
+	}
+	if len(output.LaunchConfigurations) != 1 {
+		return nil, fmt.Errorf("launch configuration %s not found", name)
+	}
+	config := output.LaunchConfigurations[0]
+	template := &AsgInstanceTemplate{
+		InstanceType: aws.StringValue(config.InstanceType),
+		VolumeSizes:  make([]int64, 0),
+		MaxPods:      parseMaxPods(aws.StringValue(config.UserData)),
+		Windows:      isWindowsUserData(aws.StringValue(config.UserData)),
+	}
+	for _, mapping := range config.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.VolumeSize != nil {
+			template.VolumeSizes = append(template.VolumeSizes, *mapping.Ebs.VolumeSize)
+			if rootDeviceNames[aws.StringValue(mapping.DeviceName)] {
+				template.RootVolumeSize = *mapping.Ebs.VolumeSize
+			}
+		}
+	}
+	return template, nil
+}
+
+func (m *AwsManager) instanceTemplateFromLaunchTemplate(spec *launchTemplateSpecification) (*AsgInstanceTemplate, error) {
+	version := aws.StringValue(spec.Version)
+	if version == "" {
+		version = defaultLaunchTemplateVersion
+	}
+	params := &describeLaunchTemplateVersionsInput{
+		LaunchTemplateId:   spec.LaunchTemplateId,
+		LaunchTemplateName: spec.LaunchTemplateName,
+		Versions:           []*string{aws.String(version)},
+	}
+	output, err := m.ec2Service.DescribeLaunchTemplateVersions(params)
+	if err != nil {
+		return nil, err
+	}
+	if len(output.LaunchTemplateVersions) != 1 || output.LaunchTemplateVersions[0].LaunchTemplateData == nil {
+		return nil, fmt.Errorf("version %s of launch template %s%s not found", version,
+			aws.StringValue(spec.LaunchTemplateId), aws.StringValue(spec.LaunchTemplateName))
+	}
+	data := output.LaunchTemplateVersions[0].LaunchTemplateData
+	template := &AsgInstanceTemplate{
+		InstanceType: aws.StringValue(data.InstanceType),
+		VolumeSizes:  make([]int64, 0),
+		MaxPods:      parseMaxPods(aws.StringValue(data.UserData)),
+		Windows:      isWindowsUserData(aws.StringValue(data.UserData)),
+	}
+	for _, mapping := range data.BlockDeviceMappings {
+		if mapping.Ebs != nil && mapping.Ebs.VolumeSize != nil {
+			template.VolumeSizes = append(template.VolumeSizes, *mapping.Ebs.VolumeSize)
+			if rootDeviceNames[aws.StringValue(mapping.DeviceName)] {
+				template.RootVolumeSize = *mapping.Ebs.VolumeSize
+			}
+		}
+	}
+	return template, nil
+}
+
+// parseMaxPods returns the kubelet --max-pods found in the user data, which AWS returns base64
+// encoded, or 0 if it's not set.
+func parseMaxPods(userData string) int64 {
+	if decoded, err := base64.StdEncoding.DecodeString(userData); err == nil {
+		userData = string(decoded)
+	}
+	match := maxPodsRegexp.FindStringSubmatch(userData)
+	if match == nil {
+		return 0
+	}
+	maxPods, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return maxPods
+}
+
+// The vendored sdk predates launch templates, so the structures below mirror the parts of the
+// AutoScaling and EC2 apis needed to resolve them.
+
+// launchTemplateSpecification is the launch template reference of an ASG.
+type launchTemplateSpecification struct {
+	_ struct{} `type:"structure"`
+
+	LaunchTemplateId   *string `min:"1" type:"string"`
+	LaunchTemplateName *string `min:"3" type:"string"`
+	Version            *string `min:"1" type:"string"`
+}
+
+// asgLaunchSource is the part of an ASG description that references the launch configuration
+// or the launch template of the group.
+type asgLaunchSource struct {
+	_ struct{} `type:"structure"`
+
+	AutoScalingGroupName    *string                      `min:"1" type:"string" required:"true"`
+	LaunchConfigurationName *string                      `min:"1" type:"string"`
+	LaunchTemplate          *launchTemplateSpecification `type:"structure"`
+}
+
+type describeAsgLaunchSourcesOutput struct {
+	_ struct{} `type:"structure"`
+
+	AutoScalingGroups []*asgLaunchSource `type:"list" required:"true"`
+	NextToken         *string            `type:"string"`
+}
+
+// DescribeAsgLaunchSource describes the ASG keeping the launch template reference that
+// autoscaling.Group lacks.
+func (s autoScalingService) DescribeAsgLaunchSource(name string) (*asgLaunchSource, error) {
+	op := &request.Operation{
+		Name:       "DescribeAutoScalingGroups",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	input := &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(name)},
+	}
+	output := &describeAsgLaunchSourcesOutput{}
+	if err := s.NewRequest(op, input, output).Send(); err != nil {
+		return nil, err
+	}
+	if len(output.AutoScalingGroups) != 1 {
+		return nil, fmt.Errorf("ASG %s not found", name)
+	}
+	return output.AutoScalingGroups[0], nil
+}
+
+type describeLaunchTemplateVersionsInput struct {
+	_ struct{} `type:"structure"`
+
+	LaunchTemplateId   *string   `type:"string"`
+	LaunchTemplateName *string   `min:"3" type:"string"`
+	Versions           []*string `locationName:"LaunchTemplateVersion" locationNameList:"item" type:"list"`
+}
+
+type describeLaunchTemplateVersionsOutput struct {
+	_ struct{} `type:"structure"`
+
+	LaunchTemplateVersions []*launchTemplateVersion `locationName:"launchTemplateVersionSet" locationNameList:"item" type:"list"`
+}
+
+type launchTemplateVersion struct {
+	_ struct{} `type:"structure"`
+
+	VersionNumber      *int64              `locationName:"versionNumber" type:"long"`
+	LaunchTemplateData *launchTemplateData `locationName:"launchTemplateData" type:"structure"`
+}
+
+type launchTemplateData struct {
+	_ struct{} `type:"structure"`
+
+	InstanceType        *string                             `locationName:"instanceType" type:"string"`
+	UserData            *string                             `locationName:"userData" type:"string"`
+	BlockDeviceMappings []*launchTemplateBlockDeviceMapping `locationName:"blockDeviceMappingSet" locationNameList:"item" type:"list"`
+}
+
+type launchTemplateBlockDeviceMapping struct {
+	_ struct{} `type:"structure"`
+
+	DeviceName *string                       `locationName:"deviceName" type:"string"`
+	Ebs        *launchTemplateEbsBlockDevice `locationName:"ebs" type:"structure"`
+}
+
+type launchTemplateEbsBlockDevice struct {
+	_ struct{} `type:"structure"`
+
+	VolumeSize *int64 `locationName:"volumeSize" type:"integer"`
+}
+
+// ec2Client contains the EC2 calls used by AwsManager.
+type ec2Client interface {
+	DescribeLaunchTemplateVersions(input *describeLaunchTemplateVersionsInput) (*describeLaunchTemplateVersionsOutput, error)
+}
+
+// ec2Service extends the sdk client with the calls missing from the vendored sdk.
+type ec2Service struct {
+	*ec2.EC2
+}
+
+// DescribeLaunchTemplateVersions describes the requested versions of a launch template.
+func (s ec2Service) DescribeLaunchTemplateVersions(input *describeLaunchTemplateVersionsInput) (*describeLaunchTemplateVersionsOutput, error) {
+	op := &request.Operation{
+		Name:       "DescribeLaunchTemplateVersions",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &describeLaunchTemplateVersionsOutput{}
+	req := s.NewRequest(op, input, output)
+	req.ClientInfo.APIVersion = launchTemplatesApiVersion
+	if err := req.Send(); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// isWindowsUserData returns true if the user data, which AWS returns base64 encoded, is a
+// PowerShell script run by EC2Launch on Windows instances.
+func isWindowsUserData(userData string) bool {
+	if decoded, err := base64.StdEncoding.DecodeString(userData); err == nil {
+		userData = string(decoded)
+	}
+	return strings.Contains(userData, "<powershell>")
+}
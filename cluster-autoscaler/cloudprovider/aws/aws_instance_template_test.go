@@ -0,0 +1,235 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/contrib/cluster-autoscaler/utils/clock"
+	kube_api "k8s.io/kubernetes/pkg/api"
+)
+
+func TestGetAsgInstanceTemplateFromLaunchTemplate(t *testing.T) {
+	service := &AutoScalingMock{}
+	ec2Service := &EC2Mock{}
+	m := &AwsManager{
+		asgs:       make([]*asgInformation, 0),
+		service:    service,
+		ec2Service: ec2Service,
+		asgCache:   make(map[AwsRef]*Asg),
+	}
+	service.On("DescribeAsgLaunchSource", "test-asg").Return(&asgLaunchSource{
+		AutoScalingGroupName: aws.String("test-asg"),
+		LaunchTemplate: &launchTemplateSpecification{
+			LaunchTemplateName: aws.String("test-template"),
+			Version:            aws.String("$Latest"),
+		},
+	})
+	ec2Service.On("DescribeLaunchTemplateVersions", &describeLaunchTemplateVersionsInput{
+		LaunchTemplateName: aws.String("test-template"),
+		Versions:           []*string{aws.String("$Latest")},
+	}).Return(&describeLaunchTemplateVersionsOutput{
+		LaunchTemplateVersions: []*launchTemplateVersion{
+			{
+				VersionNumber: aws.Int64(3),
+				LaunchTemplateData: &launchTemplateData{
+					InstanceType: aws.String("m4.large"),
+					BlockDeviceMappings: []*launchTemplateBlockDeviceMapping{
+						{
+							DeviceName: aws.String("/dev/xvda"),
+							Ebs:        &launchTemplateEbsBlockDevice{VolumeSize: aws.Int64(100)},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	template, err := m.GetAsgInstanceTemplate(&Asg{Name: "test-asg"})
+	assert.NoError(t, err)
+	assert.Equal(t, &AsgInstanceTemplate{InstanceType: "m4.large", VolumeSizes: []int64{100}, RootVolumeSize: 100}, template)
+	ec2Service.AssertNumberOfCalls(t, "DescribeLaunchTemplateVersions", 1)
+}
+
+func TestGetAsgInstanceTemplateDefaultVersion(t *testing.T) {
+	service := &AutoScalingMock{}
+	ec2Service := &EC2Mock{}
+	m := &AwsManager{
+		asgs:       make([]*asgInformation, 0),
+		service:    service,
+		ec2Service: ec2Service,
+		asgCache:   make(map[AwsRef]*Asg),
+	}
+	service.On("DescribeAsgLaunchSource", "test-asg").Return(&asgLaunchSource{
+		AutoScalingGroupName: aws.String("test-asg"),
+		LaunchTemplate: &launchTemplateSpecification{
+			LaunchTemplateId: aws.String("lt-1234"),
+		},
+	})
+	ec2Service.On("DescribeLaunchTemplateVersions", &describeLaunchTemplateVersionsInput{
+		LaunchTemplateId: aws.String("lt-1234"),
+		Versions:         []*string{aws.String("$Default")},
+	}).Return(&describeLaunchTemplateVersionsOutput{})
+
+	_, err := m.GetAsgInstanceTemplate(&Asg{Name: "test-asg"})
+	assert.Error(t, err)
+	ec2Service.AssertNumberOfCalls(t, "DescribeLaunchTemplateVersions", 1)
+}
+
+func TestGetAsgInstanceTemplateFromLaunchConfiguration(t *testing.T) {
+	service := &AutoScalingMock{}
+	m := &AwsManager{
+		asgs:       make([]*asgInformation, 0),
+		service:    service,
+		ec2Service: &EC2Mock{},
+		asgCache:   make(map[AwsRef]*Asg),
+	}
+	service.On("DescribeAsgLaunchSource", "test-asg").Return(&asgLaunchSource{
+		AutoScalingGroupName:    aws.String("test-asg"),
+		LaunchConfigurationName: aws.String("test-config"),
+	})
+	service.On("DescribeLaunchConfigurations", &autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String("test-config")},
+	}).Return(&autoscaling.DescribeLaunchConfigurationsOutput{
+		LaunchConfigurations: []*autoscaling.LaunchConfiguration{
+			{
+				LaunchConfigurationName: aws.String("test-config"),
+				InstanceType:            aws.String("c4.xlarge"),
+				BlockDeviceMappings: []*autoscaling.BlockDeviceMapping{
+					{
+						DeviceName: aws.String("/dev/xvda"),
+						Ebs:        &autoscaling.Ebs{VolumeSize: aws.Int64(50)},
+					},
+				},
+			},
+		},
+	})
+
+	template, err := m.GetAsgInstanceTemplate(&Asg{Name: "test-asg"})
+	assert.NoError(t, err)
+	assert.Equal(t, &AsgInstanceTemplate{InstanceType: "c4.xlarge", VolumeSizes: []int64{50}, RootVolumeSize: 50}, template)
+}
+
+func TestTemplateCapacity(t *testing.T) {
+	service := &AutoScalingMock{}
+	fakeClock := clock.NewFakeClock(time.Now())
+	m := &AwsManager{
+		asgs:       make([]*asgInformation, 0),
+		service:    service,
+		ec2Service: &EC2Mock{},
+		asgCache:   make(map[AwsRef]*Asg),
+		clock:      fakeClock,
+	}
+	service.On("DescribeAsgLaunchSource", "test-asg").Return(&asgLaunchSource{
+		AutoScalingGroupName:    aws.String("test-asg"),
+		LaunchConfigurationName: aws.String("test-config"),
+	})
+	service.On("DescribeLaunchConfigurations", &autoscaling.DescribeLaunchConfigurationsInput{
+		LaunchConfigurationNames: []*string{aws.String("test-config")},
+	}).Return(&autoscaling.DescribeLaunchConfigurationsOutput{
+		LaunchConfigurations: []*autoscaling.LaunchConfiguration{
+			{
+				LaunchConfigurationName: aws.String("test-config"),
+				InstanceType:            aws.String("c4.xlarge"),
+				UserData:                aws.String(base64.StdEncoding.EncodeToString([]byte("#!/bin/bash\n/etc/eks/bootstrap.sh test-cluster --kubelet-extra-args '--max-pods=17'\n"))),
+				BlockDeviceMappings: []*autoscaling.BlockDeviceMapping{
+					{
+						DeviceName: aws.String("/dev/xvdb"),
+						Ebs:        &autoscaling.Ebs{VolumeSize: aws.Int64(500)},
+					},
+					{
+						DeviceName: aws.String("/dev/xvda"),
+						Ebs:        &autoscaling.Ebs{VolumeSize: aws.Int64(50)},
+					},
+				},
+			},
+		},
+	})
+	asg := &Asg{Name: "test-asg", awsManager: m}
+
+	capacity := asg.TemplateCapacity()
+	cpu := capacity[kube_api.ResourceCPU]
+	assert.Equal(t, int64(4), cpu.Value())
+	memory := capacity[kube_api.ResourceMemory]
+	assert.Equal(t, int64(7680*1024*1024), memory.Value())
+	storage := capacity[resourceEphemeralStorage]
+	assert.Equal(t, int64(50*1024*1024*1024), storage.Value())
+	pods := capacity[kube_api.ResourcePods]
+	assert.Equal(t, int64(17), pods.Value())
+
+	// The instance template is cached.
+	fakeClock.Step(instanceTemplateCacheTTL)
+	asg.TemplateCapacity()
+	service.AssertNumberOfCalls(t, "DescribeAsgLaunchSource", 1)
+
+	// And fetched again once the cache expires.
+	fakeClock.Step(time.Second)
+	asg.TemplateCapacity()
+	service.AssertNumberOfCalls(t, "DescribeAsgLaunchSource", 2)
+}
+
+func TestParseMaxPods(t *testing.T) {
+	assert.Equal(t, int64(0), parseMaxPods(""))
+	assert.Equal(t, int64(0), parseMaxPods(base64.StdEncoding.EncodeToString([]byte("#!/bin/bash\nkubelet --v=2\n"))))
+	assert.Equal(t, int64(58), parseMaxPods(base64.StdEncoding.EncodeToString([]byte("kubelet --max-pods=58 --v=2"))))
+	assert.Equal(t, int64(29), parseMaxPods(base64.StdEncoding.EncodeToString([]byte("kubelet --max-pods 29"))))
+	// User data that isn't base64 encoded is read as is.
+	assert.Equal(t, int64(110), parseMaxPods("#!/bin/bash\nkubelet --max-pods=110\n"))
+}
+
+func TestInstanceTypeArch(t *testing.T) {
+	assert.Equal(t, "amd64", instanceTypeArch("m5.large"))
+	assert.Equal(t, "amd64", instanceTypeArch("g4dn.xlarge"))
+	assert.Equal(t, "amd64", instanceTypeArch("p4d.24xlarge"))
+	assert.Equal(t, "arm64", instanceTypeArch("a1.medium"))
+	assert.Equal(t, "arm64", instanceTypeArch("m6g.large"))
+	assert.Equal(t, "arm64", instanceTypeArch("c6gn.2xlarge"))
+	assert.Equal(t, "arm64", instanceTypeArch("t4g.micro"))
+}
+
+func TestGetInstanceTypeResources(t *testing.T) {
+	resources, found := getInstanceTypeResources("m5.large")
+	assert.True(t, found)
+	assert.Equal(t, instanceResources{vcpus: 2, memory: 8192}, resources)
+	resources, found = getInstanceTypeResources("c5.9xlarge")
+	assert.True(t, found)
+	assert.Equal(t, instanceResources{vcpus: 36, memory: 73728}, resources)
+	resources, found = getInstanceTypeResources("r4.16xlarge")
+	assert.True(t, found)
+	assert.Equal(t, instanceResources{vcpus: 64, memory: 499712}, resources)
+	resources, found = getInstanceTypeResources("t3.micro")
+	assert.True(t, found)
+	assert.Equal(t, instanceResources{vcpus: 2, memory: 1024}, resources)
+
+	_, found = getInstanceTypeResources("m5.metal")
+	assert.False(t, found)
+	_, found = getInstanceTypeResources("p4d.24xlarge")
+	assert.False(t, found)
+	_, found = getInstanceTypeResources("")
+	assert.False(t, found)
+}
+
+func TestIsWindowsUserData(t *testing.T) {
+	assert.False(t, isWindowsUserData(""))
+	assert.False(t, isWindowsUserData(base64.StdEncoding.EncodeToString([]byte("#!/bin/bash\nkubelet --v=2\n"))))
+	assert.True(t, isWindowsUserData(base64.StdEncoding.EncodeToString([]byte("<powershell>\n[string]$EKSBootstrapScriptFile\n</powershell>"))))
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"strconv"
+	"strings"
+)
+
+// mebibyte is the unit of instance type memory.
+const mebibyte = 1024 * 1024
+
+// instanceResources are the vCPUs and the memory, in MiB, of an instance type.
+type instanceResources struct {
+	vcpus  int64
+	memory int64
+}
+
+// instanceFamilyMemoryPerVCPU is the memory, in MiB, per vCPU of the instance families whose sizes
+// have 1 (medium), 2 (large), 4 (xlarge) or 4*n (<n>xlarge) vCPUs.
+var instanceFamilyMemoryPerVCPU = map[string]int64{
+	"c4":  1920,
+	"c5":  2048,
+	"c5a": 2048,
+	"c6a": 2048,
+	"c6g": 2048,
+	"c6i": 2048,
+	"m4":  4096,
+	"m5":  4096,
+	"m5a": 4096,
+	"m6a": 4096,
+	"m6g": 4096,
+	"m6i": 4096,
+	"r4":  7808,
+	"r5":  8192,
+	"r5a": 8192,
+	"r6a": 8192,
+	"r6g": 8192,
+	"r6i": 8192,
+}
+
+// instanceTypeExceptions are the instance types whose resources don't follow from their size and
+// the memory per vCPU of their family, e.g. burstable ones.
+var instanceTypeExceptions = map[string]instanceResources{
+	"c4.8xlarge":  {36, 61440},
+	"t2.nano":     {1, 512},
+	"t2.micro":    {1, 1024},
+	"t2.small":    {1, 2048},
+	"t2.medium":   {2, 4096},
+	"t2.large":    {2, 8192},
+	"t2.xlarge":   {4, 16384},
+	"t2.2xlarge":  {8, 32768},
+	"t3.nano":     {2, 512},
+	"t3.micro":    {2, 1024},
+	"t3.small":    {2, 2048},
+	"t3.medium":   {2, 4096},
+	"t3.large":    {2, 8192},
+	"t3.xlarge":   {4, 16384},
+	"t3.2xlarge":  {8, 32768},
+	"t3a.nano":    {2, 512},
+	"t3a.micro":   {2, 1024},
+	"t3a.small":   {2, 2048},
+	"t3a.medium":  {2, 4096},
+	"t3a.large":   {2, 8192},
+	"t3a.xlarge":  {4, 16384},
+	"t3a.2xlarge": {8, 32768},
+}
+
+// getInstanceTypeResources returns the resources of the instance type, and false if they're unknown.
+func getInstanceTypeResources(instanceType string) (instanceResources, bool) {
+	if resources, found := instanceTypeExceptions[instanceType]; found {
+		return resources, true
+	}
+	parts := strings.SplitN(instanceType, ".", 2)
+	if len(parts) != 2 {
+		return instanceResources{}, false
+	}
+	memoryPerVCPU, found := instanceFamilyMemoryPerVCPU[parts[0]]
+	if !found {
+		return instanceResources{}, false
+	}
+	var vcpus int64
+	switch size := parts[1]; {
+	case size == "medium":
+		vcpus = 1
+	case size == "large":
+		vcpus = 2
+	case size == "xlarge":
+		vcpus = 4
+	case strings.HasSuffix(size, "xlarge"):
+		multiplier, err := strconv.ParseInt(strings.TrimSuffix(size, "xlarge"), 10, 64)
+		if err != nil || multiplier <= 0 {
+			return instanceResources{}, false
+		}
+		vcpus = 4 * multiplier
+	default:
+		return instanceResources{}, false
+	}
+	return instanceResources{vcpus: vcpus, memory: vcpus * memoryPerVCPU}, true
+}
@@ -19,33 +19,165 @@ package aws
 import (
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"gopkg.in/gcfg.v1"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/golang/glog"
+	"k8s.io/contrib/cluster-autoscaler/utils/clock"
+	kube_api "k8s.io/kubernetes/pkg/api"
 	provider_aws "k8s.io/kubernetes/pkg/cloudprovider/providers/aws"
+	utilerrors "k8s.io/kubernetes/pkg/util/errors"
 	"k8s.io/kubernetes/pkg/util/wait"
+	"k8s.io/kubernetes/pkg/util/workqueue"
 )
 
 const (
 	operationWaitTimeout  = 5 * time.Second
 	operationPollInterval = 100 * time.Millisecond
+	// MaxAsgNamesPerDescribe is the maximum number of ASGs AWS returns from a single
+	// DescribeAutoScalingGroups call.
+	MaxAsgNamesPerDescribe = 100
+	// DefaultAsgDescribeBatchSize is the default number of ASG names per DescribeAutoScalingGroups call.
+	DefaultAsgDescribeBatchSize = 50
+	// DefaultAsgRefreshWorkers is the default number of concurrent DescribeAutoScalingGroups calls.
+	DefaultAsgRefreshWorkers = 4
+	// cacheRefreshInterval is how often Refresh regenerates the cache of ASG instances.
+	cacheRefreshInterval = time.Hour
+
+	// launchProcess is the ASG process that launches instances when the desired capacity grows.
+	launchProcess = "Launch"
+	// terminateProcess is the ASG process that terminates instances.
+	terminateProcess = "Terminate"
+
+	// lifecycleActionContinue is the lifecycle action result that lets the termination proceed.
+	lifecycleActionContinue = "CONTINUE"
+	// terminatingLifecycleState prefixes the lifecycle states of instances leaving their ASG.
+	terminatingLifecycleState = "Terminating"
+	// noActiveLifecycleAction is the message of errors completing the lifecycle action of an
+	// instance that isn't waiting for the hook, e.g. because it was already terminated.
+	noActiveLifecycleAction = "No active Lifecycle Action found"
+
+	// DefaultMaxRetries makes the AWS SDK retry failed calls its default number of times.
+	DefaultMaxRetries = aws.UseServiceDefaultRetries
+
+	// externallyTerminatedRetention is how long instances terminated outside of CA, e.g. by a spot
+	// interruption, are remembered after they disappear from their ASG.
+	externallyTerminatedRetention = time.Hour
+
+	// maxTagRecordsPerDescribe is the maximum number of tags AWS returns from a single DescribeTags call.
+	maxTagRecordsPerDescribe = 100
+
+	// PriorityTag is the ASG tag holding the priority of the ASG for the priority expander.
+	PriorityTag = "k8s.io/cluster-autoscaler/priority"
+	// TemplateTaintTagPrefix prefixes the ASG tags declaring the taints that the nodes of the ASG
+	// register with, e.g. with kubelet --register-with-taints. The rest of the tag key is the taint
+	// key and the tag value is <value>:<effect>.
+	TemplateTaintTagPrefix = "k8s.io/cluster-autoscaler/node-template/taint/"
+	// TerminationLifecycleHookTag is the ASG tag holding the comma separated names of the
+	// termination lifecycle hooks whose lifecycle actions CA completes after terminating an
+	// instance of the ASG, so that the instance doesn't wait in Terminating:Wait until they time out.
+	TerminationLifecycleHookTag = "k8s.io/cluster-autoscaler/termination-lifecycle-hook"
+	// TemplateLabelTagPrefix prefixes the ASG tags declaring the labels that the nodes of the ASG
+	// register with. The rest of the tag key is the label key and the tag value is the label value.
+	TemplateLabelTagPrefix = "k8s.io/cluster-autoscaler/node-template/label/"
 )
 
+// maxScalingActivitiesPerDescribe is the number of the most recent scaling activities of an ASG
+// checked for failed launches.
+const maxScalingActivitiesPerDescribe = 10
+
+// AwsOptions configures an AwsManager.
+type AwsOptions struct {
+	// MaxAsgs is the maximum number of registered ASGs, including discovered ones. No limit if 0.
+	MaxAsgs int
+	// DiscoveryTags are the tag keys of ASGs autoscaled in addition to the configured ones. No
+	// ASGs are discovered if empty.
+	DiscoveryTags []string
+	// DiscoveryRefreshInterval is how often the ASGs with DiscoveryTags are discovered again.
+	DiscoveryRefreshInterval time.Duration
+	// DescribeBatchSize is the number of ASG names described with a single
+	// DescribeAutoScalingGroups call, between 1 and MaxAsgNamesPerDescribe.
+	DescribeBatchSize int
+	// RefreshWorkers is the number of concurrent DescribeAutoScalingGroups calls.
+	RefreshWorkers int
+	// WarmPools is true if the warm pools of ASGs are described on every refresh.
+	WarmPools bool
+	// ReconcileBounds is true if the sizes of ASGs are clamped to their bounds in AWS.
+	ReconcileBounds bool
+	// ReadMaxRetries and WriteMaxRetries are the maximum numbers of retries of failed calls that
+	// describe and change ASGs respectively. DefaultMaxRetries uses the AWS SDK default.
+	ReadMaxRetries  int
+	WriteMaxRetries int
+	// Clock tells the time when cached data expires, the wall-clock time if nil.
+	Clock clock.Clock
+}
+
+// asgBounds are the min and max size of an ASG in AWS.
+type asgBounds struct {
+	minSize int
+	maxSize int
+}
+
 type asgInformation struct {
 	config   *Asg
 	basename string
+	// discovered is true for ASGs registered by DiscoverAsgs rather than configured with --nodes.
+	discovered bool
 }
 
 type autoScaling interface {
 	DescribeAutoScalingGroups(input *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error)
 	SetDesiredCapacity(input *autoscaling.SetDesiredCapacityInput) (*autoscaling.SetDesiredCapacityOutput, error)
 	TerminateInstanceInAutoScalingGroup(input *autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error)
+	CompleteInstanceLifecycleAction(input *completeInstanceLifecycleActionInput) error
+	DescribeLaunchConfigurations(input *autoscaling.DescribeLaunchConfigurationsInput) (*autoscaling.DescribeLaunchConfigurationsOutput, error)
+	DescribeAsgLaunchSource(name string) (*asgLaunchSource, error)
+	DescribeTags(input *autoscaling.DescribeTagsInput) (*autoscaling.DescribeTagsOutput, error)
+	DescribeWarmPool(input *describeWarmPoolInput) (*describeWarmPoolOutput, error)
+	DescribeScalingActivities(input *autoscaling.DescribeScalingActivitiesInput) (*autoscaling.DescribeScalingActivitiesOutput, error)
+}
+
+// completeInstanceLifecycleActionInput is CompleteLifecycleActionInput with the lifecycle action
+// identified by the instance id instead of the token. The vendored sdk predates the InstanceId
+// parameter and the token is only delivered with the lifecycle notification.
+type completeInstanceLifecycleActionInput struct {
+	_ struct{} `type:"structure"`
+
+	AutoScalingGroupName  *string `min:"1" type:"string" required:"true"`
+	InstanceId            *string `min:"1" type:"string" required:"true"`
+	LifecycleActionResult *string `type:"string" required:"true"`
+	LifecycleHookName     *string `min:"1" type:"string" required:"true"`
+}
+
+// autoScalingService extends the sdk client with the calls missing from the vendored sdk.
+type autoScalingService struct {
+	*autoscaling.AutoScaling
+}
+
+// newAutoScalingService builds an autoScalingService retrying failed calls up to maxRetries times.
+func newAutoScalingService(awsSession *session.Session, maxRetries int) autoScalingService {
+	return autoScalingService{autoscaling.New(awsSession, &aws.Config{MaxRetries: aws.Int(maxRetries)})}
+}
+
+// CompleteInstanceLifecycleAction completes the lifecycle action of the given instance.
+func (s autoScalingService) CompleteInstanceLifecycleAction(input *completeInstanceLifecycleActionInput) error {
+	op := &request.Operation{
+		Name:       "CompleteLifecycleAction",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	return s.NewRequest(op, input, &autoscaling.CompleteLifecycleActionOutput{}).Send()
 }
 
 // AwsManager is handles aws communication and data caching.
@@ -54,37 +186,172 @@ type AwsManager struct {
 	asgCache map[AwsRef]*Asg
 
 	service    autoScaling
+	ec2Service ec2Client
 	cacheMutex sync.Mutex
+	// writeService makes the calls that change ASGs, which may be retried less eagerly than the
+	// describe calls made with service, as retrying them can repeat a mutation. service is used if nil.
+	writeService autoScaling
+
+	// discoveryTags are the tag keys of ASGs registered by DiscoverAsgs.
+	discoveryTags []string
+	// maxAsgs is the maximum number of registered ASGs, including discovered ones. No limit if 0.
+	maxAsgs int
+	// describeBatchSize is the number of ASG names per DescribeAutoScalingGroups call,
+	// DefaultAsgDescribeBatchSize if 0.
+	describeBatchSize int
+	// refreshWorkers is the number of concurrent DescribeAutoScalingGroups calls,
+	// DefaultAsgRefreshWorkers if 0.
+	refreshWorkers int
+	// warmPools is true if RefreshSizes describes the warm pools of ASGs.
+	warmPools bool
+	// reconcileBounds is true if the sizes of ASGs are clamped to their bounds in AWS.
+	reconcileBounds bool
+	// clock tells the time when cached data expires, the wall-clock time if nil.
+	clock clock.Clock
+	// lastCacheRefresh is the time of the last successful regeneration of asgCache.
+	lastCacheRefresh time.Time
+	// stopCh is closed by Cleanup to stop the discovery of ASGs.
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	// discovery is done once the discovery of ASGs started by runDiscovery stopped.
+	discovery sync.WaitGroup
+
+	// deletedInstances holds instances terminated by CA that are still in asgCache.
+	deletedInstances map[AwsRef]bool
+	// externallyTerminated holds instances that disappeared from their ASG without being
+	// terminated by CA, e.g. because of a spot interruption, with the time they were noticed.
+	externallyTerminated map[AwsRef]time.Time
+
+	// sizeCache holds ASG desired capacities fetched by RefreshSizes, keyed by ASG name.
+	sizeCache map[string]int64
+	// suspendedProcesses holds the names of processes suspended in each ASG as of the last RefreshSizes.
+	suspendedProcesses map[string][]string
+	// priorities holds the values of PriorityTag of each ASG as of the last RefreshSizes.
+	priorities map[string]int
+	// templateTaints holds the taints declared with TemplateTaintTagPrefix tags of each ASG as of
+	// the last RefreshSizes.
+	templateTaints map[string][]kube_api.Taint
+	// templateLabels holds the labels declared with TemplateLabelTagPrefix tags of each ASG as of
+	// the last RefreshSizes.
+	templateLabels map[string]map[string]string
+	// terminationHooks holds the names of the lifecycle hooks in TerminationLifecycleHookTag of each
+	// ASG as of the last RefreshSizes.
+	terminationHooks map[string][]string
+	// warmPoolSizes holds the number of warmed instances in the warm pool of each ASG, refreshed by
+	// RefreshSizes when stale and decreased by the instances SetAsgSize takes from the pool.
+	warmPoolSizes map[string]*cachedWarmPool
+	// bounds holds the min and max size of each ASG in AWS as of the last RefreshSizes, if
+	// reconcileBounds is set.
+	bounds    map[string]asgBounds
+	sizeMutex sync.Mutex
+
+	// instanceTemplates caches the instance templates of ASGs, keyed by ASG name.
+	instanceTemplates map[string]*cachedInstanceTemplate
+	templateMutex     sync.Mutex
 }
 
-// CreateAwsManager constructs awsManager object.
-func CreateAwsManager(configReader io.Reader) (*AwsManager, error) {
+// CreateAwsManager constructs awsManager object. Discovery fails instead of registering more
+// than options.MaxAsgs ASGs in total, unless it is 0.
+func CreateAwsManager(configReader io.Reader, options AwsOptions) (*AwsManager, error) {
+	if err := validateDescribeBatchSize(options.DescribeBatchSize); err != nil {
+		return nil, err
+	}
+	if options.RefreshWorkers < 1 {
+		return nil, fmt.Errorf("ASG refresh workers must be at least 1, got %d", options.RefreshWorkers)
+	}
+	var cfg provider_aws.AWSCloudConfig
 	if configReader != nil {
-		var cfg provider_aws.AWSCloudConfig
 		if err := gcfg.ReadInto(&cfg, configReader); err != nil {
 			glog.Errorf("Couldn't read config: %v", err)
 			return nil, err
 		}
 	}
 
-	service := autoscaling.New(session.New())
+	awsSession := session.New()
+	if region := detectRegion(awsSession, &cfg, ec2metadata.New(awsSession)); region != "" {
+		awsSession = session.New(&aws.Config{Region: aws.String(region)})
+	}
 	manager := &AwsManager{
-		asgs:     make([]*asgInformation, 0),
-		service:  service,
-		asgCache: make(map[AwsRef]*Asg),
+		asgs:         make([]*asgInformation, 0),
+		service:      newAutoScalingService(awsSession, options.ReadMaxRetries),
+		writeService: newAutoScalingService(awsSession, options.WriteMaxRetries),
+		ec2Service:   ec2Service{ec2.New(awsSession)},
+		asgCache:     make(map[AwsRef]*Asg),
+
+		describeBatchSize: options.DescribeBatchSize,
+		refreshWorkers:    options.RefreshWorkers,
+		warmPools:         options.WarmPools,
+		reconcileBounds:   options.ReconcileBounds,
+		maxAsgs:           options.MaxAsgs,
+		clock:             options.Clock,
+		stopCh:            make(chan struct{}),
 	}
 
-	go wait.Forever(func() {
-		manager.cacheMutex.Lock()
-		defer manager.cacheMutex.Unlock()
-		if err := manager.regenerateCache(); err != nil {
-			glog.Errorf("Error while regenerating Asg cache: %v", err)
-		}
-	}, time.Hour)
+	if len(options.DiscoveryTags) > 0 {
+		manager.discoveryTags = options.DiscoveryTags
+		manager.runDiscovery(options.DiscoveryRefreshInterval)
+	}
 
 	return manager, nil
 }
 
+// runDiscovery discovers ASGs in the background every interval until Cleanup is called.
+func (m *AwsManager) runDiscovery(interval time.Duration) {
+	m.discovery.Add(1)
+	go func() {
+		defer m.discovery.Done()
+		wait.Until(func() {
+			if err := m.DiscoverAsgs(); err != nil {
+				glog.Errorf("Error while discovering ASGs: %v", err)
+			}
+		}, interval, m.stopCh)
+	}()
+}
+
+// Refresh regenerates the cache of ASG instances if it wasn't regenerated for cacheRefreshInterval.
+// Instances missing from the cache regenerate it anyway, so this mostly drops terminated instances.
+func (m *AwsManager) Refresh() error {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	if !m.lastCacheRefresh.IsZero() && m.now().Before(m.lastCacheRefresh.Add(cacheRefreshInterval)) {
+		return nil
+	}
+	return m.regenerateCache()
+}
+
+// Cleanup stops the discovery of ASGs and waits until a discovery in progress is done. It is safe
+// to call more than once.
+func (m *AwsManager) Cleanup() error {
+	m.stopOnce.Do(func() {
+		if m.stopCh != nil {
+			close(m.stopCh)
+		}
+	})
+	m.discovery.Wait()
+	return nil
+}
+
+// detectRegion returns the region of the AWS clients. The region configured in the session, e.g.
+// with the AWS_REGION environment variable, is used first, then the region of the zone from the
+// cloud config. Otherwise the region of the instance CA runs on is read from the instance
+// metadata service. Empty if no region could be found.
+func detectRegion(awsSession *session.Session, cfg *provider_aws.AWSCloudConfig, metadata *ec2metadata.EC2Metadata) string {
+	if region := aws.StringValue(awsSession.Config.Region); region != "" {
+		return region
+	}
+	if zone := cfg.Global.Zone; len(zone) > 1 {
+		// Zones are named after their region with a single letter suffix, e.g. us-east-1a.
+		return zone[:len(zone)-1]
+	}
+	region, err := metadata.Region()
+	if err != nil {
+		glog.Warningf("Failed to read region from the instance metadata service, configure it with AWS_REGION: %v", err)
+		return ""
+	}
+	glog.V(1).Infof("Using region %s from the instance metadata service", region)
+	return region
+}
+
 // RegisterAsg registers asg in Aws Manager.
 func (m *AwsManager) RegisterAsg(asg *Asg) {
 	m.cacheMutex.Lock()
@@ -95,10 +362,332 @@ func (m *AwsManager) RegisterAsg(asg *Asg) {
 	})
 }
 
+// DiscoverAsgs finds the ASGs having all of the discovery tags and reconciles them with the
+// registered ASGs: newly tagged ASGs are registered, ASGs that were deleted or lost a tag are
+// unregistered and size changes are applied. ASGs configured with --nodes are left untouched.
+// If more ASGs than maxAsgs would be registered, an error is returned and nothing is changed, as
+// this usually means the discovery tags are misconfigured.
+func (m *AwsManager) DiscoverAsgs() error {
+	if len(m.discoveryTags) == 0 {
+		return nil
+	}
+	names, err := m.describeTaggedAsgs(m.discoveryTags)
+	if err != nil {
+		return err
+	}
+	groups, err := m.describeAsgs(names)
+	if err != nil {
+		return err
+	}
+
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	discovered := make(map[string]*Asg)
+	for _, group := range groups {
+		discovered[*group.AutoScalingGroupName] = &Asg{
+			AwsRef:     AwsRef{Name: *group.AutoScalingGroupName},
+			awsManager: m,
+			minSize:    int(*group.MinSize),
+			maxSize:    int(*group.MaxSize),
+		}
+	}
+	asgs := make([]*asgInformation, 0, len(m.asgs))
+	replaced := make(map[*Asg]*Asg)
+	for _, asg := range m.asgs {
+		if !asg.discovered {
+			delete(discovered, asg.config.Name)
+			asgs = append(asgs, asg)
+			continue
+		}
+		config, found := discovered[asg.config.Name]
+		if !found {
+			glog.Infof("ASG %s is no longer discovered, removing it", asg.config.Name)
+			replaced[asg.config] = nil
+			continue
+		}
+		delete(discovered, asg.config.Name)
+		if config.minSize != asg.config.minSize || config.maxSize != asg.config.maxSize {
+			glog.Infof("Size limits of discovered ASG %s changed to %s", config.Name, config.Debug())
+			replaced[asg.config] = config
+			asg = &asgInformation{config: config, discovered: true}
+		}
+		asgs = append(asgs, asg)
+	}
+	if m.maxAsgs > 0 && len(asgs)+len(discovered) > m.maxAsgs {
+		return fmt.Errorf("discovery would register %d ASGs, more than the maximum of %d, check the discovery tags %v",
+			len(asgs)+len(discovered), m.maxAsgs, m.discoveryTags)
+	}
+	for _, config := range discovered {
+		glog.Infof("Discovered ASG %s", config.Debug())
+		if config.maxSize == 0 {
+			glog.Warningf("Discovered ASG %s has max size 0 and will never be scaled up", config.Name)
+		}
+		asgs = append(asgs, &asgInformation{config: config, discovered: true})
+	}
+	m.asgs = asgs
+
+	// Instances of removed ASGs are dropped from the cache, so that they don't look terminated
+	// outside of CA once the cache is regenerated. Instances of new ASGs are found on cache misses.
+	for ref, config := range m.asgCache {
+		if newConfig, found := replaced[config]; found {
+			if newConfig == nil {
+				delete(m.asgCache, ref)
+			} else {
+				m.asgCache[ref] = newConfig
+			}
+		}
+	}
+	return nil
+}
+
+// describeTaggedAsgs returns the names of ASGs having all of the given tag keys.
+func (m *AwsManager) describeTaggedAsgs(tagKeys []string) ([]string, error) {
+	params := &autoscaling.DescribeTagsInput{
+		Filters: []*autoscaling.Filter{
+			{
+				Name:   aws.String("key"),
+				Values: aws.StringSlice(tagKeys),
+			},
+		},
+		MaxRecords: aws.Int64(maxTagRecordsPerDescribe),
+	}
+	tagCounts := make(map[string]int)
+	for {
+		tags, err := m.service.DescribeTags(params)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags.Tags {
+			tagCounts[*tag.ResourceId]++
+		}
+		if tags.NextToken == nil || *tags.NextToken == "" {
+			break
+		}
+		params.NextToken = tags.NextToken
+	}
+	names := make([]string, 0, len(tagCounts))
+	for name, count := range tagCounts {
+		if count == len(tagKeys) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// DiscoveredAsgs returns the ASGs registered by DiscoverAsgs.
+func (m *AwsManager) DiscoveredAsgs() []*Asg {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	result := make([]*Asg, 0)
+	for _, asg := range m.asgs {
+		if asg.discovered {
+			result = append(result, asg.config)
+		}
+	}
+	return result
+}
+
+// RefreshSizes fetches the desired capacity of all registered ASGs using as few
+// DescribeAutoScalingGroups calls as possible and caches the results. Until the next refresh
+// GetAsgSize serves sizes from the cache. With warm pools enabled the sizes of the warm pools of
+// the ASGs are cached as well, and with bounds reconciliation their min and max sizes.
+func (m *AwsManager) RefreshSizes() error {
+	m.cacheMutex.Lock()
+	names := make([]string, 0, len(m.asgs))
+	for _, asg := range m.asgs {
+		names = append(names, asg.config.Name)
+	}
+	m.cacheMutex.Unlock()
+
+	groups, err := m.describeAsgs(names)
+	if err != nil {
+		return err
+	}
+	sizes := make(map[string]int64)
+	suspended := make(map[string][]string)
+	priorities := make(map[string]int)
+	templateTaints := make(map[string][]kube_api.Taint)
+	templateLabels := make(map[string]map[string]string)
+	terminationHooks := make(map[string][]string)
+	bounds := make(map[string]asgBounds)
+	for _, group := range groups {
+		sizes[*group.AutoScalingGroupName] = *group.DesiredCapacity
+		if m.reconcileBounds {
+			bounds[*group.AutoScalingGroupName] = asgBounds{
+				minSize: int(aws.Int64Value(group.MinSize)),
+				maxSize: int(aws.Int64Value(group.MaxSize)),
+			}
+		}
+		for _, process := range group.SuspendedProcesses {
+			suspended[*group.AutoScalingGroupName] = append(suspended[*group.AutoScalingGroupName], *process.ProcessName)
+		}
+		for _, tag := range group.Tags {
+			key := aws.StringValue(tag.Key)
+			switch {
+			case key == PriorityTag:
+				priority, err := strconv.Atoi(aws.StringValue(tag.Value))
+				if err != nil {
+					glog.Warningf("Invalid %s tag of ASG %s: %v", PriorityTag, *group.AutoScalingGroupName, err)
+					continue
+				}
+				priorities[*group.AutoScalingGroupName] = priority
+			case key == TerminationLifecycleHookTag:
+				for _, hook := range strings.Split(aws.StringValue(tag.Value), ",") {
+					if hook = strings.TrimSpace(hook); hook != "" {
+						terminationHooks[*group.AutoScalingGroupName] = append(terminationHooks[*group.AutoScalingGroupName], hook)
+					}
+				}
+			case strings.HasPrefix(key, TemplateTaintTagPrefix):
+				taint, err := parseTemplateTaint(strings.TrimPrefix(key, TemplateTaintTagPrefix), aws.StringValue(tag.Value))
+				if err != nil {
+					glog.Warningf("Invalid %s tag of ASG %s: %v", key, *group.AutoScalingGroupName, err)
+					continue
+				}
+				templateTaints[*group.AutoScalingGroupName] = append(templateTaints[*group.AutoScalingGroupName], taint)
+			case strings.HasPrefix(key, TemplateLabelTagPrefix) && key != TemplateLabelTagPrefix:
+				if templateLabels[*group.AutoScalingGroupName] == nil {
+					templateLabels[*group.AutoScalingGroupName] = make(map[string]string)
+				}
+				templateLabels[*group.AutoScalingGroupName][strings.TrimPrefix(key, TemplateLabelTagPrefix)] = aws.StringValue(tag.Value)
+			}
+		}
+		sort.Sort(byTaintKey(templateTaints[*group.AutoScalingGroupName]))
+	}
+
+	warmPoolSizes := make(map[string]*cachedWarmPool)
+	if m.warmPools {
+		m.sizeMutex.Lock()
+		cachedWarmPools := make(map[string]*cachedWarmPool, len(m.warmPoolSizes))
+		for name, pool := range m.warmPoolSizes {
+			cachedWarmPools[name] = pool
+		}
+		previousSizes := make(map[string]int64, len(m.sizeCache))
+		for name, size := range m.sizeCache {
+			previousSizes[name] = size
+		}
+		m.sizeMutex.Unlock()
+		warmPoolSizes = m.refreshWarmPools(names, cachedWarmPools, previousSizes, sizes)
+	}
+
+	m.sizeMutex.Lock()
+	defer m.sizeMutex.Unlock()
+	m.sizeCache = sizes
+	m.suspendedProcesses = suspended
+	m.priorities = priorities
+	m.templateTaints = templateTaints
+	m.templateLabels = templateLabels
+	m.terminationHooks = terminationHooks
+	m.warmPoolSizes = warmPoolSizes
+	for name, b := range bounds {
+		if old, found := m.bounds[name]; !found || old != b {
+			glog.V(0).Infof("ASG %s has min size %d and max size %d in AWS", name, b.minSize, b.maxSize)
+		}
+	}
+	m.bounds = bounds
+	return nil
+}
+
+// parseTemplateTaint parses the taint declared by a TemplateTaintTagPrefix tag, with the given
+// taint key and <value>:<effect> tag value.
+func parseTemplateTaint(key, value string) (kube_api.Taint, error) {
+	separator := strings.LastIndex(value, ":")
+	if key == "" || separator < 0 {
+		return kube_api.Taint{}, fmt.Errorf("expected %s<key> tag with <value>:<effect> value, got %s", TemplateTaintTagPrefix, value)
+	}
+	effect := kube_api.TaintEffect(value[separator+1:])
+	if effect != kube_api.TaintEffectNoSchedule && effect != kube_api.TaintEffectPreferNoSchedule {
+		return kube_api.Taint{}, fmt.Errorf("unsupported taint effect %s", effect)
+	}
+	return kube_api.Taint{Key: key, Value: value[:separator], Effect: effect}, nil
+}
+
+type byTaintKey []kube_api.Taint
+
+func (a byTaintKey) Len() int           { return len(a) }
+func (a byTaintKey) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTaintKey) Less(i, j int) bool { return a[i].Key < a[j].Key }
+
+// GetAsgTemplateLabels returns the labels declared with TemplateLabelTagPrefix tags of the ASG as
+// of the last RefreshSizes.
+func (m *AwsManager) GetAsgTemplateLabels(asg *Asg) map[string]string {
+	m.sizeMutex.Lock()
+	defer m.sizeMutex.Unlock()
+	return m.templateLabels[asg.Name]
+}
+
+// GetAsgTemplateTaints returns the taints declared with TemplateTaintTagPrefix tags of the ASG as
+// of the last RefreshSizes.
+func (m *AwsManager) GetAsgTemplateTaints(asg *Asg) []kube_api.Taint {
+	m.sizeMutex.Lock()
+	defer m.sizeMutex.Unlock()
+	return m.templateTaints[asg.Name]
+}
+
+// GetAsgTerminationHooks returns the names of the lifecycle hooks in TerminationLifecycleHookTag
+// of the ASG as of the last RefreshSizes.
+func (m *AwsManager) GetAsgTerminationHooks(asg *Asg) []string {
+	m.sizeMutex.Lock()
+	defer m.sizeMutex.Unlock()
+	return m.terminationHooks[asg.Name]
+}
+
+// GetAsgPriority returns the value of PriorityTag of the ASG as of the last RefreshSizes and
+// true, or false if the ASG has no valid priority tag.
+func (m *AwsManager) GetAsgPriority(asg *Asg) (int, bool) {
+	m.sizeMutex.Lock()
+	defer m.sizeMutex.Unlock()
+	priority, found := m.priorities[asg.Name]
+	return priority, found
+}
+
+// IsProcessSuspended returns true if the given process was suspended in the ASG as of the last
+// RefreshSizes. Size changes that depend on a suspended process don't take effect.
+func (m *AwsManager) IsProcessSuspended(asg *Asg, process string) bool {
+	m.sizeMutex.Lock()
+	defer m.sizeMutex.Unlock()
+	for _, suspended := range m.suspendedProcesses[asg.Name] {
+		if suspended == process {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAsgBounds returns the min and max size of the ASG in AWS as of the last RefreshSizes and true,
+// or false if the bounds are unknown or not reconciled.
+func (m *AwsManager) GetAsgBounds(asg *Asg) (int, int, bool) {
+	m.sizeMutex.Lock()
+	defer m.sizeMutex.Unlock()
+	b, found := m.bounds[asg.Name]
+	return b.minSize, b.maxSize, found
+}
+
+// GetAsgWarmPoolSize returns the number of warmed instances in the warm pool of the ASG that aren't
+// taken by scale ups yet.
+func (m *AwsManager) GetAsgWarmPoolSize(asg *Asg) int64 {
+	m.sizeMutex.Lock()
+	defer m.sizeMutex.Unlock()
+	if pool, found := m.warmPoolSizes[asg.Name]; found {
+		return pool.warmed
+	}
+	return 0
+}
+
 // GetAsgSize gets ASG size.
 func (m *AwsManager) GetAsgSize(asgConfig *Asg) (int64, error) {
+	m.sizeMutex.Lock()
+	size, found := m.sizeCache[asgConfig.Name]
+	m.sizeMutex.Unlock()
+	if found {
+		return size, nil
+	}
+	return m.describeAsgSize(asgConfig.Name)
+}
+
+// describeAsgSize fetches the current desired capacity of the ASG from AWS, bypassing the cache.
+func (m *AwsManager) describeAsgSize(name string) (int64, error) {
 	params := &autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []*string{aws.String(asgConfig.Name)},
+		AutoScalingGroupNames: []*string{aws.String(name)},
 		MaxRecords:            aws.Int64(1),
 	}
 	groups, err := m.service.DescribeAutoScalingGroups(params)
@@ -108,26 +697,202 @@ func (m *AwsManager) GetAsgSize(asgConfig *Asg) (int64, error) {
 	}
 
 	if len(groups.AutoScalingGroups) < 1 {
-		return -1, fmt.Errorf("Unable to get first autoscaling.Group for %s", asgConfig.Name)
+		return -1, fmt.Errorf("Unable to get first autoscaling.Group for %s", name)
 	}
 	asg := *groups.AutoScalingGroups[0]
 	return *asg.DesiredCapacity, nil
 }
 
-// SetAsgSize sets ASG size.
+// writer returns the service making the calls that change ASGs.
+func (m *AwsManager) writer() autoScaling {
+	if m.writeService == nil {
+		return m.service
+	}
+	return m.writeService
+}
+
+// SetAsgSize sets ASG size. The size is assumed to be computed from the size cached by the last
+// RefreshSizes, if any. If the desired capacity was changed outside of the autoscaler since then,
+// e.g. manually or by a scaling policy, the same change is applied to the current desired capacity
+// instead of overwriting it.
 func (m *AwsManager) SetAsgSize(asg *Asg, size int64) error {
+	m.sizeMutex.Lock()
+	baseline, cached := m.sizeCache[asg.Name]
+	m.sizeMutex.Unlock()
+	if cached {
+		current, err := m.describeAsgSize(asg.Name)
+		if err != nil {
+			return err
+		}
+		if current != baseline {
+			adjusted := current + size - baseline
+			glog.Warningf("Desired capacity of ASG %s was changed outside of the autoscaler from %d to %d, setting it to %d instead of %d",
+				asg.Name, baseline, current, adjusted, size)
+			if adjusted < 0 || (adjusted > current && adjusted > int64(asg.MaxSize())) {
+				m.invalidateSize(asg.Name)
+				return fmt.Errorf("desired capacity of ASG %s was changed outside of the autoscaler to %d, size %d is out of bounds",
+					asg.Name, current, adjusted)
+			}
+			size = adjusted
+			baseline = current
+		}
+	}
 	params := &autoscaling.SetDesiredCapacityInput{
 		AutoScalingGroupName: aws.String(asg.Name),
 		DesiredCapacity:      aws.Int64(size),
 		HonorCooldown:        aws.Bool(false),
 	}
-	_, err := m.service.SetDesiredCapacity(params)
+	m.invalidateSize(asg.Name)
+	_, err := m.writer().SetDesiredCapacity(params)
 	if err != nil {
 		return err
 	}
+	if cached {
+		m.takeFromWarmPool(asg.Name, size-baseline)
+	}
+	return nil
+}
+
+// takeFromWarmPool accounts for the change of the desired capacity of the ASG by delta in its cached
+// warm pool. New instances are taken from the warm pool first, so an increase uses up as many
+// warmed instances, while instances removed by a decrease may return to the pool, so its cached
+// size is dropped until the next RefreshSizes describes it again.
+func (m *AwsManager) takeFromWarmPool(name string, delta int64) {
+	m.sizeMutex.Lock()
+	defer m.sizeMutex.Unlock()
+	pool, found := m.warmPoolSizes[name]
+	if !found {
+		return
+	}
+	if delta < 0 {
+		delete(m.warmPoolSizes, name)
+		return
+	}
+	taken := delta
+	if taken > pool.warmed {
+		taken = pool.warmed
+	}
+	m.warmPoolSizes[name] = &cachedWarmPool{warmed: pool.warmed - taken, fetchTime: pool.fetchTime}
+}
+
+// GetAsgScaleUpFailure returns the status message of the most recent failed scaling activity of
+// the ASG that started after the given time, or an empty string if there is none. SetDesiredCapacity
+// succeeds even if EC2 can't launch the instances, e.g. with InsufficientInstanceCapacity, the
+// failure only shows up in the scaling activities.
+func (m *AwsManager) GetAsgScaleUpFailure(asg *Asg, since time.Time) (string, error) {
+	params := &autoscaling.DescribeScalingActivitiesInput{
+		AutoScalingGroupName: aws.String(asg.Name),
+		MaxRecords:           aws.Int64(maxScalingActivitiesPerDescribe),
+	}
+	output, err := m.service.DescribeScalingActivities(params)
+	if err != nil {
+		return "", fmt.Errorf("failed to describe scaling activities of %s: %v", asg.Name, err)
+	}
+	for _, activity := range output.Activities {
+		if aws.StringValue(activity.StatusCode) != autoscaling.ScalingActivityStatusCodeFailed ||
+			activity.StartTime == nil || activity.StartTime.Before(since) {
+			continue
+		}
+		return fmt.Sprintf("%s: %s", aws.StringValue(activity.Description), aws.StringValue(activity.StatusMessage)), nil
+	}
+	return "", nil
+}
+
+// invalidateSize drops the cached size of the given ASG so that the next GetAsgSize asks AWS.
+func (m *AwsManager) invalidateSize(name string) {
+	m.sizeMutex.Lock()
+	defer m.sizeMutex.Unlock()
+	delete(m.sizeCache, name)
+}
+
+// validateDescribeBatchSize checks that the batch size is accepted by DescribeAutoScalingGroups.
+func validateDescribeBatchSize(size int) error {
+	if size < 1 || size > MaxAsgNamesPerDescribe {
+		return fmt.Errorf("ASG describe batch size must be between 1 and %d, got %d", MaxAsgNamesPerDescribe, size)
+	}
 	return nil
 }
 
+// describeAsgs describes the given ASGs in batches of describeBatchSize names, with up to
+// refreshWorkers batches described concurrently. A failed batch doesn't stop the others: the
+// groups of the successful batches are returned together with the aggregated errors.
+func (m *AwsManager) describeAsgs(names []string) ([]*autoscaling.Group, error) {
+	batchSize := m.describeBatchSize
+	if batchSize == 0 {
+		batchSize = DefaultAsgDescribeBatchSize
+	}
+	workers := m.refreshWorkers
+	if workers == 0 {
+		workers = DefaultAsgRefreshWorkers
+	}
+	batches := make([][]string, 0)
+	for start := 0; start < len(names); start += batchSize {
+		end := start + batchSize
+		if end > len(names) {
+			end = len(names)
+		}
+		batches = append(batches, names[start:end])
+	}
+	if workers > len(batches) {
+		workers = len(batches)
+	}
+
+	var resultMutex sync.Mutex
+	result := make([]*autoscaling.Group, 0, len(names))
+	errs := make([]error, 0)
+	workqueue.Parallelize(workers, len(batches), func(piece int) {
+		groups, err := m.describeAsgBatch(batches[piece])
+		resultMutex.Lock()
+		defer resultMutex.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to describe ASGs %v: %v", batches[piece], err))
+			return
+		}
+		result = append(result, groups...)
+	})
+	return result, utilerrors.NewAggregate(errs)
+}
+
+// describeAsgBatch describes the given ASGs, following NextToken until all of them are returned.
+func (m *AwsManager) describeAsgBatch(names []string) ([]*autoscaling.Group, error) {
+	result := make([]*autoscaling.Group, 0, len(names))
+	params := &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: aws.StringSlice(names),
+		MaxRecords:            aws.Int64(MaxAsgNamesPerDescribe),
+	}
+	for {
+		groups, err := m.service.DescribeAutoScalingGroups(params)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, groups.AutoScalingGroups...)
+		if groups.NextToken == nil || *groups.NextToken == "" {
+			break
+		}
+		params.NextToken = groups.NextToken
+	}
+	return result, nil
+}
+
+// GetAsgInstanceCount returns the number of instances of the ASG that are not terminating, as
+// currently described by AWS.
+func (m *AwsManager) GetAsgInstanceCount(asg *Asg) (int, error) {
+	groups, err := m.describeAsgBatch([]string{asg.Name})
+	if err != nil {
+		return 0, err
+	}
+	if len(groups) == 0 {
+		return 0, fmt.Errorf("Unable to get autoscaling.Group for %s", asg.Name)
+	}
+	count := 0
+	for _, instance := range groups[0].Instances {
+		if !strings.HasPrefix(aws.StringValue(instance.LifecycleState), terminatingLifecycleState) {
+			count++
+		}
+	}
+	return count, nil
+}
+
 // DeleteInstances deletes the given instances. All instances must be controlled by the same ASG.
 func (m *AwsManager) DeleteInstances(instances []*AwsRef) error {
 	if len(instances) == 0 {
@@ -137,28 +902,67 @@ func (m *AwsManager) DeleteInstances(instances []*AwsRef) error {
 	if err != nil {
 		return err
 	}
+	if commonAsg == nil {
+		return fmt.Errorf("cannot delete instance %s which doesn't belong to a known ASG", instances[0].Name)
+	}
 	for _, instance := range instances {
 		asg, err := m.GetAsgForInstance(instance)
 		if err != nil {
 			return err
 		}
 		if asg != commonAsg {
-			return fmt.Errorf("Connot delete instances which don't belong to the same ASG.")
+			return fmt.Errorf("cannot delete instances which don't belong to the same ASG: %s doesn't belong to %s",
+				instance.Name, commonAsg.Name)
 		}
 	}
 
+	m.invalidateSize(commonAsg.Name)
 	for _, instance := range instances {
 		params := &autoscaling.TerminateInstanceInAutoScalingGroupInput{
 			InstanceId:                     aws.String(instance.Name),
 			ShouldDecrementDesiredCapacity: aws.Bool(true),
 		}
-		resp, err := m.service.TerminateInstanceInAutoScalingGroup(params)
+		resp, err := m.writer().TerminateInstanceInAutoScalingGroup(params)
 		if err != nil {
 			return err
 		}
-		glog.V(4).Infof(*resp.Activity.Description)
+		m.cacheMutex.Lock()
+		if m.deletedInstances == nil {
+			m.deletedInstances = make(map[AwsRef]bool)
+		}
+		m.deletedInstances[*instance] = true
+		m.cacheMutex.Unlock()
+		glog.V(4).Infof("%s", *resp.Activity.Description)
 	}
 
+	if hooks := m.GetAsgTerminationHooks(commonAsg); len(hooks) > 0 {
+		return m.completeTerminationLifecycleActions(commonAsg, hooks, instances)
+	}
+	return nil
+}
+
+// completeTerminationLifecycleActions completes the lifecycle actions of the given termination
+// lifecycle hooks of the ASG for the given, already terminating, instances. Instances that aren't
+// waiting for a hook, e.g. because they were terminated before the action was completed, are skipped.
+func (m *AwsManager) completeTerminationLifecycleActions(asg *Asg, hooks []string, instances []*AwsRef) error {
+	for _, hook := range hooks {
+		for _, instance := range instances {
+			glog.V(4).Infof("Completing lifecycle action %s for %s in %s", hook, instance.Name, asg.Name)
+			err := m.writer().CompleteInstanceLifecycleAction(&completeInstanceLifecycleActionInput{
+				AutoScalingGroupName:  aws.String(asg.Name),
+				InstanceId:            aws.String(instance.Name),
+				LifecycleActionResult: aws.String(lifecycleActionContinue),
+				LifecycleHookName:     aws.String(hook),
+			})
+			if err != nil && strings.Contains(err.Error(), noActiveLifecycleAction) {
+				glog.V(4).Infof("No lifecycle action %s to complete for %s: %v", hook, instance.Name, err)
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to complete lifecycle action %s for %s: %v", hook, instance.Name, err)
+			}
+		}
+	}
 	return nil
 }
 
@@ -179,32 +983,119 @@ func (m *AwsManager) GetAsgForInstance(instance *AwsRef) (*Asg, error) {
 	return nil, nil
 }
 
+// GetAsgsForInstances returns the ASGs of the given instances. The cache is regenerated at most
+// once, if any of the instances is missing from it. Instances that don't belong to any configured
+// ASG are omitted.
+func (m *AwsManager) GetAsgsForInstances(instances []*AwsRef) (map[AwsRef]*Asg, error) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	for _, instance := range instances {
+		if _, found := m.asgCache[*instance]; !found {
+			if err := m.regenerateCache(); err != nil {
+				return nil, fmt.Errorf("Error while looking for ASGs of instances, error: %v", err)
+			}
+			break
+		}
+	}
+	result := make(map[AwsRef]*Asg)
+	for _, instance := range instances {
+		if config, found := m.asgCache[*instance]; found {
+			result[*instance] = config
+		}
+	}
+	return result, nil
+}
+
 func (m *AwsManager) regenerateCache() error {
 	newCache := make(map[AwsRef]*Asg)
 
+	configs := make(map[string]*Asg)
+	names := make([]string, 0, len(m.asgs))
 	for _, asg := range m.asgs {
 		glog.V(4).Infof("Regenerating ASG information for %s", asg.config.Name)
-
-		params := &autoscaling.DescribeAutoScalingGroupsInput{
-			AutoScalingGroupNames: []*string{aws.String(asg.config.Name)},
-			MaxRecords:            aws.Int64(1),
-		}
-		groups, err := m.service.DescribeAutoScalingGroups(params)
-		if err != nil {
-			glog.V(4).Infof("Failed ASG info request for %s: %v", asg.config.Name, err)
-			return err
-		}
-		if len(groups.AutoScalingGroups) < 1 {
-			return fmt.Errorf("Unable to get first autoscaling.Group for %s", asg.config.Name)
+		configs[asg.config.Name] = asg.config
+		names = append(names, asg.config.Name)
+	}
+	errs := make([]error, 0)
+	groups, err := m.describeAsgs(names)
+	if err != nil {
+		glog.V(4).Infof("Failed ASG info request for %v: %v", names, err)
+		errs = append(errs, err)
+	}
+	for _, group := range groups {
+		config, found := configs[*group.AutoScalingGroupName]
+		if !found {
+			continue
 		}
-		group := *groups.AutoScalingGroups[0]
-
+		delete(configs, config.Name)
 		for _, instance := range group.Instances {
 			ref := AwsRef{Name: *instance.InstanceId}
-			newCache[ref] = asg.config
+			newCache[ref] = config
+		}
+	}
+	// ASGs that couldn't be described keep their cached instances until the next refresh, so
+	// that the instances are not taken for terminated ones.
+	for ref, asg := range m.asgCache {
+		if _, found := configs[asg.Name]; found {
+			newCache[ref] = asg
+		}
+	}
+	if err == nil {
+		for name := range configs {
+			errs = append(errs, fmt.Errorf("Unable to get autoscaling.Group for %s", name))
 		}
 	}
 
+	m.reconcileTerminatedInstances(newCache)
 	m.asgCache = newCache
+	if len(errs) > 0 {
+		return utilerrors.NewAggregate(errs)
+	}
+	m.lastCacheRefresh = m.now()
 	return nil
 }
+
+// reconcileTerminatedInstances finds instances that are missing from the regenerated cache. Those
+// terminated by CA are expected to disappear. The others were terminated outside of CA, e.g. by a
+// spot interruption, and the ASG replaces them without any change to its desired capacity, so the
+// cached size of their ASG is dropped instead of being adjusted for the lost instance.
+func (m *AwsManager) reconcileTerminatedInstances(newCache map[AwsRef]*Asg) {
+	if m.externallyTerminated == nil {
+		m.externallyTerminated = make(map[AwsRef]time.Time)
+	}
+	now := m.now()
+	for ref, asg := range m.asgCache {
+		if _, found := newCache[ref]; found {
+			continue
+		}
+		if m.deletedInstances[ref] {
+			delete(m.deletedInstances, ref)
+			continue
+		}
+		glog.Warningf("Instance %s was terminated outside of the autoscaler, e.g. by a spot interruption, ASG: %s", ref.Name, asg.Name)
+		m.externallyTerminated[ref] = now
+		m.invalidateSize(asg.Name)
+	}
+	for ref, terminated := range m.externallyTerminated {
+		if terminated.Add(externallyTerminatedRetention).Before(now) {
+			delete(m.externallyTerminated, ref)
+		}
+	}
+}
+
+// now returns the current time of the manager's clock.
+func (m *AwsManager) now() time.Time {
+	if m.clock == nil {
+		return clock.RealClock{}.Now()
+	}
+	return m.clock.Now()
+}
+
+// IsTerminatedExternally returns true if the instance disappeared from its ASG without being
+// terminated by CA.
+func (m *AwsManager) IsTerminatedExternally(instance *AwsRef) bool {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	_, found := m.externallyTerminated[*instance]
+	return found
+}
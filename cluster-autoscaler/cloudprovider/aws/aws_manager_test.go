@@ -0,0 +1,323 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"k8s.io/contrib/cluster-autoscaler/utils/clock"
+	kube_api "k8s.io/kubernetes/pkg/api"
+	provider_aws "k8s.io/kubernetes/pkg/cloudprovider/providers/aws"
+)
+
+func TestDetectRegion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/latest/meta-data/placement/availability-zone" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("eu-west-1b"))
+	}))
+	defer server.Close()
+
+	awsSession := session.New(&aws.Config{Region: aws.String("")})
+	metadata := ec2metadata.New(awsSession, &aws.Config{
+		Endpoint:   aws.String(server.URL + "/latest"),
+		MaxRetries: aws.Int(0),
+	})
+	cfg := &provider_aws.AWSCloudConfig{}
+
+	// Without any configured region the instance metadata service is used.
+	assert.Equal(t, "eu-west-1", detectRegion(awsSession, cfg, metadata))
+
+	cfg.Global.Zone = "us-west-2c"
+	assert.Equal(t, "us-west-2", detectRegion(awsSession, cfg, metadata))
+
+	configuredSession := session.New(&aws.Config{Region: aws.String("us-east-1")})
+	assert.Equal(t, "us-east-1", detectRegion(configuredSession, cfg, metadata))
+
+	// The instance metadata service is unavailable outside of EC2.
+	server.Close()
+	assert.Equal(t, "", detectRegion(awsSession, &provider_aws.AWSCloudConfig{}, metadata))
+}
+
+func TestDescribeAsgsBatchSize(t *testing.T) {
+	names := make([]string, 0)
+	for i := 0; i < 120; i++ {
+		names = append(names, fmt.Sprintf("test-asg-%d", i))
+	}
+	for batchSize, calls := range map[int]int{1: 120, 30: 4, 50: 3, 100: 2} {
+		service := &AutoScalingMock{}
+		m := &AwsManager{service: service, describeBatchSize: batchSize}
+		groups, err := m.describeAsgs(names)
+		assert.NoError(t, err)
+		assert.Equal(t, 120, len(groups))
+		assert.Equal(t, calls, service.describeCalls, "batch size %d", batchSize)
+	}
+
+	assert.NoError(t, validateDescribeBatchSize(100))
+	assert.Error(t, validateDescribeBatchSize(0))
+	assert.Error(t, validateDescribeBatchSize(101))
+}
+
+func TestRegenerateCacheConcurrently(t *testing.T) {
+	service := &AutoScalingMock{
+		describeDelay:  50 * time.Millisecond,
+		asgInstanceIds: make(map[string][]string),
+	}
+	m := &AwsManager{
+		asgs:              make([]*asgInformation, 0),
+		service:           service,
+		asgCache:          make(map[AwsRef]*Asg),
+		describeBatchSize: 1,
+		refreshWorkers:    2,
+	}
+	asgs := make([]*Asg, 0)
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("test-asg-%d", i)
+		asg := &Asg{AwsRef: AwsRef{Name: name}, awsManager: m, minSize: 1, maxSize: 5}
+		m.RegisterAsg(asg)
+		asgs = append(asgs, asg)
+		service.asgInstanceIds[name] = []string{name + "-instance"}
+	}
+	assert.NoError(t, m.regenerateCache())
+	assert.Equal(t, 4, service.describeCalls)
+	assert.Equal(t, 2, service.maxDescribeInFlight)
+	assert.Equal(t, 4, len(m.asgCache))
+
+	// A failing ASG keeps its cached instances and doesn't stop the refresh of the others.
+	service.failingAsgs = map[string]bool{"test-asg-1": true}
+	service.asgInstanceIds["test-asg-1"] = []string{"replaced-instance"}
+	service.asgInstanceIds["test-asg-2"] = []string{"new-instance"}
+	err := m.regenerateCache()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "test-asg-1")
+	assert.Equal(t, asgs[1], m.asgCache[AwsRef{Name: "test-asg-1-instance"}])
+	assert.Equal(t, asgs[2], m.asgCache[AwsRef{Name: "new-instance"}])
+	assert.NotContains(t, m.asgCache, AwsRef{Name: "test-asg-2-instance"})
+	assert.Equal(t, 4, len(m.asgCache))
+}
+
+func TestRefresh(t *testing.T) {
+	service := &AutoScalingMock{asgInstanceIds: map[string][]string{"test-asg": {"test-instance"}}}
+	fakeClock := clock.NewFakeClock(time.Now())
+	m := &AwsManager{
+		asgs:     make([]*asgInformation, 0),
+		service:  service,
+		asgCache: make(map[AwsRef]*Asg),
+		clock:    fakeClock,
+	}
+	asg := &Asg{AwsRef: AwsRef{Name: "test-asg"}, awsManager: m, minSize: 1, maxSize: 5}
+	m.RegisterAsg(asg)
+
+	// The cache is regenerated by the first refresh.
+	assert.NoError(t, m.Refresh())
+	assert.Equal(t, 1, service.describeCalls)
+	assert.Equal(t, asg, m.asgCache[AwsRef{Name: "test-instance"}])
+
+	// It is kept until it gets stale.
+	service.asgInstanceIds["test-asg"] = []string{"new-instance"}
+	fakeClock.Step(cacheRefreshInterval - time.Minute)
+	assert.NoError(t, m.Refresh())
+	assert.Equal(t, 1, service.describeCalls)
+	assert.Contains(t, m.asgCache, AwsRef{Name: "test-instance"})
+
+	fakeClock.Step(time.Minute)
+	assert.NoError(t, m.Refresh())
+	assert.Equal(t, 2, service.describeCalls)
+	assert.Equal(t, asg, m.asgCache[AwsRef{Name: "new-instance"}])
+	assert.NotContains(t, m.asgCache, AwsRef{Name: "test-instance"})
+
+	// A failed regeneration is retried by the next refresh.
+	fakeClock.Step(cacheRefreshInterval)
+	service.failingAsgs = map[string]bool{"test-asg": true}
+	assert.Error(t, m.Refresh())
+	service.failingAsgs = nil
+	assert.NoError(t, m.Refresh())
+	assert.Equal(t, 4, service.describeCalls)
+}
+
+func TestCleanupStopsDiscovery(t *testing.T) {
+	service := &AutoScalingMock{
+		tags: map[string]map[string]string{
+			"discovered-asg": {"k8s.io/cluster-autoscaler": ""},
+		},
+	}
+	m := &AwsManager{
+		asgs:          make([]*asgInformation, 0),
+		service:       service,
+		asgCache:      make(map[AwsRef]*Asg),
+		discoveryTags: []string{"k8s.io/cluster-autoscaler"},
+		stopCh:        make(chan struct{}),
+	}
+	provider, err := BuildAwsCloudProvider(m, nil)
+	assert.NoError(t, err)
+
+	m.runDiscovery(10 * time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(provider.NodeGroups()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, len(provider.NodeGroups()))
+
+	assert.NoError(t, provider.Cleanup())
+	// Cleanup can be called again, e.g. by a second signal.
+	assert.NoError(t, provider.Cleanup())
+
+	// Newly tagged ASGs are no longer discovered.
+	service.tags["new-asg"] = map[string]string{"k8s.io/cluster-autoscaler": ""}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, len(provider.NodeGroups()))
+}
+
+func TestGetAsgScaleUpFailure(t *testing.T) {
+	now := time.Now()
+	service := &AutoScalingMock{
+		activities: map[string][]*autoscaling.Activity{
+			"test-asg": {
+				{
+					Description:   aws.String("Launching a new EC2 instance.  Status Reason: Could not launch On-Demand Instances."),
+					StatusCode:    aws.String(autoscaling.ScalingActivityStatusCodeFailed),
+					StatusMessage: aws.String("InsufficientInstanceCapacity - We currently do not have sufficient m4.large capacity."),
+					StartTime:     aws.Time(now),
+				},
+				{
+					Description: aws.String("Launching a new EC2 instance: i-1"),
+					StatusCode:  aws.String(autoscaling.ScalingActivityStatusCodeSuccessful),
+					StartTime:   aws.Time(now.Add(-time.Minute)),
+				},
+			},
+		},
+	}
+	m := &AwsManager{service: service}
+	asg := &Asg{Name: "test-asg"}
+
+	failure, err := m.GetAsgScaleUpFailure(asg, now.Add(-2*time.Minute))
+	assert.NoError(t, err)
+	assert.Contains(t, failure, "InsufficientInstanceCapacity")
+
+	// Failures of scale ups requested before the given time are not reported.
+	failure, err = m.GetAsgScaleUpFailure(asg, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Empty(t, failure)
+	failure, err = m.GetAsgScaleUpFailure(&Asg{Name: "other-asg"}, now.Add(-2*time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, failure)
+}
+
+func TestWarmPoolCache(t *testing.T) {
+	fakeClock := clock.NewFakeClock(time.Now())
+	service := &AutoScalingMock{
+		warmPools: map[string][]string{
+			"warm-asg": {"Warmed:Stopped", "Warmed:Stopped", "Warmed:Running"},
+		},
+		desiredCapacities: map[string]int64{},
+	}
+	m := &AwsManager{
+		asgs:      make([]*asgInformation, 0),
+		service:   service,
+		asgCache:  make(map[AwsRef]*Asg),
+		warmPools: true,
+		clock:     fakeClock,
+	}
+	provider, err := BuildAwsCloudProvider(m, []string{"1:5:warm-asg", "1:5:cold-asg"})
+	assert.NoError(t, err)
+	warmAsg := provider.asgs[0]
+
+	assert.NoError(t, provider.RefreshSizes())
+	assert.Equal(t, 2, service.warmPoolCalls)
+	assert.Equal(t, 3, warmAsg.WarmPoolSize())
+
+	// Warm pools are served from the cache until they expire.
+	assert.NoError(t, provider.RefreshSizes())
+	assert.Equal(t, 2, service.warmPoolCalls)
+
+	// Instances added by the autoscaler are taken from the cached warm pool.
+	service.On("SetDesiredCapacity", &autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: aws.String("warm-asg"),
+		DesiredCapacity:      aws.Int64(4),
+		HonorCooldown:        aws.Bool(false),
+	}).Return(&autoscaling.SetDesiredCapacityOutput{})
+	assert.NoError(t, warmAsg.IncreaseSize(2))
+	assert.Equal(t, 1, warmAsg.WarmPoolSize())
+	service.desiredCapacities["warm-asg"] = 4
+	assert.NoError(t, provider.RefreshSizes())
+	assert.Equal(t, 2, service.warmPoolCalls)
+	assert.Equal(t, 1, warmAsg.WarmPoolSize())
+
+	// A change of the desired capacity outside of the autoscaler refreshes the warm pool of the ASG.
+	service.warmPools["warm-asg"] = []string{"Warmed:Stopped"}
+	service.desiredCapacities["warm-asg"] = 5
+	assert.NoError(t, provider.RefreshSizes())
+	assert.Equal(t, 3, service.warmPoolCalls)
+	assert.Equal(t, 1, warmAsg.WarmPoolSize())
+
+	fakeClock.Step(warmPoolCacheTTL + time.Second)
+	assert.NoError(t, provider.RefreshSizes())
+	assert.Equal(t, 5, service.warmPoolCalls)
+}
+
+func TestReadAndWriteRetryPolicies(t *testing.T) {
+	awsSession := session.New(&aws.Config{Region: aws.String("us-east-1")})
+	assert.Equal(t, 10, newAutoScalingService(awsSession, 10).MaxRetries())
+	assert.Equal(t, 0, newAutoScalingService(awsSession, 0).MaxRetries())
+	assert.Equal(t, 3, newAutoScalingService(awsSession, aws.UseServiceDefaultRetries).MaxRetries())
+
+	readService := &AutoScalingMock{}
+	writeService := &AutoScalingMock{}
+	m := &AwsManager{
+		asgs:         make([]*asgInformation, 0),
+		service:      readService,
+		writeService: writeService,
+		asgCache:     make(map[AwsRef]*Asg),
+	}
+	provider := testProvider(t, m)
+	assert.NoError(t, provider.addNodeGroup("1:5:test-asg"))
+	writeService.On("SetDesiredCapacity", &autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: aws.String("test-asg"),
+		DesiredCapacity:      aws.Int64(3),
+		HonorCooldown:        aws.Bool(false),
+	}).Return(&autoscaling.SetDesiredCapacityOutput{})
+	writeService.On("TerminateInstanceInAutoScalingGroup", &autoscaling.TerminateInstanceInAutoScalingGroupInput{
+		InstanceId:                     aws.String("test-instance-id"),
+		ShouldDecrementDesiredCapacity: aws.Bool(true),
+	}).Return(&autoscaling.TerminateInstanceInAutoScalingGroupOutput{
+		Activity: &autoscaling.Activity{Description: aws.String("Deleted instance")},
+	})
+
+	// The size is described with the read service and changed with the write service.
+	assert.NoError(t, provider.asgs[0].IncreaseSize(1))
+	assert.Equal(t, 1, readService.describeCalls)
+	writeService.AssertNumberOfCalls(t, "SetDesiredCapacity", 1)
+
+	node := &kube_api.Node{Spec: kube_api.NodeSpec{ProviderID: "aws:///us-east-1a/test-instance-id"}}
+	assert.NoError(t, provider.asgs[0].DeleteNodes([]*kube_api.Node{node}))
+	writeService.AssertNumberOfCalls(t, "TerminateInstanceInAutoScalingGroup", 1)
+	assert.Equal(t, 0, writeService.describeCalls)
+	readService.AssertNotCalled(t, "SetDesiredCapacity", mock.Anything)
+	readService.AssertNotCalled(t, "TerminateInstanceInAutoScalingGroup", mock.Anything)
+}
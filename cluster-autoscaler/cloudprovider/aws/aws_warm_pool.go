@@ -0,0 +1,191 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/golang/glog"
+	utilerrors "k8s.io/kubernetes/pkg/util/errors"
+	"k8s.io/kubernetes/pkg/util/workqueue"
+)
+
+const (
+	// maxWarmPoolInstancesPerDescribe is the maximum number of instances AWS returns from a single
+	// DescribeWarmPool call.
+	maxWarmPoolInstancesPerDescribe = 100
+	// warmPoolCacheTTL is how long the sizes of warm pools are cached by RefreshSizes, unless the
+	// desired capacity of their ASG changes in the meantime.
+	warmPoolCacheTTL = 5 * time.Minute
+)
+
+// cachedWarmPool is the number of warmed instances in the warm pool of an ASG and the time it was
+// described.
+type cachedWarmPool struct {
+	warmed    int64
+	fetchTime time.Time
+}
+
+// warmedLifecycleStates are the lifecycle states of warm pool instances that finished warming up
+// and are ready to be brought into service.
+var warmedLifecycleStates = map[string]bool{
+	"Warmed:Stopped":    true,
+	"Warmed:Running":    true,
+	"Warmed:Hibernated": true,
+}
+
+// The vendored sdk predates warm pools, so the structures below mirror the parts of the
+// DescribeWarmPool api needed to count the instances of a warm pool.
+
+type describeWarmPoolInput struct {
+	_ struct{} `type:"structure"`
+
+	AutoScalingGroupName *string `min:"1" type:"string" required:"true"`
+	MaxRecords           *int64  `type:"integer"`
+	NextToken            *string `type:"string"`
+}
+
+type describeWarmPoolOutput struct {
+	_ struct{} `type:"structure"`
+
+	Instances             []*warmPoolInstance    `type:"list"`
+	NextToken             *string                `type:"string"`
+	WarmPoolConfiguration *warmPoolConfiguration `type:"structure"`
+}
+
+type warmPoolConfiguration struct {
+	_ struct{} `type:"structure"`
+
+	MaxGroupPreparedCapacity *int64  `type:"integer"`
+	MinSize                  *int64  `type:"integer"`
+	PoolState                *string `type:"string"`
+	Status                   *string `type:"string"`
+}
+
+type warmPoolInstance struct {
+	_ struct{} `type:"structure"`
+
+	InstanceId     *string `min:"1" type:"string"`
+	LifecycleState *string `type:"string"`
+}
+
+// DescribeWarmPool describes the warm pool of an ASG and its instances.
+func (s autoScalingService) DescribeWarmPool(input *describeWarmPoolInput) (*describeWarmPoolOutput, error) {
+	op := &request.Operation{
+		Name:       "DescribeWarmPool",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	output := &describeWarmPoolOutput{}
+	if err := s.NewRequest(op, input, output).Send(); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// refreshWarmPools returns the warm pools of the given ASGs, keyed by ASG name, describing only the
+// ones not in cached, described more than warmPoolCacheTTL ago, or whose desired capacity changed
+// between previousSizes and sizes, as instances may have left or joined the pool. Warm pools that
+// fail to be described are left out, so that they are retried by the next refresh.
+func (m *AwsManager) refreshWarmPools(names []string, cached map[string]*cachedWarmPool, previousSizes, sizes map[string]int64) map[string]*cachedWarmPool {
+	now := m.now()
+	result := make(map[string]*cachedWarmPool)
+	stale := make([]string, 0)
+	for _, name := range names {
+		pool, found := cached[name]
+		previous, sizeKnown := previousSizes[name]
+		if !found || pool.fetchTime.Add(warmPoolCacheTTL).Before(now) || (sizeKnown && previous != sizes[name]) {
+			stale = append(stale, name)
+			continue
+		}
+		result[name] = pool
+	}
+	if len(stale) == 0 {
+		return result
+	}
+	warmed, err := m.describeWarmPools(stale)
+	if err != nil {
+		// Missing warm pools only make scale up slower, so they don't fail the refresh.
+		glog.Warningf("Failed to describe warm pools: %v", err)
+	}
+	for name, count := range warmed {
+		result[name] = &cachedWarmPool{warmed: count, fetchTime: now}
+	}
+	return result
+}
+
+// describeWarmPools returns the number of warmed instances in the warm pool of every given ASG,
+// keyed by ASG name. ASGs without a warm pool have none. Warm pools of failed ASGs are skipped
+// and the errors aggregated.
+func (m *AwsManager) describeWarmPools(names []string) (map[string]int64, error) {
+	workers := m.refreshWorkers
+	if workers == 0 {
+		workers = DefaultAsgRefreshWorkers
+	}
+	if workers > len(names) {
+		workers = len(names)
+	}
+
+	var resultMutex sync.Mutex
+	result := make(map[string]int64)
+	errs := make([]error, 0)
+	workqueue.Parallelize(workers, len(names), func(piece int) {
+		warmed, err := m.describeWarmPool(names[piece])
+		resultMutex.Lock()
+		defer resultMutex.Unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to describe warm pool of %s: %v", names[piece], err))
+			return
+		}
+		result[names[piece]] = warmed
+	})
+	return result, utilerrors.NewAggregate(errs)
+}
+
+// describeWarmPool returns the number of warmed instances in the warm pool of the ASG, following
+// NextToken until all instances are returned. Instances that are still warming up or are being
+// removed from the pool are not counted.
+func (m *AwsManager) describeWarmPool(name string) (int64, error) {
+	params := &describeWarmPoolInput{
+		AutoScalingGroupName: aws.String(name),
+		MaxRecords:           aws.Int64(maxWarmPoolInstancesPerDescribe),
+	}
+	var warmed int64
+	for {
+		output, err := m.service.DescribeWarmPool(params)
+		if err != nil {
+			return 0, err
+		}
+		if output.WarmPoolConfiguration == nil {
+			return 0, nil
+		}
+		for _, instance := range output.Instances {
+			if warmedLifecycleStates[aws.StringValue(instance.LifecycleState)] {
+				warmed++
+			}
+		}
+		if output.NextToken == nil || *output.NextToken == "" {
+			break
+		}
+		params.NextToken = output.NextToken
+	}
+	return warmed, nil
+}
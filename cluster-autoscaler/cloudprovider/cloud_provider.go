@@ -17,6 +17,10 @@ limitations under the License.
 package cloudprovider
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	kube_api "k8s.io/kubernetes/pkg/api"
 )
 
@@ -33,6 +37,24 @@ type CloudProvider interface {
 	// should not be processed by cluster autoscaler, or non-nil error if such
 	// occurred.
 	NodeGroupForNode(*kube_api.Node) (NodeGroup, error)
+
+	// NodeGroupsForNodes returns the node groups of the given nodes, keyed by node name. Unlike
+	// calling NodeGroupForNode for every node, it resolves all nodes in one pass over the cached
+	// group membership. Nodes that should not be processed by cluster autoscaler are omitted.
+	NodeGroupsForNodes([]*kube_api.Node) (map[string]NodeGroup, error)
+
+	// RefreshSizes fetches the target sizes of all node groups in as few calls as possible and
+	// caches them, so that TargetSize calls during a single loop don't hit the cloud api for
+	// every node group. Resizing a node group invalidates its cached size.
+	RefreshSizes() error
+
+	// Refresh is called once at the start of every scan, before RefreshSizes, to update the
+	// cached state of the cloud provider synchronously instead of in background goroutines.
+	Refresh() error
+
+	// Cleanup stops all background work of the cloud provider. It is called before cluster
+	// autoscaler exits.
+	Cleanup() error
 }
 
 // NodeGroup contains configuration info and functions to control a set
@@ -55,6 +77,11 @@ type NodeGroup interface {
 	// node group size is updated.
 	IncreaseSize(delta int) error
 
+	// DecreaseTargetSize decreases the target size of the node group. This function
+	// doesn't permit to delete any existing node and can be used only to reduce the
+	// request for new nodes that have not been yet fulfilled. Delta should be negative.
+	DecreaseTargetSize(delta int) error
+
 	// DeleteNodes deletes nodes from this node group. Error is returned either on
 	// failure or if the given node doesn't belong to this node group. This function
 	// should wait until node group size is updated.
@@ -66,3 +93,58 @@ type NodeGroup interface {
 	// Debug returns a string containing all information regarding this node group.
 	Debug() string
 }
+
+// PrioritizedNodeGroup is implemented by node groups whose priority for the priority expander can
+// be set along with the node group itself, e.g. with an ASG tag or MIG instance template metadata.
+type PrioritizedNodeGroup interface {
+	// Priority returns the priority of the node group and true, or false if none is set.
+	Priority() (int, bool)
+}
+
+// TemplatedNodeGroup is implemented by node groups that declare the labels, taints and resources
+// their nodes register with, e.g. with ASG tags, so that the template nodes of node groups scaled
+// down to zero have them.
+type TemplatedNodeGroup interface {
+	// TemplateLabels returns the labels of the nodes of the node group.
+	TemplateLabels() map[string]string
+	// TemplateCapacity returns the capacity of the nodes of the node group that isn't known from
+	// sampled nodes, e.g. the ephemeral storage derived from the root volume of the instances.
+	TemplateCapacity() kube_api.ResourceList
+	// TemplateTaints returns the taints of the nodes of the node group.
+	TemplateTaints() []kube_api.Taint
+}
+
+// WarmPoolNodeGroup is implemented by node groups that keep pre-initialized instances, e.g. in an
+// ASG warm pool, and bring them into service first when their target size grows. Such instances
+// don't count towards the target size, and their nodes are ready much sooner than new ones.
+type WarmPoolNodeGroup interface {
+	// WarmPoolSize returns the number of pre-initialized instances ready to be brought into service.
+	WarmPoolSize() int
+}
+
+// ScaleUpFailureNodeGroup is implemented by node groups whose cloud provider reports failures to
+// launch requested instances only after the target size was increased, e.g. in the scaling
+// activities of an ASG when EC2 has no capacity for its instance type.
+type ScaleUpFailureNodeGroup interface {
+	// ScaleUpFailure returns the reason why instances requested since the given time failed to
+	// launch, or an empty string if none failed.
+	ScaleUpFailure(since time.Time) (string, error)
+}
+
+// CheckDuplicateNodeGroups returns an error listing the ids of node groups that are configured
+// more than once, e.g. by two --nodes specs pointing at the same ASG or MIG.
+func CheckDuplicateNodeGroups(nodeGroups []NodeGroup) error {
+	seen := make(map[string]int)
+	duplicates := make([]string, 0)
+	for _, nodeGroup := range nodeGroups {
+		id := nodeGroup.Id()
+		seen[id]++
+		if seen[id] == 2 {
+			duplicates = append(duplicates, id)
+		}
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("node groups configured more than once: %s", strings.Join(duplicates, ", "))
+	}
+	return nil
+}
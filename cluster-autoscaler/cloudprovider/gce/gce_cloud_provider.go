@@ -23,6 +23,8 @@ import (
 
 	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
 	kube_api "k8s.io/kubernetes/pkg/api"
+
+	"github.com/golang/glog"
 )
 
 // GceCloudProvider implements CloudProvider interface.
@@ -42,6 +44,9 @@ func BuildGceCloudProvider(gceManager *GceManager, specs []string) (*GceCloudPro
 			return nil, err
 		}
 	}
+	if err := cloudprovider.CheckDuplicateNodeGroups(gce.NodeGroups()); err != nil {
+		return nil, err
+	}
 	return gce, nil
 }
 
@@ -73,6 +78,12 @@ func (gce *GceCloudProvider) NodeGroups() []cloudprovider.NodeGroup {
 
 // NodeGroupForNode returns the node group for the given node.
 func (gce *GceCloudProvider) NodeGroupForNode(node *kube_api.Node) (cloudprovider.NodeGroup, error) {
+	if node.Spec.ProviderID == "" {
+		// ProviderID is set by the cloud controller shortly after the node registers. Until then
+		// the node cannot be matched with a node group, so it is ignored.
+		glog.V(2).Infof("Node %s has no ProviderID yet, skipping", node.Name)
+		return nil, nil
+	}
 	ref, err := GceRefFromProviderId(node.Spec.ProviderID)
 	if err != nil {
 		return nil, err
@@ -81,6 +92,50 @@ func (gce *GceCloudProvider) NodeGroupForNode(node *kube_api.Node) (cloudprovide
 	return mig, err
 }
 
+// NodeGroupsForNodes returns the node groups of the given nodes, keyed by node name.
+func (gce *GceCloudProvider) NodeGroupsForNodes(nodes []*kube_api.Node) (map[string]cloudprovider.NodeGroup, error) {
+	refs := make(map[string]*GceRef)
+	instances := make([]*GceRef, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Spec.ProviderID == "" {
+			glog.V(2).Infof("Node %s has no ProviderID yet, skipping", node.Name)
+			continue
+		}
+		ref, err := GceRefFromProviderId(node.Spec.ProviderID)
+		if err != nil {
+			return nil, err
+		}
+		refs[node.Name] = ref
+		instances = append(instances, ref)
+	}
+	migs, err := gce.gceManager.GetMigsForInstances(instances)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]cloudprovider.NodeGroup)
+	for name, ref := range refs {
+		if mig, found := migs[*ref]; found {
+			result[name] = mig
+		}
+	}
+	return result, nil
+}
+
+// Refresh regenerates the cache of MIG instances if it is due.
+func (gce *GceCloudProvider) Refresh() error {
+	return gce.gceManager.Refresh()
+}
+
+// Cleanup stops the background work of the GCE manager.
+func (gce *GceCloudProvider) Cleanup() error {
+	return gce.gceManager.Cleanup()
+}
+
+// RefreshSizes fetches the sizes of all MIGs in bulk and caches them until the next refresh.
+func (gce *GceCloudProvider) RefreshSizes() error {
+	return gce.gceManager.RefreshSizes()
+}
+
 // GceRef contains s reference to some entity in GCE/GKE world.
 type GceRef struct {
 	Project string
@@ -88,20 +143,28 @@ type GceRef struct {
 	Name    string
 }
 
-// GceRefFromProviderId creates InstanceConfig object
-// from provider id which must be in format:
-// gce://<project-id>/<zone>/<name>
-// TODO(piosz): add better check whether the id is correct
+// GceRefFromProviderId creates GceRef object from provider id. Both the legacy format
+// gce://<project-id>/<zone>/<name> and the resource path format
+// gce://projects/<project-id>/zones/<zone>/instances/<name> are accepted.
 func GceRefFromProviderId(id string) (*GceRef, error) {
-	splitted := strings.Split(id[6:], "/")
-	if len(splitted) != 3 {
-		return nil, fmt.Errorf("Wrong id: expected format gce://<project-id>/<zone>/<name>, got %v", id)
+	errMsg := fmt.Errorf("Wrong id: expected format %s<project-id>/<zone>/<name>, got %v", gceProviderIdPrefix, id)
+	if !strings.HasPrefix(id, gceProviderIdPrefix) {
+		return nil, errMsg
+	}
+	splitted := strings.Split(strings.TrimPrefix(id, gceProviderIdPrefix), "/")
+	var ref GceRef
+	switch {
+	case len(splitted) == 3:
+		ref = GceRef{Project: splitted[0], Zone: splitted[1], Name: splitted[2]}
+	case len(splitted) == 6 && splitted[0] == "projects" && splitted[2] == "zones" && splitted[4] == "instances":
+		ref = GceRef{Project: splitted[1], Zone: splitted[3], Name: splitted[5]}
+	default:
+		return nil, errMsg
 	}
-	return &GceRef{
-		Project: splitted[0],
-		Zone:    splitted[1],
-		Name:    splitted[2],
-	}, nil
+	if ref.Project == "" || ref.Zone == "" || ref.Name == "" {
+		return nil, errMsg
+	}
+	return &ref, nil
 }
 
 // Mig implements NodeGroup interfrace.
@@ -146,6 +209,28 @@ func (mig *Mig) IncreaseSize(delta int) error {
 	return mig.gceManager.SetMigSize(mig, size+int64(delta))
 }
 
+// DecreaseTargetSize decreases the target size of the MIG. It fails instead of decreasing the size
+// below the number of existing instances of the MIG, as GCE would delete some of them. Delta should
+// be negative.
+func (mig *Mig) DecreaseTargetSize(delta int) error {
+	if delta >= 0 {
+		return fmt.Errorf("size decrease must be negative")
+	}
+	size, err := mig.gceManager.GetMigSize(mig)
+	if err != nil {
+		return err
+	}
+	instances, err := mig.gceManager.GetMigInstanceCount(mig)
+	if err != nil {
+		return err
+	}
+	if size+int64(delta) < instances {
+		return fmt.Errorf("attempt to delete existing nodes targetSize:%d delta:%d existingNodes: %d",
+			size, delta, instances)
+	}
+	return mig.gceManager.SetMigSize(mig, size+int64(delta))
+}
+
 // Belongs returns true if the given node belongs to the NodeGroup.
 func (mig *Mig) Belongs(node *kube_api.Node) (bool, error) {
 	ref, err := GceRefFromProviderId(node.Spec.ProviderID)
@@ -193,6 +278,26 @@ func (mig *Mig) DeleteNodes(nodes []*kube_api.Node) error {
 	return mig.gceManager.DeleteInstances(refs)
 }
 
+// Priority returns the priority of the Mig set with PriorityMetadataKey, if any.
+func (mig *Mig) Priority() (int, bool) {
+	return mig.gceManager.GetMigPriority(mig)
+}
+
+// TemplateLabels returns the labels set with TemplateLabelsMetadataKey, if any.
+func (mig *Mig) TemplateLabels() map[string]string {
+	return mig.gceManager.GetMigTemplateLabels(mig)
+}
+
+// TemplateCapacity returns nil, capacity can't be declared for Migs.
+func (mig *Mig) TemplateCapacity() kube_api.ResourceList {
+	return nil
+}
+
+// TemplateTaints returns nil, taints can't be declared for Migs.
+func (mig *Mig) TemplateTaints() []kube_api.Taint {
+	return nil
+}
+
 // Id returns mig url.
 func (mig *Mig) Id() string {
 	return GenerateMigUrl(mig.Project, mig.Zone, mig.Name)
@@ -213,8 +318,8 @@ func buildMig(value string, gceManager *GceManager) (*Mig, error) {
 		gceManager: gceManager,
 	}
 	if size, err := strconv.Atoi(tokens[0]); err == nil {
-		if size <= 0 {
-			return nil, fmt.Errorf("min size must be >= 1")
+		if size < 0 {
+			return nil, fmt.Errorf("min size must be >= 0")
 		}
 		mig.minSize = size
 	} else {
@@ -19,6 +19,8 @@ package gce
 import (
 	"testing"
 
+	kube_api "k8s.io/kubernetes/pkg/api"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,6 +33,10 @@ func TestBuildMig(t *testing.T) {
 	assert.Error(t, err)
 	_, err = buildMig("1:2:", nil)
 	assert.Error(t, err)
+	_, err = buildMig("-1:2:https://content.googleapis.com/compute/v1/projects/test-project/zones/test-zone/instanceGroups/test-name", nil)
+	assert.Error(t, err)
+	_, err = buildMig("0:2:https://content.googleapis.com/compute/v1/projects/test-project/zones/test-zone/instanceGroups/test-name", nil)
+	assert.NoError(t, err)
 
 	mig, err := buildMig("111:222:https://content.googleapis.com/compute/v1/projects/test-project/zones/test-zone/instanceGroups/test-name", nil)
 	assert.NoError(t, err)
@@ -39,3 +45,100 @@ func TestBuildMig(t *testing.T) {
 	assert.Equal(t, "test-zone", mig.Zone)
 	assert.Equal(t, "test-name", mig.Name)
 }
+
+func TestBuildGceCloudProviderDuplicateMig(t *testing.T) {
+	m := &GceManager{
+		migs:     make([]*migInformation, 0),
+		migCache: make(map[GceRef]*Mig),
+	}
+	url := "https://content.googleapis.com/compute/v1/projects/test-project/zones/test-zone/instanceGroups/test-name"
+	_, err := BuildGceCloudProvider(m, []string{"1:5:" + url, "2:10:" + url})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), url)
+
+	_, err = BuildGceCloudProvider(m, []string{"1:5:" + url})
+	assert.NoError(t, err)
+}
+
+func TestNodeGroupForNodeWithoutProviderId(t *testing.T) {
+	m := &GceManager{
+		migs:     make([]*migInformation, 0),
+		migCache: make(map[GceRef]*Mig),
+	}
+	provider, err := BuildGceCloudProvider(m, nil)
+	assert.NoError(t, err)
+
+	group, err := provider.NodeGroupForNode(&kube_api.Node{ObjectMeta: kube_api.ObjectMeta{Name: "bootstrapping"}})
+	assert.NoError(t, err)
+	assert.Nil(t, group)
+}
+
+func TestNodeGroupsForNodes(t *testing.T) {
+	m := &GceManager{
+		migs:     make([]*migInformation, 0),
+		migCache: make(map[GceRef]*Mig),
+	}
+	url := "https://content.googleapis.com/compute/v1/projects/test-project/zones/test-zone/instanceGroups/test-name"
+	provider, err := BuildGceCloudProvider(m, []string{"1:5:" + url})
+	assert.NoError(t, err)
+	mig := provider.migs[0]
+	m.migs[0].basename = "test-name"
+	m.migCache[GceRef{Project: "test-project", Zone: "test-zone", Name: "test-name-a"}] = mig
+	m.migCache[GceRef{Project: "test-project", Zone: "test-zone", Name: "test-name-b"}] = mig
+
+	nodes := []*kube_api.Node{
+		{
+			ObjectMeta: kube_api.ObjectMeta{Name: "a"},
+			Spec:       kube_api.NodeSpec{ProviderID: "gce://test-project/test-zone/test-name-a"},
+		},
+		{
+			ObjectMeta: kube_api.ObjectMeta{Name: "b"},
+			Spec:       kube_api.NodeSpec{ProviderID: "gce://test-project/test-zone/test-name-b"},
+		},
+		{
+			ObjectMeta: kube_api.ObjectMeta{Name: "other-zone"},
+			Spec:       kube_api.NodeSpec{ProviderID: "gce://test-project/other-zone/test-name-c"},
+		},
+		{
+			ObjectMeta: kube_api.ObjectMeta{Name: "bootstrapping"},
+		},
+	}
+	groups, err := provider.NodeGroupsForNodes(nodes)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(groups))
+
+	for _, node := range nodes {
+		group, err := provider.NodeGroupForNode(node)
+		assert.NoError(t, err)
+		if group == nil || group.(*Mig) == nil {
+			assert.NotContains(t, groups, node.Name)
+		} else {
+			assert.Equal(t, group, groups[node.Name])
+		}
+	}
+}
+
+func TestGceRefFromProviderId(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected *GceRef
+	}{
+		{"gce://project1/us-central1-b/instance1", &GceRef{Project: "project1", Zone: "us-central1-b", Name: "instance1"}},
+		{"gce://projects/project1/zones/us-central1-b/instances/instance1", &GceRef{Project: "project1", Zone: "us-central1-b", Name: "instance1"}},
+		{"gce://project1/instance1", nil},
+		{"gce://project1//instance1", nil},
+		{"gce://projects/project1/regions/us-central1/instances/instance1", nil},
+		{"aws:///us-east-1a/i-260942b3", nil},
+		{"gce:/", nil},
+		{"", nil},
+	}
+	for _, tc := range tests {
+		ref, err := GceRefFromProviderId(tc.id)
+		if tc.expected == nil {
+			assert.Error(t, err, tc.id)
+		} else {
+			assert.NoError(t, err, tc.id)
+			assert.Equal(t, tc.expected, ref, tc.id)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	gce "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// managedInstancesPage mirrors gce.InstanceGroupManagersListManagedInstancesResponse, which in the
+// vendored api lacks the nextPageToken returned for large MIGs.
+type managedInstancesPage struct {
+	ManagedInstances []*gce.ManagedInstance `json:"managedInstances,omitempty"`
+	NextPageToken    string                 `json:"nextPageToken,omitempty"`
+}
+
+// listManagedInstances lists all instances of the MIG, following nextPageToken until the last page.
+func (m *GceManager) listManagedInstances(mig *Mig) ([]*gce.ManagedInstance, error) {
+	instances := make([]*gce.ManagedInstance, 0)
+	pageToken := ""
+	for {
+		page, err := m.listManagedInstancesPage(mig, pageToken)
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, page.ManagedInstances...)
+		if page.NextPageToken == "" {
+			return instances, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func (m *GceManager) listManagedInstancesPage(mig *Mig, pageToken string) (*managedInstancesPage, error) {
+	params := url.Values{"alt": []string{"json"}}
+	if pageToken != "" {
+		params.Set("pageToken", pageToken)
+	}
+	urls := googleapi.ResolveRelative(m.service.BasePath,
+		"{project}/zones/{zone}/instanceGroupManagers/{instanceGroupManager}/listManagedInstances")
+	req, err := http.NewRequest("POST", urls+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	googleapi.Expand(req.URL, map[string]string{
+		"project":              mig.Project,
+		"zone":                 mig.Zone,
+		"instanceGroupManager": mig.Name,
+	})
+	res, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer googleapi.CloseBody(res)
+	if err := googleapi.CheckResponse(res); err != nil {
+		return nil, err
+	}
+	page := &managedInstancesPage{}
+	if err := json.NewDecoder(res.Body).Decode(page); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
@@ -19,6 +19,9 @@ package gce
 import (
 	"fmt"
 	"io"
+	"net/http"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,15 +32,45 @@ import (
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	gce "google.golang.org/api/compute/v1"
+	"k8s.io/contrib/cluster-autoscaler/utils/clock"
 	provider_gce "k8s.io/kubernetes/pkg/cloudprovider/providers/gce"
-	"k8s.io/kubernetes/pkg/util/wait"
+	utilerrors "k8s.io/kubernetes/pkg/util/errors"
+	"k8s.io/kubernetes/pkg/util/workqueue"
 )
 
 const (
 	operationWaitTimeout  = 5 * time.Second
 	operationPollInterval = 100 * time.Millisecond
+
+	// PriorityMetadataKey is the instance template metadata key holding the priority of the MIG
+	// for the priority expander.
+	PriorityMetadataKey = "cluster-autoscaler-priority"
+	// TemplateLabelsMetadataKey is the instance template metadata key holding the labels that the
+	// nodes of the MIG register with, in format key1=value1,key2=value2.
+	TemplateLabelsMetadataKey = "cluster-autoscaler-node-template-labels"
+
+	// MaxInstancesPerDelete is the maximum number of instances accepted by a single
+	// InstanceGroupManagers.DeleteInstances call.
+	MaxInstancesPerDelete = 1000
+	// DefaultDeleteBatchSize is the default number of instances deleted with a single call.
+	DefaultDeleteBatchSize = 100
+	// DefaultDeleteWorkers is the default number of concurrent DeleteInstances calls.
+	DefaultDeleteWorkers = 4
+	// cacheRefreshInterval is how often Refresh regenerates the cache of MIG instances.
+	cacheRefreshInterval = time.Hour
 )
 
+// GceOptions configures a GceManager.
+type GceOptions struct {
+	// DeleteBatchSize is the number of instances deleted from a MIG with a single DeleteInstances
+	// call, between 1 and MaxInstancesPerDelete.
+	DeleteBatchSize int
+	// DeleteWorkers is the number of concurrent DeleteInstances calls.
+	DeleteWorkers int
+	// Clock tells the time when cached data expires, the wall-clock time if nil.
+	Clock clock.Clock
+}
+
 type migInformation struct {
 	config   *Mig
 	basename string
@@ -47,13 +80,40 @@ type migInformation struct {
 type GceManager struct {
 	migs     []*migInformation
 	migCache map[GceRef]*Mig
+	// priorities holds the PriorityMetadataKey values of MIG instance templates as of the last
+	// cache regeneration.
+	priorities map[GceRef]int
+	// templateLabels holds the TemplateLabelsMetadataKey values of MIG instance templates as of
+	// the last cache regeneration.
+	templateLabels map[GceRef]map[string]string
 
-	service    *gce.Service
+	service *gce.Service
+	// client is the authenticated client of service, used for the calls the vendored api can't make.
+	client     *http.Client
 	cacheMutex sync.Mutex
+	// clock tells the time when cached data expires, the wall-clock time if nil.
+	clock clock.Clock
+	// lastCacheRefresh is the time of the last successful regeneration of migCache.
+	lastCacheRefresh time.Time
+
+	// sizeCache holds MIG target sizes fetched by RefreshSizes.
+	sizeCache map[GceRef]int64
+	sizeMutex sync.Mutex
+
+	// deleteBatchSize is the number of instances per DeleteInstances call, DefaultDeleteBatchSize if 0.
+	deleteBatchSize int
+	// deleteWorkers is the number of concurrent DeleteInstances calls, DefaultDeleteWorkers if 0.
+	deleteWorkers int
 }
 
 // CreateGceManager constructs gceManager object.
-func CreateGceManager(configReader io.Reader) (*GceManager, error) {
+func CreateGceManager(configReader io.Reader, options GceOptions) (*GceManager, error) {
+	if options.DeleteBatchSize < 1 || options.DeleteBatchSize > MaxInstancesPerDelete {
+		return nil, fmt.Errorf("GCE delete batch size must be between 1 and %d, got %d", MaxInstancesPerDelete, options.DeleteBatchSize)
+	}
+	if options.DeleteWorkers < 1 {
+		return nil, fmt.Errorf("GCE delete workers must be at least 1, got %d", options.DeleteWorkers)
+	}
 	// Create Google Compute Engine token.
 	tokenSource := google.ComputeTokenSource("")
 	if configReader != nil {
@@ -81,18 +141,31 @@ func CreateGceManager(configReader io.Reader) (*GceManager, error) {
 	manager := &GceManager{
 		migs:     make([]*migInformation, 0),
 		service:  gceService,
+		client:   client,
 		migCache: make(map[GceRef]*Mig),
+
+		deleteBatchSize: options.DeleteBatchSize,
+		deleteWorkers:   options.DeleteWorkers,
+		clock:           options.Clock,
 	}
-	go wait.Forever(func() {
-		manager.cacheMutex.Lock()
-		defer manager.cacheMutex.Unlock()
-		if err := manager.regenerateCache(); err != nil {
-			glog.Errorf("Error while regenerating Mig cache: %v", err)
-		}
-	}, time.Hour)
 	return manager, nil
 }
 
+// Refresh regenerates the cache of MIG instances if it wasn't regenerated for cacheRefreshInterval.
+func (m *GceManager) Refresh() error {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	if !m.lastCacheRefresh.IsZero() && m.now().Before(m.lastCacheRefresh.Add(cacheRefreshInterval)) {
+		return nil
+	}
+	return m.regenerateCache()
+}
+
+// Cleanup is a no-op, GceManager doesn't run any background work.
+func (m *GceManager) Cleanup() error {
+	return nil
+}
+
 // RegisterMig registers mig in Gce Manager.
 func (m *GceManager) RegisterMig(mig *Mig) {
 	m.cacheMutex.Lock()
@@ -103,8 +176,57 @@ func (m *GceManager) RegisterMig(mig *Mig) {
 	})
 }
 
+// RefreshSizes fetches the target size of all registered MIGs with a single aggregated list
+// call per project and caches the results. Until the next refresh GetMigSize serves sizes
+// from the cache.
+func (m *GceManager) RefreshSizes() error {
+	m.cacheMutex.Lock()
+	projects := make(map[string]bool)
+	for _, mig := range m.migs {
+		projects[mig.config.Project] = true
+	}
+	m.cacheMutex.Unlock()
+
+	sizes := make(map[GceRef]int64)
+	for project := range projects {
+		call := m.service.InstanceGroupManagers.AggregatedList(project)
+		err := call.Pages(oauth2.NoContext, func(list *gce.InstanceGroupManagerAggregatedList) error {
+			for _, scopedList := range list.Items {
+				for _, igm := range scopedList.InstanceGroupManagers {
+					// Zone is returned as a url, e.g. .../projects/<project-id>/zones/<zone>.
+					ref := GceRef{Project: project, Zone: path.Base(igm.Zone), Name: igm.Name}
+					sizes[ref] = igm.TargetSize
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	m.sizeMutex.Lock()
+	defer m.sizeMutex.Unlock()
+	m.sizeCache = sizes
+	return nil
+}
+
+// invalidateSize drops the cached size of the given MIG so that the next GetMigSize asks GCE.
+func (m *GceManager) invalidateSize(mig *Mig) {
+	m.sizeMutex.Lock()
+	defer m.sizeMutex.Unlock()
+	delete(m.sizeCache, mig.GceRef)
+}
+
 // GetMigSize gets MIG size.
 func (m *GceManager) GetMigSize(mig *Mig) (int64, error) {
+	m.sizeMutex.Lock()
+	size, found := m.sizeCache[mig.GceRef]
+	m.sizeMutex.Unlock()
+	if found {
+		return size, nil
+	}
+
 	igm, err := m.service.InstanceGroupManagers.Get(mig.Project, mig.Zone, mig.Name).Do()
 	if err != nil {
 		return -1, err
@@ -112,8 +234,29 @@ func (m *GceManager) GetMigSize(mig *Mig) (int64, error) {
 	return igm.TargetSize, nil
 }
 
+// GetMigInstanceCount returns the number of existing instances of the MIG that are not being
+// deleted. Instances that are still being created, e.g. retried after a stockout, are not counted:
+// decreasing the target size cancels their creation.
+func (m *GceManager) GetMigInstanceCount(mig *Mig) (int64, error) {
+	igm, err := m.service.InstanceGroupManagers.Get(mig.Project, mig.Zone, mig.Name).Do()
+	if err != nil {
+		return -1, err
+	}
+	return existingInstances(igm.CurrentActions), nil
+}
+
+// existingInstances returns the number of existing instances that are not being deleted or
+// abandoned among the given managed instance actions.
+func existingInstances(actions *gce.InstanceGroupManagerActionsSummary) int64 {
+	if actions == nil {
+		return 0
+	}
+	return actions.None + actions.Recreating + actions.Refreshing + actions.Restarting
+}
+
 // SetMigSize sets MIG size.
 func (m *GceManager) SetMigSize(mig *Mig, size int64) error {
+	m.invalidateSize(mig)
 	op, err := m.service.InstanceGroupManagers.Resize(mig.Project, mig.Zone, mig.Name, size).Do()
 	if err != nil {
 		return err
@@ -140,6 +283,8 @@ func (m *GceManager) waitForOp(operation *gce.Operation, project string, zone st
 }
 
 // DeleteInstances deletes the given instances. All instances must be controlled by the same MIG.
+// They are deleted in batches of deleteBatchSize instances, with up to deleteWorkers batches
+// deleted concurrently. A failed batch doesn't stop the others, their errors are aggregated.
 func (m *GceManager) DeleteInstances(instances []*GceRef) error {
 	if len(instances) == 0 {
 		return nil
@@ -158,21 +303,54 @@ func (m *GceManager) DeleteInstances(instances []*GceRef) error {
 		}
 	}
 
-	req := gce.InstanceGroupManagersDeleteInstancesRequest{
-		Instances: []string{},
+	batchSize := m.deleteBatchSize
+	if batchSize == 0 {
+		batchSize = DefaultDeleteBatchSize
 	}
-	for _, instance := range instances {
-		req.Instances = append(req.Instances, GenerateInstanceUrl(instance.Project, instance.Zone, instance.Name))
+	workers := m.deleteWorkers
+	if workers == 0 {
+		workers = DefaultDeleteWorkers
+	}
+	batches := make([][]string, 0)
+	for start := 0; start < len(instances); start += batchSize {
+		end := start + batchSize
+		if end > len(instances) {
+			end = len(instances)
+		}
+		urls := make([]string, 0, end-start)
+		for _, instance := range instances[start:end] {
+			urls = append(urls, GenerateInstanceUrl(instance.Project, instance.Zone, instance.Name))
+		}
+		batches = append(batches, urls)
+	}
+	if workers > len(batches) {
+		workers = len(batches)
 	}
 
-	op, err := m.service.InstanceGroupManagers.DeleteInstances(commonMig.Project, commonMig.Zone, commonMig.Name, &req).Do()
-	if err != nil {
-		return err
+	m.invalidateSize(commonMig)
+	var errsMutex sync.Mutex
+	errs := make([]error, 0)
+	workqueue.Parallelize(workers, len(batches), func(piece int) {
+		if err := m.deleteInstanceBatch(commonMig, batches[piece]); err != nil {
+			errsMutex.Lock()
+			defer errsMutex.Unlock()
+			errs = append(errs, fmt.Errorf("failed to delete instances %v: %v", batches[piece], err))
+		}
+	})
+	return utilerrors.NewAggregate(errs)
+}
+
+// deleteInstanceBatch deletes the instances with the given urls from the MIG and waits for the
+// operation to complete.
+func (m *GceManager) deleteInstanceBatch(mig *Mig, urls []string) error {
+	req := gce.InstanceGroupManagersDeleteInstancesRequest{
+		Instances: urls,
 	}
-	if err := m.waitForOp(op, commonMig.Project, commonMig.Zone); err != nil {
+	op, err := m.service.InstanceGroupManagers.DeleteInstances(mig.Project, mig.Zone, mig.Name, &req).Do()
+	if err != nil {
 		return err
 	}
-	return nil
+	return m.waitForOp(op, mig.Project, mig.Zone)
 }
 
 // GetMigForInstance returns MigConfig of the given Instance
@@ -183,25 +361,109 @@ func (m *GceManager) GetMigForInstance(instance *GceRef) (*Mig, error) {
 		return mig, nil
 	}
 
+	if m.matchesMigBasename(instance) {
+		if err := m.regenerateCache(); err != nil {
+			return nil, fmt.Errorf("Error while looking for MIG for instance %+v, error: %v", *instance, err)
+		}
+		if mig, found := m.migCache[*instance]; found {
+			return mig, nil
+		}
+		return nil, fmt.Errorf("Instance %+v does not belong to any configured MIG", *instance)
+	}
+	// Instance doesn't belong to any configured mig.
+	return nil, nil
+}
+
+// GetMigsForInstances returns the MIGs of the given instances. The cache is regenerated at most
+// once, if any of the instances is missing from it but looks like an instance of a configured MIG.
+// Instances that don't belong to any configured MIG are omitted.
+func (m *GceManager) GetMigsForInstances(instances []*GceRef) (map[GceRef]*Mig, error) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	for _, instance := range instances {
+		if _, found := m.migCache[*instance]; !found && m.matchesMigBasename(instance) {
+			if err := m.regenerateCache(); err != nil {
+				return nil, fmt.Errorf("Error while looking for MIGs of instances, error: %v", err)
+			}
+			break
+		}
+	}
+	result := make(map[GceRef]*Mig)
+	for _, instance := range instances {
+		if mig, found := m.migCache[*instance]; found {
+			result[*instance] = mig
+		} else if m.matchesMigBasename(instance) {
+			return nil, fmt.Errorf("Instance %+v does not belong to any configured MIG", *instance)
+		}
+	}
+	return result, nil
+}
+
+// matchesMigBasename checks whether the instance is in the project and zone of a configured MIG
+// and its name starts with the base instance name of the MIG.
+func (m *GceManager) matchesMigBasename(instance *GceRef) bool {
 	for _, mig := range m.migs {
 		if mig.config.Project == instance.Project &&
 			mig.config.Zone == instance.Zone &&
 			strings.HasPrefix(instance.Name, mig.basename) {
-			if err := m.regenerateCache(); err != nil {
-				return nil, fmt.Errorf("Error while looking for MIG for instance %+v, error: %v", *instance, err)
-			}
-			if mig, found := m.migCache[*instance]; found {
-				return mig, nil
-			}
-			return nil, fmt.Errorf("Instance %+v does not belong to any configured MIG", *instance)
+			return true
 		}
 	}
-	// Instance doesn't belong to any configured mig.
-	return nil, nil
+	return false
+}
+
+// GetMigPriority returns the PriorityMetadataKey value of the MIG instance template and true, or
+// false if it's not set.
+func (m *GceManager) GetMigPriority(mig *Mig) (int, bool) {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	priority, found := m.priorities[mig.GceRef]
+	return priority, found
+}
+
+// GetMigTemplateLabels returns the labels set with TemplateLabelsMetadataKey in the MIG instance
+// template, nil if there are none.
+func (m *GceManager) GetMigTemplateLabels(mig *Mig) map[string]string {
+	m.cacheMutex.Lock()
+	defer m.cacheMutex.Unlock()
+	return m.templateLabels[mig.GceRef]
+}
+
+// templateMetadata returns the metadata of the given instance template.
+func (m *GceManager) templateMetadata(project string, templateUrl string) (map[string]string, error) {
+	template, err := m.service.InstanceTemplates.Get(project, path.Base(templateUrl)).Do()
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]string)
+	if template.Properties == nil || template.Properties.Metadata == nil {
+		return metadata, nil
+	}
+	for _, item := range template.Properties.Metadata.Items {
+		if item.Value != nil {
+			metadata[item.Key] = *item.Value
+		}
+	}
+	return metadata, nil
+}
+
+// parseTemplateLabels parses labels in format key1=value1,key2=value2.
+func parseTemplateLabels(value string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, label := range strings.Split(value, ",") {
+		tokens := strings.SplitN(label, "=", 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			return nil, fmt.Errorf("wrong label %s, expected key=value", label)
+		}
+		labels[tokens[0]] = tokens[1]
+	}
+	return labels, nil
 }
 
 func (m *GceManager) regenerateCache() error {
 	newMigCache := make(map[GceRef]*Mig)
+	priorities := make(map[GceRef]int)
+	templateLabels := make(map[GceRef]map[string]string)
 
 	for _, migInfo := range m.migs {
 		mig := migInfo.config
@@ -213,12 +475,31 @@ func (m *GceManager) regenerateCache() error {
 		}
 		migInfo.basename = instanceGroupManager.BaseInstanceName
 
-		instances, err := m.service.InstanceGroupManagers.ListManagedInstances(mig.Project, mig.Zone, mig.Name).Do()
+		metadata, err := m.templateMetadata(mig.Project, instanceGroupManager.InstanceTemplate)
+		if err != nil {
+			glog.Warningf("Failed to read instance template metadata of MIG %s %s %s: %v", mig.Project, mig.Zone, mig.Name, err)
+		}
+		if value, found := metadata[PriorityMetadataKey]; found {
+			if priority, err := strconv.Atoi(value); err != nil {
+				glog.Warningf("Invalid %s metadata of MIG %s %s %s: %v", PriorityMetadataKey, mig.Project, mig.Zone, mig.Name, err)
+			} else {
+				priorities[mig.GceRef] = priority
+			}
+		}
+		if value, found := metadata[TemplateLabelsMetadataKey]; found {
+			if labels, err := parseTemplateLabels(value); err != nil {
+				glog.Warningf("Invalid %s metadata of MIG %s %s %s: %v", TemplateLabelsMetadataKey, mig.Project, mig.Zone, mig.Name, err)
+			} else {
+				templateLabels[mig.GceRef] = labels
+			}
+		}
+
+		instances, err := m.listManagedInstances(mig)
 		if err != nil {
 			glog.V(4).Infof("Failed MIG info request for %s %s %s: %v", mig.Project, mig.Zone, mig.Name, err)
 			return err
 		}
-		for _, instance := range instances.ManagedInstances {
+		for _, instance := range instances {
 			project, zone, name, err := ParseInstanceUrl(instance.Instance)
 			if err != nil {
 				return err
@@ -228,5 +509,16 @@ func (m *GceManager) regenerateCache() error {
 	}
 
 	m.migCache = newMigCache
+	m.priorities = priorities
+	m.templateLabels = templateLabels
+	m.lastCacheRefresh = m.now()
 	return nil
 }
+
+// now returns the current time of the manager's clock.
+func (m *GceManager) now() time.Time {
+	if m.clock == nil {
+		return clock.RealClock{}.Now()
+	}
+	return m.clock.Now()
+}
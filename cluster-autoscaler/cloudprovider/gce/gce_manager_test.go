@@ -0,0 +1,305 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gce
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	gce "google.golang.org/api/compute/v1"
+	"k8s.io/contrib/cluster-autoscaler/utils/clock"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegenerateCachePaginated(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test-project/zones/test-zone/instanceGroupManagers/test-name", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&gce.InstanceGroupManager{
+			Name:             "test-name",
+			BaseInstanceName: "test-name",
+			InstanceTemplate: "https://www.googleapis.com/compute/v1/projects/test-project/global/instanceTemplates/test-template",
+		})
+	})
+	mux.HandleFunc("/test-project/global/instanceTemplates/test-template", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&gce.InstanceTemplate{Name: "test-template"})
+	})
+	mux.HandleFunc("/test-project/zones/test-zone/instanceGroupManagers/test-name/listManagedInstances", func(w http.ResponseWriter, r *http.Request) {
+		page := &managedInstancesPage{}
+		switch r.URL.Query().Get("pageToken") {
+		case "":
+			page.ManagedInstances = []*gce.ManagedInstance{
+				{Instance: GenerateInstanceUrl("test-project", "test-zone", "test-name-a")},
+			}
+			page.NextPageToken = "second"
+		case "second":
+			page.ManagedInstances = []*gce.ManagedInstance{
+				{Instance: GenerateInstanceUrl("test-project", "test-zone", "test-name-b")},
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	service, err := gce.New(http.DefaultClient)
+	assert.NoError(t, err)
+	service.BasePath = server.URL + "/"
+	m := &GceManager{
+		migs:     make([]*migInformation, 0),
+		migCache: make(map[GceRef]*Mig),
+		service:  service,
+		client:   http.DefaultClient,
+	}
+	mig := &Mig{GceRef: GceRef{Project: "test-project", Zone: "test-zone", Name: "test-name"}, gceManager: m}
+	m.RegisterMig(mig)
+
+	assert.NoError(t, m.regenerateCache())
+	assert.Equal(t, map[GceRef]*Mig{
+		{Project: "test-project", Zone: "test-zone", Name: "test-name-a"}: mig,
+		{Project: "test-project", Zone: "test-zone", Name: "test-name-b"}: mig,
+	}, m.migCache)
+}
+
+func TestRegenerateCacheTemplateMetadata(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test-project/zones/test-zone/instanceGroupManagers/test-name", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&gce.InstanceGroupManager{
+			Name:             "test-name",
+			BaseInstanceName: "test-name",
+			InstanceTemplate: "https://www.googleapis.com/compute/v1/projects/test-project/global/instanceTemplates/test-template",
+		})
+	})
+	mux.HandleFunc("/test-project/global/instanceTemplates/test-template", func(w http.ResponseWriter, r *http.Request) {
+		priority := "10"
+		labels := "accelerator=gpu,pool=batch"
+		json.NewEncoder(w).Encode(&gce.InstanceTemplate{
+			Name: "test-template",
+			Properties: &gce.InstanceProperties{
+				Metadata: &gce.Metadata{
+					Items: []*gce.MetadataItems{
+						{Key: PriorityMetadataKey, Value: &priority},
+						{Key: TemplateLabelsMetadataKey, Value: &labels},
+					},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/test-project/zones/test-zone/instanceGroupManagers/test-name/listManagedInstances", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&managedInstancesPage{})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	service, err := gce.New(http.DefaultClient)
+	assert.NoError(t, err)
+	service.BasePath = server.URL + "/"
+	m := &GceManager{
+		migs:     make([]*migInformation, 0),
+		migCache: make(map[GceRef]*Mig),
+		service:  service,
+		client:   http.DefaultClient,
+	}
+	mig := &Mig{GceRef: GceRef{Project: "test-project", Zone: "test-zone", Name: "test-name"}, gceManager: m}
+	m.RegisterMig(mig)
+
+	assert.NoError(t, m.regenerateCache())
+	priority, found := mig.Priority()
+	assert.True(t, found)
+	assert.Equal(t, 10, priority)
+	assert.Equal(t, map[string]string{"accelerator": "gpu", "pool": "batch"}, mig.TemplateLabels())
+}
+
+func TestRefresh(t *testing.T) {
+	var requests int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test-project/zones/test-zone/instanceGroupManagers/test-name", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		json.NewEncoder(w).Encode(&gce.InstanceGroupManager{
+			Name:             "test-name",
+			BaseInstanceName: "test-name",
+			InstanceTemplate: "https://www.googleapis.com/compute/v1/projects/test-project/global/instanceTemplates/test-template",
+		})
+	})
+	mux.HandleFunc("/test-project/global/instanceTemplates/test-template", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&gce.InstanceTemplate{Name: "test-template"})
+	})
+	mux.HandleFunc("/test-project/zones/test-zone/instanceGroupManagers/test-name/listManagedInstances", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&managedInstancesPage{
+			ManagedInstances: []*gce.ManagedInstance{
+				{Instance: GenerateInstanceUrl("test-project", "test-zone", "test-name-a")},
+			},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	service, err := gce.New(http.DefaultClient)
+	assert.NoError(t, err)
+	service.BasePath = server.URL + "/"
+	fakeClock := clock.NewFakeClock(time.Now())
+	m := &GceManager{
+		migs:     make([]*migInformation, 0),
+		migCache: make(map[GceRef]*Mig),
+		service:  service,
+		client:   http.DefaultClient,
+		clock:    fakeClock,
+	}
+	mig := &Mig{GceRef: GceRef{Project: "test-project", Zone: "test-zone", Name: "test-name"}, gceManager: m}
+	m.RegisterMig(mig)
+
+	// The cache is regenerated by the first refresh and kept by the following ones until it gets stale.
+	assert.NoError(t, m.Refresh())
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, mig, m.migCache[GceRef{Project: "test-project", Zone: "test-zone", Name: "test-name-a"}])
+	assert.NoError(t, m.Refresh())
+	assert.Equal(t, 1, requests)
+
+	fakeClock.Step(cacheRefreshInterval - time.Minute)
+	assert.NoError(t, m.Refresh())
+	assert.Equal(t, 1, requests)
+	fakeClock.Step(time.Minute)
+	assert.NoError(t, m.Refresh())
+	assert.Equal(t, 2, requests)
+}
+
+func TestParseTemplateLabels(t *testing.T) {
+	labels, err := parseTemplateLabels("a=1,b=")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": ""}, labels)
+
+	_, err = parseTemplateLabels("a")
+	assert.Error(t, err)
+	_, err = parseTemplateLabels("=1")
+	assert.Error(t, err)
+}
+
+func TestDeleteInstancesConcurrently(t *testing.T) {
+	var mutex sync.Mutex
+	deleted := make([]string, 0)
+	inFlight, maxInFlight := 0, 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test-project/zones/test-zone/instanceGroupManagers/test-name/deleteInstances", func(w http.ResponseWriter, r *http.Request) {
+		var req gce.InstanceGroupManagersDeleteInstancesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+		// Keeps the call in flight long enough for the other workers to start theirs.
+		time.Sleep(50 * time.Millisecond)
+		mutex.Lock()
+		defer mutex.Unlock()
+		inFlight--
+		for _, url := range req.Instances {
+			if strings.HasSuffix(url, "/test-name-3") {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		deleted = append(deleted, req.Instances...)
+		json.NewEncoder(w).Encode(&gce.Operation{Name: "test-operation"})
+	})
+	mux.HandleFunc("/test-project/zones/test-zone/operations/test-operation", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&gce.Operation{Name: "test-operation", Status: "DONE"})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	service, err := gce.New(http.DefaultClient)
+	assert.NoError(t, err)
+	service.BasePath = server.URL + "/"
+	m := &GceManager{
+		migs:            make([]*migInformation, 0),
+		migCache:        make(map[GceRef]*Mig),
+		service:         service,
+		client:          http.DefaultClient,
+		deleteBatchSize: 2,
+		deleteWorkers:   2,
+	}
+	mig := &Mig{GceRef: GceRef{Project: "test-project", Zone: "test-zone", Name: "test-name"}, gceManager: m}
+	m.RegisterMig(mig)
+	instances := make([]*GceRef, 0)
+	for i := 0; i < 6; i++ {
+		ref := GceRef{Project: "test-project", Zone: "test-zone", Name: fmt.Sprintf("test-name-%d", i)}
+		m.migCache[ref] = mig
+		instances = append(instances, &ref)
+	}
+
+	err = m.DeleteInstances(instances)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "test-name-3")
+	// The failed batch doesn't stop the other ones.
+	assert.Equal(t, 4, len(deleted))
+	for _, name := range []string{"test-name-0", "test-name-1", "test-name-4", "test-name-5"} {
+		assert.Contains(t, deleted, GenerateInstanceUrl("test-project", "test-zone", name))
+	}
+	assert.Equal(t, 2, maxInFlight)
+}
+
+func TestDecreaseTargetSize(t *testing.T) {
+	resized := make([]string, 0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test-project/zones/test-zone/instanceGroupManagers/test-name", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&gce.InstanceGroupManager{
+			Name:       "test-name",
+			TargetSize: 4,
+			// The instances that are still being created can be given up.
+			CurrentActions: &gce.InstanceGroupManagerActionsSummary{None: 1, Restarting: 1, Creating: 2},
+		})
+	})
+	mux.HandleFunc("/test-project/zones/test-zone/instanceGroupManagers/test-name/resize", func(w http.ResponseWriter, r *http.Request) {
+		resized = append(resized, r.URL.Query().Get("size"))
+		json.NewEncoder(w).Encode(&gce.Operation{Name: "test-operation"})
+	})
+	mux.HandleFunc("/test-project/zones/test-zone/operations/test-operation", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&gce.Operation{Name: "test-operation", Status: "DONE"})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	service, err := gce.New(http.DefaultClient)
+	assert.NoError(t, err)
+	service.BasePath = server.URL + "/"
+	m := &GceManager{
+		migs:     make([]*migInformation, 0),
+		migCache: make(map[GceRef]*Mig),
+		service:  service,
+		client:   http.DefaultClient,
+	}
+	mig := &Mig{GceRef: GceRef{Project: "test-project", Zone: "test-zone", Name: "test-name"}, gceManager: m, minSize: 0, maxSize: 10}
+	m.RegisterMig(mig)
+
+	assert.Error(t, mig.DecreaseTargetSize(-3))
+	assert.Equal(t, 0, len(resized))
+	assert.NoError(t, mig.DecreaseTargetSize(-2))
+	assert.Equal(t, []string{"2"}, resized)
+}
@@ -27,6 +27,7 @@ const (
 	gcePrefix           = gceUrlSchema + "://content." + gceDomainSufix
 	instanceUrlTemplate = gcePrefix + "%s/zones/%s/instances/%s"
 	migUrlTemplate      = gcePrefix + "%s/zones/%s/instanceGroups/%s"
+	gceProviderIdPrefix = "gce://"
 )
 
 // ParseMigUrl expects url in format:
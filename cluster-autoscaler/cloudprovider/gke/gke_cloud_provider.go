@@ -0,0 +1,316 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gke
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	provider_gce "k8s.io/contrib/cluster-autoscaler/cloudprovider/gce"
+	kube_api "k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// NodePoolLabel is the label GKE puts on nodes with the name of their node pool.
+	NodePoolLabel = "cloud.google.com/gke-nodepool"
+
+	nodePoolUrlPrefix   = "https://container.googleapis.com/v1/projects/"
+	nodePoolUrlTemplate = nodePoolUrlPrefix + "%s/zones/%s/clusters/%s/nodePools/%s"
+)
+
+// GkeCloudProvider implements CloudProvider interface for GKE node pools.
+type GkeCloudProvider struct {
+	gkeManager *GkeManager
+	nodePools  []*NodePool
+}
+
+// BuildGkeCloudProvider builds CloudProvider implementation for GKE.
+func BuildGkeCloudProvider(gkeManager *GkeManager, specs []string) (*GkeCloudProvider, error) {
+	gke := &GkeCloudProvider{
+		gkeManager: gkeManager,
+		nodePools:  make([]*NodePool, 0),
+	}
+	for _, spec := range specs {
+		if err := gke.addNodeGroup(spec); err != nil {
+			return nil, err
+		}
+	}
+	if err := cloudprovider.CheckDuplicateNodeGroups(gke.NodeGroups()); err != nil {
+		return nil, err
+	}
+	return gke, nil
+}
+
+// addNodeGroup adds node group defined in string spec. Format:
+// minNodes:maxNodes:nodePoolUrl
+func (gke *GkeCloudProvider) addNodeGroup(spec string) error {
+	pool, err := buildNodePool(spec, gke.gkeManager)
+	if err != nil {
+		return err
+	}
+	gke.nodePools = append(gke.nodePools, pool)
+	return nil
+}
+
+// Name returns name of the cloud provider.
+func (gke *GkeCloudProvider) Name() string {
+	return "gke"
+}
+
+// NodeGroups returns all node groups configured for this cloud provider.
+func (gke *GkeCloudProvider) NodeGroups() []cloudprovider.NodeGroup {
+	result := make([]cloudprovider.NodeGroup, 0, len(gke.nodePools))
+	for _, pool := range gke.nodePools {
+		result = append(result, pool)
+	}
+	return result
+}
+
+// NodeGroupForNode returns the node group for the given node. Nodes are matched with node pools
+// by the NodePoolLabel, so all configured node pools must belong to the cluster of the autoscaler.
+func (gke *GkeCloudProvider) NodeGroupForNode(node *kube_api.Node) (cloudprovider.NodeGroup, error) {
+	poolName, found := node.Labels[NodePoolLabel]
+	if !found {
+		return nil, nil
+	}
+	for _, pool := range gke.nodePools {
+		if pool.Name == poolName {
+			return pool, nil
+		}
+	}
+	return nil, nil
+}
+
+// NodeGroupsForNodes returns the node groups of the given nodes, keyed by node name. Nodes are
+// matched by the NodePoolLabel, so no cloud api calls are made.
+func (gke *GkeCloudProvider) NodeGroupsForNodes(nodes []*kube_api.Node) (map[string]cloudprovider.NodeGroup, error) {
+	result := make(map[string]cloudprovider.NodeGroup)
+	for _, node := range nodes {
+		poolName, found := node.Labels[NodePoolLabel]
+		if !found {
+			continue
+		}
+		for _, pool := range gke.nodePools {
+			if pool.Name == poolName {
+				result[node.Name] = pool
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// Refresh is a no-op, GKE node pools are not cached.
+func (gke *GkeCloudProvider) Refresh() error {
+	return nil
+}
+
+// Cleanup is a no-op, GKE cloud provider has no background work.
+func (gke *GkeCloudProvider) Cleanup() error {
+	return nil
+}
+
+// RefreshSizes is a no-op, node pool sizes are always fetched from GCE.
+func (gke *GkeCloudProvider) RefreshSizes() error {
+	return nil
+}
+
+// NodePoolRef contains a reference to a GKE node pool.
+type NodePoolRef struct {
+	Project string
+	Zone    string
+	Cluster string
+	Name    string
+}
+
+// NodePoolRefFromUrl parses node pool url in format:
+// https://container.googleapis.com/v1/projects/<project-id>/zones/<zone>/clusters/<cluster>/nodePools/<name>
+func NodePoolRefFromUrl(url string) (NodePoolRef, error) {
+	errMsg := fmt.Errorf("Wrong url: expected format %s<project-id>/zones/<zone>/clusters/<cluster>/nodePools/<name>, got %s",
+		nodePoolUrlPrefix, url)
+	if !strings.HasPrefix(url, nodePoolUrlPrefix) {
+		return NodePoolRef{}, errMsg
+	}
+	splitted := strings.Split(strings.TrimPrefix(url, nodePoolUrlPrefix), "/")
+	if len(splitted) != 7 || splitted[1] != "zones" || splitted[3] != "clusters" || splitted[5] != "nodePools" {
+		return NodePoolRef{}, errMsg
+	}
+	return NodePoolRef{
+		Project: splitted[0],
+		Zone:    splitted[2],
+		Cluster: splitted[4],
+		Name:    splitted[6],
+	}, nil
+}
+
+// MigRef contains a reference to the mig backing a node pool.
+type MigRef struct {
+	Project string
+	Zone    string
+	Name    string
+}
+
+// MigRefFromUrl parses the instance group url of a node pool, in format:
+// https://www.googleapis.com/compute/v1/projects/<project-id>/zones/<zone>/instanceGroupManagers/<name>
+func MigRefFromUrl(url string) (MigRef, error) {
+	splitted := strings.Split(url, "/projects/")
+	if len(splitted) != 2 {
+		return MigRef{}, fmt.Errorf("Wrong instance group url: %s", url)
+	}
+	splitted = strings.Split(splitted[1], "/")
+	if len(splitted) != 5 || splitted[1] != "zones" || splitted[3] != "instanceGroupManagers" {
+		return MigRef{}, fmt.Errorf("Wrong instance group url: %s", url)
+	}
+	return MigRef{
+		Project: splitted[0],
+		Zone:    splitted[2],
+		Name:    splitted[4],
+	}, nil
+}
+
+// NodePool implements NodeGroup interface.
+type NodePool struct {
+	NodePoolRef
+
+	gkeManager *GkeManager
+
+	minSize int
+	maxSize int
+}
+
+// MaxSize returns maximum size of the node group.
+func (pool *NodePool) MaxSize() int {
+	return pool.maxSize
+}
+
+// MinSize returns minimum size of the node group.
+func (pool *NodePool) MinSize() int {
+	return pool.minSize
+}
+
+// TargetSize returns the current TARGET size of the node group. It is possible that the
+// number is different from the number of nodes registered in Kuberentes.
+func (pool *NodePool) TargetSize() (int, error) {
+	size, err := pool.gkeManager.GetNodePoolSize(pool)
+	return int(size), err
+}
+
+// IncreaseSize increases node pool size.
+func (pool *NodePool) IncreaseSize(delta int) error {
+	if delta <= 0 {
+		return fmt.Errorf("size increase must be positive")
+	}
+	size, err := pool.gkeManager.GetNodePoolSize(pool)
+	if err != nil {
+		return err
+	}
+	if int(size)+delta > pool.MaxSize() {
+		return fmt.Errorf("size increase too large - desired:%d max:%d", int(size)+delta, pool.MaxSize())
+	}
+	return pool.gkeManager.SetNodePoolSize(pool, size+int64(delta))
+}
+
+// DecreaseTargetSize decreases the target size of the node pool. It fails instead of decreasing the
+// size below the number of existing instances of the node pool, as GKE would delete some of them.
+// Delta should be negative.
+func (pool *NodePool) DecreaseTargetSize(delta int) error {
+	if delta >= 0 {
+		return fmt.Errorf("size decrease must be negative")
+	}
+	size, err := pool.gkeManager.GetNodePoolSize(pool)
+	if err != nil {
+		return err
+	}
+	instances, err := pool.gkeManager.GetNodePoolInstanceCount(pool)
+	if err != nil {
+		return err
+	}
+	if size+int64(delta) < instances {
+		return fmt.Errorf("attempt to delete existing nodes targetSize:%d delta:%d existingNodes: %d",
+			size, delta, instances)
+	}
+	return pool.gkeManager.SetNodePoolSize(pool, size+int64(delta))
+}
+
+// DeleteNodes deletes the nodes from the group.
+func (pool *NodePool) DeleteNodes(nodes []*kube_api.Node) error {
+	size, err := pool.gkeManager.GetNodePoolSize(pool)
+	if err != nil {
+		return err
+	}
+	if int(size)-len(nodes) < pool.MinSize() {
+		return fmt.Errorf("min size reached, nodes will not be deleted")
+	}
+	instanceUrls := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Labels[NodePoolLabel] != pool.Name {
+			return fmt.Errorf("%s belongs to a different node pool than %s", node.Name, pool.Id())
+		}
+		ref, err := provider_gce.GceRefFromProviderId(node.Spec.ProviderID)
+		if err != nil {
+			return err
+		}
+		instanceUrls = append(instanceUrls, provider_gce.GenerateInstanceUrl(ref.Project, ref.Zone, ref.Name))
+	}
+	return pool.gkeManager.DeleteInstances(pool, instanceUrls)
+}
+
+// Id returns node pool url.
+func (pool *NodePool) Id() string {
+	return fmt.Sprintf(nodePoolUrlTemplate, pool.Project, pool.Zone, pool.Cluster, pool.Name)
+}
+
+// Debug returns a debug string for the node pool.
+func (pool *NodePool) Debug() string {
+	return fmt.Sprintf("%s (%d:%d)", pool.Id(), pool.MinSize(), pool.MaxSize())
+}
+
+func buildNodePool(value string, gkeManager *GkeManager) (*NodePool, error) {
+	tokens := strings.SplitN(value, ":", 3)
+	if len(tokens) != 3 {
+		return nil, fmt.Errorf("wrong nodes configuration: %s", value)
+	}
+
+	pool := NodePool{
+		gkeManager: gkeManager,
+	}
+	if size, err := strconv.Atoi(tokens[0]); err == nil {
+		if size < 0 {
+			return nil, fmt.Errorf("min size must be >= 0")
+		}
+		pool.minSize = size
+	} else {
+		return nil, fmt.Errorf("failed to set min size: %s, expected integer", tokens[0])
+	}
+
+	if size, err := strconv.Atoi(tokens[1]); err == nil {
+		if size < pool.minSize {
+			return nil, fmt.Errorf("max size must be greater or equal to min size")
+		}
+		pool.maxSize = size
+	} else {
+		return nil, fmt.Errorf("failed to set max size: %s, expected integer", tokens[1])
+	}
+
+	var err error
+	if pool.NodePoolRef, err = NodePoolRefFromUrl(tokens[2]); err != nil {
+		return nil, fmt.Errorf("failed to parse node pool url: %s got error: %v", tokens[2], err)
+	}
+	return &pool, nil
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gke
+
+import (
+	"fmt"
+	"testing"
+
+	container "google.golang.org/api/container/v1"
+	kube_api "k8s.io/kubernetes/pkg/api"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testPoolUrl = "https://container.googleapis.com/v1/projects/test-project/zones/test-zone/clusters/test-cluster/nodePools/test-pool"
+	testMigUrl  = "https://www.googleapis.com/compute/v1/projects/test-project/zones/test-zone/instanceGroupManagers/gke-test-pool-grp"
+)
+
+// fakeGkeService keeps node pools and mig sizes in memory and records setSize calls.
+type fakeGkeService struct {
+	pools    map[NodePoolRef]*container.NodePool
+	migSizes map[MigRef]int64
+	// migInstances holds the number of existing instances of migs, 0 if missing.
+	migInstances map[MigRef]int64
+	setSizes     map[NodePoolRef]int64
+	deleted      []string
+}
+
+func newFakeGkeService() *fakeGkeService {
+	return &fakeGkeService{
+		pools:        make(map[NodePoolRef]*container.NodePool),
+		migSizes:     make(map[MigRef]int64),
+		migInstances: make(map[MigRef]int64),
+		setSizes:     make(map[NodePoolRef]int64),
+	}
+}
+
+func (f *fakeGkeService) GetNodePool(pool NodePoolRef) (*container.NodePool, error) {
+	nodePool, found := f.pools[pool]
+	if !found {
+		return nil, fmt.Errorf("node pool %v not found", pool)
+	}
+	return nodePool, nil
+}
+
+func (f *fakeGkeService) SetNodePoolSize(pool NodePoolRef, size int64) error {
+	f.setSizes[pool] = size
+	return nil
+}
+
+func (f *fakeGkeService) GetMigSize(mig MigRef) (int64, error) {
+	size, found := f.migSizes[mig]
+	if !found {
+		return -1, fmt.Errorf("mig %v not found", mig)
+	}
+	return size, nil
+}
+
+func (f *fakeGkeService) GetMigInstanceCount(mig MigRef) (int64, error) {
+	return f.migInstances[mig], nil
+}
+
+func (f *fakeGkeService) DeleteMigInstances(mig MigRef, instanceUrls []string) error {
+	f.deleted = append(f.deleted, instanceUrls...)
+	return nil
+}
+
+func buildTestProvider(t *testing.T, service *fakeGkeService) *GkeCloudProvider {
+	ref, err := NodePoolRefFromUrl(testPoolUrl)
+	assert.NoError(t, err)
+	mig, err := MigRefFromUrl(testMigUrl)
+	assert.NoError(t, err)
+	service.pools[ref] = &container.NodePool{Name: ref.Name, InstanceGroupUrls: []string{testMigUrl}}
+	service.migSizes[mig] = 3
+
+	provider, err := BuildGkeCloudProvider(&GkeManager{service: service}, []string{"1:5:" + testPoolUrl})
+	assert.NoError(t, err)
+	return provider
+}
+
+func TestBuildNodePool(t *testing.T) {
+	_, err := buildNodePool("a", nil)
+	assert.Error(t, err)
+	_, err = buildNodePool("a:b:c", nil)
+	assert.Error(t, err)
+	_, err = buildNodePool("-1:2:"+testPoolUrl, nil)
+	assert.Error(t, err)
+	_, err = buildNodePool("1:2:https://container.googleapis.com/v1/projects/test-project/zones/test-zone/clusters/test-cluster", nil)
+	assert.Error(t, err)
+
+	pool, err := buildNodePool("111:222:"+testPoolUrl, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 111, pool.MinSize())
+	assert.Equal(t, 222, pool.MaxSize())
+	assert.Equal(t, NodePoolRef{Project: "test-project", Zone: "test-zone", Cluster: "test-cluster", Name: "test-pool"}, pool.NodePoolRef)
+	assert.Equal(t, testPoolUrl, pool.Id())
+}
+
+func TestBuildGkeCloudProviderDuplicateNodePool(t *testing.T) {
+	_, err := BuildGkeCloudProvider(&GkeManager{}, []string{"1:5:" + testPoolUrl, "2:10:" + testPoolUrl})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), testPoolUrl)
+}
+
+func TestMigRefFromUrl(t *testing.T) {
+	mig, err := MigRefFromUrl(testMigUrl)
+	assert.NoError(t, err)
+	assert.Equal(t, MigRef{Project: "test-project", Zone: "test-zone", Name: "gke-test-pool-grp"}, mig)
+
+	_, err = MigRefFromUrl("https://www.googleapis.com/compute/v1/projects/test-project/zones/test-zone/instances/i1")
+	assert.Error(t, err)
+}
+
+func TestNodePoolSize(t *testing.T) {
+	service := newFakeGkeService()
+	provider := buildTestProvider(t, service)
+	pool := provider.NodeGroups()[0]
+
+	size, err := pool.TargetSize()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, size)
+
+	assert.NoError(t, pool.IncreaseSize(2))
+	assert.Equal(t, int64(5), service.setSizes[provider.nodePools[0].NodePoolRef])
+
+	assert.Error(t, pool.IncreaseSize(3))
+
+	assert.NoError(t, pool.DecreaseTargetSize(-1))
+	assert.Equal(t, int64(2), service.setSizes[provider.nodePools[0].NodePoolRef])
+}
+
+func TestNodePoolDecreaseTargetSizeKeepsInstances(t *testing.T) {
+	service := newFakeGkeService()
+	provider := buildTestProvider(t, service)
+	pool := provider.NodeGroups()[0]
+	mig, err := MigRefFromUrl(testMigUrl)
+	assert.NoError(t, err)
+	service.migInstances[mig] = 2
+
+	// The target size is 3, so only the instance that doesn't exist yet may be given up.
+	assert.Error(t, pool.DecreaseTargetSize(-2))
+	assert.Equal(t, 0, len(service.setSizes))
+	assert.NoError(t, pool.DecreaseTargetSize(-1))
+	assert.Equal(t, int64(2), service.setSizes[provider.nodePools[0].NodePoolRef])
+}
+
+func TestNodePoolSizeMultiZone(t *testing.T) {
+	service := newFakeGkeService()
+	provider := buildTestProvider(t, service)
+	service.pools[provider.nodePools[0].NodePoolRef].InstanceGroupUrls = append(
+		service.pools[provider.nodePools[0].NodePoolRef].InstanceGroupUrls,
+		"https://www.googleapis.com/compute/v1/projects/test-project/zones/other-zone/instanceGroupManagers/gke-test-pool-grp")
+
+	_, err := provider.NodeGroups()[0].TargetSize()
+	assert.Error(t, err)
+}
+
+func TestNodeGroupForNode(t *testing.T) {
+	provider := buildTestProvider(t, newFakeGkeService())
+
+	node := &kube_api.Node{
+		ObjectMeta: kube_api.ObjectMeta{
+			Name:   "n1",
+			Labels: map[string]string{NodePoolLabel: "test-pool"},
+		},
+	}
+	group, err := provider.NodeGroupForNode(node)
+	assert.NoError(t, err)
+	assert.Equal(t, testPoolUrl, group.Id())
+
+	node.Labels[NodePoolLabel] = "other-pool"
+	group, err = provider.NodeGroupForNode(node)
+	assert.NoError(t, err)
+	assert.Nil(t, group)
+
+	group, err = provider.NodeGroupForNode(&kube_api.Node{ObjectMeta: kube_api.ObjectMeta{Name: "bootstrapping"}})
+	assert.NoError(t, err)
+	assert.Nil(t, group)
+}
+
+func TestDeleteNodes(t *testing.T) {
+	service := newFakeGkeService()
+	provider := buildTestProvider(t, service)
+
+	node := &kube_api.Node{
+		ObjectMeta: kube_api.ObjectMeta{
+			Name:   "n1",
+			Labels: map[string]string{NodePoolLabel: "test-pool"},
+		},
+		Spec: kube_api.NodeSpec{ProviderID: "gce://test-project/test-zone/n1"},
+	}
+	assert.NoError(t, provider.NodeGroups()[0].DeleteNodes([]*kube_api.Node{node}))
+	assert.Equal(t, []string{"https://content.googleapis.com/compute/v1/projects/test-project/zones/test-zone/instances/n1"}, service.deleted)
+}
+
+func TestDeleteNodesBelowMinSize(t *testing.T) {
+	service := newFakeGkeService()
+	provider := buildTestProvider(t, service)
+
+	nodes := make([]*kube_api.Node, 0)
+	for _, name := range []string{"n1", "n2", "n3"} {
+		nodes = append(nodes, &kube_api.Node{
+			ObjectMeta: kube_api.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{NodePoolLabel: "test-pool"},
+			},
+			Spec: kube_api.NodeSpec{ProviderID: "gce://test-project/test-zone/" + name},
+		})
+	}
+	// The pool has 3 nodes and min size 1, so removing all of them at once would go below it.
+	assert.Error(t, provider.NodeGroups()[0].DeleteNodes(nodes))
+	assert.Equal(t, 0, len(service.deleted))
+	assert.NoError(t, provider.NodeGroups()[0].DeleteNodes(nodes[:2]))
+	assert.Equal(t, 2, len(service.deleted))
+}
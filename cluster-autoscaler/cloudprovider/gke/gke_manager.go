@@ -0,0 +1,238 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gke
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"gopkg.in/gcfg.v1"
+
+	"github.com/golang/glog"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	gce "google.golang.org/api/compute/v1"
+	container "google.golang.org/api/container/v1"
+	"google.golang.org/api/googleapi"
+	provider_gce "k8s.io/kubernetes/pkg/cloudprovider/providers/gce"
+)
+
+const (
+	operationWaitTimeout  = 30 * time.Second
+	operationPollInterval = 100 * time.Millisecond
+)
+
+// gkeService contains the GKE and GCE calls used by GkeManager. All calls that start an
+// operation wait until it is done.
+type gkeService interface {
+	// GetNodePool returns the node pool from the container api.
+	GetNodePool(pool NodePoolRef) (*container.NodePool, error)
+	// SetNodePoolSize resizes the node pool through the container api.
+	SetNodePoolSize(pool NodePoolRef, size int64) error
+	// GetMigSize returns the target size of the mig.
+	GetMigSize(mig MigRef) (int64, error)
+	// GetMigInstanceCount returns the number of existing instances of the mig that are not being
+	// deleted. Instances that are still being created are not counted.
+	GetMigInstanceCount(mig MigRef) (int64, error)
+	// DeleteMigInstances deletes the given instances, identified by urls, from the mig.
+	DeleteMigInstances(mig MigRef, instanceUrls []string) error
+}
+
+// GkeManager handles communication with GKE and GCE.
+type GkeManager struct {
+	service gkeService
+}
+
+// CreateGkeManager constructs GkeManager object.
+func CreateGkeManager(configReader io.Reader) (*GkeManager, error) {
+	// Create Google Compute Engine token.
+	tokenSource := google.ComputeTokenSource("")
+	if configReader != nil {
+		var cfg provider_gce.Config
+		if err := gcfg.ReadInto(&cfg, configReader); err != nil {
+			glog.Errorf("Couldn't read config: %v", err)
+			return nil, err
+		}
+		if cfg.Global.TokenURL == "" {
+			glog.Warning("Empty tokenUrl in cloud config")
+		} else {
+			glog.Infof("Using TokenSource from config %#v", tokenSource)
+			tokenSource = provider_gce.NewAltTokenSource(cfg.Global.TokenURL, cfg.Global.TokenBody)
+		}
+	} else {
+		glog.Infof("Using default TokenSource %#v", tokenSource)
+	}
+
+	client := oauth2.NewClient(oauth2.NoContext, tokenSource)
+	gceService, err := gce.New(client)
+	if err != nil {
+		return nil, err
+	}
+	containerService, err := container.New(client)
+	if err != nil {
+		return nil, err
+	}
+	return &GkeManager{
+		service: &apiGkeService{
+			client:           client,
+			gceService:       gceService,
+			containerService: containerService,
+		},
+	}, nil
+}
+
+// getMig returns the mig backing the node pool. Only single-zone node pools, backed by exactly
+// one mig, are supported.
+func (m *GkeManager) getMig(pool *NodePool) (MigRef, error) {
+	nodePool, err := m.service.GetNodePool(pool.NodePoolRef)
+	if err != nil {
+		return MigRef{}, err
+	}
+	if len(nodePool.InstanceGroupUrls) != 1 {
+		return MigRef{}, fmt.Errorf("node pool %s has %d instance groups, only single-zone node pools are supported",
+			pool.Id(), len(nodePool.InstanceGroupUrls))
+	}
+	return MigRefFromUrl(nodePool.InstanceGroupUrls[0])
+}
+
+// GetNodePoolSize gets node pool size.
+func (m *GkeManager) GetNodePoolSize(pool *NodePool) (int64, error) {
+	mig, err := m.getMig(pool)
+	if err != nil {
+		return -1, err
+	}
+	return m.service.GetMigSize(mig)
+}
+
+// GetNodePoolInstanceCount returns the number of existing instances of the node pool that are not
+// being deleted.
+func (m *GkeManager) GetNodePoolInstanceCount(pool *NodePool) (int64, error) {
+	mig, err := m.getMig(pool)
+	if err != nil {
+		return -1, err
+	}
+	return m.service.GetMigInstanceCount(mig)
+}
+
+// SetNodePoolSize sets node pool size.
+func (m *GkeManager) SetNodePoolSize(pool *NodePool, size int64) error {
+	return m.service.SetNodePoolSize(pool.NodePoolRef, size)
+}
+
+// DeleteInstances deletes the given instances from the node pool.
+func (m *GkeManager) DeleteInstances(pool *NodePool, instanceUrls []string) error {
+	if len(instanceUrls) == 0 {
+		return nil
+	}
+	mig, err := m.getMig(pool)
+	if err != nil {
+		return err
+	}
+	return m.service.DeleteMigInstances(mig, instanceUrls)
+}
+
+// apiGkeService implements gkeService with the container and compute apis.
+type apiGkeService struct {
+	client           *http.Client
+	gceService       *gce.Service
+	containerService *container.Service
+}
+
+func (s *apiGkeService) GetNodePool(pool NodePoolRef) (*container.NodePool, error) {
+	return s.containerService.Projects.Zones.Clusters.NodePools.Get(pool.Project, pool.Zone, pool.Cluster, pool.Name).Do()
+}
+
+// SetNodePoolSize calls the setSize method of the container api, which is missing in the vendored
+// client library.
+func (s *apiGkeService) SetNodePoolSize(pool NodePoolRef, size int64) error {
+	body, err := json.Marshal(map[string]int64{"nodeCount": size})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%sv1/projects/%s/zones/%s/clusters/%s/nodePools/%s/setSize",
+		s.containerService.BasePath, pool.Project, pool.Zone, pool.Cluster, pool.Name)
+	res, err := s.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer googleapi.CloseBody(res)
+	if err := googleapi.CheckResponse(res); err != nil {
+		return err
+	}
+	op := &container.Operation{}
+	if err := json.NewDecoder(res.Body).Decode(op); err != nil {
+		return err
+	}
+	for start := time.Now(); time.Since(start) < operationWaitTimeout; time.Sleep(operationPollInterval) {
+		glog.V(4).Infof("Waiting for operation %s %s %s", pool.Project, pool.Zone, op.Name)
+		if current, err := s.containerService.Projects.Zones.Operations.Get(pool.Project, pool.Zone, op.Name).Do(); err == nil {
+			glog.V(4).Infof("Operation %s %s %s status: %s", pool.Project, pool.Zone, op.Name, current.Status)
+			if current.Status == "DONE" {
+				return nil
+			}
+		} else {
+			glog.Warningf("Error while getting operation on %s: %v", pool.Name, err)
+		}
+	}
+	return fmt.Errorf("Timeout while waiting for resize of node pool %s to complete.", pool.Name)
+}
+
+func (s *apiGkeService) GetMigSize(mig MigRef) (int64, error) {
+	igm, err := s.gceService.InstanceGroupManagers.Get(mig.Project, mig.Zone, mig.Name).Do()
+	if err != nil {
+		return -1, err
+	}
+	return igm.TargetSize, nil
+}
+
+func (s *apiGkeService) GetMigInstanceCount(mig MigRef) (int64, error) {
+	igm, err := s.gceService.InstanceGroupManagers.Get(mig.Project, mig.Zone, mig.Name).Do()
+	if err != nil {
+		return -1, err
+	}
+	if igm.CurrentActions == nil {
+		return 0, nil
+	}
+	actions := igm.CurrentActions
+	return actions.None + actions.Recreating + actions.Refreshing + actions.Restarting, nil
+}
+
+func (s *apiGkeService) DeleteMigInstances(mig MigRef, instanceUrls []string) error {
+	req := gce.InstanceGroupManagersDeleteInstancesRequest{
+		Instances: instanceUrls,
+	}
+	op, err := s.gceService.InstanceGroupManagers.DeleteInstances(mig.Project, mig.Zone, mig.Name, &req).Do()
+	if err != nil {
+		return err
+	}
+	for start := time.Now(); time.Since(start) < operationWaitTimeout; time.Sleep(operationPollInterval) {
+		glog.V(4).Infof("Waiting for operation %s %s %s", mig.Project, mig.Zone, op.Name)
+		if current, err := s.gceService.ZoneOperations.Get(mig.Project, mig.Zone, op.Name).Do(); err == nil {
+			glog.V(4).Infof("Operation %s %s %s status: %s", mig.Project, mig.Zone, op.Name, current.Status)
+			if current.Status == "DONE" {
+				return nil
+			}
+		} else {
+			glog.Warningf("Error while getting operation on %s: %v", mig.Name, err)
+		}
+	}
+	return fmt.Errorf("Timeout while waiting for deletion of instances from %s to complete.", mig.Name)
+}
@@ -0,0 +1,244 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"fmt"
+	"sync"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	kube_api "k8s.io/kubernetes/pkg/api"
+)
+
+// OnScaleUpFunc is a function called on node group increase in TestCloudProvider.
+// First parameter is the NodeGroup id, second is the increase delta.
+type OnScaleUpFunc func(string, int) error
+
+// OnScaleDownFunc is a function called on cluster scale down
+type OnScaleDownFunc func(string, string) error
+
+// TestCloudProvider is a dummy cloud provider to be used in tests.
+type TestCloudProvider struct {
+	sync.Mutex
+	nodes       map[string]string
+	groups      map[string]*TestNodeGroup
+	onScaleUp   func(string, int) error
+	onScaleDown func(string, string) error
+}
+
+// NewTestCloudProvider builds new TestCloudProvider
+func NewTestCloudProvider(onScaleUp OnScaleUpFunc, onScaleDown OnScaleDownFunc) *TestCloudProvider {
+	return &TestCloudProvider{
+		nodes:       make(map[string]string),
+		groups:      make(map[string]*TestNodeGroup),
+		onScaleUp:   onScaleUp,
+		onScaleDown: onScaleDown,
+	}
+}
+
+// Name returns name of the cloud provider.
+func (tcp *TestCloudProvider) Name() string {
+	return "TestCloudProvider"
+}
+
+// NodeGroups returns all node groups configured for this cloud provider.
+func (tcp *TestCloudProvider) NodeGroups() []cloudprovider.NodeGroup {
+	tcp.Lock()
+	defer tcp.Unlock()
+
+	result := make([]cloudprovider.NodeGroup, 0)
+	for _, group := range tcp.groups {
+		result = append(result, group)
+	}
+	return result
+}
+
+// NodeGroupForNode returns the node group for the given node.
+func (tcp *TestCloudProvider) NodeGroupForNode(node *kube_api.Node) (cloudprovider.NodeGroup, error) {
+	tcp.Lock()
+	defer tcp.Unlock()
+
+	groupName, found := tcp.nodes[node.Name]
+	if !found {
+		return nil, nil
+	}
+	group, found := tcp.groups[groupName]
+	if !found {
+		return nil, nil
+	}
+	return group, nil
+}
+
+// NodeGroupsForNodes returns the node groups of the given nodes, keyed by node name.
+func (tcp *TestCloudProvider) NodeGroupsForNodes(nodes []*kube_api.Node) (map[string]cloudprovider.NodeGroup, error) {
+	tcp.Lock()
+	defer tcp.Unlock()
+
+	result := make(map[string]cloudprovider.NodeGroup)
+	for _, node := range nodes {
+		groupName, found := tcp.nodes[node.Name]
+		if !found {
+			continue
+		}
+		if group, found := tcp.groups[groupName]; found {
+			result[node.Name] = group
+		}
+	}
+	return result, nil
+}
+
+// Refresh is a no-op, as test node groups are kept in memory.
+func (tcp *TestCloudProvider) Refresh() error {
+	return nil
+}
+
+// Cleanup is a no-op, as test cloud provider has no background work.
+func (tcp *TestCloudProvider) Cleanup() error {
+	return nil
+}
+
+// RefreshSizes is a no-op, as test node groups keep their sizes in memory.
+func (tcp *TestCloudProvider) RefreshSizes() error {
+	return nil
+}
+
+// AddNodeGroup adds node group to test cloud provider.
+func (tcp *TestCloudProvider) AddNodeGroup(id string, min int, max int, size int) {
+	tcp.Lock()
+	defer tcp.Unlock()
+
+	tcp.groups[id] = &TestNodeGroup{
+		cloudProvider: tcp,
+		id:            id,
+		minSize:       min,
+		maxSize:       max,
+		targetSize:    size,
+	}
+}
+
+// AddNode adds the given node to the group.
+func (tcp *TestCloudProvider) AddNode(nodeGroupId string, node *kube_api.Node) {
+	tcp.Lock()
+	defer tcp.Unlock()
+	tcp.nodes[node.Name] = nodeGroupId
+}
+
+// TestNodeGroup is a node group used by TestCloudProvider.
+type TestNodeGroup struct {
+	sync.Mutex
+	cloudProvider *TestCloudProvider
+	id            string
+	maxSize       int
+	minSize       int
+	targetSize    int
+}
+
+// MaxSize returns maximum size of the node group.
+func (tng *TestNodeGroup) MaxSize() int {
+	tng.Lock()
+	defer tng.Unlock()
+
+	return tng.maxSize
+}
+
+// MinSize returns minimum size of the node group.
+func (tng *TestNodeGroup) MinSize() int {
+	tng.Lock()
+	defer tng.Unlock()
+
+	return tng.minSize
+}
+
+// TargetSize returns the current TARGET size of the node group. It is possible that the
+// number is different from the number of nodes registered in Kuberentes.
+func (tng *TestNodeGroup) TargetSize() (int, error) {
+	tng.Lock()
+	defer tng.Unlock()
+
+	return tng.targetSize, nil
+}
+
+// IncreaseSize increases node group size.
+func (tng *TestNodeGroup) IncreaseSize(delta int) error {
+	tng.Lock()
+	defer tng.Unlock()
+
+	if delta <= 0 {
+		return fmt.Errorf("size increase must be positive")
+	}
+	if tng.targetSize+delta > tng.maxSize {
+		return fmt.Errorf("size increase too large - desired:%d max:%d", tng.targetSize+delta, tng.maxSize)
+	}
+	if tng.cloudProvider.onScaleUp != nil {
+		if err := tng.cloudProvider.onScaleUp(tng.id, delta); err != nil {
+			return err
+		}
+	}
+	tng.targetSize += delta
+	return nil
+}
+
+// DecreaseTargetSize decreases the target size of the node group. Test node groups have no
+// instances, so it only fails instead of decreasing the size below 0. Delta should be negative.
+func (tng *TestNodeGroup) DecreaseTargetSize(delta int) error {
+	tng.Lock()
+	defer tng.Unlock()
+
+	if delta >= 0 {
+		return fmt.Errorf("size decrease must be negative")
+	}
+	if tng.targetSize+delta < 0 {
+		return fmt.Errorf("size decrease too large - desired:%d", tng.targetSize+delta)
+	}
+	tng.targetSize += delta
+	return nil
+}
+
+// DeleteNodes deletes nodes from the group.
+func (tng *TestNodeGroup) DeleteNodes(nodes []*kube_api.Node) error {
+	tng.Lock()
+	id := tng.id
+	tng.Unlock()
+
+	for _, node := range nodes {
+		if tng.cloudProvider.onScaleDown != nil {
+			if err := tng.cloudProvider.onScaleDown(id, node.Name); err != nil {
+				return err
+			}
+		}
+		tng.Lock()
+		tng.targetSize--
+		tng.Unlock()
+	}
+	return nil
+}
+
+// Id returns an unique identifier of the node group.
+func (tng *TestNodeGroup) Id() string {
+	tng.Lock()
+	defer tng.Unlock()
+
+	return tng.id
+}
+
+// Debug returns a string containing all information regarding this node group.
+func (tng *TestNodeGroup) Debug() string {
+	tng.Lock()
+	defer tng.Unlock()
+
+	return fmt.Sprintf("%s target:%d min:%d max:%d", tng.id, tng.targetSize, tng.minSize, tng.maxSize)
+}
@@ -18,27 +18,35 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
 	"k8s.io/contrib/cluster-autoscaler/cloudprovider/aws"
 	"k8s.io/contrib/cluster-autoscaler/cloudprovider/gce"
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/gke"
 	"k8s.io/contrib/cluster-autoscaler/config"
+	"k8s.io/contrib/cluster-autoscaler/estimator"
 	"k8s.io/contrib/cluster-autoscaler/simulator"
+	"k8s.io/contrib/cluster-autoscaler/utils/clock"
 	kube_util "k8s.io/contrib/cluster-autoscaler/utils/kubernetes"
 	kube_api "k8s.io/kubernetes/pkg/api"
 	kube_leaderelection "k8s.io/kubernetes/pkg/client/leaderelection"
 	kube_record "k8s.io/kubernetes/pkg/client/record"
 	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/labels"
 	kube_flag "k8s.io/kubernetes/pkg/util/flag"
 
 	"github.com/golang/glog"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/pflag"
+	"golang.org/x/net/context"
 )
 
 // MultiStringFlag is a flag for passing multiple parameters using same flag
@@ -64,32 +72,174 @@ const (
 
 var (
 	nodeGroupsFlag          MultiStringFlag
+	disabledNodeGroupsFlag  MultiStringFlag
+	ignorablePodsFlag       MultiStringFlag
+	ignoreTaintsFlag        MultiStringFlag
+	balancingIgnoreLabels   MultiStringFlag
+	expanderPodClassesFlag  MultiStringFlag
 	address                 = flag.String("address", ":8085", "The address to expose prometheus metrics.")
 	kubernetes              = flag.String("kubernetes", "", "Kuberentes master location. Leave blank for default")
 	cloudConfig             = flag.String("cloud-config", "", "The path to the cloud provider configuration file.  Empty string for no configuration file.")
 	verifyUnschedulablePods = flag.Bool("verify-unschedulable-pods", true,
 		"If enabled CA will ensure that each pod marked by Scheduler as unschedulable actually can't be scheduled on any node."+
 			"This prevents from adding unnecessary nodes in situation when CA and Scheduler have different configuration.")
-	scaleDownEnabled = flag.Bool("scale-down-enabled", true, "Should CA scale down the cluster")
-	scaleDownDelay   = flag.Duration("scale-down-delay", 10*time.Minute,
+	watchPersistentVolumeClaims = flag.Bool("watch-persistent-volume-claims", true,
+		"Should CA watch PersistentVolumeClaims in all namespaces, so that pods waiting for a claim to be bound don't trigger scale up. "+
+			"Requires list and watch permissions on persistentvolumeclaims.")
+	scaleDownEnabled       = flag.Bool("scale-down-enabled", true, "Should CA scale down the cluster")
+	scaleDownDelayAfterAdd = flag.Duration("scale-down-delay-after-add", 10*time.Minute,
 		"Duration from the last scale up to the time when CA starts to check scale down options")
+	scaleDownDelayAfterDelete = flag.Duration("scale-down-delay-after-delete", 0,
+		"Duration from the last node deletion to the time when CA attempts the next scale down")
+	scaleDownDelayAfterFailure = flag.Duration("scale-down-delay-after-failure", 3*time.Minute,
+		"Duration from the last failed scale down to the time when CA attempts the next scale down")
+	scaleDownDelay        = flag.Duration("scale-down-delay", 0, "Deprecated, use --scale-down-delay-after-add. Overrides it if set.")
 	scaleDownUnneededTime = flag.Duration("scale-down-unneeded-time", 10*time.Minute,
 		"How long the node should be unneeded before it is eligible for scale down")
 	scaleDownUtilizationThreshold = flag.Float64("scale-down-utilization-threshold", 0.5,
 		"Node utilization level, defined as sum of requested resources divided by capacity, below which a node can be considered for scale down")
+	ignoreDaemonSetsUtilization = flag.Bool("ignore-daemonsets-utilization", false,
+		"If true, requests of DaemonSet and mirror pods are not counted in node utilization when checking scale down. "+
+			"These pods run on every node, so they don't need room elsewhere when the node is removed.")
+	maxPodEvictionTime = flag.Duration("max-pod-eviction-time", 2*time.Minute,
+		"Maximum time CA retries evicting the pods of a node that is scaled down, for example when a PodDisruptionBudget refuses the eviction. "+
+			"After that the node is skipped, unless --force-drain is set. Pods are evicted in ascending order of their "+
+			"cluster-autoscaler.kubernetes.io/pod-priority annotation, which has to be set on the pods: pod.Spec.Priority is not read.")
+	forceDrain = flag.Bool("force-drain", false,
+		"If true, pods that couldn't be evicted within --max-pod-eviction-time are deleted, ignoring PodDisruptionBudgets, and the node is removed.")
 	scaleDownTrialInterval = flag.Duration("scale-down-trial-interval", 1*time.Minute,
 		"How often scale down possiblity is check")
 	scanInterval           = flag.Duration("scan-interval", 10*time.Second, "How often cluster is reevaluated for scale up or down")
+	scanTimeout            = flag.Duration("scan-timeout", 0, "Maximum duration of a single scan. Longer scans are abandoned without starting scale operations. 0 means no timeout.")
 	maxNodesTotal          = flag.Int("max-nodes-total", 0, "Maximum number of nodes in all node groups. Cluster autoscaler will not grow the cluster beyond this number.")
-	cloudProviderFlag      = flag.String("cloud-provider", "gce", "Cloud provider type. Allowed values: gce, aws")
+	maxNodeGroups          = flag.Int("max-node-groups", 0, "Maximum number of node groups, including the ones discovered by the cloud provider. Exceeding it fails the startup or the discovery of new groups. 0 means no limit.")
+	minNodesTotal          = flag.Int("min-nodes-total", 0, "Minimum number of nodes in all node groups. Cluster autoscaler will not shrink the cluster below this number.")
+	coresTotal             = flag.String("cores-total", "0:0", "Minimum and maximum number of cores in all node groups, in format <min>:<max>. Max 0 means no limit.")
+	memoryTotal            = flag.String("memory-total", "0:0", "Minimum and maximum number of gigabytes (GiB) of memory in all node groups, in format <min>:<max>. Max 0 means no limit.")
+	cloudProviderFlag      = flag.String("cloud-provider", "gce", "Cloud provider type. Allowed values: gce, gke, aws")
 	maxEmptyBulkDeleteFlag = flag.Int("max-empty-bulk-delete", 10, "Maximum number of empty nodes that can be deleted at the same time.")
+	nodeDeletionBatching   = flag.Duration("node-deletion-batching", 0,
+		"For how long empty nodes are collected before all empty nodes of a node group are deleted in a single cloud provider call. "+
+			"0 deletes every empty node separately.")
+
+	maxNodeProvisionTime = flag.Duration("max-node-provision-time", 15*time.Minute,
+		"Maximum time CA waits for a requested node to register. After that the target size of the node group is decreased back.")
+	maxWarmNodeProvisionTime = flag.Duration("max-warm-node-provision-time", 5*time.Minute,
+		"Maximum time CA waits for requested nodes that are all taken from a warm pool of pre-provisioned instances to register. "+
+			"0 waits as long as for other nodes.")
+	scaleUpFailureBackoff = flag.Duration("scale-up-failure-backoff", 5*time.Minute,
+		"For how long a node group whose requested nodes timed out or failed to launch is not scaled up, so that other node groups are tried instead. "+
+			"0 disables the backoff.")
+	phantomCapacityTimeout = flag.Duration("phantom-capacity-timeout", 0,
+		"How long the target size of a node group may exceed the number of its registered nodes when no scale up of the group is pending. "+
+			"After that the target size is decreased to the number of registered nodes. 0 disables the reconciliation.")
+	newNodeGroupGracePeriod = flag.Duration("new-node-group-grace-period", 0,
+		"For how long a node group registered at startup or by the discovery of the cloud provider is neither scaled up nor down, "+
+			"while its cloud state may still be inconsistent. 0 disables the grace period.")
+	maxDivergentScans = flag.Int("max-divergent-scans", 0,
+		"Number of scans in which a node group may have more requested than registered nodes before it is marked unhealthy "+
+			"and no longer scaled up, until a new node of the group registers. 0 disables the check.")
+	scaleUpHintsURL = flag.String("scale-up-hints-url", "", "Optional URL returning a JSON object that maps node group ids to minimum sizes. "+
+		"Cluster autoscaler scales node groups up to these sizes even if there are no unschedulable pods, and doesn't scale them down below. "+
+		"If the URL can't be fetched the last hints are used.")
+	scaleUpHintsTimeout = flag.Duration("scale-up-hints-timeout", 5*time.Second, "Timeout for fetching scale up hints from --scale-up-hints-url.")
+	scaleDownVetoURL    = flag.String("scale-down-veto-url", "", "Optional URL to which every node about to be removed in scale down is posted, "+
+		"with its pods. The endpoint can veto the removal, in which case the node is skipped in that iteration.")
+	scaleDownVetoTimeout  = flag.Duration("scale-down-veto-timeout", 5*time.Second, "Timeout of requests to --scale-down-veto-url.")
+	scaleDownVetoFailOpen = flag.Bool("scale-down-veto-fail-open", false,
+		"If true, nodes are removed when the request to --scale-down-veto-url fails. Otherwise they are kept.")
+	statusNamespace = flag.String("status-namespace", "kube-system", "Namespace of the "+StatusConfigMapName+" ConfigMap to which the autoscaler status is written on every scan.")
+
+	circuitBreakerFailures = flag.Int("circuit-breaker-failures", 5,
+		"Number of consecutive failed scale operations on the cloud provider after which scaling is stopped for --circuit-breaker-cooldown. 0 disables the circuit breaker.")
+	circuitBreakerCooldown = flag.Duration("circuit-breaker-cooldown", 5*time.Minute,
+		"How long scaling is stopped after --circuit-breaker-failures consecutive failures. After that a single scale operation is tried before scaling is resumed.")
+
+	maxTotalUnreadyPercentage = flag.Float64("max-total-unready-percentage", 45,
+		"Maximum percentage of unready nodes in the cluster. Above it, and above --ok-total-unready-count, scale up and scale down are halted.")
+	okTotalUnreadyCount = flag.Int("ok-total-unready-count", 3, "Number of unready nodes that is always tolerated, regardless of --max-total-unready-percentage.")
+
+	consistencyCheckInterval = flag.Duration("cloud-consistency-check-interval", 5*time.Minute,
+		"How often the target size of every node group is compared with the number of its registered nodes. Discrepancies are logged "+
+			"and exposed as the cluster_autoscaler_node_group_size_discrepancy metric. 0 disables the check.")
+
+	nodeLabelSelector = flag.String("node-label-selector", "",
+		"Label selector of the nodes managed by the autoscaler, e.g. managed-by=terraform. Other nodes are ignored entirely. Empty selects all nodes.")
+	gpuNodeLabel = flag.String("gpu-node-label", "",
+		"Label of GPU nodes, e.g. accelerator. GPU nodes are treated as unready until they advertise allocatable GPUs. Empty disables the check.")
+
+	eventSourceComponent = flag.String("event-source-component", "cluster-autoscaler",
+		"Source component of the events recorded by the autoscaler. Lets clusters running multiple autoscalers tell their events apart.")
+	errorLogSummaryInterval = flag.Duration("error-log-summary-interval", 5*time.Minute,
+		"Errors repeated on every scan are logged once and then summarized with a count at most this often. 0 logs every error.")
+
+	awsAsgDiscoveryTags = flag.String("aws-asg-discovery-tags", "",
+		"Comma separated list of tag keys. If set, ASGs having all of these tags are autoscaled in addition to the ones "+
+			"configured with --nodes, with the min and max size of the ASG.")
+	awsAsgDescribeBatchSize = flag.Int("aws-asg-describe-batch-size", aws.DefaultAsgDescribeBatchSize,
+		fmt.Sprintf("Number of ASG names described with a single DescribeAutoScalingGroups call, between 1 and %d.", aws.MaxAsgNamesPerDescribe))
+	awsAsgRefreshWorkers = flag.Int("aws-asg-refresh-workers", aws.DefaultAsgRefreshWorkers,
+		"Number of concurrent DescribeAutoScalingGroups calls made when refreshing ASGs, each describing --aws-asg-describe-batch-size ASGs.")
+	awsAsgDiscoveryRefreshInterval = flag.Duration("aws-asg-discovery-refresh-interval", time.Minute,
+		"How often the ASGs matching --aws-asg-discovery-tags are discovered again, so that newly tagged ASGs are autoscaled "+
+			"and deleted or untagged ones are not.")
+	awsReconcileAsgBounds = flag.Bool("aws-reconcile-asg-bounds", false,
+		"If true, the min and max size of every ASG are re-read from AWS on every refresh and the min and max sizes configured "+
+			"with --nodes are clamped to them, so that changes of the ASG bounds take effect without a restart.")
+	awsWarmPools = flag.Bool("aws-warm-pools", false,
+		"If true, the warm pools of ASGs are described on every refresh and their pre-initialized instances are treated as capacity "+
+			"that the ASG brings into service almost instantly. Requires the autoscaling:DescribeWarmPool permission.")
+	awsReadMaxRetries = flag.Int("aws-read-max-retries", aws.DefaultMaxRetries,
+		"Maximum number of retries of failed autoscaling calls that only describe ASGs. -1 uses the AWS SDK default.")
+	awsWriteMaxRetries = flag.Int("aws-write-max-retries", aws.DefaultMaxRetries,
+		"Maximum number of retries of failed autoscaling calls that change ASGs, i.e. set their desired capacity, terminate "+
+			"instances or complete lifecycle actions. -1 uses the AWS SDK default.")
+	gceDeleteBatchSize = flag.Int("gce-delete-batch-size", gce.DefaultDeleteBatchSize,
+		fmt.Sprintf("Number of instances deleted from a MIG with a single DeleteInstances call, between 1 and %d.", gce.MaxInstancesPerDelete))
+	gceDeleteWorkers = flag.Int("gce-delete-workers", gce.DefaultDeleteWorkers,
+		"Number of concurrent DeleteInstances calls made when deleting instances, each deleting --gce-delete-batch-size instances.")
 
 	// AvailableEstimators is a list of available estimators.
 	AvailableEstimators = []string{BasicEstimatorName, BinpackingEstimatorName}
 	estimatorFlag       = flag.String("estimator", BinpackingEstimatorName,
 		"Type of resource estimator to be used in scale up. Available values: ["+strings.Join(AvailableEstimators, ",")+"]")
+
+	// AvailableExpanders is a list of available expanders.
+	AvailableExpanders = []string{DefaultExpanderName, HttpExpanderName, PriorityExpanderName}
+	expanderFlag       = flag.String("expander", DefaultExpanderName,
+		"Type of expander picking the node group to scale up. Available values: ["+strings.Join(AvailableExpanders, ",")+"]. "+
+			"The http expander posts the scale up options to --expander-url and falls back to the default expander on error. "+
+			"The priority expander only considers the node groups with the highest priority, see --expander-priorities.")
+	expanderURL     = flag.String("expander-url", "", "URL of the http expander.")
+	expanderTimeout = flag.Duration("expander-timeout", 5*time.Second, "Timeout of requests to the http expander.")
+
+	expanderPriorities = flag.String("expander-priorities", "",
+		"Priorities of node groups used by the priority expander, in format <node group id>=<priority>,... "+
+			"Priorities set with the k8s.io/cluster-autoscaler/priority ASG tag or the cluster-autoscaler-priority "+
+			"MIG instance template metadata take precedence. Node groups without a priority have priority 0.")
+
+	expanderRandomTieBreak = flag.Bool("expander-random-tie-break", false,
+		"If true, a random node group is picked among equally good scale up options. Otherwise the node group "+
+			"with the smallest id is picked, so that repeated runs on the same cluster state choose the same group.")
+	balanceSimilarNodeGroups = flag.Bool("balance-similar-node-groups", false,
+		"If true, scale ups are split between node groups with the same capacity, allocatable resources and labels, keeping their sizes balanced, "+
+			"e.g. between node groups of the same instance type in different zones. Labels set with --balancing-ignore-label are not compared.")
+	estimatorResourceModeFlag = flag.String("estimator-resource-mode", estimator.RequestsResourceMode,
+		"Pod resources used by the estimator to compute the number of nodes needed in scale up. Limits fall back to requests if unset. "+
+			"The scheduler and predicate checks always use requests. Available values: ["+strings.Join(estimator.AvailableResourceModes, ",")+"]")
 )
 
+// isFlagSet returns true if the flag was given on the command line, even if with its default value.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 func createKubeClient() *kube_client.Client {
 	url, err := url.Parse(*kubernetes)
 	if err != nil {
@@ -104,17 +254,19 @@ func createKubeClient() *kube_client.Client {
 	return kube_client.NewOrDie(kubeConfig)
 }
 
-func createEventRecorder(kubeClient *kube_client.Client) kube_record.EventRecorder {
+func createEventRecorder(kubeClient *kube_client.Client, component string) kube_record.EventRecorder {
 	eventBroadcaster := kube_record.NewBroadcaster()
 	eventBroadcaster.StartLogging(glog.Infof)
 	eventBroadcaster.StartRecordingToSink(kubeClient.Events(""))
-	return eventBroadcaster.NewRecorder(kube_api.EventSource{Component: "cluster-autoscaler"})
+	return eventBroadcaster.NewRecorder(kube_api.EventSource{Component: component})
 }
 
 // In order to meet interface criteria for LeaderElectionConfig we need to
 // take stop channell as an argument. However, since we are committing a suicide
-// after loosing mastership we can safely ignore it.
-func run(_ <-chan struct{}) {
+// after loosing mastership we can safely ignore it. The circuit breaker is built
+// by main, so that the health check can be served before the mastership is acquired.
+func run(_ <-chan struct{}, circuitBreaker *CircuitBreaker, estimationReports *EstimationReports,
+	scaleDownReports *ScaleDownReports, nodeGroupDetails *NodeGroupDetailsEndpoint, scaleUpEvaluations *ScaleUpEvaluationEndpoint) {
 	kubeClient := createKubeClient()
 
 	predicateChecker, err := simulator.NewPredicateChecker(kubeClient)
@@ -124,18 +276,42 @@ func run(_ <-chan struct{}) {
 	unschedulablePodLister := kube_util.NewUnschedulablePodLister(kubeClient, kube_api.NamespaceAll)
 	scheduledPodLister := kube_util.NewScheduledPodLister(kubeClient)
 	nodeLister := kube_util.NewNodeLister(kubeClient)
+	nodeSelector, err := labels.Parse(*nodeLabelSelector)
+	if err != nil {
+		glog.Fatalf("Failed to parse --node-label-selector: %v", err)
+	}
 
-	lastScaleUpTime := time.Now()
-	lastScaleDownFailedTrial := time.Now()
-	unneededNodes := make(map[string]time.Time)
-	podLocationHints := make(map[string]string)
-	nodeUtilizationMap := make(map[string]float64)
-	usageTracker := simulator.NewUsageTracker()
+	if isFlagSet("scale-down-delay") {
+		glog.Warningf("--scale-down-delay is deprecated, use --scale-down-delay-after-add")
+	}
+	errorLog := NewLogDeduplicator(*errorLogSummaryInterval)
 
-	recorder := createEventRecorder(kubeClient)
+	recorder := createEventRecorder(kubeClient, *eventSourceComponent)
 
 	var cloudProvider cloudprovider.CloudProvider
 
+	// The cloud providers and the scans read the time from the same clock.
+	autoscalerClock := clock.RealClock{}
+	gceOptions := gce.GceOptions{
+		DeleteBatchSize: *gceDeleteBatchSize,
+		DeleteWorkers:   *gceDeleteWorkers,
+		Clock:           autoscalerClock,
+	}
+	awsOptions := aws.AwsOptions{
+		MaxAsgs:                  *maxNodeGroups,
+		DiscoveryRefreshInterval: *awsAsgDiscoveryRefreshInterval,
+		DescribeBatchSize:        *awsAsgDescribeBatchSize,
+		RefreshWorkers:           *awsAsgRefreshWorkers,
+		WarmPools:                *awsWarmPools,
+		ReconcileBounds:          *awsReconcileAsgBounds,
+		ReadMaxRetries:           *awsReadMaxRetries,
+		WriteMaxRetries:          *awsWriteMaxRetries,
+		Clock:                    autoscalerClock,
+	}
+	if *awsAsgDiscoveryTags != "" {
+		awsOptions.DiscoveryTags = strings.Split(*awsAsgDiscoveryTags, ",")
+	}
+
 	if *cloudProviderFlag == "gce" {
 		// GCE Manager
 		var gceManager *gce.GceManager
@@ -143,15 +319,15 @@ func run(_ <-chan struct{}) {
 		if *cloudConfig != "" {
 			config, fileErr := os.Open(*cloudConfig)
 			if fileErr != nil {
-				glog.Fatalf("Couldn't open cloud provider configuration %s: %#v", *cloudConfig, err)
+				glog.Fatalf("Couldn't open cloud provider configuration %s: %#v", *cloudConfig, fileErr)
 			}
 			defer config.Close()
-			gceManager, gceError = gce.CreateGceManager(config)
+			gceManager, gceError = gce.CreateGceManager(config, gceOptions)
 		} else {
-			gceManager, gceError = gce.CreateGceManager(nil)
+			gceManager, gceError = gce.CreateGceManager(nil, gceOptions)
 		}
 		if gceError != nil {
-			glog.Fatalf("Failed to create GCE Manager: %v", err)
+			glog.Fatalf("Failed to create GCE Manager: %v", gceError)
 		}
 		cloudProvider, err = gce.BuildGceCloudProvider(gceManager, nodeGroupsFlag)
 		if err != nil {
@@ -159,184 +335,221 @@ func run(_ <-chan struct{}) {
 		}
 	}
 
+	if *cloudProviderFlag == "gke" {
+		var gkeManager *gke.GkeManager
+		var gkeError error
+		if *cloudConfig != "" {
+			config, fileErr := os.Open(*cloudConfig)
+			if fileErr != nil {
+				glog.Fatalf("Couldn't open cloud provider configuration %s: %#v", *cloudConfig, fileErr)
+			}
+			defer config.Close()
+			gkeManager, gkeError = gke.CreateGkeManager(config)
+		} else {
+			gkeManager, gkeError = gke.CreateGkeManager(nil)
+		}
+		if gkeError != nil {
+			glog.Fatalf("Failed to create GKE Manager: %v", gkeError)
+		}
+		cloudProvider, err = gke.BuildGkeCloudProvider(gkeManager, nodeGroupsFlag)
+		if err != nil {
+			glog.Fatalf("Failed to create GKE cloud provider: %v", err)
+		}
+	}
+
 	if *cloudProviderFlag == "aws" {
 		var awsManager *aws.AwsManager
 		var awsError error
 		if *cloudConfig != "" {
 			config, fileErr := os.Open(*cloudConfig)
 			if fileErr != nil {
-				glog.Fatalf("Couldn't open cloud provider configuration %s: %#v", *cloudConfig, err)
+				glog.Fatalf("Couldn't open cloud provider configuration %s: %#v", *cloudConfig, fileErr)
 			}
 			defer config.Close()
-			awsManager, awsError = aws.CreateAwsManager(config)
+			awsManager, awsError = aws.CreateAwsManager(config, awsOptions)
 		} else {
-			awsManager, awsError = aws.CreateAwsManager(nil)
+			awsManager, awsError = aws.CreateAwsManager(nil, awsOptions)
 		}
 		if awsError != nil {
-			glog.Fatalf("Failed to create AWS Manager: %v", err)
+			glog.Fatalf("Failed to create AWS Manager: %v", awsError)
 		}
 		cloudProvider, err = aws.BuildAwsCloudProvider(awsManager, nodeGroupsFlag)
 		if err != nil {
 			glog.Fatalf("Failed to create AWS cloud provider: %v", err)
 		}
 	}
+	if count := len(cloudProvider.NodeGroups()); *maxNodeGroups > 0 && count > *maxNodeGroups {
+		glog.Fatalf("Found %d node groups, more than --max-node-groups=%d", count, *maxNodeGroups)
+	}
 
+	// Signals are handled by the main loop between scans, so that a running scan isn't interrupted
+	// in the middle of a scale operation.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	autoscalingContext := AutoscalingContext{
+		CloudProvider:          cloudProvider,
+		ClientSet:              kubeClient,
+		Recorder:               recorder,
+		PredicateChecker:       predicateChecker,
+		MaxEmptyBulkDelete:     *maxEmptyBulkDeleteFlag,
+		MinNodesTotal:          *minNodesTotal,
+		ScaleDownUnneededTime:  *scaleDownUnneededTime,
+		MaxNodesTotal:          *maxNodesTotal,
+		EstimatorName:          *estimatorFlag,
+		EstimatorResourceMode:  *estimatorResourceModeFlag,
+		ExpanderRandomTieBreak: *expanderRandomTieBreak,
+		Clock:                  autoscalerClock,
+		PodEvicter:             NewKubePodEvicter(kubeClient),
+		NodeCordoner:           NewKubeNodeCordoner(kubeClient),
+		MaxPodEvictionTime:     *maxPodEvictionTime,
+		ForceDrain:             *forceDrain,
+		CircuitBreaker:         circuitBreaker,
+		EstimationReports:      estimationReports,
+		ScaleDownReports:       scaleDownReports,
+		NodeDeletionTracker:    NewNodeDeletionTracker(),
+		ScaleActivity:          NewScaleActivity(),
+	}
+	minCores, maxCores, err := ParseMinMax(*coresTotal)
+	if err != nil {
+		glog.Fatalf("Failed to parse --cores-total: %v", err)
+	}
+	minMemory, maxMemory, err := ParseMinMax(*memoryTotal)
+	if err != nil {
+		glog.Fatalf("Failed to parse --memory-total: %v", err)
+	}
+	if minCores > 0 || maxCores > 0 || minMemory > 0 || maxMemory > 0 {
+		autoscalingContext.ResourceLimits = &ResourceLimits{
+			MinCores:  minCores,
+			MaxCores:  maxCores,
+			MinMemory: minMemory * gigabyte,
+			MaxMemory: maxMemory * gigabyte,
+		}
+	}
+	autoscalingContext.AutoscalerObject, err = GetAutoscalerObjectReference(kubeClient)
+	if err != nil {
+		glog.Errorf("Failed to resolve the autoscaler object, summary events will not be recorded: %v", err)
+	}
+	for _, value := range ignorablePodsFlag {
+		selector, err := ParseIgnorablePodSelector(value)
+		if err != nil {
+			glog.Fatalf("Failed to parse --ignorable-pods: %v", err)
+		}
+		autoscalingContext.IgnorablePods = append(autoscalingContext.IgnorablePods, selector)
+	}
+	if *balanceSimilarNodeGroups {
+		autoscalingContext.BalanceSimilarNodeGroups = true
+		autoscalingContext.BalancingIgnoredLabels = make(map[string]bool)
+		for _, key := range append(DefaultBalancingIgnoredLabels, balancingIgnoreLabels...) {
+			autoscalingContext.BalancingIgnoredLabels[key] = true
+		}
+	}
+	if *watchPersistentVolumeClaims {
+		autoscalingContext.PersistentVolumeClaims = kube_util.NewPersistentVolumeClaimLister(kubeClient)
+	}
+	autoscalingContext.DisabledNodeGroups = make(map[string]bool)
+	for _, id := range disabledNodeGroupsFlag {
+		autoscalingContext.DisabledNodeGroups[id] = true
+	}
+	for id := range autoscalingContext.DisabledNodeGroups {
+		if !hasNodeGroup(cloudProvider, id) {
+			glog.Warningf("Disabled node group %s is not configured with --nodes", id)
+		}
+	}
+	for _, id := range ZeroMaxSizeNodeGroups(cloudProvider) {
+		glog.Warningf("Node group %s has max size 0 and will never be scaled up", id)
+	}
+	podClasses := make([]PodClass, 0, len(expanderPodClassesFlag))
+	usedExpanders := map[string]bool{*expanderFlag: true}
+	for _, value := range expanderPodClassesFlag {
+		class, err := ParsePodClass(value)
+		if err != nil {
+			glog.Fatalf("Failed to parse --expander-pod-class: %v", err)
+		}
+		podClasses = append(podClasses, class)
+		usedExpanders[class.Expander] = true
+	}
+	var externalExpander ExternalExpander
+	if usedExpanders[HttpExpanderName] {
+		if *expanderURL == "" {
+			glog.Fatalf("--expander-url is required by the %s expander", HttpExpanderName)
+		}
+		externalExpander = NewHttpExternalExpander(*expanderURL, *expanderTimeout)
+	}
+	var priorityExpander *PriorityExpander
+	if usedExpanders[PriorityExpanderName] {
+		priorities, err := ParseExpanderPriorities(*expanderPriorities)
+		if err != nil {
+			glog.Fatalf("Failed to parse --expander-priorities: %v", err)
+		}
+		priorityExpander = NewPriorityExpander(priorities)
+	}
+	if *expanderFlag == HttpExpanderName {
+		autoscalingContext.ExternalExpander = externalExpander
+	}
+	if *expanderFlag == PriorityExpanderName {
+		autoscalingContext.PriorityExpander = priorityExpander
+	}
+	if len(podClasses) > 0 {
+		autoscalingContext.PodClassExpanders = NewPodClassExpanders(podClasses, priorityExpander, externalExpander)
+	}
+	if *scaleDownVetoURL != "" {
+		autoscalingContext.ScaleDownVeto = NewHttpScaleDownVeto(*scaleDownVetoURL, *scaleDownVetoTimeout)
+		autoscalingContext.ScaleDownVetoFailOpen = *scaleDownVetoFailOpen
+	}
+	if *scaleUpHintsURL != "" {
+		autoscalingContext.ScaleUpHintProvider = NewHttpScaleUpHintProvider(*scaleUpHintsURL, *scaleUpHintsTimeout)
+	}
+
+	ignoredTaints := make(map[string]bool)
+	for _, key := range ignoreTaintsFlag {
+		ignoredTaints[key] = true
+	}
+
+	dependencies := &ScanDependencies{
+		kubeClient:             kubeClient,
+		unschedulablePodLister: unschedulablePodLister,
+		scheduledPodLister:     scheduledPodLister,
+		nodeLister:             nodeLister,
+		nodeSelector:           nodeSelector,
+		ignoredTaints:          ignoredTaints,
+		nodeGroupDetails:       nodeGroupDetails,
+		errorLog:               errorLog,
+		baseContext:            autoscalingContext,
+	}
+	autoscaler := NewAutoscaler(dependencies)
+
+	scanRunner := NewScanRunner(*scanTimeout)
+	// A ticker, unlike a timer created on every iteration, keeps scans on schedule while scale up
+	// evaluations are requested.
+	scanTicker := time.NewTicker(*scanInterval)
+	defer scanTicker.Stop()
 	for {
 		select {
-		case <-time.After(*scanInterval):
-			{
-				loopStart := time.Now()
-				updateLastTime("main")
-
-				nodes, err := nodeLister.List()
-				if err != nil {
-					glog.Errorf("Failed to list nodes: %v", err)
-					continue
-				}
-				if len(nodes) == 0 {
-					glog.Errorf("No nodes in the cluster")
-					continue
-				}
-
-				if err := CheckGroupsAndNodes(nodes, cloudProvider); err != nil {
-					glog.Warningf("Cluster is not ready for autoscaling: %v", err)
-					continue
-				}
-
-				allUnschedulablePods, err := unschedulablePodLister.List()
-				if err != nil {
-					glog.Errorf("Failed to list unscheduled pods: %v", err)
-					continue
-				}
-
-				allScheduled, err := scheduledPodLister.List()
-				if err != nil {
-					glog.Errorf("Failed to list scheduled pods: %v", err)
-					continue
-				}
-
-				// We need to reset all pods that have been marked as unschedulable not after
-				// the newest node became available for the scheduler.
-				allNodesAvailableTime := GetAllNodesAvailableTime(nodes)
-				podsToReset, unschedulablePodsToHelp := SlicePodsByPodScheduledTime(allUnschedulablePods, allNodesAvailableTime)
-				ResetPodScheduledCondition(kubeClient, podsToReset)
-
-				// We need to check whether pods marked as unschedulable are actually unschedulable.
-				// This should prevent from adding unnecessary nodes. Example of such situation:
-				// - CA and Scheduler has slightly different configuration
-				// - Scheduler can't schedule a pod and marks it as unschedulable
-				// - CA added a node which should help the pod
-				// - Scheduler doesn't schedule the pod on the new node
-				//   because according to it logic it doesn't fit there
-				// - CA see the pod is still unschedulable, so it adds another node to help it
-				//
-				// With the check enabled the last point won't happen because CA will ignore a pod
-				// which is supposed to schedule on an existing node.
-				//
-				// Without below check cluster might be unnecessary scaled up to the max allowed size
-				// in the describe situation.
-				schedulablePodsPresent := false
-				if *verifyUnschedulablePods {
-					newUnschedulablePodsToHelp := FilterOutSchedulable(unschedulablePodsToHelp, nodes, allScheduled, predicateChecker)
-
-					if len(newUnschedulablePodsToHelp) != len(unschedulablePodsToHelp) {
-						glog.V(2).Info("Schedulable pods present")
-						schedulablePodsPresent = true
-					}
-					unschedulablePodsToHelp = newUnschedulablePodsToHelp
-				}
-
-				if len(unschedulablePodsToHelp) == 0 {
-					glog.V(1).Info("No unschedulable pods")
-				} else if *maxNodesTotal > 0 && len(nodes) >= *maxNodesTotal {
-					glog.V(1).Info("Max total nodes in cluster reached")
-				} else {
-					scaleUpStart := time.Now()
-					updateLastTime("scaleup")
-					scaledUp, err := ScaleUp(unschedulablePodsToHelp, nodes, cloudProvider, kubeClient, predicateChecker, recorder,
-						*maxNodesTotal, *estimatorFlag)
-
-					updateDuration("scaleup", scaleUpStart)
-
-					if err != nil {
-						glog.Errorf("Failed to scale up: %v", err)
-						continue
-					} else {
-						if scaledUp {
-							lastScaleUpTime = time.Now()
-							// No scale down in this iteration.
-							continue
-						}
-					}
-				}
-
-				if *scaleDownEnabled {
-					unneededStart := time.Now()
-
-					// In dry run only utilization is updated
-					calculateUnneededOnly := lastScaleUpTime.Add(*scaleDownDelay).After(time.Now()) ||
-						lastScaleDownFailedTrial.Add(*scaleDownTrialInterval).After(time.Now()) ||
-						schedulablePodsPresent
-
-					glog.V(4).Infof("Scale down status: unneededOnly=%v lastScaleUpTime=%s "+
-						"lastScaleDownFailedTrail=%s schedulablePodsPresent=%v", calculateUnneededOnly,
-						lastScaleUpTime, lastScaleDownFailedTrial, schedulablePodsPresent)
-
-					updateLastTime("findUnneeded")
-					glog.V(4).Infof("Calculating unneeded nodes")
-
-					usageTracker.CleanUp(time.Now().Add(-(*scaleDownUnneededTime)))
-					unneededNodes, podLocationHints, nodeUtilizationMap = FindUnneededNodes(
-						nodes,
-						unneededNodes,
-						*scaleDownUtilizationThreshold,
-						allScheduled,
-						predicateChecker,
-						podLocationHints,
-						usageTracker, time.Now())
-
-					updateDuration("findUnneeded", unneededStart)
-
-					for key, val := range unneededNodes {
-						if glog.V(4) {
-							glog.V(4).Infof("%s is unneeded since %s duration %s", key, val.String(), time.Now().Sub(val).String())
-						}
-					}
-
-					if !calculateUnneededOnly {
-						glog.V(4).Infof("Starting scale down")
-
-						scaleDownStart := time.Now()
-						updateLastTime("scaledown")
-
-						result, err := ScaleDown(
-							nodes,
-							nodeUtilizationMap,
-							unneededNodes,
-							*scaleDownUnneededTime,
-							allScheduled,
-							cloudProvider,
-							kubeClient,
-							predicateChecker,
-							podLocationHints,
-							usageTracker,
-							recorder,
-							*maxEmptyBulkDeleteFlag)
-
-						updateDuration("scaledown", scaleDownStart)
-
-						// TODO: revisit result handling
-						if err != nil {
-							glog.Errorf("Failed to scale down: %v", err)
-						} else {
-							if result == ScaleDownError || result == ScaleDownNoNodeDeleted {
-								lastScaleDownFailedTrial = time.Now()
-							}
-						}
-					}
-				}
-				updateDuration("main", loopStart)
+		case request := <-scaleUpEvaluations.Requests():
+			err := scanRunner.Run(func(ctx context.Context) {
+				request.Reply(autoscaler.evaluateScaleUp())
+			})
+			if err != nil {
+				request.Reply(ScaleUpEvaluation{}, err)
+			}
+		case <-scanTicker.C:
+			err := scanRunner.Run(autoscaler.RunOnce)
+			if err == errScanAbandoned {
+				// The abandoned scan may still modify the state of its autoscaler.
+				errorLog.Errorf("Scan timed out after %v, the next scans start with new state", *scanTimeout)
+				autoscaler = NewAutoscaler(dependencies)
+			} else if err != nil {
+				errorLog.Errorf("Scan failed: %v", err)
 			}
+		case sig := <-signals:
+			// Stop the background work of the cloud provider before exiting.
+			glog.Infof("Received %v, cleaning up the cloud provider", sig)
+			if err := cloudProvider.Cleanup(); err != nil {
+				glog.Errorf("Failed to clean up the cloud provider: %v", err)
+			}
+			glog.Flush()
+			os.Exit(0)
 		}
 	}
 }
@@ -348,6 +561,17 @@ func main() {
 	kube_leaderelection.BindFlags(&leaderElection, pflag.CommandLine)
 	flag.Var(&nodeGroupsFlag, "nodes", "sets min,max size and other configuration data for a node group in a format accepted by cloud provider."+
 		"Can be used multiple times. Format: <min>:<max>:<other...>")
+	flag.Var(&disabledNodeGroupsFlag, "disabled-node-group", "id of a node group configured with --nodes that should temporarily be neither scaled up nor down. "+
+		"The group is still tracked, e.g. in the status. Can be used multiple times.")
+	flag.Var(&ignorablePodsFlag, "ignorable-pods", "pods that, like DaemonSet and mirror pods, don't keep a node from being removed as empty in scale down. "+
+		"Format: <namespace>:<label selector>, either part can be empty. Can be used multiple times.")
+	flag.Var(&ignoreTaintsFlag, "ignore-taint", "key of a taint that is removed from the template nodes of node groups without nodes, "+
+		"e.g. a startup taint removed once nodes are ready, so it doesn't keep pending pods from fitting on them. Can be used multiple times.")
+	flag.Var(&balancingIgnoreLabels, "balancing-ignore-label", "key of a node label that is not compared when looking for similar node groups "+
+		"with --balance-similar-node-groups, in addition to "+strings.Join(DefaultBalancingIgnoredLabels, ", ")+". Can be used multiple times.")
+	flag.Var(&expanderPodClassesFlag, "expander-pod-class", "class of pods whose scale ups use the given expander instead of --expander, "+
+		"if most of the pods triggering the scale up belong to it. Format: <namespace>:<label selector>:<expander>, either of the first two "+
+		"parts can be empty. Pods belong to the first matching class. Can be used multiple times.")
 	kube_flag.InitFlags()
 
 	glog.Infof("Cluster Autoscaler %s", ClusterAutoscalerVersion)
@@ -362,14 +586,45 @@ func main() {
 		glog.Fatalf("Unrecognized estimator: %v", *estimatorFlag)
 	}
 
+	if !isAvailableExpander(*expanderFlag) {
+		glog.Fatalf("Unrecognized expander: %v", *expanderFlag)
+	}
+	if *expanderFlag == HttpExpanderName && *expanderURL == "" {
+		glog.Fatalf("--expander-url is required by the %s expander", HttpExpanderName)
+	}
+
+	correctResourceMode := false
+	for _, availableResourceMode := range estimator.AvailableResourceModes {
+		if *estimatorResourceModeFlag == availableResourceMode {
+			correctResourceMode = true
+		}
+	}
+	if !correctResourceMode {
+		glog.Fatalf("Unrecognized estimator resource mode: %v", *estimatorResourceModeFlag)
+	}
+
+	var circuitBreaker *CircuitBreaker
+	if *circuitBreakerFailures > 0 {
+		circuitBreaker = NewCircuitBreaker(*circuitBreakerFailures, *circuitBreakerCooldown)
+	}
+	estimationReports := NewEstimationReports()
+	scaleDownReports := NewScaleDownReports()
+	nodeGroupDetails := NewNodeGroupDetailsEndpoint()
+	scaleUpEvaluations := NewScaleUpEvaluationEndpoint()
+
 	go func() {
 		http.Handle("/metrics", prometheus.Handler())
+		http.Handle("/health-check", circuitBreaker)
+		http.Handle("/report", estimationReports)
+		http.Handle("/scaledown", scaleDownReports)
+		http.Handle("/nodegroups", nodeGroupDetails)
+		http.Handle("/debug/scaleup", scaleUpEvaluations)
 		err := http.ListenAndServe(*address, nil)
 		glog.Fatalf("Failed to start metrics: %v", err)
 	}()
 
 	if !leaderElection.LeaderElect {
-		run(nil)
+		run(nil, circuitBreaker, estimationReports, scaleDownReports, nodeGroupDetails, scaleUpEvaluations)
 	} else {
 		id, err := os.Hostname()
 		if err != nil {
@@ -384,12 +639,14 @@ func main() {
 			},
 			Client:        kubeClient,
 			Identity:      id,
-			EventRecorder: createEventRecorder(kubeClient),
+			EventRecorder: createEventRecorder(kubeClient, *eventSourceComponent),
 			LeaseDuration: leaderElection.LeaseDuration.Duration,
 			RenewDeadline: leaderElection.RenewDeadline.Duration,
 			RetryPeriod:   leaderElection.RetryPeriod.Duration,
 			Callbacks: kube_leaderelection.LeaderCallbacks{
-				OnStartedLeading: run,
+				OnStartedLeading: func(_ <-chan struct{}) {
+					run(nil, circuitBreaker, estimationReports, scaleDownReports, nodeGroupDetails, scaleUpEvaluations)
+				},
 				OnStoppedLeading: func() {
 					glog.Fatalf("lost master")
 				},
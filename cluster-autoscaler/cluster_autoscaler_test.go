@@ -0,0 +1,117 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/testapi"
+	"k8s.io/kubernetes/pkg/client/restclient"
+	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/client/unversioned/fake"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventRecorderSourceComponent(t *testing.T) {
+	codec := testapi.Default.Codec()
+	header := http.Header{}
+	header.Set("Content-Type", runtime.ContentTypeJSON)
+	sources := make(chan string, 10)
+	fakeClient := &fake.RESTClient{
+		Codec: codec,
+		Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			if req.Method != "POST" || req.URL.Path != "/api/v1/namespaces/default/events" {
+				t.Errorf("unexpected request: %v %v", req.Method, req.URL)
+				return &http.Response{StatusCode: 404, Header: header, Body: ioutil.NopCloser(&bytes.Buffer{})}, nil
+			}
+			body, err := ioutil.ReadAll(req.Body)
+			assert.NoError(t, err)
+			event := &kube_api.Event{}
+			assert.NoError(t, runtime.DecodeInto(codec, body, event))
+			sources <- event.Source.Component
+			return &http.Response{StatusCode: 201, Header: header, Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+		}),
+	}
+	kubeClient := kube_client.NewOrDie(&restclient.Config{
+		ContentConfig: restclient.ContentConfig{
+			ContentType:  runtime.ContentTypeJSON,
+			GroupVersion: testapi.Default.GroupVersion(),
+		},
+	})
+	kubeClient.Client = fakeClient.Client
+
+	n1 := BuildTestNode("n1", 1000, 1000)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         createEventRecorder(kubeClient, "team-a-autoscaler"),
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{"ng1": buildTestNodeInfo(n1)}
+
+	p1 := BuildTestPod("p1", 800, 0)
+	p1.Namespace = "default"
+	p1.SelfLink = "/api/v1/namespaces/default/pods/p1"
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+
+	select {
+	case source := <-sources:
+		assert.Equal(t, "team-a-autoscaler", source)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event recorded")
+	}
+}
+
+func TestIsFlagSet(t *testing.T) {
+	flag.Duration("test-is-flag-set", 0, "")
+	assert.False(t, isFlagSet("test-is-flag-set"))
+	// A flag set to its default value is still set.
+	assert.NoError(t, flag.Set("test-is-flag-set", "0"))
+	assert.True(t, isFlagSet("test-is-flag-set"))
+}
+
+func TestNewAutoscalerKeepsNodeDeletionTracker(t *testing.T) {
+	tracker := NewNodeDeletionTracker()
+	dependencies := &ScanDependencies{baseContext: AutoscalingContext{NodeDeletionTracker: tracker}}
+	autoscaler := NewAutoscaler(dependencies)
+	autoscaler.context.NodeDeletionTracker.StartDrain("n1")
+
+	// A new Autoscaler replacing one whose scan was abandoned still knows the drained node.
+	autoscaler = NewAutoscaler(dependencies)
+	assert.True(t, tracker == autoscaler.context.NodeDeletionTracker)
+	assert.Equal(t, "n1", autoscaler.context.NodeDeletionTracker.DrainingNode())
+}
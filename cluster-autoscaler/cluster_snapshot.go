@@ -0,0 +1,118 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	kube_api "k8s.io/kubernetes/pkg/api"
+
+	"github.com/golang/glog"
+)
+
+// ClusterSnapshot is the state of the cluster observed once at the start of a scan. Scale up and
+// scale down of the scan both work on it, so that they see the same nodes, pods and node group
+// sizes, and the cloud provider isn't asked for the sizes again in every phase.
+type ClusterSnapshot struct {
+	// Time is when the snapshot was taken.
+	Time time.Time
+	// Nodes are the ready nodes considered by the autoscaler.
+	Nodes []*kube_api.Node
+	// AllNodes are all nodes considered by the autoscaler, including the unready ones.
+	AllNodes []*kube_api.Node
+	// ScheduledPods are the pods running on the nodes.
+	ScheduledPods []*kube_api.Pod
+	// UnschedulablePods are the pods the scheduler failed to schedule.
+	UnschedulablePods []*kube_api.Pod
+
+	sync.Mutex
+	// targetSizes holds the target size of every node group, keyed by node group id.
+	targetSizes map[string]int
+	// sizeErrors holds why the target size of a node group couldn't be read, keyed by node group id.
+	sizeErrors map[string]error
+}
+
+// NewClusterSnapshot builds ClusterSnapshot, reading the target size of every node group. A node
+// group whose size can't be read is skipped in the scan, without asking the cloud provider again,
+// while the other node groups are autoscaled as usual.
+func NewClusterSnapshot(nodes, allNodes []*kube_api.Node, scheduledPods, unschedulablePods []*kube_api.Pod,
+	cloudProvider cloudprovider.CloudProvider, now time.Time) *ClusterSnapshot {
+	snapshot := &ClusterSnapshot{
+		Time:              now,
+		Nodes:             nodes,
+		AllNodes:          allNodes,
+		ScheduledPods:     scheduledPods,
+		UnschedulablePods: unschedulablePods,
+		targetSizes:       make(map[string]int),
+		sizeErrors:        make(map[string]error),
+	}
+	for _, nodeGroup := range cloudProvider.NodeGroups() {
+		size, err := nodeGroup.TargetSize()
+		if err != nil {
+			glog.Errorf("Failed to get size of %s, skipping it in this scan: %v", nodeGroup.Id(), err)
+			snapshot.sizeErrors[nodeGroup.Id()] = fmt.Errorf("failed to get size of %s: %v", nodeGroup.Id(), err)
+			continue
+		}
+		snapshot.targetSizes[nodeGroup.Id()] = size
+	}
+	return snapshot
+}
+
+// TargetSize returns the target size of the node group in the snapshot, or the error of reading it.
+// Node groups missing from the snapshot are asked for their current size.
+func (s *ClusterSnapshot) TargetSize(nodeGroup cloudprovider.NodeGroup) (int, error) {
+	s.Lock()
+	size, found := s.targetSizes[nodeGroup.Id()]
+	sizeErr := s.sizeErrors[nodeGroup.Id()]
+	s.Unlock()
+	if sizeErr != nil {
+		return 0, sizeErr
+	}
+	if !found {
+		return nodeGroup.TargetSize()
+	}
+	return size, nil
+}
+
+// RegisterSizeChange updates the target size of the node group after the autoscaler resized it, so
+// that the later phases of the scan see the change.
+func (s *ClusterSnapshot) RegisterSizeChange(nodeGroup cloudprovider.NodeGroup, delta int) {
+	s.Lock()
+	defer s.Unlock()
+	if size, found := s.targetSizes[nodeGroup.Id()]; found {
+		s.targetSizes[nodeGroup.Id()] = size + delta
+	}
+}
+
+// targetSize returns the target size of the node group from context.ClusterSnapshot, if set, or
+// from the cloud provider.
+func targetSize(context *AutoscalingContext, nodeGroup cloudprovider.NodeGroup) (int, error) {
+	if context.ClusterSnapshot == nil {
+		return nodeGroup.TargetSize()
+	}
+	return context.ClusterSnapshot.TargetSize(nodeGroup)
+}
+
+// registerSizeChange records a resize of the node group in context.ClusterSnapshot, if set.
+func registerSizeChange(context *AutoscalingContext, nodeGroup cloudprovider.NodeGroup, delta int) {
+	if context.ClusterSnapshot != nil {
+		context.ClusterSnapshot.RegisterSizeChange(nodeGroup, delta)
+	}
+}
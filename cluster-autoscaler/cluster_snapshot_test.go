@@ -0,0 +1,235 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sizeCallCounter counts TargetSize calls made on the node groups of countingCloudProvider.
+type sizeCallCounter struct {
+	sync.Mutex
+	calls int
+}
+
+func (c *sizeCallCounter) count() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.calls
+}
+
+// countingNodeGroup counts TargetSize calls of the wrapped node group.
+type countingNodeGroup struct {
+	cloudprovider.NodeGroup
+	counter *sizeCallCounter
+	failing bool
+}
+
+func (ng *countingNodeGroup) TargetSize() (int, error) {
+	ng.counter.Lock()
+	ng.counter.calls++
+	ng.counter.Unlock()
+	if ng.failing {
+		return 0, fmt.Errorf("size of %s unavailable", ng.Id())
+	}
+	return ng.NodeGroup.TargetSize()
+}
+
+// countingCloudProvider wraps node groups of the test cloud provider in countingNodeGroups. The
+// sizes of the node groups in failing can't be read.
+type countingCloudProvider struct {
+	*test.TestCloudProvider
+	counter *sizeCallCounter
+	failing map[string]bool
+}
+
+func (p *countingCloudProvider) wrap(nodeGroup cloudprovider.NodeGroup) cloudprovider.NodeGroup {
+	if nodeGroup == nil {
+		return nil
+	}
+	return &countingNodeGroup{NodeGroup: nodeGroup, counter: p.counter, failing: p.failing[nodeGroup.Id()]}
+}
+
+func (p *countingCloudProvider) NodeGroups() []cloudprovider.NodeGroup {
+	result := make([]cloudprovider.NodeGroup, 0)
+	for _, nodeGroup := range p.TestCloudProvider.NodeGroups() {
+		result = append(result, p.wrap(nodeGroup))
+	}
+	return result
+}
+
+func (p *countingCloudProvider) NodeGroupForNode(node *kube_api.Node) (cloudprovider.NodeGroup, error) {
+	nodeGroup, err := p.TestCloudProvider.NodeGroupForNode(node)
+	return p.wrap(nodeGroup), err
+}
+
+func (p *countingCloudProvider) NodeGroupsForNodes(nodes []*kube_api.Node) (map[string]cloudprovider.NodeGroup, error) {
+	nodeGroups, err := p.TestCloudProvider.NodeGroupsForNodes(nodes)
+	result := make(map[string]cloudprovider.NodeGroup)
+	for name, nodeGroup := range nodeGroups {
+		result[name] = p.wrap(nodeGroup)
+	}
+	return result, err
+}
+
+func TestClusterSnapshotTargetSize(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	provider.AddNode("ng1", n1)
+	ng1, err := provider.NodeGroupForNode(n1)
+	assert.NoError(t, err)
+
+	snapshot := NewClusterSnapshot(nil, nil, nil, nil, provider, time.Now())
+
+	size, err := snapshot.TargetSize(ng1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, size)
+
+	snapshot.RegisterSizeChange(ng1, 2)
+	snapshot.RegisterSizeChange(ng1, -1)
+	size, err = snapshot.TargetSize(ng1)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, size)
+
+	// Node groups added after the snapshot was taken are asked directly.
+	provider.AddNodeGroup("ng2", 1, 10, 5)
+	provider.AddNode("ng2", n2)
+	ng2, err := provider.NodeGroupForNode(n2)
+	assert.NoError(t, err)
+	size, err = snapshot.TargetSize(ng2)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, size)
+}
+
+func TestClusterSnapshotSkipsNodeGroupsWithoutSize(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	provider := &countingCloudProvider{
+		TestCloudProvider: test.NewTestCloudProvider(nil, nil),
+		counter:           &sizeCallCounter{},
+		failing:           map[string]bool{"ng2": true},
+	}
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+	ng1, err := provider.NodeGroupForNode(n1)
+	assert.NoError(t, err)
+	ng2, err := provider.NodeGroupForNode(n2)
+	assert.NoError(t, err)
+
+	// The failing node group doesn't keep the other one from being autoscaled.
+	snapshot := NewClusterSnapshot(nil, nil, nil, nil, provider, time.Now())
+	size, err := snapshot.TargetSize(ng1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+	_, err = snapshot.TargetSize(ng2)
+	assert.Error(t, err)
+	// The error is kept for the scan, the cloud provider is not asked again.
+	assert.Equal(t, 2, provider.counter.count())
+
+	unready, err := CheckGroupsAndNodes([]*kube_api.Node{n1, n2}, provider)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"ng2": true}, unready)
+}
+
+func TestClusterSnapshotSharedByScaleUpAndScaleDown(t *testing.T) {
+	p1 := BuildTestPod("p1", 800, 0)
+	p1.Spec.NodeName = "n1"
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	pending := BuildTestPod("pending", 800, 0)
+
+	scaledUp := make(map[string]int)
+	deleted := make([]string, 0)
+	provider := &countingCloudProvider{
+		TestCloudProvider: test.NewTestCloudProvider(func(id string, delta int) error {
+			scaledUp[id] += delta
+			return nil
+		}, func(id string, node string) error {
+			deleted = append(deleted, node)
+			return nil
+		}),
+		counter: &sizeCallCounter{},
+	}
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNodeGroup("ng2", 1, 10, 2)
+	provider.AddNode("ng2", n2)
+
+	nodes := []*kube_api.Node{n1, n2}
+	snapshot := NewClusterSnapshot(nodes, nodes, []*kube_api.Pod{p1}, []*kube_api.Pod{pending}, provider, time.Now())
+	// Every node group is asked for its size exactly once, when the snapshot is taken.
+	assert.Equal(t, 2, provider.counter.count())
+
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           kube_record.NewFakeRecorder(10),
+		EstimatorName:      BinpackingEstimatorName,
+		MaxEmptyBulkDelete: 1,
+		ClusterSnapshot:    snapshot,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+	}
+	result, err := ScaleUp(context, snapshot.UnschedulablePods, snapshot.Nodes, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, result)
+	assert.Equal(t, map[string]int{"ng1": 1}, scaledUp)
+
+	unneeded, hints, utilization := FindUnneededNodes(snapshot.Nodes, map[string]time.Time{}, 0.5, false,
+		snapshot.ScheduledPods, context.PredicateChecker, make(map[string]string),
+		simulator.NewUsageTracker(), time.Now(), nil)
+	assert.Contains(t, unneeded, "n2")
+	unneeded["n2"] = time.Now().Add(-time.Hour)
+
+	scaleDownResult, err := ScaleDown(context, snapshot.Nodes, utilization, unneeded, snapshot.ScheduledPods,
+		hints, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, scaleDownResult)
+	assert.Equal(t, []string{"n2"}, deleted)
+
+	// Both phases read the sizes from the snapshot, which saw the resizes of the scan.
+	assert.Equal(t, 2, provider.counter.count())
+	ng1, err := provider.NodeGroupForNode(n1)
+	assert.NoError(t, err)
+	ng2, err := provider.NodeGroupForNode(n2)
+	assert.NoError(t, err)
+	size, err := snapshot.TargetSize(ng1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, size)
+	size, err = snapshot.TargetSize(ng2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+}
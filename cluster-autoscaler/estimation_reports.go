@@ -0,0 +1,90 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/estimator"
+)
+
+// EstimationReport describes the last estimation of nodes needed in a node group.
+type EstimationReport struct {
+	// Time is when the estimation was made.
+	Time time.Time `json:"time"`
+	// Pods is the number of pending pods that fit on a node of the node group.
+	Pods int `json:"pods"`
+	// NodeCount is the estimated number of nodes needed for the pods.
+	NodeCount int `json:"nodeCount"`
+	// Report explains how the estimator arrived at NodeCount.
+	Report string `json:"report"`
+	// Estimation is the structured form of Report.
+	Estimation estimator.EstimationReport `json:"estimation"`
+}
+
+// EstimationReports keeps the most recent EstimationReport of every node group, so that operators
+// can inspect the estimation reasoning without searching the logs. It is safe for concurrent
+// use and all methods are safe to call on nil EstimationReports.
+type EstimationReports struct {
+	mutex   sync.Mutex
+	reports map[string]EstimationReport
+}
+
+// NewEstimationReports builds EstimationReports.
+func NewEstimationReports() *EstimationReports {
+	return &EstimationReports{
+		reports: make(map[string]EstimationReport),
+	}
+}
+
+// Record stores the report of the node group, replacing the previous one.
+func (r *EstimationReports) Record(nodeGroupId string, report EstimationReport) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reports[nodeGroupId] = report
+}
+
+// Reports returns a copy of the most recent reports, keyed by node group id.
+func (r *EstimationReports) Reports() map[string]EstimationReport {
+	result := make(map[string]EstimationReport)
+	if r == nil {
+		return result
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for id, report := range r.reports {
+		result[id] = report
+	}
+	return result
+}
+
+// ServeHTTP responds with the JSON encoded most recent reports, keyed by node group id.
+func (r *EstimationReports) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	encoded, err := json.Marshal(r.Reports())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(encoded)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimationReportsUpdatedOnScaleUp(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	reports := NewEstimationReports()
+	context := &AutoscalingContext{
+		CloudProvider:     provider,
+		PredicateChecker:  simulator.NewTestPredicateChecker(),
+		Recorder:          kube_record.NewFakeRecorder(10),
+		EstimatorName:     BasicEstimatorName,
+		EstimationReports: reports,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{"ng1": buildTestNodeInfo(n1)}
+
+	_, err := ScaleUp(context, []*kube_api.Pod{BuildTestPod("p1", 600, 0)}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	report, found := reports.Reports()["ng1"]
+	assert.True(t, found)
+	assert.Equal(t, 1, report.Pods)
+	assert.Equal(t, 1, report.NodeCount)
+	assert.Contains(t, report.Report, "CPU: 1")
+	firstTime := report.Time
+
+	pods := []*kube_api.Pod{BuildTestPod("p1", 600, 0), BuildTestPod("p2", 600, 0), BuildTestPod("p3", 600, 0)}
+	_, err = ScaleUp(context, pods, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	report = reports.Reports()["ng1"]
+	assert.Equal(t, 3, report.Pods)
+	assert.Equal(t, 2, report.NodeCount)
+	assert.Contains(t, report.Report, "CPU: 2")
+	assert.False(t, report.Time.Before(firstTime))
+
+	recorder := httptest.NewRecorder()
+	reports.ServeHTTP(recorder, &http.Request{})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	served := make(map[string]EstimationReport)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &served))
+	assert.Equal(t, 2, served["ng1"].NodeCount)
+}
+
+func TestEstimationReportsNil(t *testing.T) {
+	var reports *EstimationReports
+	reports.Record("ng1", EstimationReport{NodeCount: 1})
+	assert.Empty(t, reports.Reports())
+}
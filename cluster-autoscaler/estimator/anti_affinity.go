@@ -0,0 +1,101 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package estimator
+
+import (
+	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+// nodeAntiAffinityTerms returns the required pod anti-affinity terms of the pod that keep matching
+// pods off the same node, i.e. with the hostname topology key. Terms with a wider topology, e.g.
+// a zone, are skipped as all nodes of a node group usually share it.
+func nodeAntiAffinityTerms(pod *kube_api.Pod) []kube_api.PodAffinityTerm {
+	affinity, err := kube_api.GetAffinityFromPodAnnotations(pod.Annotations)
+	if err != nil || affinity.PodAntiAffinity == nil {
+		return nil
+	}
+	terms := make([]kube_api.PodAffinityTerm, 0)
+	for _, term := range affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+		if term.TopologyKey == unversioned.LabelHostname {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// termMatches checks if the anti-affinity term of owner matches the other pod.
+func termMatches(term kube_api.PodAffinityTerm, owner *kube_api.Pod, other *kube_api.Pod) bool {
+	namespaces := term.Namespaces
+	if namespaces == nil {
+		namespaces = []string{owner.Namespace}
+	}
+	namespaceMatches := len(namespaces) == 0
+	for _, namespace := range namespaces {
+		if namespace == other.Namespace {
+			namespaceMatches = true
+		}
+	}
+	if !namespaceMatches {
+		return false
+	}
+	selector, err := unversioned.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(other.Labels))
+}
+
+// antiAffinityTerms caches the node anti-affinity terms of pods, as parsing them from the
+// annotations for every pair of pods would be costly.
+type antiAffinityTerms map[*kube_api.Pod][]kube_api.PodAffinityTerm
+
+func (cache antiAffinityTerms) get(pod *kube_api.Pod) []kube_api.PodAffinityTerm {
+	terms, found := cache[pod]
+	if !found {
+		terms = nodeAntiAffinityTerms(pod)
+		cache[pod] = terms
+	}
+	return terms
+}
+
+// conflicts checks if the pods can't run on the same node because of the anti-affinity of
+// either of them.
+func (cache antiAffinityTerms) conflicts(a *kube_api.Pod, b *kube_api.Pod) bool {
+	for _, term := range cache.get(a) {
+		if termMatches(term, a, b) {
+			return true
+		}
+	}
+	for _, term := range cache.get(b) {
+		if termMatches(term, b, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// conflictsWithAny checks if the pod can't run on the same node as any of the given pods.
+func (cache antiAffinityTerms) conflictsWithAny(pod *kube_api.Pod, pods []*kube_api.Pod) bool {
+	for _, other := range pods {
+		if cache.conflicts(pod, other) {
+			return true
+		}
+	}
+	return false
+}
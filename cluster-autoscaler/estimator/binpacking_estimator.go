@@ -17,6 +17,7 @@ limitations under the License.
 package estimator
 
 import (
+	"fmt"
 	"sort"
 
 	"k8s.io/contrib/cluster-autoscaler/simulator"
@@ -55,8 +56,11 @@ func NewBinpackingNodeEstimator(predicateChecker *simulator.PredicateChecker) *B
 // will be cpu thus the estimated overprovisioning of 11/9 * optimal + 6/9 should be
 // still be maintained.
 // It is assumed that all pods from the given list can fit to nodeTemplate.
-// Returns the number of nodes needed to accommodate all pods from the list.
-func (estimator *BinpackingNodeEstimator) Estimate(pods []*kube_api.Pod, nodeTemplate *schedulercache.NodeInfo) int {
+// Pods with required anti-affinity to each other on the hostname topology are placed on
+// separate nodes.
+// Pods of nodeTemplate, e.g. DaemonSet pods, are assumed to run on every new node.
+// Returns the number of nodes needed to accommodate all pods from the list and a report explaining it.
+func (estimator *BinpackingNodeEstimator) Estimate(pods []*kube_api.Pod, nodeTemplate *schedulercache.NodeInfo) (int, EstimationReport) {
 
 	podInfos := calculatePodScore(pods, nodeTemplate)
 	sort.Sort(byScoreDesc(podInfos))
@@ -70,10 +74,14 @@ func (estimator *BinpackingNodeEstimator) Estimate(pods []*kube_api.Pod, nodeTem
 		return newNodeInfo
 	}
 
+	antiAffinity := make(antiAffinityTerms)
 	newNodes := make([]*schedulercache.NodeInfo, 0)
 	for _, podInfo := range podInfos {
 		found := false
 		for i, nodeInfo := range newNodes {
+			if antiAffinity.conflictsWithAny(podInfo.pod, nodeInfo.Pods()) {
+				continue
+			}
 			if err := estimator.predicateChecker.CheckPredicates(podInfo.pod, nodeInfo); err == nil {
 				found = true
 				newNodes[i] = nodeWithPod(nodeInfo, podInfo.pod)
@@ -84,7 +92,20 @@ func (estimator *BinpackingNodeEstimator) Estimate(pods []*kube_api.Pod, nodeTem
 			newNodes = append(newNodes, nodeWithPod(nodeTemplate, podInfo.pod))
 		}
 	}
-	return len(newNodes)
+
+	requested := kube_api.ResourceList{}
+	for _, pod := range pods {
+		addPodRequests(requested, pod, 1)
+	}
+	for _, pod := range nodeTemplate.Pods() {
+		addPodRequests(requested, pod, len(newNodes))
+	}
+	report := newEstimationReport(len(pods), len(newNodes), simulator.NodeAllocatable(nodeTemplate.Node()), requested)
+	report.Details = []string{fmt.Sprintf("Binpacking: %d pods packed on %d nodes", len(pods), len(newNodes))}
+	if templatePods := len(nodeTemplate.Pods()); templatePods > 0 {
+		report.Assumptions = []string{fmt.Sprintf("%d pods of the node template, e.g. DaemonSet pods, run on every new node", templatePods)}
+	}
+	return len(newNodes), report
 }
 
 // Calculates score for all pods and returns podInfo structure.
@@ -64,10 +64,48 @@ func TestBinpackingEstimate(t *testing.T) {
 
 	nodeInfo := schedulercache.NewNodeInfo()
 	nodeInfo.SetNode(node)
-	estimate := estimator.Estimate(pods, nodeInfo)
+	estimate, _ := estimator.Estimate(pods, nodeInfo)
 	assert.Equal(t, 5, estimate)
 }
 
+func TestBinpackingEstimateWithMixedUnits(t *testing.T) {
+	estimator := NewBinpackingNodeEstimator(simulator.NewTestPredicateChecker())
+
+	// Every pod requests half a cpu and 256Mi of memory, in different units.
+	requests := []kube_api.ResourceList{
+		{kube_api.ResourceCPU: resource.MustParse("0.5"), kube_api.ResourceMemory: resource.MustParse("256Mi")},
+		{kube_api.ResourceCPU: resource.MustParse("500m"), kube_api.ResourceMemory: resource.MustParse("0.25Gi")},
+		{kube_api.ResourceCPU: resource.MustParse("0.5"), kube_api.ResourceMemory: resource.MustParse("268435456")},
+		{kube_api.ResourceCPU: resource.MustParse("500m"), kube_api.ResourceMemory: resource.MustParse("262144Ki")},
+	}
+	pods := make([]*kube_api.Pod, 0)
+	for _, request := range requests {
+		pods = append(pods, &kube_api.Pod{
+			Spec: kube_api.PodSpec{
+				Containers: []kube_api.Container{
+					{Resources: kube_api.ResourceRequirements{Requests: request}},
+				},
+			},
+		})
+	}
+	node := &kube_api.Node{
+		Status: kube_api.NodeStatus{
+			Capacity: kube_api.ResourceList{
+				kube_api.ResourceCPU:    resource.MustParse("1"),
+				kube_api.ResourceMemory: resource.MustParse("512Mi"),
+				kube_api.ResourcePods:   resource.MustParse("10"),
+			},
+		},
+	}
+	node.Status.Allocatable = node.Status.Capacity
+
+	nodeInfo := schedulercache.NewNodeInfo()
+	nodeInfo.SetNode(node)
+	// Exactly two pods fit on a node.
+	estimate, _ := estimator.Estimate(pods, nodeInfo)
+	assert.Equal(t, 2, estimate)
+}
+
 func TestBinpackingEstimateWithPorts(t *testing.T) {
 	estimator := NewBinpackingNodeEstimator(simulator.NewTestPredicateChecker())
 
@@ -109,6 +147,66 @@ func TestBinpackingEstimateWithPorts(t *testing.T) {
 
 	nodeInfo := schedulercache.NewNodeInfo()
 	nodeInfo.SetNode(node)
-	estimate := estimator.Estimate(pods, nodeInfo)
+	estimate, _ := estimator.Estimate(pods, nodeInfo)
 	assert.Equal(t, 8, estimate)
 }
+
+// buildAntiAffinePod builds a pod that must not share a node with other pods labeled app=spread.
+func buildAntiAffinePod(name string, cpu int64, memory int64) *kube_api.Pod {
+	return &kube_api.Pod{
+		ObjectMeta: kube_api.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{"app": "spread"},
+			Annotations: map[string]string{
+				kube_api.AffinityAnnotationKey: `{"podAntiAffinity": {"requiredDuringSchedulingIgnoredDuringExecution": [
+					{"labelSelector": {"matchLabels": {"app": "spread"}}, "topologyKey": "kubernetes.io/hostname"}]}}`,
+			},
+		},
+		Spec: kube_api.PodSpec{
+			Containers: []kube_api.Container{
+				{
+					Resources: kube_api.ResourceRequirements{
+						Requests: kube_api.ResourceList{
+							kube_api.ResourceCPU:    *resource.NewMilliQuantity(cpu, resource.DecimalSI),
+							kube_api.ResourceMemory: *resource.NewQuantity(memory, resource.DecimalSI),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestBinpackingEstimateWithAntiAffinity(t *testing.T) {
+	estimator := NewBinpackingNodeEstimator(simulator.NewTestPredicateChecker())
+
+	cpuPerPod := int64(100)
+	memoryPerPod := int64(100 * 1024 * 1024)
+	pods := []*kube_api.Pod{
+		buildAntiAffinePod("p1", cpuPerPod, memoryPerPod),
+		buildAntiAffinePod("p2", cpuPerPod, memoryPerPod),
+		buildAntiAffinePod("p3", cpuPerPod, memoryPerPod),
+	}
+	node := &kube_api.Node{
+		Status: kube_api.NodeStatus{
+			Capacity: kube_api.ResourceList{
+				kube_api.ResourceCPU:    *resource.NewMilliQuantity(cpuPerPod*10, resource.DecimalSI),
+				kube_api.ResourceMemory: *resource.NewQuantity(memoryPerPod*10, resource.DecimalSI),
+				kube_api.ResourcePods:   *resource.NewQuantity(10, resource.DecimalSI),
+			},
+		},
+	}
+	node.Status.Allocatable = node.Status.Capacity
+
+	nodeInfo := schedulercache.NewNodeInfo()
+	nodeInfo.SetNode(node)
+	estimate, _ := estimator.Estimate(pods, nodeInfo)
+	assert.Equal(t, 3, estimate)
+
+	// Pods in other namespaces are not matched by the anti-affinity.
+	pods[1].Namespace = "other"
+	pods[2].Namespace = "other"
+	estimate, _ = estimator.Estimate(pods, nodeInfo)
+	assert.Equal(t, 2, estimate)
+}
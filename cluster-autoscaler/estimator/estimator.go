@@ -19,20 +19,23 @@ package estimator
 import (
 	"bytes"
 	"fmt"
-	"math"
+	"sort"
 
+	"k8s.io/contrib/cluster-autoscaler/simulator"
 	kube_api "k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/api/resource"
 )
 
 // BasicNodeEstimator estimates the number of needed nodes to handle the given amount of pods.
 // It will never overestimate the number of nodes but is quite likekly to provide a number that
-// is too small.
+// is too small. Requests are summed as quantities, so requests in different units, e.g. 0.5 and
+// 250m cpu or 128Mi and 0.5Gi memory, are exact.
 type BasicNodeEstimator struct {
-	cpuSum      resource.Quantity
-	memorySum   resource.Quantity
-	portSum     map[int32]int
-	FittingPods map[*kube_api.Pod]struct{}
+	cpuSum              resource.Quantity
+	memorySum           resource.Quantity
+	ephemeralStorageSum resource.Quantity
+	portSum             map[int32]int
+	FittingPods         map[*kube_api.Pod]struct{}
 }
 
 // NewBasicNodeEstimator builds BasicNodeEstimator.
@@ -46,6 +49,8 @@ func NewBasicNodeEstimator() *BasicNodeEstimator {
 // Add adds Pod to the estimation.
 func (basicEstimator *BasicNodeEstimator) Add(pod *kube_api.Pod) error {
 	ports := make(map[int32]struct{})
+	// TODO: add pod overhead (RuntimeClass) to the requests once the vendored api has PodSpec.Overhead,
+	// here, in calculatePodScore and in the predicate checker.
 	for _, container := range pod.Spec.Containers {
 		if request, ok := container.Resources.Requests[kube_api.ResourceCPU]; ok {
 			basicEstimator.cpuSum.Add(request)
@@ -59,6 +64,7 @@ func (basicEstimator *BasicNodeEstimator) Add(pod *kube_api.Pod) error {
 			}
 		}
 	}
+	basicEstimator.ephemeralStorageSum.Add(simulator.PodEphemeralStorageRequest(pod))
 	for port := range ports {
 		if sum, ok := basicEstimator.portSum[port]; ok {
 			basicEstimator.portSum[port] = sum + 1
@@ -77,43 +83,121 @@ func maxInt(a, b int) int {
 	return b
 }
 
+// divideRoundUp returns the number of nodes with the given amount of a resource needed to hold the
+// requested amount. Integer math is used, as float division of large quantities, e.g. memory in
+// bytes, may round an exact fit up to an extra node. Returns 0 if the node has none of the resource.
+func divideRoundUp(requested, perNode int64) int {
+	if perNode <= 0 {
+		return 0
+	}
+	return int((requested + perNode - 1) / perNode)
+}
+
 // GetDebug returns debug information about the current state of BasicNodeEstimator
 func (basicEstimator *BasicNodeEstimator) GetDebug() string {
 	var buffer bytes.Buffer
 	buffer.WriteString("Resources needed:\n")
 	buffer.WriteString(fmt.Sprintf("CPU: %s\n", basicEstimator.cpuSum.String()))
 	buffer.WriteString(fmt.Sprintf("Mem: %s\n", basicEstimator.memorySum.String()))
+	buffer.WriteString(fmt.Sprintf("Ephemeral storage: %s\n", basicEstimator.ephemeralStorageSum.String()))
 	for port, count := range basicEstimator.portSum {
 		buffer.WriteString(fmt.Sprintf("Port %d: %d\n", port, count))
 	}
 	return buffer.String()
 }
 
-// Estimate estimates the number needed of nodes of the given shape.
-func (basicEstimator *BasicNodeEstimator) Estimate(node *kube_api.Node) (int, string) {
-	var buffer bytes.Buffer
-	buffer.WriteString("Needed nodes according to:\n")
+// Estimate estimates the number needed of nodes of the given shape. Pods are fitted against the
+// allocatable resources of the node. Pods already running on the node, e.g. DaemonSet pods, are not
+// accounted for. The report explains the estimate.
+func (basicEstimator *BasicNodeEstimator) Estimate(node *kube_api.Node) (int, EstimationReport) {
+	details := []string{"Needed nodes according to:"}
 	result := 0
-	if cpuCapcaity, ok := node.Status.Capacity[kube_api.ResourceCPU]; ok {
-		prop := int(math.Ceil(float64(basicEstimator.cpuSum.MilliValue()) / float64(cpuCapcaity.MilliValue())))
-		buffer.WriteString(fmt.Sprintf("CPU: %d\n", prop))
+	allocatable := simulator.NodeAllocatable(node)
+	if cpuAllocatable, ok := allocatable[kube_api.ResourceCPU]; ok {
+		prop := divideRoundUp(basicEstimator.cpuSum.MilliValue(), cpuAllocatable.MilliValue())
+		details = append(details, fmt.Sprintf("CPU: %d", prop))
+		result = maxInt(result, prop)
+	}
+	if memAllocatable, ok := allocatable[kube_api.ResourceMemory]; ok {
+		prop := divideRoundUp(basicEstimator.memorySum.Value(), memAllocatable.Value())
+		details = append(details, fmt.Sprintf("Mem: %d", prop))
 		result = maxInt(result, prop)
 	}
-	if memCapcaity, ok := node.Status.Capacity[kube_api.ResourceMemory]; ok {
-		prop := int(math.Ceil(float64(basicEstimator.memorySum.Value()) / float64(memCapcaity.Value())))
-		buffer.WriteString(fmt.Sprintf("Mem: %d\n", prop))
+	if storageAllocatable, ok := allocatable[simulator.ResourceEphemeralStorage]; ok {
+		prop := divideRoundUp(basicEstimator.ephemeralStorageSum.Value(), storageAllocatable.Value())
+		details = append(details, fmt.Sprintf("Ephemeral storage: %d", prop))
 		result = maxInt(result, prop)
 	}
-	if podCapcaity, ok := node.Status.Capacity[kube_api.ResourcePods]; ok {
-		prop := int(math.Ceil(float64(basicEstimator.GetCount()) / float64(podCapcaity.Value())))
-		buffer.WriteString(fmt.Sprintf("Pods: %d\n", prop))
+	if podAllocatable, ok := allocatable[kube_api.ResourcePods]; ok {
+		prop := divideRoundUp(int64(basicEstimator.GetCount()), podAllocatable.Value())
+		details = append(details, fmt.Sprintf("Pods: %d", prop))
 		result = maxInt(result, prop)
 	}
 	for port, count := range basicEstimator.portSum {
-		buffer.WriteString(fmt.Sprintf("Port %d: %d\n", port, count))
+		details = append(details, fmt.Sprintf("Port %d: %d", port, count))
 		result = maxInt(result, count)
 	}
-	return result, buffer.String()
+	if antiAffine := basicEstimator.antiAffinePodCount(); antiAffine > 1 {
+		details = append(details, fmt.Sprintf("Anti-affinity: %d", antiAffine))
+		result = maxInt(result, antiAffine)
+	}
+
+	report := newEstimationReport(basicEstimator.GetCount(), result, allocatable, kube_api.ResourceList{
+		kube_api.ResourceCPU:               basicEstimator.cpuSum,
+		kube_api.ResourceMemory:            basicEstimator.memorySum,
+		simulator.ResourceEphemeralStorage: basicEstimator.ephemeralStorageSum,
+	})
+	report.Details = details
+	report.Assumptions = []string{"no DaemonSet or other pods besides the estimated ones run on new nodes"}
+	return result, report
+}
+
+// antiAffinePodCount returns the size of a set of pods that must all run on different nodes
+// because of their anti-affinity. Finding the largest such set is expensive, so the sets are built
+// greedily starting from every pod, which keeps the estimate from exceeding the needed nodes.
+func (basicEstimator *BasicNodeEstimator) antiAffinePodCount() int {
+	pods := make([]*kube_api.Pod, 0, len(basicEstimator.FittingPods))
+	for pod := range basicEstimator.FittingPods {
+		pods = append(pods, pod)
+	}
+	sort.Sort(byNamespaceAndName(pods))
+
+	antiAffinity := make(antiAffinityTerms)
+	result := 0
+	for i, seed := range pods {
+		if len(antiAffinity.get(seed)) == 0 {
+			continue
+		}
+		set := []*kube_api.Pod{seed}
+		for j, pod := range pods {
+			if i == j {
+				continue
+			}
+			conflictsWithAll := true
+			for _, member := range set {
+				if !antiAffinity.conflicts(pod, member) {
+					conflictsWithAll = false
+					break
+				}
+			}
+			if conflictsWithAll {
+				set = append(set, pod)
+			}
+		}
+		result = maxInt(result, len(set))
+	}
+	return result
+}
+
+type byNamespaceAndName []*kube_api.Pod
+
+func (a byNamespaceAndName) Len() int      { return len(a) }
+func (a byNamespaceAndName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byNamespaceAndName) Less(i, j int) bool {
+	if a[i].Namespace != a[j].Namespace {
+		return a[i].Namespace < a[j].Namespace
+	}
+	return a[i].Name < a[j].Name
 }
 
 // GetCount returns number of pods included in the estimation.
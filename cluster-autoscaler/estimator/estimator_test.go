@@ -17,8 +17,10 @@ limitations under the License.
 package estimator
 
 import (
+	"fmt"
 	"testing"
 
+	"k8s.io/contrib/cluster-autoscaler/simulator"
 	kube_api "k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/api/resource"
 
@@ -66,7 +68,7 @@ func TestEstimate(t *testing.T) {
 	}
 	estimate, report := estimator.Estimate(node)
 	assert.Contains(t, estimator.GetDebug(), "CPU")
-	assert.Contains(t, report, "CPU")
+	assert.Contains(t, report.String(), "CPU")
 	assert.Equal(t, 3, estimate)
 }
 
@@ -110,6 +112,185 @@ func TestEstimateWithPorts(t *testing.T) {
 
 	estimate, report := estimator.Estimate(node)
 	assert.Contains(t, estimator.GetDebug(), "CPU")
-	assert.Contains(t, report, "CPU")
+	assert.Contains(t, report.String(), "CPU")
 	assert.Equal(t, 5, estimate)
 }
+
+func TestEstimateWithAllocatable(t *testing.T) {
+	cpuPerPod := int64(250)
+	memoryPerPod := int64(100 * 1024 * 1024)
+
+	estimator := NewBasicNodeEstimator()
+	for _, name := range []string{"p1", "p2", "p3", "p4"} {
+		estimator.Add(&kube_api.Pod{
+			ObjectMeta: kube_api.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: kube_api.PodSpec{
+				Containers: []kube_api.Container{
+					{
+						Resources: kube_api.ResourceRequirements{
+							Requests: kube_api.ResourceList{
+								kube_api.ResourceCPU:    *resource.NewMilliQuantity(cpuPerPod, resource.DecimalSI),
+								kube_api.ResourceMemory: *resource.NewQuantity(memoryPerPod, resource.DecimalSI),
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	node := &kube_api.Node{
+		Status: kube_api.NodeStatus{
+			Capacity: kube_api.ResourceList{
+				kube_api.ResourceCPU:    *resource.NewMilliQuantity(4*cpuPerPod, resource.DecimalSI),
+				kube_api.ResourceMemory: *resource.NewQuantity(10*memoryPerPod, resource.DecimalSI),
+				kube_api.ResourcePods:   *resource.NewQuantity(10, resource.DecimalSI),
+			},
+		},
+	}
+	estimate, _ := estimator.Estimate(node)
+	assert.Equal(t, 1, estimate)
+
+	// Half of the cpu is reserved for the system.
+	node.Status.Allocatable = kube_api.ResourceList{
+		kube_api.ResourceCPU:    *resource.NewMilliQuantity(2*cpuPerPod, resource.DecimalSI),
+		kube_api.ResourceMemory: *resource.NewQuantity(10*memoryPerPod, resource.DecimalSI),
+		kube_api.ResourcePods:   *resource.NewQuantity(10, resource.DecimalSI),
+	}
+	estimate, _ = estimator.Estimate(node)
+	assert.Equal(t, 2, estimate)
+}
+
+func TestEstimateExactFit(t *testing.T) {
+	// Float division of these sums gives 3.0000000000000004.
+	memoryPerPod := int64(9007199254740993)
+
+	estimator := NewBasicNodeEstimator()
+	for _, name := range []string{"p1", "p2", "p3"} {
+		estimator.Add(&kube_api.Pod{
+			ObjectMeta: kube_api.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: kube_api.PodSpec{
+				Containers: []kube_api.Container{
+					{
+						Resources: kube_api.ResourceRequirements{
+							Requests: kube_api.ResourceList{
+								kube_api.ResourceMemory: *resource.NewQuantity(memoryPerPod, resource.BinarySI),
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	node := &kube_api.Node{
+		Status: kube_api.NodeStatus{
+			Capacity: kube_api.ResourceList{
+				kube_api.ResourceMemory: *resource.NewQuantity(memoryPerPod, resource.BinarySI),
+				kube_api.ResourcePods:   *resource.NewQuantity(10, resource.DecimalSI),
+			},
+		},
+	}
+	estimate, report := estimator.Estimate(node)
+	assert.Contains(t, report.String(), "Mem: 3")
+	assert.Equal(t, 3, estimate)
+}
+
+func TestEstimateWithMixedUnits(t *testing.T) {
+	estimator := NewBasicNodeEstimator()
+	requests := []kube_api.ResourceList{
+		{kube_api.ResourceCPU: resource.MustParse("0.5"), kube_api.ResourceMemory: resource.MustParse("128Mi")},
+		{kube_api.ResourceCPU: resource.MustParse("250m"), kube_api.ResourceMemory: resource.MustParse("0.5Gi")},
+		{kube_api.ResourceCPU: resource.MustParse("1.25"), kube_api.ResourceMemory: resource.MustParse("402653184")},
+	}
+	for i, request := range requests {
+		estimator.Add(&kube_api.Pod{
+			ObjectMeta: kube_api.ObjectMeta{Name: fmt.Sprintf("p%d", i), Namespace: "default"},
+			Spec: kube_api.PodSpec{
+				Containers: []kube_api.Container{
+					{Resources: kube_api.ResourceRequirements{Requests: request}},
+				},
+			},
+		})
+	}
+
+	// The pods need exactly 2 cpus and 1Gi of memory.
+	node := &kube_api.Node{
+		Status: kube_api.NodeStatus{
+			Capacity: kube_api.ResourceList{
+				kube_api.ResourceCPU:    resource.MustParse("1"),
+				kube_api.ResourceMemory: resource.MustParse("512Mi"),
+				kube_api.ResourcePods:   resource.MustParse("10"),
+			},
+		},
+	}
+	estimate, report := estimator.Estimate(node)
+	assert.Contains(t, report.String(), "CPU: 2")
+	assert.Contains(t, report.String(), "Mem: 2")
+	assert.Equal(t, 2, estimate)
+}
+
+func TestEstimateWithAntiAffinity(t *testing.T) {
+	cpuPerPod := int64(100)
+	memoryPerPod := int64(100 * 1024 * 1024)
+
+	estimator := NewBasicNodeEstimator()
+	for _, name := range []string{"p1", "p2", "p3"} {
+		estimator.Add(buildAntiAffinePod(name, cpuPerPod, memoryPerPod))
+	}
+	// A pod without anti-affinity can share a node with any of them.
+	estimator.Add(&kube_api.Pod{ObjectMeta: kube_api.ObjectMeta{Name: "p4", Namespace: "default"}})
+
+	node := &kube_api.Node{
+		Status: kube_api.NodeStatus{
+			Capacity: kube_api.ResourceList{
+				kube_api.ResourceCPU:    *resource.NewMilliQuantity(cpuPerPod*10, resource.DecimalSI),
+				kube_api.ResourceMemory: *resource.NewQuantity(memoryPerPod*10, resource.DecimalSI),
+				kube_api.ResourcePods:   *resource.NewQuantity(10, resource.DecimalSI),
+			},
+		},
+	}
+	estimate, report := estimator.Estimate(node)
+	assert.Contains(t, report.String(), "Anti-affinity: 3")
+	assert.Equal(t, 3, estimate)
+}
+
+func TestEstimateWithEphemeralStorage(t *testing.T) {
+	storagePerPod := int64(4 * 1024 * 1024 * 1024)
+
+	estimator := NewBasicNodeEstimator()
+	for _, name := range []string{"p1", "p2", "p3"} {
+		estimator.Add(&kube_api.Pod{
+			ObjectMeta: kube_api.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: kube_api.PodSpec{
+				Containers: []kube_api.Container{
+					{
+						Resources: kube_api.ResourceRequirements{
+							Requests: kube_api.ResourceList{
+								kube_api.ResourceCPU:               *resource.NewMilliQuantity(100, resource.DecimalSI),
+								simulator.ResourceEphemeralStorage: *resource.NewQuantity(storagePerPod, resource.BinarySI),
+							},
+						},
+					},
+				},
+			},
+		})
+	}
+
+	node := &kube_api.Node{
+		Status: kube_api.NodeStatus{
+			Capacity: kube_api.ResourceList{
+				kube_api.ResourceCPU:  *resource.NewMilliQuantity(1000, resource.DecimalSI),
+				kube_api.ResourcePods: *resource.NewQuantity(10, resource.DecimalSI),
+			},
+		},
+	}
+	// Without ephemeral storage on the node only cpu counts.
+	estimate, _ := estimator.Estimate(node)
+	assert.Equal(t, 1, estimate)
+
+	// Two pods fit in the ephemeral storage of a node.
+	node.Status.Capacity[simulator.ResourceEphemeralStorage] = *resource.NewQuantity(10*1024*1024*1024, resource.BinarySI)
+	estimate, _ = estimator.Estimate(node)
+	assert.Equal(t, 2, estimate)
+}
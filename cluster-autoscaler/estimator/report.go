@@ -0,0 +1,136 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package estimator
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+)
+
+// reportedResources are the resources whose utilization and headroom are reported.
+var reportedResources = []kube_api.ResourceName{kube_api.ResourceCPU, kube_api.ResourceMemory, simulator.ResourceEphemeralStorage}
+
+// EstimationReport describes how an estimator arrived at the number of nodes needed for a set of pods.
+type EstimationReport struct {
+	// FittingPods is the number of pods included in the estimation.
+	FittingPods int `json:"fittingPods"`
+	// NodeCount is the estimated number of nodes.
+	NodeCount int `json:"nodeCount"`
+	// Utilization is the fraction of the allocatable resources of the estimated nodes that would be
+	// requested, keyed by resource.
+	Utilization map[kube_api.ResourceName]float64 `json:"utilization,omitempty"`
+	// Headroom is the amount of the allocatable resources of the estimated nodes that would be left
+	// unrequested. It is negative if the estimator didn't fit all requests.
+	Headroom kube_api.ResourceList `json:"headroom,omitempty"`
+	// Assumptions lists the assumptions the estimation relies on, e.g. the overhead of DaemonSet pods
+	// expected on every new node.
+	Assumptions []string `json:"assumptions,omitempty"`
+	// Details lists estimator specific steps of the estimation.
+	Details []string `json:"details,omitempty"`
+}
+
+// String returns a human readable form of the report.
+func (report EstimationReport) String() string {
+	var buffer bytes.Buffer
+	for _, line := range report.Details {
+		buffer.WriteString(line + "\n")
+	}
+	for _, name := range sortedResourceNames(report.Utilization) {
+		headroom := report.Headroom[name]
+		buffer.WriteString(fmt.Sprintf("Utilization of %s: %.0f%%, headroom: %s\n",
+			name, 100*report.Utilization[name], headroom.String()))
+	}
+	for _, assumption := range report.Assumptions {
+		buffer.WriteString(fmt.Sprintf("Assuming %s\n", assumption))
+	}
+	return buffer.String()
+}
+
+func sortedResourceNames(utilization map[kube_api.ResourceName]float64) []kube_api.ResourceName {
+	names := make([]kube_api.ResourceName, 0, len(utilization))
+	for name := range utilization {
+		names = append(names, name)
+	}
+	sort.Sort(byResourceName(names))
+	return names
+}
+
+type byResourceName []kube_api.ResourceName
+
+func (a byResourceName) Len() int           { return len(a) }
+func (a byResourceName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byResourceName) Less(i, j int) bool { return a[i] < a[j] }
+
+// newEstimationReport builds a report of nodeCount nodes with the given allocatable resources on
+// which the given amounts of resources are requested.
+func newEstimationReport(fittingPods, nodeCount int, allocatable, requested kube_api.ResourceList) EstimationReport {
+	report := EstimationReport{
+		FittingPods: fittingPods,
+		NodeCount:   nodeCount,
+		Utilization: make(map[kube_api.ResourceName]float64),
+		Headroom:    kube_api.ResourceList{},
+	}
+	if nodeCount <= 0 {
+		return report
+	}
+	for _, name := range reportedResources {
+		perNode, found := allocatable[name]
+		if !found || perNode.IsZero() {
+			continue
+		}
+		request := requested[name]
+		var total *resource.Quantity
+		if name == kube_api.ResourceCPU {
+			total = resource.NewMilliQuantity(perNode.MilliValue()*int64(nodeCount), perNode.Format)
+			report.Utilization[name] = float64(request.MilliValue()) / float64(total.MilliValue())
+		} else {
+			total = resource.NewQuantity(perNode.Value()*int64(nodeCount), perNode.Format)
+			report.Utilization[name] = float64(request.Value()) / float64(total.Value())
+		}
+		total.Sub(request)
+		report.Headroom[name] = *total
+	}
+	return report
+}
+
+// addPodRequests adds the requests of the pod to the reported resources in requested, count times.
+func addPodRequests(requested kube_api.ResourceList, pod *kube_api.Pod, count int) {
+	podRequests := kube_api.ResourceList{
+		simulator.ResourceEphemeralStorage: simulator.PodEphemeralStorageRequest(pod),
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, name := range []kube_api.ResourceName{kube_api.ResourceCPU, kube_api.ResourceMemory} {
+			if request, ok := container.Resources.Requests[name]; ok {
+				sum := podRequests[name]
+				sum.Add(request)
+				podRequests[name] = sum
+			}
+		}
+	}
+	for name, request := range podRequests {
+		sum := requested[name]
+		for i := 0; i < count; i++ {
+			sum.Add(request)
+		}
+		requested[name] = sum
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package estimator
+
+import (
+	"testing"
+
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const mebibyte = 1024 * 1024
+
+func buildReportTestPod(name string, cpu, memory int64) *kube_api.Pod {
+	return &kube_api.Pod{
+		ObjectMeta: kube_api.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: kube_api.PodSpec{
+			Containers: []kube_api.Container{
+				{
+					Resources: kube_api.ResourceRequirements{
+						Requests: kube_api.ResourceList{
+							kube_api.ResourceCPU:    *resource.NewMilliQuantity(cpu, resource.DecimalSI),
+							kube_api.ResourceMemory: *resource.NewQuantity(memory, resource.BinarySI),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func buildReportTestNode() *kube_api.Node {
+	node := &kube_api.Node{
+		Status: kube_api.NodeStatus{
+			Capacity: kube_api.ResourceList{
+				kube_api.ResourceCPU:    *resource.NewMilliQuantity(1000, resource.DecimalSI),
+				kube_api.ResourceMemory: *resource.NewQuantity(1000*mebibyte, resource.BinarySI),
+				kube_api.ResourcePods:   *resource.NewQuantity(10, resource.DecimalSI),
+			},
+		},
+	}
+	node.Status.Allocatable = node.Status.Capacity
+	return node
+}
+
+func TestBinpackingEstimationReport(t *testing.T) {
+	pods := []*kube_api.Pod{
+		buildReportTestPod("p1", 400, 200*mebibyte),
+		buildReportTestPod("p2", 400, 200*mebibyte),
+		buildReportTestPod("p3", 400, 200*mebibyte),
+		buildReportTestPod("p4", 400, 200*mebibyte),
+	}
+	// A DaemonSet pod runs on the sampled node.
+	nodeInfo := schedulercache.NewNodeInfo(buildReportTestPod("ds", 100, 100*mebibyte))
+	nodeInfo.SetNode(buildReportTestNode())
+
+	estimator := NewBinpackingNodeEstimator(simulator.NewTestPredicateChecker())
+	estimate, report := estimator.Estimate(pods, nodeInfo)
+	assert.Equal(t, 2, estimate)
+	assert.Equal(t, 4, report.FittingPods)
+	assert.Equal(t, 2, report.NodeCount)
+	assert.InDelta(t, 0.9, report.Utilization[kube_api.ResourceCPU], 0.001)
+	assert.InDelta(t, 0.5, report.Utilization[kube_api.ResourceMemory], 0.001)
+	assert.Len(t, report.Utilization, 2)
+	cpuHeadroom := report.Headroom[kube_api.ResourceCPU]
+	assert.Equal(t, int64(200), cpuHeadroom.MilliValue())
+	memoryHeadroom := report.Headroom[kube_api.ResourceMemory]
+	assert.Equal(t, int64(1000*mebibyte), memoryHeadroom.Value())
+	assert.Equal(t, []string{"1 pods of the node template, e.g. DaemonSet pods, run on every new node"}, report.Assumptions)
+	assert.Equal(t, []string{"Binpacking: 4 pods packed on 2 nodes"}, report.Details)
+
+	assert.Contains(t, report.String(), "Binpacking: 4 pods packed on 2 nodes\n")
+	assert.Contains(t, report.String(), "Utilization of cpu: 90%, headroom: 200m\n")
+	assert.Contains(t, report.String(), "Assuming 1 pods of the node template")
+}
+
+func TestBasicEstimationReport(t *testing.T) {
+	estimator := NewBasicNodeEstimator()
+	for _, name := range []string{"p1", "p2", "p3", "p4"} {
+		estimator.Add(buildReportTestPod(name, 400, 200*mebibyte))
+	}
+
+	estimate, report := estimator.Estimate(buildReportTestNode())
+	assert.Equal(t, 2, estimate)
+	assert.Equal(t, 4, report.FittingPods)
+	assert.Equal(t, 2, report.NodeCount)
+	assert.InDelta(t, 0.8, report.Utilization[kube_api.ResourceCPU], 0.001)
+	assert.InDelta(t, 0.4, report.Utilization[kube_api.ResourceMemory], 0.001)
+	cpuHeadroom := report.Headroom[kube_api.ResourceCPU]
+	assert.Equal(t, int64(400), cpuHeadroom.MilliValue())
+	assert.Len(t, report.Assumptions, 1)
+	assert.Contains(t, report.Details, "CPU: 2")
+	assert.Contains(t, report.Details, "Mem: 1")
+}
+
+func TestEstimationReportWithoutNodes(t *testing.T) {
+	report := newEstimationReport(0, 0, buildReportTestNode().Status.Allocatable, kube_api.ResourceList{})
+	assert.Empty(t, report.Utilization)
+	assert.Empty(t, report.Headroom)
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package estimator
+
+import (
+	kube_api "k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// RequestsResourceMode makes the estimators account pods by their resource requests.
+	RequestsResourceMode = "requests"
+	// LimitsResourceMode makes the estimators account pods by their resource limits, falling back
+	// to requests for resources without a limit.
+	LimitsResourceMode = "limits"
+)
+
+// AvailableResourceModes is a list of available resource modes.
+var AvailableResourceModes = []string{RequestsResourceMode, LimitsResourceMode}
+
+// PodsForResourceMode returns pods whose requests reflect the given resource mode. In
+// LimitsResourceMode copies of the pods are returned with each container limit used as its request.
+// The scheduler always uses requests, so this only affects the estimated number of nodes.
+func PodsForResourceMode(pods []*kube_api.Pod, mode string) []*kube_api.Pod {
+	if mode != LimitsResourceMode {
+		return pods
+	}
+	result := make([]*kube_api.Pod, 0, len(pods))
+	for _, pod := range pods {
+		result = append(result, podWithLimitsAsRequests(pod))
+	}
+	return result
+}
+
+func podWithLimitsAsRequests(pod *kube_api.Pod) *kube_api.Pod {
+	podCopy := *pod
+	podCopy.Spec.Containers = make([]kube_api.Container, len(pod.Spec.Containers))
+	for i, container := range pod.Spec.Containers {
+		requests := kube_api.ResourceList{}
+		for name, quantity := range container.Resources.Requests {
+			requests[name] = quantity
+		}
+		for name, quantity := range container.Resources.Limits {
+			requests[name] = quantity
+		}
+		container.Resources.Requests = requests
+		podCopy.Spec.Containers[i] = container
+	}
+	return &podCopy
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/runtime"
+)
+
+// Reasons of the events recorded by the autoscaler, on pods, nodes and the autoscaler object.
+const (
+	// ReasonTriggeredScaleUp is recorded on pods that triggered a scale up.
+	ReasonTriggeredScaleUp = "TriggeredScaleUp"
+	// ReasonNotTriggerScaleUp is recorded on pods that wouldn't fit on a new node of any node group.
+	ReasonNotTriggerScaleUp = "NotTriggerScaleUp"
+	// ReasonNotTriggerScaleUpUnboundClaim is recorded on pods waiting for a PersistentVolumeClaim to
+	// be bound.
+	ReasonNotTriggerScaleUpUnboundClaim = "NotTriggerScaleUpUnboundClaim"
+	// ReasonPodTooLargeForAnyNodeGroup is recorded on pods larger than a node of any node group.
+	ReasonPodTooLargeForAnyNodeGroup = "PodTooLargeForAnyNodeGroup"
+	// ReasonScaledUpGroup is recorded on the autoscaler object when a node group is scaled up.
+	ReasonScaledUpGroup = "ScaledUpGroup"
+	// ReasonFailedToScaleUpGroup is recorded on the autoscaler object when the cloud provider fails
+	// to scale up a node group.
+	ReasonFailedToScaleUpGroup = "FailedToScaleUpGroup"
+	// ReasonScaleUpTimedOut is recorded on the autoscaler object when requested nodes of a node
+	// group don't register within --max-node-provision-time, or fail to launch, and are given up.
+	ReasonScaleUpTimedOut = "ScaleUpTimedOut"
+	// ReasonScaleDown is recorded on removed nodes and on the pods evicted from them.
+	ReasonScaleDown = "ScaleDown"
+	// ReasonScaleDownFailed is recorded on nodes that couldn't be drained or deleted.
+	ReasonScaleDownFailed = "ScaleDownFailed"
+	// ReasonScaledDownNode is recorded on the autoscaler object when a node is removed.
+	ReasonScaledDownNode = "ScaledDownNode"
+)
+
+// noopRecorder is an EventRecorder dropping all events, for dry runs that mustn't record anything.
+type noopRecorder struct{}
+
+// Event drops the event.
+func (noopRecorder) Event(object runtime.Object, eventtype, reason, message string) {}
+
+// Eventf drops the event.
+func (noopRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+}
+
+// PastEventf drops the event.
+func (noopRecorder) PastEventf(object runtime.Object, timestamp unversioned.Time, eventtype, reason, messageFmt string,
+	args ...interface{}) {
+}
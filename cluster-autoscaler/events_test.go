@@ -0,0 +1,110 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// eventReasons drains the recorder and returns "<type> <reason>" of the recorded events.
+func eventReasons(recorder *kube_record.FakeRecorder) []string {
+	result := make([]string, 0)
+	for len(recorder.Events) > 0 {
+		fields := strings.SplitN(<-recorder.Events, " ", 3)
+		result = append(result, fields[0]+" "+fields[1])
+	}
+	return result
+}
+
+func TestScaleUpEventReasons(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	var scaleUpErr error
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		return scaleUpErr
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	recorder := kube_record.NewFakeRecorder(10)
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         recorder,
+		EstimatorName:    BinpackingEstimatorName,
+		AutoscalerObject: &kube_api.ObjectReference{Kind: "Deployment", Namespace: "kube-system", Name: "cluster-autoscaler"},
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+	}
+
+	_, err := ScaleUp(context, []*kube_api.Pod{BuildTestPod("p1", 800, 0)}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Normal " + ReasonScaledUpGroup, "Normal " + ReasonTriggeredScaleUp}, eventReasons(recorder))
+
+	_, err = ScaleUp(context, []*kube_api.Pod{BuildTestPod("p2", 2000, 0)}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Warning " + ReasonPodTooLargeForAnyNodeGroup}, eventReasons(recorder))
+
+	scaleUpErr = fmt.Errorf("quota exceeded")
+	_, err = ScaleUp(context, []*kube_api.Pod{BuildTestPod("p3", 800, 0)}, []*kube_api.Node{n1}, nodeInfos)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"Warning " + ReasonFailedToScaleUpGroup}, eventReasons(recorder))
+}
+
+func TestScaleDownEventReasons(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	var deleteErr error
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		return deleteErr
+	})
+	provider.AddNodeGroup("ng1", 0, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	recorder := kube_record.NewFakeRecorder(10)
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           recorder,
+		MaxEmptyBulkDelete: 10,
+		AutoscalerObject:   &kube_api.ObjectReference{Kind: "Deployment", Namespace: "kube-system", Name: "cluster-autoscaler"},
+	}
+	scaleDown := func() {
+		unneeded := map[string]time.Time{"n1": time.Now().Add(-time.Hour)}
+		ScaleDown(context, []*kube_api.Node{n1}, map[string]float64{}, unneeded,
+			[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	}
+
+	deleteErr = fmt.Errorf("instance not found")
+	scaleDown()
+	assert.Equal(t, []string{"Warning " + ReasonScaleDownFailed}, eventReasons(recorder))
+
+	deleteErr = nil
+	scaleDown()
+	assert.Equal(t, []string{"Normal " + ReasonScaleDown, "Normal " + ReasonScaledDownNode}, eventReasons(recorder))
+}
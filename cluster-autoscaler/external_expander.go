@@ -0,0 +1,144 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	kube_api_unversioned "k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// DefaultExpanderName picks the expansion option with BestExpansionOption.
+	DefaultExpanderName = "default"
+	// HttpExpanderName asks an http endpoint to pick the expansion option.
+	HttpExpanderName = "http"
+)
+
+// ExternalExpansionOption describes an expansion option to an ExternalExpander.
+type ExternalExpansionOption struct {
+	NodeGroupId  string `json:"nodeGroupId"`
+	InstanceType string `json:"instanceType,omitempty"`
+	// NodeCount is the estimated number of nodes needed in the node group.
+	NodeCount int `json:"nodeCount"`
+	// Pods are namespace/name of the pending pods that fit on a node of the node group.
+	Pods []string `json:"pods"`
+}
+
+// ExternalExpander picks the best expansion option outside of the autoscaler, so that custom
+// expansion logic can be implemented out of process.
+type ExternalExpander interface {
+	// BestOption returns the node group id of the chosen option.
+	BestOption(options []ExternalExpansionOption) (string, error)
+}
+
+// HttpExternalExpander posts the expansion options to an http endpoint as a JSON object
+// {"options": [...]} and expects a JSON object {"nodeGroupId": "..."} with the choice.
+type HttpExternalExpander struct {
+	url    string
+	client *http.Client
+}
+
+// NewHttpExternalExpander builds HttpExternalExpander.
+func NewHttpExternalExpander(url string, timeout time.Duration) *HttpExternalExpander {
+	return &HttpExternalExpander{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+type externalExpanderRequest struct {
+	Options []ExternalExpansionOption `json:"options"`
+}
+
+type externalExpanderResponse struct {
+	NodeGroupId string `json:"nodeGroupId"`
+}
+
+// BestOption returns the node group id chosen by the endpoint.
+func (e *HttpExternalExpander) BestOption(options []ExternalExpansionOption) (string, error) {
+	body, err := json.Marshal(externalExpanderRequest{Options: options})
+	if err != nil {
+		return "", err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code from %s: %d", e.url, resp.StatusCode)
+	}
+	choice := externalExpanderResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&choice); err != nil {
+		return "", fmt.Errorf("failed to decode choice from %s: %v", e.url, err)
+	}
+	return choice.NodeGroupId, nil
+}
+
+// bestExpansionOption picks the expansion option with context.ExternalExpander, if set. If the
+// external expander fails or returns an unknown node group it falls back to BestExpansionOption.
+// With context.PriorityExpander set, only the options with the highest priority are considered.
+// If the pods of the options belong mostly to a class of context.PodClassExpanders, the expander of
+// that class is used instead.
+func bestExpansionOption(context *AutoscalingContext, options []ExpansionOption,
+	nodeInfos map[string]*schedulercache.NodeInfo) *ExpansionOption {
+	// TODO: prefer the node groups in zones that reduce the max skew of pods with topology spread
+	// constraints, once the vendored api has them. Zones of node groups can be read from the
+	// failure-domain.beta.kubernetes.io/zone label of their template nodes in nodeInfos.
+	priorityExpander, externalExpander := context.PriorityExpander, context.ExternalExpander
+	if name, found := context.PodClassExpanders.ExpanderFor(options); found {
+		priorityExpander, externalExpander = context.PodClassExpanders.Expanders(name)
+	}
+	options = priorityExpander.HighestPriorityOptions(options)
+	options = warmPoolOptions(options)
+	if externalExpander != nil && len(options) > 0 {
+		externalOptions := make([]ExternalExpansionOption, 0, len(options))
+		for _, option := range options {
+			externalOption := ExternalExpansionOption{
+				NodeGroupId: option.nodeGroup.Id(),
+				NodeCount:   option.nodeCount,
+				Pods:        make([]string, 0, len(option.pods)),
+			}
+			if nodeInfo, found := nodeInfos[option.nodeGroup.Id()]; found && nodeInfo.Node() != nil {
+				externalOption.InstanceType = nodeInfo.Node().Labels[kube_api_unversioned.LabelInstanceType]
+			}
+			for _, pod := range option.pods {
+				externalOption.Pods = append(externalOption.Pods, pod.Namespace+"/"+pod.Name)
+			}
+			externalOptions = append(externalOptions, externalOption)
+		}
+		id, err := externalExpander.BestOption(externalOptions)
+		if err == nil {
+			for i := range options {
+				if options[i].nodeGroup.Id() == id {
+					return &options[i]
+				}
+			}
+			err = fmt.Errorf("unknown node group %q", id)
+		}
+		glog.Warningf("External expander failed, falling back to the default expander: %v", err)
+	}
+	return BestExpansionOption(options, context.ExpanderRandomTieBreak, context.ScaleUpHistory)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_api_unversioned "k8s.io/kubernetes/pkg/api/unversioned"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scaleUpWithExpander(t *testing.T, expander ExternalExpander) map[string]int {
+	n1 := BuildTestNode("n1", 4000, 1000)
+	n1.Labels = map[string]string{kube_api_unversioned.LabelInstanceType: "n1-standard-4"}
+	n2 := BuildTestNode("n2", 4000, 1000)
+	n2.Labels = map[string]string{kube_api_unversioned.LabelInstanceType: "n1-highcpu-4"}
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+		ExternalExpander: expander,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+		"ng2": buildTestNodeInfo(n2),
+	}
+
+	p1 := BuildTestPod("p1", 2000, 0)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1, n2}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	return scaledGroups
+}
+
+type byExternalNodeGroupId []ExternalExpansionOption
+
+func (a byExternalNodeGroupId) Len() int           { return len(a) }
+func (a byExternalNodeGroupId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byExternalNodeGroupId) Less(i, j int) bool { return a[i].NodeGroupId < a[j].NodeGroupId }
+
+func TestHttpExternalExpander(t *testing.T) {
+	var request externalExpanderRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		w.Write([]byte(`{"nodeGroupId": "ng2"}`))
+	}))
+	defer server.Close()
+
+	scaledGroups := scaleUpWithExpander(t, NewHttpExternalExpander(server.URL, time.Second))
+	assert.Equal(t, map[string]int{"ng2": 1}, scaledGroups)
+	// Options are posted in no particular order.
+	sort.Sort(byExternalNodeGroupId(request.Options))
+	assert.Equal(t, []ExternalExpansionOption{
+		{NodeGroupId: "ng1", InstanceType: "n1-standard-4", NodeCount: 1, Pods: []string{"default/p1"}},
+		{NodeGroupId: "ng2", InstanceType: "n1-highcpu-4", NodeCount: 1, Pods: []string{"default/p1"}},
+	}, request.Options)
+}
+
+func TestHttpExternalExpanderFallback(t *testing.T) {
+	// Without an external expander ng1 is picked.
+	assert.Equal(t, map[string]int{"ng1": 1}, scaleUpWithExpander(t, nil))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "expander failure", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	assert.Equal(t, map[string]int{"ng1": 1}, scaleUpWithExpander(t, NewHttpExternalExpander(server.URL, time.Second)))
+
+	unknownServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"nodeGroupId": "ng3"}`))
+	}))
+	defer unknownServer.Close()
+	assert.Equal(t, map[string]int{"ng1": 1}, scaleUpWithExpander(t, NewHttpExternalExpander(unknownServer.URL, time.Second)))
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"nodeGroupId": "ng2"}`))
+	}))
+	defer slowServer.Close()
+	assert.Equal(t, map[string]int{"ng1": 1}, scaleUpWithExpander(t, NewHttpExternalExpander(slowServer.URL, 50*time.Millisecond)))
+}
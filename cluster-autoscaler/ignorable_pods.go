@@ -0,0 +1,81 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/labels"
+)
+
+// IgnorablePodSelector matches pods that don't keep a node from being considered empty in scale down,
+// in addition to DaemonSet and mirror pods. Such pods are usually infrastructure pods that run on every
+// node and are not worth moving anywhere.
+type IgnorablePodSelector struct {
+	// Namespace of the matched pods. Empty matches all namespaces.
+	Namespace string
+	// Selector of the matched pods' labels.
+	Selector labels.Selector
+}
+
+// ParseIgnorablePodSelector parses an ignorable pod selector in format <namespace>:<label selector>.
+// Either part can be empty, but not both.
+func ParseIgnorablePodSelector(value string) (IgnorablePodSelector, error) {
+	tokens := strings.SplitN(value, ":", 2)
+	if len(tokens) != 2 {
+		return IgnorablePodSelector{}, fmt.Errorf("wrong ignorable pod selector: %s, expected <namespace>:<label selector>", value)
+	}
+	if tokens[0] == "" && tokens[1] == "" {
+		return IgnorablePodSelector{}, fmt.Errorf("ignorable pod selector must have a namespace or a label selector")
+	}
+	selector, err := labels.Parse(tokens[1])
+	if err != nil {
+		return IgnorablePodSelector{}, fmt.Errorf("failed to parse label selector of %s: %v", value, err)
+	}
+	return IgnorablePodSelector{Namespace: tokens[0], Selector: selector}, nil
+}
+
+// Matches returns true if the pod is matched by the selector.
+func (s IgnorablePodSelector) Matches(pod *kube_api.Pod) bool {
+	if s.Namespace != "" && s.Namespace != pod.Namespace {
+		return false
+	}
+	return s.Selector.Matches(labels.Set(pod.Labels))
+}
+
+// FilterOutIgnorablePods returns the pods not matched by any of the selectors.
+func FilterOutIgnorablePods(pods []*kube_api.Pod, selectors []IgnorablePodSelector) []*kube_api.Pod {
+	if len(selectors) == 0 {
+		return pods
+	}
+	result := make([]*kube_api.Pod, 0, len(pods))
+	for _, pod := range pods {
+		ignorable := false
+		for _, selector := range selectors {
+			if selector.Matches(pod) {
+				ignorable = true
+				break
+			}
+		}
+		if !ignorable {
+			result = append(result, pod)
+		}
+	}
+	return result
+}
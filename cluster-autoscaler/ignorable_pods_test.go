@@ -0,0 +1,54 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIgnorablePodSelector(t *testing.T) {
+	_, err := ParseIgnorablePodSelector("kube-system")
+	assert.Error(t, err)
+	_, err = ParseIgnorablePodSelector(":")
+	assert.Error(t, err)
+	_, err = ParseIgnorablePodSelector("infra:app in (")
+	assert.Error(t, err)
+
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.Namespace = "infra"
+	p2 := BuildTestPod("p2", 100, 0)
+	p2.Labels = map[string]string{"role": "logging"}
+
+	selector, err := ParseIgnorablePodSelector("infra:")
+	assert.NoError(t, err)
+	assert.True(t, selector.Matches(p1))
+	assert.False(t, selector.Matches(p2))
+
+	selector, err = ParseIgnorablePodSelector(":role=logging")
+	assert.NoError(t, err)
+	assert.False(t, selector.Matches(p1))
+	assert.True(t, selector.Matches(p2))
+
+	selector, err = ParseIgnorablePodSelector("infra:role=logging")
+	assert.NoError(t, err)
+	assert.False(t, selector.Matches(p1))
+	assert.False(t, selector.Matches(p2))
+}
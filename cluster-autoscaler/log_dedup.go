@@ -0,0 +1,103 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// LogDeduplicator collapses identical errors that are logged on every scan, e.g. when the cloud
+// provider keeps failing. The first occurrence of an error is logged in full, repetitions are
+// only counted and summarized once per summaryInterval. An error that isn't repeated for
+// summaryInterval is logged in full again on its next occurrence. With summaryInterval 0 every
+// error is logged. It is safe for concurrent use.
+type LogDeduplicator struct {
+	mutex           sync.Mutex
+	summaryInterval time.Duration
+	logf            func(format string, args ...interface{})
+	entries         map[string]*logEntry
+}
+
+type logEntry struct {
+	repeated    int
+	lastSeen    time.Time
+	lastLogged  time.Time
+	firstRepeat time.Time
+}
+
+// NewLogDeduplicator builds new LogDeduplicator logging errors with glog.
+func NewLogDeduplicator(summaryInterval time.Duration) *LogDeduplicator {
+	return &LogDeduplicator{
+		summaryInterval: summaryInterval,
+		logf:            glog.Errorf,
+		entries:         make(map[string]*logEntry),
+	}
+}
+
+// Errorf logs the error unless it is a repetition of a recently logged one.
+func (d *LogDeduplicator) Errorf(format string, args ...interface{}) {
+	d.errorfAt(time.Now(), format, args...)
+}
+
+func (d *LogDeduplicator) errorfAt(now time.Time, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.summaryInterval <= 0 {
+		d.logf("%s", msg)
+		return
+	}
+	d.expire(now)
+	entry, found := d.entries[msg]
+	if !found {
+		d.logf("%s", msg)
+		d.entries[msg] = &logEntry{lastSeen: now, lastLogged: now}
+		return
+	}
+	entry.lastSeen = now
+	if entry.repeated == 0 {
+		entry.firstRepeat = now
+	}
+	entry.repeated++
+	if now.Sub(entry.lastLogged) >= d.summaryInterval {
+		d.logSummary(msg, entry)
+		entry.lastLogged = now
+	}
+}
+
+// expire forgets errors that weren't repeated for summaryInterval, summarizing their
+// repetitions that weren't logged yet.
+func (d *LogDeduplicator) expire(now time.Time) {
+	for msg, entry := range d.entries {
+		if now.Sub(entry.lastSeen) < d.summaryInterval {
+			continue
+		}
+		if entry.repeated > 0 {
+			d.logSummary(msg, entry)
+		}
+		delete(d.entries, msg)
+	}
+}
+
+func (d *LogDeduplicator) logSummary(msg string, entry *logEntry) {
+	d.logf("%s (repeated %d times since %s)", msg, entry.repeated, entry.firstRepeat.Format(time.RFC3339))
+	entry.repeated = 0
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogDeduplicator(t *testing.T) {
+	lines := make([]string, 0)
+	d := NewLogDeduplicator(time.Minute)
+	d.logf = func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	start := time.Now()
+	// An identical error on every 10 second scan for 2.5 minutes.
+	for i := 0; i < 16; i++ {
+		d.errorfAt(start.Add(time.Duration(i)*10*time.Second), "Failed to refresh node group sizes: %v", "throttled")
+	}
+	assert.Equal(t, 3, len(lines))
+	assert.Equal(t, "Failed to refresh node group sizes: throttled", lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "Failed to refresh node group sizes: throttled (repeated 6 times since "))
+	assert.True(t, strings.HasPrefix(lines[2], "Failed to refresh node group sizes: throttled (repeated 6 times since "))
+
+	// A different error is logged right away.
+	d.errorfAt(start.Add(150*time.Second), "Failed to list nodes: %v", "timeout")
+	assert.Equal(t, 4, len(lines))
+	assert.Equal(t, "Failed to list nodes: timeout", lines[3])
+
+	// After a minute without the error the pending repetitions are summarized and the next
+	// occurrence is logged in full.
+	d.errorfAt(start.Add(5*time.Minute), "Failed to refresh node group sizes: %v", "throttled")
+	assert.Equal(t, 6, len(lines))
+	assert.True(t, strings.HasPrefix(lines[4], "Failed to refresh node group sizes: throttled (repeated 3 times since "))
+	assert.Equal(t, "Failed to refresh node group sizes: throttled", lines[5])
+}
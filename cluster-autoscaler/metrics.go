@@ -19,6 +19,8 @@ package main
 import (
 	"time"
 
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -46,12 +48,146 @@ var (
 			Help:      "Time spent in main loop fragments in microseconds.",
 		}, []string{"main"},
 	)
+
+	timedOutScaleUps = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "timed_out_scale_up_nodes_total",
+			Help:      "Number of requested nodes that didn't register within max node provision time.",
+		}, []string{"node_group"},
+	)
+
+	failedScaleUps = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "failed_scale_ups_total",
+			Help:      "Number of scale ups whose instances the cloud provider failed to launch.",
+		}, []string{"node_group"},
+	)
+
+	reclaimedPhantomNodes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "reclaimed_phantom_nodes_total",
+			Help:      "Number of requested nodes that never registered and were removed from the target size of a node group.",
+		}, []string{"node_group"},
+	)
+
+	scaledDownNodes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "scaled_down_nodes_total",
+			Help:      "Number of nodes removed from a node group in scale down.",
+		}, []string{"node_group"},
+	)
+
+	skippedScaleDowns = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "skipped_scale_down_nodes_total",
+			Help:      "Number of nodes not removed because their pods couldn't be evicted within max pod eviction time.",
+		},
+	)
+
+	circuitBreakerState = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "circuit_breaker_state",
+			Help:      "State of the scale operations circuit breaker: 0 - closed, 1 - open, 2 - half-open.",
+		},
+	)
+
+	nodeGroupSizeDiscrepancy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "node_group_size_discrepancy",
+			Help:      "Target size of a node group on the cloud provider side minus the number of its nodes registered in Kubernetes.",
+		}, []string{"node_group"},
+	)
+
+	nodeGroupUnhealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "node_group_unhealthy",
+			Help:      "Whether a node group isn't scaled up because its requested nodes keep failing to register: 0 - no, 1 - yes.",
+		}, []string{"node_group"},
+	)
+
+	unreadyNodesCount = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "unready_nodes_count",
+			Help:      "Number of unready nodes in the cluster.",
+		},
+	)
+
+	unmanagedNodesCount = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "unmanaged_nodes_count",
+			Help:      "Number of nodes that don't belong to any configured node group.",
+		},
+	)
+
+	predicateFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "predicate_failures_total",
+			Help:      "Number of times a predicate rejected a pending pod on the template node of a node group during scale up.",
+		}, []string{"predicate"},
+	)
+
+	nodeGroupLastScaleUpTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "node_group_last_scale_up_timestamp_seconds",
+			Help:      "Unix time of the last successful scale up of a node group.",
+		}, []string{"node_group"},
+	)
+
+	nodeGroupLastScaleDownTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "node_group_last_scale_down_timestamp_seconds",
+			Help:      "Unix time of the last node removed from a node group in scale down.",
+		}, []string{"node_group"},
+	)
+
+	safetyBrakeEngaged = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "cluster_autoscaler",
+			Name:      "safety_brake_engaged",
+			Help:      "Whether scaling is halted because too many nodes are unready: 0 - no, 1 - yes.",
+		},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(duration)
 	prometheus.MustRegister(lastDuration)
 	prometheus.MustRegister(lastTimestamp)
+	prometheus.MustRegister(timedOutScaleUps)
+	prometheus.MustRegister(failedScaleUps)
+	prometheus.MustRegister(reclaimedPhantomNodes)
+	prometheus.MustRegister(scaledDownNodes)
+	prometheus.MustRegister(skippedScaleDowns)
+	prometheus.MustRegister(circuitBreakerState)
+	prometheus.MustRegister(nodeGroupSizeDiscrepancy)
+	prometheus.MustRegister(nodeGroupUnhealthy)
+	prometheus.MustRegister(unreadyNodesCount)
+	prometheus.MustRegister(unmanagedNodesCount)
+	prometheus.MustRegister(predicateFailures)
+	prometheus.MustRegister(safetyBrakeEngaged)
+	prometheus.MustRegister(nodeGroupLastScaleUpTimestamp)
+	prometheus.MustRegister(nodeGroupLastScaleDownTimestamp)
+}
+
+// recordPredicateFailure counts the predicate that rejected a pod, if err is a
+// simulator.PredicateError.
+func recordPredicateFailure(err error) {
+	if predicateErr, ok := err.(*simulator.PredicateError); ok {
+		predicateFailures.WithLabelValues(predicateErr.PredicateName).Inc()
+	}
 }
 
 func durationToMicro(start time.Time) float64 {
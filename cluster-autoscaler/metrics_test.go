@@ -0,0 +1,74 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// metricValue returns the current value of a counter or gauge.
+func metricValue(t *testing.T, metric prometheus.Metric) float64 {
+	var m dto.Metric
+	assert.NoError(t, metric.Write(&m))
+	if m.Counter != nil {
+		return m.Counter.GetValue()
+	}
+	return m.Gauge.GetValue()
+}
+
+func TestPredicateFailuresMetric(t *testing.T) {
+	taints, _ := json.Marshal([]kube_api.Taint{{Key: "dedicated", Value: "gpu", Effect: kube_api.TaintEffectNoSchedule}})
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n1.Annotations = map[string]string{kube_api.TaintsAnnotationKey: string(taints)}
+
+	provider := test.NewTestCloudProvider(func(id string, delta int) error { return nil }, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{"ng1": buildTestNodeInfo(n1)}
+
+	taintFailures := predicateFailures.WithLabelValues("PodToleratesNodeTaints")
+	resourceFailures := predicateFailures.WithLabelValues("GeneralPredicates")
+	taintsBefore := metricValue(t, taintFailures)
+	resourcesBefore := metricValue(t, resourceFailures)
+
+	// The pod doesn't tolerate the taint of the only node group.
+	p1 := BuildTestPod("p1", 500, 0)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.False(t, scaledUp)
+	assert.Equal(t, taintsBefore+1, metricValue(t, taintFailures))
+	assert.Equal(t, resourcesBefore, metricValue(t, resourceFailures))
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
+
+	"github.com/golang/glog"
+)
+
+// NodeCordoner marks nodes that are scaled down as unschedulable, so that the evicted pods are not
+// scheduled back on them.
+type NodeCordoner interface {
+	// SetUnschedulable sets the unschedulable flag of the node.
+	SetUnschedulable(node *kube_api.Node, unschedulable bool) error
+}
+
+// kubeNodeCordoner implements NodeCordoner with the Kubernetes api.
+type kubeNodeCordoner struct {
+	client *kube_client.Client
+}
+
+// NewKubeNodeCordoner builds a NodeCordoner that uses the given client.
+func NewKubeNodeCordoner(client *kube_client.Client) NodeCordoner {
+	return &kubeNodeCordoner{client: client}
+}
+
+func (c *kubeNodeCordoner) SetUnschedulable(node *kube_api.Node, unschedulable bool) error {
+	freshNode, err := c.client.Nodes().Get(node.Name)
+	if err != nil {
+		return err
+	}
+	if freshNode.Spec.Unschedulable == unschedulable {
+		return nil
+	}
+	freshNode.Spec.Unschedulable = unschedulable
+	_, err = c.client.Nodes().Update(freshNode)
+	return err
+}
+
+// cordonNode marks the node as unschedulable and returns true, unless it already was unschedulable,
+// e.g. cordoned by an administrator, in which case it returns false and the node must not be
+// uncordoned by the autoscaler.
+func cordonNode(context *AutoscalingContext, node *kube_api.Node) (bool, error) {
+	if context.NodeCordoner == nil || node.Spec.Unschedulable {
+		return false, nil
+	}
+	if err := context.NodeCordoner.SetUnschedulable(node, true); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// uncordonNode makes the node schedulable again after its removal failed.
+func uncordonNode(context *AutoscalingContext, node *kube_api.Node) {
+	if context.NodeCordoner == nil {
+		return
+	}
+	if err := context.NodeCordoner.SetUnschedulable(node, false); err != nil {
+		glog.Errorf("Failed to uncordon %s: %v", node.Name, err)
+	}
+}
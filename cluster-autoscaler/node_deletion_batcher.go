@@ -0,0 +1,147 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+
+	"github.com/golang/glog"
+)
+
+// NodeDeletionBatch contains empty nodes of a single node group that are deleted together.
+type NodeDeletionBatch struct {
+	NodeGroup cloudprovider.NodeGroup
+	Nodes     []*kube_api.Node
+}
+
+// NodeDeletionBatcher collects empty nodes so that the empty nodes of a node group are deleted in a
+// single cloud provider call instead of one call per node. Cloud providers like AWS can only delete
+// instances of one group at a time, so nodes are batched per node group.
+type NodeDeletionBatcher struct {
+	// interval is for how long empty nodes are collected before their batch is deleted.
+	interval time.Duration
+	// firstSeen is when the nodes waiting for deletion were first seen empty, keyed by node name.
+	firstSeen map[string]time.Time
+}
+
+// NewNodeDeletionBatcher builds new NodeDeletionBatcher.
+func NewNodeDeletionBatcher(interval time.Duration) *NodeDeletionBatcher {
+	return &NodeDeletionBatcher{
+		interval:  interval,
+		firstSeen: make(map[string]time.Time),
+	}
+}
+
+// Update records the current empty nodes and returns the batches that are ready to be deleted: all
+// empty nodes of every node group whose first empty node was seen at least interval ago. Nodes that
+// are no longer empty are dropped from their batch.
+func (b *NodeDeletionBatcher) Update(emptyNodes []*kube_api.Node, nodeGroups map[string]cloudprovider.NodeGroup,
+	now time.Time) []NodeDeletionBatch {
+	firstSeen := make(map[string]time.Time)
+	batches := make(map[string]*NodeDeletionBatch)
+	oldest := make(map[string]time.Time)
+	ids := make([]string, 0)
+	for _, node := range emptyNodes {
+		nodeGroup := nodeGroups[node.Name]
+		seen, found := b.firstSeen[node.Name]
+		if !found {
+			seen = now
+		}
+		firstSeen[node.Name] = seen
+
+		batch, found := batches[nodeGroup.Id()]
+		if !found {
+			batch = &NodeDeletionBatch{NodeGroup: nodeGroup}
+			batches[nodeGroup.Id()] = batch
+			oldest[nodeGroup.Id()] = seen
+			ids = append(ids, nodeGroup.Id())
+		}
+		batch.Nodes = append(batch.Nodes, node)
+		if seen.Before(oldest[nodeGroup.Id()]) {
+			oldest[nodeGroup.Id()] = seen
+		}
+	}
+
+	result := make([]NodeDeletionBatch, 0)
+	for _, id := range ids {
+		if oldest[id].Add(b.interval).After(now) {
+			continue
+		}
+		result = append(result, *batches[id])
+		for _, node := range batches[id].Nodes {
+			delete(firstSeen, node.Name)
+		}
+	}
+	b.firstSeen = firstSeen
+	return result
+}
+
+// deleteNodeBatches deletes the nodes of every batch with a single DeleteNodes call of its node group.
+func deleteNodeBatches(context *AutoscalingContext, batches []NodeDeletionBatch, utilization map[string]float64,
+	usageTracker *simulator.UsageTracker, unneededNodes map[string]time.Time) (ScaleDownResult, error) {
+	if len(batches) == 0 {
+		glog.V(1).Infof("No scale down - collecting empty nodes to delete them in batches")
+		return ScaleDownInProgress, nil
+	}
+	confirmation := make(chan error, len(batches))
+	for _, batch := range batches {
+		for _, node := range batch.Nodes {
+			glog.V(0).Infof("Scale-down: removing empty node %s in a batch of %s", node.Name, batch.NodeGroup.Id())
+			simulator.RemoveNodeFromTracker(usageTracker, node.Name, unneededNodes)
+		}
+		go func(batch NodeDeletionBatch) {
+			confirmation <- deleteNodeBatch(context, batch, utilization)
+		}(batch)
+	}
+	var finalError error
+	for range batches {
+		if err := <-confirmation; err != nil {
+			glog.Errorf("Problem with empty node deletion: %v", err)
+			finalError = err
+		}
+	}
+	if finalError == nil {
+		return ScaleDownNodeDeleted, nil
+	}
+	return ScaleDownError, fmt.Errorf("failed to delete at least one batch of empty nodes: %v", finalError)
+}
+
+// deleteNodeBatch deletes the nodes of the batch from its node group, skipping nodes whose deletion
+// is already in progress.
+func deleteNodeBatch(context *AutoscalingContext, batch NodeDeletionBatch, utilization map[string]float64) error {
+	nodes, err := deleteNodesFromNodeGroup(batch.NodeGroup, batch.Nodes, context.Recorder, context.NodeDeletionTracker,
+		context.Now())
+	context.CircuitBreaker.RecordResult(err, context.Now())
+	if err != nil {
+		return fmt.Errorf("failed to delete nodes of %s: %v", batch.NodeGroup.Id(), err)
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	registerSizeChange(context, batch.NodeGroup, -len(nodes))
+	context.ScaleActivity.RegisterScaleDown(batch.NodeGroup.Id(), context.Now())
+	for _, node := range nodes {
+		recordScaleDownSummary(context, node, batch.NodeGroup, utilization[node.Name], 0)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// deleteCallsNodeGroup records the nodes passed to every DeleteNodes call of the wrapped node group.
+type deleteCallsNodeGroup struct {
+	cloudprovider.NodeGroup
+	calls chan []string
+}
+
+func (ng *deleteCallsNodeGroup) DeleteNodes(nodes []*kube_api.Node) error {
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+	sort.Strings(names)
+	ng.calls <- names
+	return ng.NodeGroup.DeleteNodes(nodes)
+}
+
+// deleteCallsCloudProvider wraps node groups of the test cloud provider in deleteCallsNodeGroups.
+type deleteCallsCloudProvider struct {
+	*test.TestCloudProvider
+	calls chan []string
+}
+
+func (p *deleteCallsCloudProvider) NodeGroupsForNodes(nodes []*kube_api.Node) (map[string]cloudprovider.NodeGroup, error) {
+	nodeGroups, err := p.TestCloudProvider.NodeGroupsForNodes(nodes)
+	result := make(map[string]cloudprovider.NodeGroup)
+	for name, nodeGroup := range nodeGroups {
+		result[name] = &deleteCallsNodeGroup{NodeGroup: nodeGroup, calls: p.calls}
+	}
+	return result, err
+}
+
+func TestNodeDeletionBatcherUpdate(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNodeGroup("ng2", 0, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	provider.AddNode("ng2", n3)
+	nodeGroups, err := provider.NodeGroupsForNodes([]*kube_api.Node{n1, n2, n3})
+	assert.NoError(t, err)
+
+	batcher := NewNodeDeletionBatcher(time.Minute)
+	now := time.Now()
+	assert.Empty(t, batcher.Update([]*kube_api.Node{n1, n3}, nodeGroups, now))
+
+	// n3 is no longer empty, so its wait starts over.
+	assert.Empty(t, batcher.Update([]*kube_api.Node{n1, n2}, nodeGroups, now.Add(30*time.Second)))
+	assert.Empty(t, batcher.Update([]*kube_api.Node{n1, n2, n3}, nodeGroups, now.Add(50*time.Second)))
+
+	// n2 joins the batch of n1, which has waited long enough.
+	batches := batcher.Update([]*kube_api.Node{n1, n2, n3}, nodeGroups, now.Add(time.Minute))
+	assert.Equal(t, 1, len(batches))
+	assert.Equal(t, "ng1", batches[0].NodeGroup.Id())
+	assert.Equal(t, []*kube_api.Node{n1, n2}, batches[0].Nodes)
+
+	batches = batcher.Update([]*kube_api.Node{n3}, nodeGroups, now.Add(110*time.Second))
+	assert.Equal(t, 1, len(batches))
+	assert.Equal(t, "ng2", batches[0].NodeGroup.Id())
+}
+
+func TestScaleDownEmptyNodesInBatch(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	n4 := BuildTestNode("n4", 1000, 1000)
+	p4 := BuildTestPod("p4", 500, 0)
+	p4.Spec.NodeName = "n4"
+	nodes := []*kube_api.Node{n1, n2, n3, n4}
+
+	provider := &deleteCallsCloudProvider{
+		TestCloudProvider: test.NewTestCloudProvider(nil, nil),
+		calls:             make(chan []string, 10),
+	}
+	provider.AddNodeGroup("ng1", 1, 10, 4)
+	for _, node := range nodes {
+		provider.AddNode("ng1", node)
+	}
+	context := &AutoscalingContext{
+		CloudProvider:       provider,
+		PredicateChecker:    simulator.NewTestPredicateChecker(),
+		Recorder:            kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete:  10,
+		NodeDeletionBatcher: NewNodeDeletionBatcher(0),
+	}
+	unneeded := map[string]time.Time{
+		"n1": time.Now().Add(-time.Hour),
+		"n2": time.Now().Add(-time.Hour),
+		"n3": time.Now().Add(-time.Hour),
+	}
+	result, err := ScaleDown(context, nodes, map[string]float64{}, unneeded,
+		[]*kube_api.Pod{p4}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+
+	// All empty nodes are deleted in a single call.
+	assert.Equal(t, 1, len(provider.calls))
+	assert.Equal(t, []string{"n1", "n2", "n3"}, <-provider.calls)
+	ng1, err := provider.NodeGroupForNode(n4)
+	assert.NoError(t, err)
+	size, err := ng1.TargetSize()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+}
+
+func TestScaleDownCollectingEmptyNodesIsInProgress(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	nodes := []*kube_api.Node{n1, n2}
+
+	provider := &deleteCallsCloudProvider{
+		TestCloudProvider: test.NewTestCloudProvider(nil, nil),
+		calls:             make(chan []string, 10),
+	}
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	for _, node := range nodes {
+		provider.AddNode("ng1", node)
+	}
+	context := &AutoscalingContext{
+		CloudProvider:       provider,
+		PredicateChecker:    simulator.NewTestPredicateChecker(),
+		Recorder:            kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete:  10,
+		NodeDeletionBatcher: NewNodeDeletionBatcher(time.Minute),
+	}
+	unneeded := map[string]time.Time{"n1": time.Now().Add(-time.Hour)}
+
+	// Waiting for the batch is not a failed scale down, so it doesn't back off.
+	result, err := ScaleDown(context, nodes, map[string]float64{}, unneeded,
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownInProgress, result)
+	assert.Equal(t, 0, len(provider.calls))
+}
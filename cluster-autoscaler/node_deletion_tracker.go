@@ -0,0 +1,170 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	kube_api "k8s.io/kubernetes/pkg/api"
+)
+
+// NodeDeletionTracker keeps track of nodes that were deleted from the cloud provider but are still
+// registered in Kubernetes. Such nodes may look unneeded in the following scans, so without the
+// tracker the autoscaler would try to delete them again while the instances are terminating. It
+// also keeps the state of the node that is drained in the background before its deletion.
+type NodeDeletionTracker struct {
+	sync.Mutex
+	deletions map[string]time.Time
+	// drainingNode is the name of the node that is being drained, empty if there is none.
+	drainingNode string
+	// drainResult is the result of the last drain, nil if it was already taken.
+	drainResult *NodeDrainResult
+}
+
+// NodeDrainResult is the result of the removal of a node that was drained in the background.
+type NodeDrainResult struct {
+	NodeName string
+	Result   ScaleDownResult
+	Err      error
+	// Node, NodeGroup, Utilization and Pods describe the removed node, so that the scan taking the
+	// result can record the removal.
+	Node        *kube_api.Node
+	NodeGroup   cloudprovider.NodeGroup
+	Utilization float64
+	Pods        int
+	// FinishTime is when the removal finished.
+	FinishTime time.Time
+}
+
+// NewNodeDeletionTracker builds new NodeDeletionTracker.
+func NewNodeDeletionTracker() *NodeDeletionTracker {
+	return &NodeDeletionTracker{
+		deletions: make(map[string]time.Time),
+	}
+}
+
+// StartDeletion records that the node is being deleted. Returns false if the deletion of the node
+// is already in progress.
+func (tracker *NodeDeletionTracker) StartDeletion(nodeName string, now time.Time) bool {
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	if _, found := tracker.deletions[nodeName]; found {
+		return false
+	}
+	tracker.deletions[nodeName] = now
+	return true
+}
+
+// AbortDeletion forgets the deletion of the node, so it can be deleted again, e.g. after the cloud
+// provider failed to delete it.
+func (tracker *NodeDeletionTracker) AbortDeletion(nodeName string) {
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	delete(tracker.deletions, nodeName)
+}
+
+// IsBeingDeleted returns true if the deletion of the node is in progress.
+func (tracker *NodeDeletionTracker) IsBeingDeleted(nodeName string) bool {
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	_, found := tracker.deletions[nodeName]
+	return found
+}
+
+// FilterOutNodesBeingDeleted returns the nodes whose deletion is not in progress. Such nodes are
+// already gone from the target size of their node groups, so the remaining nodes are the effective
+// size of the groups. It is safe to call on a nil tracker.
+func (tracker *NodeDeletionTracker) FilterOutNodesBeingDeleted(nodes []*kube_api.Node) []*kube_api.Node {
+	if tracker == nil {
+		return nodes
+	}
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	result := make([]*kube_api.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if _, found := tracker.deletions[node.Name]; !found {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// StartDrain records that the node is being drained in the background.
+func (tracker *NodeDeletionTracker) StartDrain(nodeName string) {
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	tracker.drainingNode = nodeName
+	tracker.drainResult = nil
+}
+
+// FinishDrain records the result of the removal of the node that was drained.
+func (tracker *NodeDeletionTracker) FinishDrain(result NodeDrainResult) {
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	tracker.drainingNode = ""
+	tracker.drainResult = &result
+}
+
+// DrainingNode returns the name of the node that is being drained, empty if there is none. It is
+// safe to call on a nil tracker.
+func (tracker *NodeDeletionTracker) DrainingNode() string {
+	if tracker == nil {
+		return ""
+	}
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	return tracker.drainingNode
+}
+
+// TakeDrainResult returns the result of the last finished drain and forgets it, so that it is
+// reported only once. Returns nil if there is none. It is safe to call on a nil tracker.
+func (tracker *NodeDeletionTracker) TakeDrainResult() *NodeDrainResult {
+	if tracker == nil {
+		return nil
+	}
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	result := tracker.drainResult
+	tracker.drainResult = nil
+	return result
+}
+
+// Update forgets the deletions of nodes that are no longer registered in Kubernetes.
+func (tracker *NodeDeletionTracker) Update(nodes []*kube_api.Node) {
+	tracker.Lock()
+	defer tracker.Unlock()
+
+	registered := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		registered[node.Name] = true
+	}
+	for name := range tracker.deletions {
+		if !registered[name] {
+			delete(tracker.deletions, name)
+		}
+	}
+}
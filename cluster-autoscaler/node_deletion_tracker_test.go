@@ -0,0 +1,137 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeDeletionTracker(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	tracker := NewNodeDeletionTracker()
+	now := time.Now()
+
+	assert.True(t, tracker.StartDeletion("n1", now))
+	assert.False(t, tracker.StartDeletion("n1", now))
+	assert.True(t, tracker.IsBeingDeleted("n1"))
+
+	tracker.AbortDeletion("n1")
+	assert.False(t, tracker.IsBeingDeleted("n1"))
+
+	assert.True(t, tracker.StartDeletion("n1", now))
+	assert.True(t, tracker.StartDeletion("n2", now))
+	tracker.Update([]*kube_api.Node{n1, n2})
+	assert.True(t, tracker.IsBeingDeleted("n1"))
+	assert.True(t, tracker.IsBeingDeleted("n2"))
+
+	// n2 is gone.
+	tracker.Update([]*kube_api.Node{n1})
+	assert.True(t, tracker.IsBeingDeleted("n1"))
+	assert.False(t, tracker.IsBeingDeleted("n2"))
+}
+
+func TestDeleteNodeInProgress(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	deleted := make([]string, 0)
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNode("ng1", n1)
+	tracker := NewNodeDeletionTracker()
+
+	assert.NoError(t, deleteNodeFromCloudProvider(n1, provider, kube_record.NewFakeRecorder(10), tracker, time.Now()))
+	assert.Equal(t, []string{"n1"}, deleted)
+
+	// The node is still registered, the second delete is a no-op.
+	assert.NoError(t, deleteNodeFromCloudProvider(n1, provider, kube_record.NewFakeRecorder(10), tracker, time.Now()))
+	assert.Equal(t, []string{"n1"}, deleted)
+
+	// The node is gone, it can be deleted again if it comes back.
+	tracker.Update([]*kube_api.Node{})
+	assert.NoError(t, deleteNodeFromCloudProvider(n1, provider, kube_record.NewFakeRecorder(10), tracker, time.Now()))
+	assert.Equal(t, []string{"n1", "n1"}, deleted)
+}
+
+func TestScaleDownWithNodesBeingDeleted(t *testing.T) {
+	p2 := BuildTestPod("p2", 600, 0)
+	p2.Spec.NodeName = "n2"
+	p2.Annotations = map[string]string{
+		"kubernetes.io/created-by": "{\"kind\":\"SerializedReference\",\"apiVersion\":\"v1\",\"reference\":{\"kind\":\"ReplicaSet\"}}",
+	}
+	p3 := BuildTestPod("p3", 600, 0)
+	p3.Spec.NodeName = "n3"
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	nodes := []*kube_api.Node{n1, n2, n3}
+	pods := []*kube_api.Pod{p2, p3}
+
+	deleted := make([]string, 0)
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	provider.AddNode("ng1", n3)
+	tracker := NewNodeDeletionTracker()
+	context := &AutoscalingContext{
+		CloudProvider:       provider,
+		PredicateChecker:    simulator.NewTestPredicateChecker(),
+		Recorder:            kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete:  10,
+		NodeDeletionTracker: tracker,
+	}
+
+	// n1 was removed in an earlier scan and is terminating. The target size already reflects it.
+	assert.NoError(t, deleteNodeFromCloudProvider(n1, provider, context.Recorder, tracker, time.Now()))
+	unready, err := CheckGroupsAndNodes(nodes, provider)
+	assert.NoError(t, err)
+	assert.True(t, unready["ng1"])
+	unready, err = CheckGroupsAndNodes(tracker.FilterOutNodesBeingDeleted(nodes), provider)
+	assert.NoError(t, err)
+	assert.Empty(t, unready)
+
+	// p2 fits only on n1, so n2 looks unneeded while n1 is counted.
+	unneeded, _, _ := FindUnneededNodes(nodes, map[string]time.Time{}, 0.7, false, pods,
+		context.PredicateChecker, map[string]string{}, simulator.NewUsageTracker(), time.Now(), nil)
+	assert.Contains(t, unneeded, "n2")
+	unneeded, _, utilization := FindUnneededNodes(tracker.FilterOutNodesBeingDeleted(nodes), map[string]time.Time{}, 0.7, false, pods,
+		context.PredicateChecker, map[string]string{}, simulator.NewUsageTracker(), time.Now(), nil)
+	assert.Empty(t, unneeded)
+
+	// And n1 is not deleted again.
+	result, err := ScaleDown(context, nodes, utilization, map[string]time.Time{"n1": time.Now().Add(-time.Hour)},
+		pods, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoUnneeded, result)
+	assert.Equal(t, []string{"n1"}, deleted)
+}
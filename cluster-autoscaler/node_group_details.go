@@ -0,0 +1,132 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+
+	"github.com/golang/glog"
+)
+
+// NodeGroupDetails describes a configured node group as seen by the last scan.
+type NodeGroupDetails struct {
+	Id string `json:"id"`
+	// Name is the last segment of the id, i.e. the name of the ASG, MIG or node pool.
+	Name string `json:"name"`
+	// Provider is the name of the cloud provider of the node group.
+	Provider string `json:"provider"`
+	MinSize  int    `json:"minSize"`
+	MaxSize  int    `json:"maxSize"`
+	// Target is the target size of the node group in the cloud provider.
+	Target int `json:"target"`
+	// Registered is the number of nodes of the node group registered in Kubernetes.
+	Registered int `json:"registered"`
+	// Ready is false if the node group is not in sync with its target size, in which case it is
+	// neither scaled up nor down.
+	Ready bool `json:"ready"`
+}
+
+type byNodeGroupDetailsId []NodeGroupDetails
+
+func (d byNodeGroupDetailsId) Len() int           { return len(d) }
+func (d byNodeGroupDetailsId) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d byNodeGroupDetailsId) Less(i, j int) bool { return d[i].Id < d[j].Id }
+
+// BuildNodeGroupDetails returns the details of all node groups of the cloud provider sorted by id,
+// with target sizes and registered nodes taken from the snapshot. Node groups whose size couldn't be
+// read are left out.
+func BuildNodeGroupDetails(snapshot *ClusterSnapshot, cloudProvider cloudprovider.CloudProvider,
+	unreadyNodeGroups map[string]bool) ([]NodeGroupDetails, error) {
+	nodeGroups, err := cloudProvider.NodeGroupsForNodes(snapshot.AllNodes)
+	if err != nil {
+		return nil, err
+	}
+	registered := make(map[string]int)
+	for _, nodeGroup := range nodeGroups {
+		if nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+			continue
+		}
+		registered[nodeGroup.Id()]++
+	}
+
+	result := make([]NodeGroupDetails, 0)
+	for _, nodeGroup := range cloudProvider.NodeGroups() {
+		size, err := snapshot.TargetSize(nodeGroup)
+		if err != nil {
+			glog.Errorf("Skipping details of %s: %v", nodeGroup.Id(), err)
+			continue
+		}
+		id := nodeGroup.Id()
+		result = append(result, NodeGroupDetails{
+			Id:         id,
+			Name:       id[strings.LastIndex(id, "/")+1:],
+			Provider:   cloudProvider.Name(),
+			MinSize:    nodeGroup.MinSize(),
+			MaxSize:    nodeGroup.MaxSize(),
+			Target:     size,
+			Registered: registered[id],
+			Ready:      !unreadyNodeGroups[id],
+		})
+	}
+	sort.Sort(byNodeGroupDetailsId(result))
+	return result, nil
+}
+
+// NodeGroupDetailsEndpoint serves the node group details of the last scan as JSON, so operators get
+// a live view of the node groups without kubectl or the cloud console. It is safe for concurrent use.
+type NodeGroupDetailsEndpoint struct {
+	mutex   sync.Mutex
+	details []NodeGroupDetails
+}
+
+// NewNodeGroupDetailsEndpoint builds NodeGroupDetailsEndpoint.
+func NewNodeGroupDetailsEndpoint() *NodeGroupDetailsEndpoint {
+	return &NodeGroupDetailsEndpoint{
+		details: make([]NodeGroupDetails, 0),
+	}
+}
+
+// Update replaces the served details.
+func (e *NodeGroupDetailsEndpoint) Update(details []NodeGroupDetails) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.details = details
+}
+
+// ServeHTTP responds to GET requests with the JSON encoded node group details.
+func (e *NodeGroupDetailsEndpoint) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "" && req.Method != "GET" {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	e.mutex.Lock()
+	encoded, err := json.Marshal(e.details)
+	e.mutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(encoded)
+}
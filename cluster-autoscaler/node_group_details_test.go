@@ -0,0 +1,65 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeGroupDetailsEndpoint(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNodeGroup("ng2", 0, 5, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	provider.AddNode("ng2", n3)
+
+	nodes := []*kube_api.Node{n1, n2, n3}
+	snapshot := NewClusterSnapshot(nodes, nodes, []*kube_api.Pod{}, []*kube_api.Pod{}, provider, time.Now())
+	details, err := BuildNodeGroupDetails(snapshot, provider, map[string]bool{"ng2": true})
+	assert.NoError(t, err)
+
+	endpoint := NewNodeGroupDetailsEndpoint()
+	endpoint.Update(details)
+	recorder := httptest.NewRecorder()
+	endpoint.ServeHTTP(recorder, &http.Request{Method: "GET"})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	served := make([]NodeGroupDetails, 0)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &served))
+	assert.Equal(t, []NodeGroupDetails{
+		{Id: "ng1", Name: "ng1", Provider: "TestCloudProvider", MinSize: 1, MaxSize: 10, Target: 2, Registered: 2, Ready: true},
+		{Id: "ng2", Name: "ng2", Provider: "TestCloudProvider", MinSize: 0, MaxSize: 5, Target: 2, Registered: 1, Ready: false},
+	}, served)
+
+	recorder = httptest.NewRecorder()
+	endpoint.ServeHTTP(recorder, &http.Request{Method: "POST"})
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/golang/glog"
+)
+
+// NodeGroupDivergenceDetector detects node groups whose target size keeps exceeding the number of
+// their registered nodes, e.g. because their instances fail to join the cluster. ScaleUpTracker
+// gives such nodes up after max node provision time, after which the group is in sync and would be
+// scaled up again, only to add more nodes that never register. Scans in which requested nodes are
+// missing past max node provision time are therefore counted until a new node of the group
+// registers, and a group that diverged in too many scans is marked unhealthy and not scaled up
+// until then.
+type NodeGroupDivergenceDetector struct {
+	maxDivergentScans int
+	// divergentScans holds the number of scans in which each node group missed requested nodes past
+	// max node provision time since one of its nodes last registered.
+	divergentScans map[string]int
+	// registered holds the number of registered nodes of each node group in the last scan.
+	registered map[string]int
+}
+
+// NewNodeGroupDivergenceDetector builds new NodeGroupDivergenceDetector.
+func NewNodeGroupDivergenceDetector(maxDivergentScans int) *NodeGroupDivergenceDetector {
+	return &NodeGroupDivergenceDetector{
+		maxDivergentScans: maxDivergentScans,
+		divergentScans:    make(map[string]int),
+		registered:        make(map[string]int),
+	}
+}
+
+// Update counts the scans in which node groups miss requested nodes past max node provision time
+// and returns the ids of node groups that reached the maximum number of such scans. Nodes of a scale
+// up tracked by context.ScaleUpTracker are not missing until it expects them, and the nodes it gave
+// up in this scan, passed in timedOut, are. The count of a group is reset once the number of its
+// registered nodes grows. Node groups missing from sizes are left as they are. It is safe to call on
+// a nil detector.
+func (detector *NodeGroupDivergenceDetector) Update(context *AutoscalingContext, sizes map[string]NodeGroupSize, timedOut map[string]int) map[string]bool {
+	if detector == nil {
+		return nil
+	}
+	var pendingScaleUps map[string]*ScaleUpRequest
+	if context.ScaleUpTracker != nil {
+		pendingScaleUps = context.ScaleUpTracker.Requests()
+	}
+	now := context.Now()
+
+	result := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		id := nodeGroup.Id()
+		seen[id] = true
+		size, found := sizes[id]
+		if !found {
+			if detector.divergentScans[id] >= detector.maxDivergentScans {
+				result[id] = true
+			}
+			continue
+		}
+		if previous, found := detector.registered[id]; found && size.Registered > previous {
+			if detector.divergentScans[id] >= detector.maxDivergentScans {
+				glog.Infof("A new node of node group %s registered, it is healthy again", id)
+			}
+			delete(detector.divergentScans, id)
+		}
+		detector.registered[id] = size.Registered
+
+		missing := size.Target - size.Registered
+		if request, found := pendingScaleUps[id]; found && now.Before(request.ExpectedAddTime) {
+			missing -= request.Increase
+		}
+		if missing > 0 || timedOut[id] > 0 {
+			detector.divergentScans[id]++
+			if detector.divergentScans[id] == detector.maxDivergentScans {
+				glog.Warningf("Node group %s missed requested nodes past max node provision time in %d scans, "+
+					"it won't be scaled up until a new node registers", id, detector.maxDivergentScans)
+			}
+		}
+		if detector.divergentScans[id] >= detector.maxDivergentScans {
+			result[id] = true
+			nodeGroupUnhealthy.WithLabelValues(id).Set(1)
+		} else {
+			nodeGroupUnhealthy.WithLabelValues(id).Set(0)
+		}
+	}
+	for id := range detector.registered {
+		if !seen[id] {
+			delete(detector.registered, id)
+			delete(detector.divergentScans, id)
+			nodeGroupUnhealthy.DeleteLabelValues(id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	"k8s.io/contrib/cluster-autoscaler/utils/clock"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func divergenceSizes(t *testing.T, context *AutoscalingContext, nodes ...*kube_api.Node) map[string]NodeGroupSize {
+	sizes, err := GetNodeGroupSizes(context, nodes)
+	assert.NoError(t, err)
+	return sizes
+}
+
+func TestNodeGroupDivergenceDetector(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	context := &AutoscalingContext{CloudProvider: provider}
+
+	// No scale up is tracked, so the requested node is missing past max node provision time.
+	detector := NewNodeGroupDivergenceDetector(2)
+	unhealthy := detector.Update(context, divergenceSizes(t, context, n1), nil)
+	assert.Empty(t, unhealthy)
+	unhealthy = detector.Update(context, divergenceSizes(t, context, n1), nil)
+	assert.Equal(t, map[string]bool{"ng1": true}, unhealthy)
+
+	// Groups whose size can't be read keep their state.
+	unhealthy = detector.Update(context, map[string]NodeGroupSize{}, nil)
+	assert.Equal(t, map[string]bool{"ng1": true}, unhealthy)
+
+	// A new node registering makes the group healthy again, even if it is not ready yet.
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n2.Status.Conditions = []kube_api.NodeCondition{{Type: kube_api.NodeReady, Status: kube_api.ConditionFalse}}
+	provider.AddNode("ng1", n2)
+	unhealthy = detector.Update(context, divergenceSizes(t, context, n1, n2), nil)
+	assert.Empty(t, unhealthy)
+	assert.Empty(t, detector.divergentScans)
+
+	// Groups that are no longer configured are forgotten.
+	detector.Update(&AutoscalingContext{CloudProvider: test.NewTestCloudProvider(nil, nil)}, nil, nil)
+	assert.Empty(t, detector.registered)
+
+	var disabled *NodeGroupDivergenceDetector
+	assert.Nil(t, disabled.Update(context, divergenceSizes(t, context, n1), nil))
+}
+
+func TestNodeGroupDivergenceDetectorIgnoresScaleUpsInFlight(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+
+	fakeClock := clock.NewFakeClock(time.Now())
+	tracker := NewScaleUpTracker(time.Minute, 0, 0)
+	tracker.RegisterScaleUp("ng1", 1, 0, fakeClock.Now())
+	context := &AutoscalingContext{CloudProvider: provider, ScaleUpTracker: tracker, Clock: fakeClock}
+
+	detector := NewNodeGroupDivergenceDetector(1)
+	for scan := 0; scan < 3; scan++ {
+		assert.Empty(t, detector.Update(context, divergenceSizes(t, context, n1), nil))
+		fakeClock.Step(10 * time.Second)
+	}
+
+	// The node is given up after max node provision time, which puts the group back in sync.
+	fakeClock.Step(time.Minute)
+	timedOut, err := tracker.Update([]*kube_api.Node{n1}, provider, fakeClock.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"ng1": 1}, timedOut)
+	unhealthy := detector.Update(context, divergenceSizes(t, context, n1), timedOut)
+	assert.Equal(t, map[string]bool{"ng1": true}, unhealthy)
+}
+
+func TestScaleUpSkipsNodeGroupWithSustainedDivergence(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	fakeClock := clock.NewFakeClock(time.Now())
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+		ScaleUpTracker:   NewScaleUpTracker(time.Minute, 0, 0),
+		Clock:            fakeClock,
+	}
+	nodes := []*kube_api.Node{n1}
+	nodeInfos := map[string]*schedulercache.NodeInfo{"ng1": buildTestNodeInfo(n1)}
+	p1 := BuildTestPod("p1", 800, 0)
+	detector := NewNodeGroupDivergenceDetector(2)
+
+	// Every requested node fails to register and is given up after max node provision time, which
+	// puts the group back in sync.
+	var timedOut map[string]int
+	for attempt := 0; attempt < 2; attempt++ {
+		context.UnhealthyNodeGroups = detector.Update(context, divergenceSizes(t, context, n1), timedOut)
+		scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, nodes, nodeInfos)
+		assert.NoError(t, err)
+		assert.True(t, scaledUp)
+
+		// Scans while the scale up is in flight don't count.
+		assert.Empty(t, detector.Update(context, divergenceSizes(t, context, n1), nil))
+
+		fakeClock.Step(2 * time.Minute)
+		timedOut, err = context.ScaleUpTracker.Update(nodes, provider, fakeClock.Now())
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, map[string]int{"ng1": 2}, scaledGroups)
+
+	unhealthy := detector.Update(context, divergenceSizes(t, context, n1), timedOut)
+	assert.Equal(t, map[string]bool{"ng1": true}, unhealthy)
+	context.UnhealthyNodeGroups = unhealthy
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, nodes, nodeInfos)
+	assert.NoError(t, err)
+	assert.False(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 2}, scaledGroups)
+}
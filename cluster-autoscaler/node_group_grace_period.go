@@ -0,0 +1,72 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+
+	"github.com/golang/glog"
+)
+
+// NodeGroupGracePeriod keeps track of when node groups were registered, at startup or by the
+// discovery of the cloud provider. The cloud state of a just-registered node group may still be
+// inconsistent, so for a grace period the group is observed but neither scaled up nor down.
+type NodeGroupGracePeriod struct {
+	gracePeriod time.Duration
+	// registered holds the time at which each node group was first seen.
+	registered map[string]time.Time
+}
+
+// NewNodeGroupGracePeriod builds new NodeGroupGracePeriod.
+func NewNodeGroupGracePeriod(gracePeriod time.Duration) *NodeGroupGracePeriod {
+	return &NodeGroupGracePeriod{
+		gracePeriod: gracePeriod,
+		registered:  make(map[string]time.Time),
+	}
+}
+
+// Update records the registration of node groups seen for the first time and forgets the ones that
+// are no longer configured, so that a group registered again gets a new grace period. It returns
+// the ids of node groups still within their grace period. It is safe to call on a nil tracker.
+func (tracker *NodeGroupGracePeriod) Update(cloudProvider cloudprovider.CloudProvider, now time.Time) map[string]bool {
+	if tracker == nil {
+		return nil
+	}
+	result := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, nodeGroup := range cloudProvider.NodeGroups() {
+		id := nodeGroup.Id()
+		seen[id] = true
+		registered, found := tracker.registered[id]
+		if !found {
+			registered = now
+			tracker.registered[id] = now
+			glog.V(1).Infof("Node group %s registered, it won't be scaled until %v", id, now.Add(tracker.gracePeriod))
+		}
+		if now.Before(registered.Add(tracker.gracePeriod)) {
+			result[id] = true
+		}
+	}
+	for id := range tracker.registered {
+		if !seen[id] {
+			delete(tracker.registered, id)
+		}
+	}
+	return result
+}
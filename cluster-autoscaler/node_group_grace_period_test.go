@@ -0,0 +1,89 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeGroupGracePeriod(t *testing.T) {
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+
+	now := time.Now()
+	tracker := NewNodeGroupGracePeriod(time.Minute)
+	assert.Equal(t, map[string]bool{"ng1": true}, tracker.Update(provider, now))
+
+	// A group discovered later gets its own grace period.
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	assert.Equal(t, map[string]bool{"ng1": true, "ng2": true}, tracker.Update(provider, now.Add(30*time.Second)))
+	assert.Equal(t, map[string]bool{"ng2": true}, tracker.Update(provider, now.Add(time.Minute)))
+	assert.Equal(t, map[string]bool{}, tracker.Update(provider, now.Add(90*time.Second)))
+
+	// Groups that are no longer configured are forgotten.
+	tracker.Update(test.NewTestCloudProvider(nil, nil), now.Add(2*time.Minute))
+	assert.Empty(t, tracker.registered)
+
+	var disabled *NodeGroupGracePeriod
+	assert.Nil(t, disabled.Update(provider, now))
+}
+
+func TestScaleUpSkipsNodeGroupInGracePeriod(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{"ng1": buildTestNodeInfo(n1)}
+	p1 := BuildTestPod("p1", 800, 0)
+
+	now := time.Now()
+	tracker := NewNodeGroupGracePeriod(time.Minute)
+	context.NewNodeGroups = tracker.Update(provider, now)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.False(t, scaledUp)
+	assert.Empty(t, scaledGroups)
+
+	context.NewNodeGroups = tracker.Update(provider, now.Add(time.Minute))
+	scaledUp, err = ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 1}, scaledGroups)
+}
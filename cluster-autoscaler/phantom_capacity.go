@@ -0,0 +1,101 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// PhantomCapacityReconciler decreases the target size of node groups whose target size stayed above
+// the number of their registered nodes for longer than a timeout. This reclaims requested nodes
+// that never registered and that ScaleUpTracker doesn't know about, e.g. because they were requested
+// before a restart of the autoscaler, which would otherwise keep the node group out of sync forever.
+type PhantomCapacityReconciler struct {
+	timeout time.Duration
+	// since holds the time since which the target size of each node group exceeds the number of its
+	// registered nodes.
+	since map[string]time.Time
+}
+
+// NewPhantomCapacityReconciler builds new PhantomCapacityReconciler.
+func NewPhantomCapacityReconciler(timeout time.Duration) *PhantomCapacityReconciler {
+	return &PhantomCapacityReconciler{
+		timeout: timeout,
+		since:   make(map[string]time.Time),
+	}
+}
+
+// Update decreases the target size of node groups that had more requested than registered nodes
+// for longer than the timeout to the number of registered nodes, but not below the min size of
+// the group. Cloud providers refuse to decrease the target size below the number of running
+// instances, so instances that are up but didn't register are not terminated. Node groups with a
+// scale up tracked by context.ScaleUpTracker are left to it. It is safe to call on a nil reconciler.
+func (reconciler *PhantomCapacityReconciler) Update(context *AutoscalingContext, sizes map[string]NodeGroupSize, now time.Time) {
+	if reconciler == nil {
+		return
+	}
+	var pendingScaleUps map[string]*ScaleUpRequest
+	if context.ScaleUpTracker != nil {
+		pendingScaleUps = context.ScaleUpTracker.Requests()
+	}
+
+	seen := make(map[string]bool)
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		id := nodeGroup.Id()
+		size, found := sizes[id]
+		if !found {
+			continue
+		}
+		if _, found := pendingScaleUps[id]; found {
+			continue
+		}
+		target := size.Registered
+		if target < nodeGroup.MinSize() {
+			target = nodeGroup.MinSize()
+		}
+		if size.Target <= target {
+			continue
+		}
+		seen[id] = true
+		since, found := reconciler.since[id]
+		if !found {
+			reconciler.since[id] = now
+			continue
+		}
+		if now.Sub(since) < reconciler.timeout {
+			continue
+		}
+		// A failed decrease is retried after another timeout.
+		delete(reconciler.since, id)
+		delete(seen, id)
+		glog.Warningf("Node group %s had %d requested nodes that didn't register for %v, decreasing target size to %d",
+			id, size.Target-target, reconciler.timeout, target)
+		if err := nodeGroup.DecreaseTargetSize(target - size.Target); err != nil {
+			glog.Warningf("Failed to reclaim requested nodes of %s: %v", id, err)
+			continue
+		}
+		registerSizeChange(context, nodeGroup, target-size.Target)
+		reclaimedPhantomNodes.WithLabelValues(id).Add(float64(size.Target - target))
+	}
+	for id := range reconciler.since {
+		if !seen[id] {
+			delete(reconciler.since, id)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func phantomCapacitySizes(t *testing.T, context *AutoscalingContext, nodes ...*kube_api.Node) map[string]NodeGroupSize {
+	sizes, err := GetNodeGroupSizes(context, nodes)
+	assert.NoError(t, err)
+	return sizes
+}
+
+func TestPhantomCapacityReclaimedAfterTimeout(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	// Two requested nodes never registered and no scale up is tracked.
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	provider.AddNode("ng1", n1)
+	nodeGroup := provider.NodeGroups()[0]
+
+	now := time.Now()
+	snapshot := NewClusterSnapshot(nil, nil, nil, nil, provider, now)
+	context := &AutoscalingContext{CloudProvider: provider, ClusterSnapshot: snapshot}
+	reconciler := NewPhantomCapacityReconciler(time.Minute)
+	reconciler.Update(context, phantomCapacitySizes(t, context, n1), now)
+	reconciler.Update(context, phantomCapacitySizes(t, context, n1), now.Add(30*time.Second))
+	size, _ := nodeGroup.TargetSize()
+	assert.Equal(t, 3, size)
+
+	reconciler.Update(context, phantomCapacitySizes(t, context, n1), now.Add(time.Minute))
+	size, _ = nodeGroup.TargetSize()
+	assert.Equal(t, 1, size)
+	size, _ = snapshot.TargetSize(nodeGroup)
+	assert.Equal(t, 1, size)
+	assert.Empty(t, reconciler.since)
+}
+
+func TestPhantomCapacityCountsUnreadyNodes(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n2.Status.Conditions = []kube_api.NodeCondition{{Type: kube_api.NodeReady, Status: kube_api.ConditionFalse}}
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	nodeGroup := provider.NodeGroups()[0]
+
+	now := time.Now()
+	context := &AutoscalingContext{CloudProvider: provider}
+	reconciler := NewPhantomCapacityReconciler(time.Minute)
+	reconciler.Update(context, phantomCapacitySizes(t, context, n1, n2), now)
+	reconciler.Update(context, phantomCapacitySizes(t, context, n1, n2), now.Add(time.Minute))
+	size, _ := nodeGroup.TargetSize()
+	assert.Equal(t, 2, size)
+	assert.Empty(t, reconciler.since)
+}
+
+func TestPhantomCapacityKeptWhileScaleUpPending(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	provider.AddNode("ng1", n1)
+	nodeGroup := provider.NodeGroups()[0]
+
+	now := time.Now()
+	tracker := NewScaleUpTracker(time.Minute, 0, 0)
+	tracker.RegisterScaleUp("ng1", 2, 0, now)
+	context := &AutoscalingContext{CloudProvider: provider, ScaleUpTracker: tracker}
+	reconciler := NewPhantomCapacityReconciler(time.Minute)
+	reconciler.Update(context, phantomCapacitySizes(t, context, n1), now)
+	reconciler.Update(context, phantomCapacitySizes(t, context, n1), now.Add(2*time.Minute))
+	size, _ := nodeGroup.TargetSize()
+	assert.Equal(t, 3, size)
+
+	// The timeout starts once the scale up is no longer tracked.
+	context.ScaleUpTracker = nil
+	reconciler.Update(context, phantomCapacitySizes(t, context, n1), now.Add(2*time.Minute))
+	reconciler.Update(context, phantomCapacitySizes(t, context, n1), now.Add(150*time.Second))
+	size, _ = nodeGroup.TargetSize()
+	assert.Equal(t, 3, size)
+}
+
+func TestPhantomCapacityNotReclaimedBelowMinSize(t *testing.T) {
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 2, 10, 3)
+	nodeGroup := provider.NodeGroups()[0]
+
+	now := time.Now()
+	context := &AutoscalingContext{CloudProvider: provider}
+	reconciler := NewPhantomCapacityReconciler(time.Minute)
+	reconciler.Update(context, phantomCapacitySizes(t, context), now)
+	reconciler.Update(context, phantomCapacitySizes(t, context), now.Add(time.Minute))
+	size, _ := nodeGroup.TargetSize()
+	assert.Equal(t, 2, size)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/labels"
+
+	"github.com/golang/glog"
+)
+
+// PodClass maps a class of pods to the expander picking the node group for the scale ups that are
+// triggered mostly by them, e.g. the priority expander for batch pods.
+type PodClass struct {
+	// Namespace of the pods of the class. Empty matches all namespaces.
+	Namespace string
+	// Selector of the labels of the pods of the class.
+	Selector labels.Selector
+	// Expander is the name of the expander used for the class.
+	Expander string
+}
+
+// ParsePodClass parses a pod class in format <namespace>:<label selector>:<expander>. Either the
+// namespace or the label selector can be empty, but not both.
+func ParsePodClass(value string) (PodClass, error) {
+	tokens := strings.SplitN(value, ":", 3)
+	if len(tokens) != 3 {
+		return PodClass{}, fmt.Errorf("wrong pod class: %s, expected <namespace>:<label selector>:<expander>", value)
+	}
+	if tokens[0] == "" && tokens[1] == "" {
+		return PodClass{}, fmt.Errorf("pod class %s must have a namespace or a label selector", value)
+	}
+	if !isAvailableExpander(tokens[2]) {
+		return PodClass{}, fmt.Errorf("unrecognized expander of pod class %s: %s", value, tokens[2])
+	}
+	selector, err := labels.Parse(tokens[1])
+	if err != nil {
+		return PodClass{}, fmt.Errorf("failed to parse label selector of %s: %v", value, err)
+	}
+	return PodClass{Namespace: tokens[0], Selector: selector, Expander: tokens[2]}, nil
+}
+
+// Matches returns true if the pod belongs to the class.
+func (c PodClass) Matches(pod *kube_api.Pod) bool {
+	if c.Namespace != "" && c.Namespace != pod.Namespace {
+		return false
+	}
+	return c.Selector.Matches(labels.Set(pod.Labels))
+}
+
+// PodClassExpanders picks the expander for a scale up from the classes of the pods triggering it.
+// Pods belong to the first class matching them. The expander of the class with the most pods is
+// used, the first one on ties. If at least as many pods belong to no class, the global expander is
+// used instead.
+type PodClassExpanders struct {
+	classes []PodClass
+	// priority and external are used by the classes with the priority and the http expander.
+	priority *PriorityExpander
+	external ExternalExpander
+}
+
+// NewPodClassExpanders builds PodClassExpanders. The priority and external expanders must be set
+// if any class uses the priority or the http expander.
+func NewPodClassExpanders(classes []PodClass, priority *PriorityExpander, external ExternalExpander) *PodClassExpanders {
+	return &PodClassExpanders{
+		classes:  classes,
+		priority: priority,
+		external: external,
+	}
+}
+
+// ExpanderFor returns the name of the expander of the class that most pods of the options belong
+// to. It returns false if the global expander should be used, always for a nil PodClassExpanders.
+func (e *PodClassExpanders) ExpanderFor(options []ExpansionOption) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+	seen := make(map[string]bool)
+	counts := make([]int, len(e.classes))
+	unclassified := 0
+	for _, option := range options {
+		for _, pod := range option.pods {
+			key := pod.Namespace + "/" + pod.Name
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			class := e.classOf(pod)
+			if class < 0 {
+				unclassified++
+			} else {
+				counts[class]++
+			}
+		}
+	}
+	best := -1
+	for i, count := range counts {
+		if count > unclassified && (best < 0 || count > counts[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return "", false
+	}
+	glog.V(2).Infof("Scale up triggered mostly by %d pods of class %d, using the %s expander", counts[best], best, e.classes[best].Expander)
+	return e.classes[best].Expander, true
+}
+
+// Expanders returns the priority and external expanders of the named expander, nil if the
+// expander doesn't use them.
+func (e *PodClassExpanders) Expanders(name string) (*PriorityExpander, ExternalExpander) {
+	switch name {
+	case PriorityExpanderName:
+		return e.priority, nil
+	case HttpExpanderName:
+		return nil, e.external
+	}
+	return nil, nil
+}
+
+// classOf returns the index of the first class of the pod, -1 if it belongs to none.
+func (e *PodClassExpanders) classOf(pod *kube_api.Pod) int {
+	for i, class := range e.classes {
+		if class.Matches(pod) {
+			return i
+		}
+	}
+	return -1
+}
+
+// isAvailableExpander returns true if name is one of AvailableExpanders.
+func isAvailableExpander(name string) bool {
+	for _, available := range AvailableExpanders {
+		if name == available {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+	kube_api "k8s.io/kubernetes/pkg/api"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePodClass(t *testing.T) {
+	class, err := ParsePodClass("batch-jobs:workload=batch:priority")
+	assert.NoError(t, err)
+	assert.Equal(t, "batch-jobs", class.Namespace)
+	assert.Equal(t, PriorityExpanderName, class.Expander)
+
+	pod := BuildTestPod("p1", 100, 0)
+	pod.Namespace = "batch-jobs"
+	pod.Labels = map[string]string{"workload": "batch"}
+	assert.True(t, class.Matches(pod))
+	pod.Namespace = "default"
+	assert.False(t, class.Matches(pod))
+
+	class, err = ParsePodClass(":workload=batch:default")
+	assert.NoError(t, err)
+	assert.True(t, class.Matches(pod))
+
+	_, err = ParsePodClass("batch-jobs:priority")
+	assert.Error(t, err)
+	_, err = ParsePodClass("::priority")
+	assert.Error(t, err)
+	_, err = ParsePodClass("batch-jobs::cheapest")
+	assert.Error(t, err)
+	_, err = ParsePodClass(":workload in batch:priority")
+	assert.Error(t, err)
+}
+
+func TestPodClassExpanders(t *testing.T) {
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	groups := make(map[string]cloudprovider.NodeGroup)
+	for _, nodeGroup := range provider.NodeGroups() {
+		groups[nodeGroup.Id()] = nodeGroup
+	}
+
+	buildPods := func(prefix string, count int, labels map[string]string) []*kube_api.Pod {
+		pods := make([]*kube_api.Pod, 0, count)
+		for i := 0; i < count; i++ {
+			pod := BuildTestPod(prefix+string('a'+rune(i)), 100, 0)
+			pod.Labels = labels
+			pods = append(pods, pod)
+		}
+		return pods
+	}
+	buildOptions := func(pods []*kube_api.Pod) []ExpansionOption {
+		return []ExpansionOption{
+			{nodeGroup: groups["ng1"], nodeCount: 1, pods: pods},
+			{nodeGroup: groups["ng2"], nodeCount: 1, pods: pods},
+		}
+	}
+
+	class, err := ParsePodClass(":workload=batch:priority")
+	assert.NoError(t, err)
+	priorityExpander := NewPriorityExpander(map[string]int{"ng2": 10})
+	context := &AutoscalingContext{
+		PodClassExpanders: NewPodClassExpanders([]PodClass{class}, priorityExpander, nil),
+	}
+
+	// Scale ups triggered by batch pods use the priority expander.
+	batchPods := buildPods("batch", 3, map[string]string{"workload": "batch"})
+	name, found := context.PodClassExpanders.ExpanderFor(buildOptions(batchPods))
+	assert.True(t, found)
+	assert.Equal(t, PriorityExpanderName, name)
+	assert.Equal(t, "ng2", bestExpansionOption(context, buildOptions(batchPods), nil).nodeGroup.Id())
+
+	// Scale ups triggered by service pods use the global default expander.
+	servicePods := buildPods("service", 3, map[string]string{"workload": "service"})
+	_, found = context.PodClassExpanders.ExpanderFor(buildOptions(servicePods))
+	assert.False(t, found)
+	assert.Equal(t, "ng1", bestExpansionOption(context, buildOptions(servicePods), nil).nodeGroup.Id())
+
+	// The class of most pods wins, pods shared by options are counted once.
+	mixed := append(buildPods("batch", 2, map[string]string{"workload": "batch"}), servicePods[0])
+	assert.Equal(t, "ng2", bestExpansionOption(context, buildOptions(mixed), nil).nodeGroup.Id())
+	mixed = append(buildPods("batch", 1, map[string]string{"workload": "batch"}), servicePods[0])
+	assert.Equal(t, "ng1", bestExpansionOption(context, buildOptions(mixed), nil).nodeGroup.Id())
+
+	var disabled *PodClassExpanders
+	_, found = disabled.ExpanderFor(buildOptions(batchPods))
+	assert.False(t, found)
+}
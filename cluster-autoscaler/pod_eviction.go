@@ -0,0 +1,176 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_errors "k8s.io/kubernetes/pkg/api/errors"
+	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// podEvictionRetryInterval is the time between consecutive attempts to evict the pods of a node.
+	podEvictionRetryInterval = 10 * time.Second
+	// PodPriorityAnnotationKey is the annotation holding the integer priority of a pod. Pods with lower
+	// priority are evicted first when a node is drained. The vendored api predates pod.Spec.Priority,
+	// so the order is opt-in: nothing but the pod owners sets this annotation.
+	// TODO: read pod.Spec.Priority once the vendored api has it.
+	PodPriorityAnnotationKey = "cluster-autoscaler.kubernetes.io/pod-priority"
+)
+
+// PodEvicter removes pods from nodes that are scaled down.
+type PodEvicter interface {
+	// EvictPod evicts the pod through the eviction api, which refuses evictions that would
+	// violate a PodDisruptionBudget.
+	EvictPod(pod *kube_api.Pod) error
+	// DeletePod deletes the pod, ignoring PodDisruptionBudgets.
+	DeletePod(pod *kube_api.Pod) error
+}
+
+// kubePodEvicter implements PodEvicter with the Kubernetes api.
+type kubePodEvicter struct {
+	client *kube_client.Client
+}
+
+// NewKubePodEvicter builds a PodEvicter that uses the given client.
+func NewKubePodEvicter(client *kube_client.Client) PodEvicter {
+	return &kubePodEvicter{client: client}
+}
+
+// EvictPod posts an Eviction to the eviction subresource of the pod. The vendored api predates the
+// Eviction type, so the object is encoded by hand.
+func (e *kubePodEvicter) EvictPod(pod *kube_api.Pod) error {
+	eviction, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "policy/v1alpha1",
+		"kind":       "Eviction",
+		"metadata": map[string]string{
+			"namespace": pod.Namespace,
+			"name":      pod.Name,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = e.client.Post().Namespace(pod.Namespace).Resource("pods").Name(pod.Name).SubResource("eviction").
+		Body(eviction).Do().Error()
+	if kube_errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (e *kubePodEvicter) DeletePod(pod *kube_api.Pod) error {
+	err := e.client.Pods(pod.Namespace).Delete(pod.Name, nil)
+	if kube_errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// drainNode evicts the given pods from the node, retrying evictions that are refused, for example
+// because of a PodDisruptionBudget, for up to context.MaxPodEvictionTime. If some pods are still not
+// evicted then, they are deleted if context.ForceDrain is set, otherwise an error is returned and the
+// node should be left in place. Pods are removed in ascending priority order, so that the most
+// important ones are disrupted last. A ScaleDown event is recorded on every removed pod.
+func drainNode(context *AutoscalingContext, node *kube_api.Node, pods []*kube_api.Pod) error {
+	if context.PodEvicter == nil {
+		return nil
+	}
+	deadline := context.Now().Add(context.MaxPodEvictionTime)
+	remaining := sortPodsByPriority(pods)
+	for {
+		blocked := make([]*kube_api.Pod, 0)
+		for _, pod := range remaining {
+			if err := context.PodEvicter.EvictPod(pod); err != nil {
+				glog.V(2).Infof("Failed to evict %s/%s from %s: %v", pod.Namespace, pod.Name, node.Name, err)
+				blocked = append(blocked, pod)
+				continue
+			}
+			recordPodScaleDownEvent(context, pod, node, "evicted")
+		}
+		if len(blocked) == 0 {
+			return nil
+		}
+		remaining = blocked
+		if !context.Now().Add(podEvictionRetryInterval).Before(deadline) {
+			break
+		}
+		context.Sleep(podEvictionRetryInterval)
+	}
+
+	podNames := make([]string, 0, len(remaining))
+	for _, pod := range remaining {
+		podNames = append(podNames, pod.Namespace+"/"+pod.Name)
+	}
+	glog.Warningf("Failed to evict pods from %s within %v: %s", node.Name, context.MaxPodEvictionTime,
+		strings.Join(podNames, ","))
+	if !context.ForceDrain {
+		return fmt.Errorf("failed to evict %d pods from %s within %v", len(remaining), node.Name, context.MaxPodEvictionTime)
+	}
+	for _, pod := range remaining {
+		glog.Warningf("Force draining %s: deleting %s/%s", node.Name, pod.Namespace, pod.Name)
+		if err := context.PodEvicter.DeletePod(pod); err != nil {
+			return fmt.Errorf("failed to delete %s/%s: %v", pod.Namespace, pod.Name, err)
+		}
+		recordPodScaleDownEvent(context, pod, node, "deleted")
+	}
+	return nil
+}
+
+// podPriority returns the priority of the pod from PodPriorityAnnotationKey, 0 if it is not set.
+func podPriority(pod *kube_api.Pod) int {
+	value, found := pod.Annotations[PodPriorityAnnotationKey]
+	if !found {
+		return 0
+	}
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		glog.Warningf("Invalid priority of %s/%s: %v", pod.Namespace, pod.Name, err)
+		return 0
+	}
+	return priority
+}
+
+// sortPodsByPriority returns a copy of the pods sorted by ascending priority. Pods with equal
+// priority keep their order.
+func sortPodsByPriority(pods []*kube_api.Pod) []*kube_api.Pod {
+	result := make([]*kube_api.Pod, len(pods))
+	copy(result, pods)
+	sort.Stable(byPriority(result))
+	return result
+}
+
+type byPriority []*kube_api.Pod
+
+func (a byPriority) Len() int           { return len(a) }
+func (a byPriority) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byPriority) Less(i, j int) bool { return podPriority(a[i]) < podPriority(a[j]) }
+
+// recordPodScaleDownEvent tells the owners of the pod why it was removed from its node.
+func recordPodScaleDownEvent(context *AutoscalingContext, pod *kube_api.Pod, node *kube_api.Node, action string) {
+	context.Recorder.Eventf(pod, kube_api.EventTypeNormal, ReasonScaleDown,
+		"pod %s by cluster autoscaler, its node %s is removed for underutilization", action, node.Name)
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/utils/clock"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePodEvicter refuses evictions of the pods protected by a PodDisruptionBudget. The budget can
+// never be satisfied, unless clock is set: then it's satisfied from protectedUntil on.
+type fakePodEvicter struct {
+	protected      map[string]bool
+	clock          clock.Clock
+	protectedUntil time.Time
+	evicted        []string
+	deleted        []string
+}
+
+func (e *fakePodEvicter) EvictPod(pod *kube_api.Pod) error {
+	if e.protected[pod.Name] && (e.clock == nil || e.clock.Now().Before(e.protectedUntil)) {
+		return fmt.Errorf("Cannot evict pod as it would violate the pod's disruption budget.")
+	}
+	e.evicted = append(e.evicted, pod.Name)
+	return nil
+}
+
+func (e *fakePodEvicter) DeletePod(pod *kube_api.Pod) error {
+	e.deleted = append(e.deleted, pod.Name)
+	return nil
+}
+
+func TestDrainNode(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	p1 := BuildTestPod("p1", 100, 0)
+	p2 := BuildTestPod("p2", 100, 0)
+
+	evicter := &fakePodEvicter{}
+	recorder := kube_record.NewFakeRecorder(10)
+	context := &AutoscalingContext{PodEvicter: evicter, Recorder: recorder}
+	assert.NoError(t, drainNode(context, n1, []*kube_api.Pod{p1, p2}))
+	assert.Equal(t, []string{"p1", "p2"}, evicter.evicted)
+	assert.Empty(t, evicter.deleted)
+
+	// Both evicted pods are told why they were moved.
+	for range []*kube_api.Pod{p1, p2} {
+		select {
+		case event := <-recorder.Events:
+			assert.Contains(t, event, "ScaleDown")
+			assert.Contains(t, event, "n1 is removed for underutilization")
+		default:
+			t.Fatal("missing ScaleDown event")
+		}
+	}
+}
+
+func TestDrainNodeUnsatisfiablePodDisruptionBudget(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	p1 := BuildTestPod("p1", 100, 0)
+	p2 := BuildTestPod("p2", 100, 0)
+
+	// The node is skipped after the timeout.
+	evicter := &fakePodEvicter{protected: map[string]bool{"p2": true}}
+	context := &AutoscalingContext{PodEvicter: evicter, Recorder: kube_record.NewFakeRecorder(10)}
+	assert.Error(t, drainNode(context, n1, []*kube_api.Pod{p1, p2}))
+	assert.Equal(t, []string{"p1"}, evicter.evicted)
+	assert.Empty(t, evicter.deleted)
+
+	// Or the pod is deleted with ForceDrain.
+	evicter = &fakePodEvicter{protected: map[string]bool{"p2": true}}
+	context = &AutoscalingContext{PodEvicter: evicter, Recorder: kube_record.NewFakeRecorder(10), ForceDrain: true}
+	assert.NoError(t, drainNode(context, n1, []*kube_api.Pod{p1, p2}))
+	assert.Equal(t, []string{"p1"}, evicter.evicted)
+	assert.Equal(t, []string{"p2"}, evicter.deleted)
+}
+
+func TestDrainNodeRetriesEvictions(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	p1 := BuildTestPod("p1", 100, 0)
+	p2 := BuildTestPod("p2", 100, 0)
+	start := time.Now()
+
+	// p2 is evicted once its disruption budget is satisfied, before the timeout.
+	fakeClock := clock.NewFakeClock(start)
+	evicter := &fakePodEvicter{protected: map[string]bool{"p2": true}, clock: fakeClock, protectedUntil: start.Add(30 * time.Second)}
+	context := &AutoscalingContext{PodEvicter: evicter, Recorder: kube_record.NewFakeRecorder(10),
+		MaxPodEvictionTime: time.Minute, Clock: fakeClock}
+	assert.NoError(t, drainNode(context, n1, []*kube_api.Pod{p1, p2}))
+	assert.Equal(t, []string{"p1", "p2"}, evicter.evicted)
+	assert.Empty(t, evicter.deleted)
+	assert.Equal(t, start.Add(30*time.Second), fakeClock.Now())
+
+	// Otherwise it's deleted with ForceDrain after the last retry within the timeout.
+	fakeClock = clock.NewFakeClock(start)
+	evicter = &fakePodEvicter{protected: map[string]bool{"p2": true}, clock: fakeClock, protectedUntil: start.Add(time.Hour)}
+	context = &AutoscalingContext{PodEvicter: evicter, Recorder: kube_record.NewFakeRecorder(10),
+		MaxPodEvictionTime: time.Minute, Clock: fakeClock, ForceDrain: true}
+	assert.NoError(t, drainNode(context, n1, []*kube_api.Pod{p1, p2}))
+	assert.Equal(t, []string{"p1"}, evicter.evicted)
+	assert.Equal(t, []string{"p2"}, evicter.deleted)
+	assert.Equal(t, start.Add(50*time.Second), fakeClock.Now())
+}
+
+func TestDrainNodeInPriorityOrder(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.Annotations = map[string]string{PodPriorityAnnotationKey: "1000"}
+	p2 := BuildTestPod("p2", 100, 0)
+	p3 := BuildTestPod("p3", 100, 0)
+	p3.Annotations = map[string]string{PodPriorityAnnotationKey: "-10"}
+	p4 := BuildTestPod("p4", 100, 0)
+	p4.Annotations = map[string]string{PodPriorityAnnotationKey: "10"}
+	p5 := BuildTestPod("p5", 100, 0)
+
+	evicter := &fakePodEvicter{}
+	context := &AutoscalingContext{PodEvicter: evicter, Recorder: kube_record.NewFakeRecorder(10)}
+	pods := []*kube_api.Pod{p1, p2, p3, p4, p5}
+	assert.NoError(t, drainNode(context, n1, pods))
+	assert.Equal(t, []string{"p3", "p2", "p5", "p4", "p1"}, evicter.evicted)
+	// The passed pods are not reordered.
+	assert.Equal(t, []*kube_api.Pod{p1, p2, p3, p4, p5}, pods)
+}
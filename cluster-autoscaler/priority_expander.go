@@ -0,0 +1,92 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+)
+
+// PriorityExpanderName picks the expansion option among the node groups with the highest priority.
+const PriorityExpanderName = "priority"
+
+// PriorityExpander narrows the expansion options down to the node groups with the highest
+// priority. The priority set on the node group itself, e.g. with an ASG tag, takes precedence
+// over the configured one. Node groups without any priority have priority 0.
+type PriorityExpander struct {
+	// priorities are the configured priorities, keyed by node group id.
+	priorities map[string]int
+}
+
+// NewPriorityExpander builds PriorityExpander.
+func NewPriorityExpander(priorities map[string]int) *PriorityExpander {
+	return &PriorityExpander{priorities: priorities}
+}
+
+// ParseExpanderPriorities parses priorities in format <node group id>=<priority>,...
+func ParseExpanderPriorities(value string) (map[string]int, error) {
+	result := make(map[string]int)
+	if value == "" {
+		return result, nil
+	}
+	for _, entry := range strings.Split(value, ",") {
+		separator := strings.LastIndex(entry, "=")
+		if separator <= 0 {
+			return nil, fmt.Errorf("wrong priority: expected format <node group id>=<priority>, got %s", entry)
+		}
+		priority, err := strconv.Atoi(entry[separator+1:])
+		if err != nil {
+			return nil, fmt.Errorf("wrong priority of %s: %v", entry[:separator], err)
+		}
+		result[entry[:separator]] = priority
+	}
+	return result, nil
+}
+
+// Priority returns the priority of the node group.
+func (e *PriorityExpander) Priority(nodeGroup cloudprovider.NodeGroup) int {
+	if prioritized, ok := nodeGroup.(cloudprovider.PrioritizedNodeGroup); ok {
+		if priority, found := prioritized.Priority(); found {
+			return priority
+		}
+	}
+	return e.priorities[nodeGroup.Id()]
+}
+
+// HighestPriorityOptions returns the options of node groups with the highest priority. A nil
+// PriorityExpander returns all options.
+func (e *PriorityExpander) HighestPriorityOptions(options []ExpansionOption) []ExpansionOption {
+	if e == nil || len(options) == 0 {
+		return options
+	}
+	result := make([]ExpansionOption, 0, len(options))
+	highest := e.Priority(options[0].nodeGroup)
+	for _, option := range options {
+		priority := e.Priority(option.nodeGroup)
+		if priority > highest {
+			highest = priority
+			result = result[:0]
+		}
+		if priority == highest {
+			result = append(result, option)
+		}
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// prioritizedNodeGroup is a node group with a priority set on the cloud provider side.
+type prioritizedNodeGroup struct {
+	cloudprovider.NodeGroup
+	priority int
+}
+
+func (ng *prioritizedNodeGroup) Priority() (int, bool) {
+	return ng.priority, true
+}
+
+func TestParseExpanderPriorities(t *testing.T) {
+	priorities, err := ParseExpanderPriorities("")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{}, priorities)
+
+	priorities, err = ParseExpanderPriorities("ng1=10,ng2=-1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"ng1": 10, "ng2": -1}, priorities)
+
+	_, err = ParseExpanderPriorities("ng1")
+	assert.Error(t, err)
+	_, err = ParseExpanderPriorities("ng1=high")
+	assert.Error(t, err)
+}
+
+func TestPriorityExpander(t *testing.T) {
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNodeGroup("ng3", 1, 10, 1)
+	provider.AddNodeGroup("ng4", 1, 10, 1)
+	groups := make(map[string]cloudprovider.NodeGroup)
+	for _, nodeGroup := range provider.NodeGroups() {
+		groups[nodeGroup.Id()] = nodeGroup
+	}
+	options := []ExpansionOption{
+		{nodeGroup: groups["ng1"], nodeCount: 1},
+		{nodeGroup: &prioritizedNodeGroup{NodeGroup: groups["ng2"], priority: 20}, nodeCount: 1},
+		{nodeGroup: &prioritizedNodeGroup{NodeGroup: groups["ng3"], priority: 5}, nodeCount: 1},
+		{nodeGroup: groups["ng4"], nodeCount: 1},
+	}
+	ids := func(options []ExpansionOption) []string {
+		result := make([]string, 0)
+		for _, option := range options {
+			result = append(result, option.nodeGroup.Id())
+		}
+		return result
+	}
+
+	var disabled *PriorityExpander
+	assert.Equal(t, []string{"ng1", "ng2", "ng3", "ng4"}, ids(disabled.HighestPriorityOptions(options)))
+
+	// The priority of ng2 set on the node group takes precedence over the configured one.
+	expander := NewPriorityExpander(map[string]int{"ng1": 20, "ng2": 1, "ng3": 30})
+	assert.Equal(t, []string{"ng1", "ng2"}, ids(expander.HighestPriorityOptions(options)))
+
+	expander = NewPriorityExpander(map[string]int{})
+	assert.Equal(t, []string{"ng2"}, ids(expander.HighestPriorityOptions(options)))
+
+	context := &AutoscalingContext{PriorityExpander: expander}
+	assert.Equal(t, "ng2", bestExpansionOption(context, options, nil).nodeGroup.Id())
+}
@@ -0,0 +1,221 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// replayFixturesGlob matches the recorded states replayed by TestReplayFixtures. A reported scaling
+// bug becomes a regression test by capturing the state it happened in as a new fixture. Fixtures are
+// replayed against the test cloud provider, so they cover the decisions of the autoscaler, while bugs
+// in a cloud provider need a test in its package.
+const replayFixturesGlob = "testdata/replay/*.json"
+
+// replayFixture is a recorded state of the cluster and the cloud provider, together with the
+// decision a single autoscaler iteration is expected to make on it.
+type replayFixture struct {
+	Description                   string            `json:"description"`
+	ScaleDownUtilizationThreshold float64           `json:"scaleDownUtilizationThreshold"`
+	ScaleDownUnneededTime         string            `json:"scaleDownUnneededTime"`
+	NodeGroups                    []replayNodeGroup `json:"nodeGroups"`
+	Nodes                         []replayNode      `json:"nodes"`
+	Pods                          []replayPod       `json:"pods"`
+	Expected                      replayDecision    `json:"expected"`
+}
+
+type replayNodeGroup struct {
+	Id         string `json:"id"`
+	MinSize    int    `json:"minSize"`
+	MaxSize    int    `json:"maxSize"`
+	TargetSize int    `json:"targetSize"`
+}
+
+type replayNode struct {
+	Name      string `json:"name"`
+	NodeGroup string `json:"nodeGroup"`
+	Cpu       int64  `json:"cpu"`
+	Memory    int64  `json:"memory"`
+	// UnneededFor is how long the node has been unneeded, e.g. "15m". Empty if it's not unneeded.
+	UnneededFor string `json:"unneededFor"`
+}
+
+type replayPod struct {
+	Name string `json:"name"`
+	// Node is the name of the node the pod runs on. Pods without a node are pending.
+	Node       string `json:"node"`
+	Cpu        int64  `json:"cpu"`
+	Memory     int64  `json:"memory"`
+	Replicated bool   `json:"replicated"`
+}
+
+// replayDecision is the outcome of an iteration: the increase of every scaled up node group and
+// the node group every deleted node was deleted from, keyed by node name.
+type replayDecision struct {
+	ScaledUp map[string]int    `json:"scaledUp"`
+	Deleted  map[string]string `json:"deleted"`
+}
+
+// loadReplayFixture reads a replayFixture from the JSON file at path.
+func loadReplayFixture(path string) (*replayFixture, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fixture := &replayFixture{}
+	if err := json.Unmarshal(data, fixture); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return fixture, nil
+}
+
+// replay runs a single iteration of the autoscaler on the recorded state against a test cloud
+// provider. As in the main loop, a scale up is attempted if there are pending pods and scale down
+// only if nothing was scaled up.
+func replay(fixture *replayFixture) (replayDecision, error) {
+	decision := replayDecision{ScaledUp: make(map[string]int), Deleted: make(map[string]string)}
+	// Empty nodes are deleted concurrently.
+	var decisionMutex sync.Mutex
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		decisionMutex.Lock()
+		defer decisionMutex.Unlock()
+		decision.ScaledUp[id] += delta
+		return nil
+	}, func(id string, node string) error {
+		decisionMutex.Lock()
+		defer decisionMutex.Unlock()
+		decision.Deleted[node] = id
+		return nil
+	})
+	for _, group := range fixture.NodeGroups {
+		provider.AddNodeGroup(group.Id, group.MinSize, group.MaxSize, group.TargetSize)
+	}
+
+	now := time.Now()
+	nodes := make([]*kube_api.Node, 0, len(fixture.Nodes))
+	unneeded := make(map[string]time.Time)
+	for _, recorded := range fixture.Nodes {
+		node := BuildTestNode(recorded.Name, recorded.Cpu, recorded.Memory)
+		nodes = append(nodes, node)
+		if recorded.NodeGroup != "" {
+			provider.AddNode(recorded.NodeGroup, node)
+		}
+		if recorded.UnneededFor != "" {
+			duration, err := time.ParseDuration(recorded.UnneededFor)
+			if err != nil {
+				return decision, fmt.Errorf("invalid unneededFor of %s: %v", recorded.Name, err)
+			}
+			unneeded[node.Name] = now.Add(-duration)
+		}
+	}
+	scheduled := make([]*kube_api.Pod, 0)
+	pending := make([]*kube_api.Pod, 0)
+	for _, recorded := range fixture.Pods {
+		pod := BuildTestPod(recorded.Name, recorded.Cpu, recorded.Memory)
+		if recorded.Replicated {
+			pod.Annotations = map[string]string{
+				"kubernetes.io/created-by": "{\"kind\":\"SerializedReference\",\"apiVersion\":\"v1\",\"reference\":{\"kind\":\"ReplicaSet\"}}",
+			}
+		}
+		pod.Spec.NodeName = recorded.Node
+		if recorded.Node == "" {
+			pending = append(pending, pod)
+		} else {
+			scheduled = append(scheduled, pod)
+		}
+	}
+
+	unneededTime := 10 * time.Minute
+	if fixture.ScaleDownUnneededTime != "" {
+		var err error
+		if unneededTime, err = time.ParseDuration(fixture.ScaleDownUnneededTime); err != nil {
+			return decision, fmt.Errorf("invalid scaleDownUnneededTime: %v", err)
+		}
+	}
+	context := &AutoscalingContext{
+		CloudProvider:         provider,
+		PredicateChecker:      simulator.NewTestPredicateChecker(),
+		Recorder:              kube_record.NewFakeRecorder(100),
+		MaxEmptyBulkDelete:    10,
+		ScaleDownUnneededTime: unneededTime,
+		EstimatorName:         BinpackingEstimatorName,
+	}
+
+	if len(pending) > 0 {
+		// The first node of every node group is its sample node.
+		nodeInfos := make(map[string]*schedulercache.NodeInfo)
+		for _, node := range nodes {
+			nodeGroup, err := provider.NodeGroupForNode(node)
+			if err != nil || nodeGroup == nil {
+				continue
+			}
+			if _, found := nodeInfos[nodeGroup.Id()]; !found {
+				nodeInfo := schedulercache.NewNodeInfo()
+				nodeInfo.SetNode(node)
+				nodeInfos[nodeGroup.Id()] = nodeInfo
+			}
+		}
+		scaledUp, err := ScaleUp(context, pending, nodes, nodeInfos)
+		if err != nil || scaledUp {
+			return decision, err
+		}
+	}
+
+	tracker := simulator.NewUsageTracker()
+	unneeded, hints, utilization := FindUnneededNodes(nodes, unneeded, fixture.ScaleDownUtilizationThreshold, false,
+		scheduled, context.PredicateChecker, make(map[string]string), tracker, now, nil)
+	_, err := ScaleDown(context, nodes, utilization, unneeded, scheduled, hints, tracker)
+	return decision, err
+}
+
+func TestReplayFixtures(t *testing.T) {
+	paths, err := filepath.Glob(replayFixturesGlob)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, paths)
+	for _, path := range paths {
+		fixture, err := loadReplayFixture(path)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		expected := replayDecision{ScaledUp: make(map[string]int), Deleted: make(map[string]string)}
+		for id, delta := range fixture.Expected.ScaledUp {
+			expected.ScaledUp[id] = delta
+		}
+		for node, id := range fixture.Expected.Deleted {
+			expected.Deleted[node] = id
+		}
+		decision, err := replay(fixture)
+		assert.NoError(t, err, "%s: %s", path, fixture.Description)
+		assert.Equal(t, expected, decision, "%s: %s", path, fixture.Description)
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
+)
+
+// gigabyte is the unit of the memory limit flags.
+const gigabyte = 1024 * 1024 * 1024
+
+// ResourceLimits bounds the total cores and memory of the cluster, computed as the capacity of the
+// registered nodes plus the capacity of the requested nodes that haven't registered yet. A max of 0
+// means no limit.
+type ResourceLimits struct {
+	MinCores int64
+	MaxCores int64
+	// MinMemory and MaxMemory are in bytes.
+	MinMemory int64
+	MaxMemory int64
+}
+
+// ParseMinMax parses a limit in format min:max.
+func ParseMinMax(value string) (int64, int64, error) {
+	tokens := strings.Split(value, ":")
+	if len(tokens) != 2 {
+		return 0, 0, fmt.Errorf("wrong limit: %s, expected min:max", value)
+	}
+	min, err := strconv.ParseInt(tokens[0], 10, 64)
+	if err != nil || min < 0 {
+		return 0, 0, fmt.Errorf("wrong min in limit %s, expected non-negative integer", value)
+	}
+	max, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil || max < 0 {
+		return 0, 0, fmt.Errorf("wrong max in limit %s, expected non-negative integer", value)
+	}
+	if max > 0 && max < min {
+		return 0, 0, fmt.Errorf("max must be greater or equal to min in limit %s", value)
+	}
+	return min, max, nil
+}
+
+// nodeResources returns the cores and memory capacity of the node.
+func nodeResources(node *kube_api.Node) (int64, int64) {
+	cpu := node.Status.Capacity[kube_api.ResourceCPU]
+	memory := node.Status.Capacity[kube_api.ResourceMemory]
+	return cpu.Value(), memory.Value()
+}
+
+// newNodeResources returns the cores and memory capacity of a new node of the node group, taken from
+// its NodeInfo, its template node or the capacity it declares as a cloudprovider.TemplatedNodeGroup,
+// in that order. It fails if none of them is known.
+func newNodeResources(context *AutoscalingContext, nodeGroup cloudprovider.NodeGroup,
+	nodeInfos map[string]*schedulercache.NodeInfo) (int64, int64, error) {
+	if nodeInfo, found := nodeInfos[nodeGroup.Id()]; found && nodeInfo.Node() != nil {
+		cores, memory := nodeResources(nodeInfo.Node())
+		return cores, memory, nil
+	}
+	if template, found := context.NodeTemplates[nodeGroup.Id()]; found {
+		template, err := applyNodeGroupTemplate(template, nodeGroup)
+		if err != nil {
+			return 0, 0, err
+		}
+		cores, memory := nodeResources(template)
+		return cores, memory, nil
+	}
+	if templated, ok := nodeGroup.(cloudprovider.TemplatedNodeGroup); ok {
+		capacity := templated.TemplateCapacity()
+		cpu, cpuFound := capacity[kube_api.ResourceCPU]
+		memory, memoryFound := capacity[kube_api.ResourceMemory]
+		if cpuFound && memoryFound {
+			return cpu.Value(), memory.Value(), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("capacity of nodes of %s is unknown", nodeGroup.Id())
+}
+
+// clusterResources returns the total cores and memory of the cluster: the capacity of all registered
+// nodes of context.ClusterSnapshot that are not being deleted, plus the capacity of the requested
+// nodes of every node group that haven't registered yet. It fails if the cluster state or the
+// capacity of requested nodes is unknown, so that the limits are never exceeded by undercounting.
+func clusterResources(context *AutoscalingContext, nodeInfos map[string]*schedulercache.NodeInfo) (int64, int64, error) {
+	if context.ClusterSnapshot == nil {
+		return 0, 0, fmt.Errorf("no cluster snapshot")
+	}
+	nodes := context.NodeDeletionTracker.FilterOutNodesBeingDeleted(context.ClusterSnapshot.AllNodes)
+	var cores, memory int64
+	for _, node := range nodes {
+		nodeCores, nodeMemory := nodeResources(node)
+		cores += nodeCores
+		memory += nodeMemory
+	}
+	registered, err := countNodesInGroups(nodes, context.CloudProvider)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		size, err := targetSize(context, nodeGroup)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to get size of %s: %v", nodeGroup.Id(), err)
+		}
+		requested := size - registered[nodeGroup.Id()]
+		if requested <= 0 {
+			continue
+		}
+		nodeCores, nodeMemory, err := newNodeResources(context, nodeGroup, nodeInfos)
+		if err != nil {
+			return 0, 0, err
+		}
+		cores += nodeCores * int64(requested)
+		memory += nodeMemory * int64(requested)
+	}
+	return cores, memory, nil
+}
+
+// capSizeToResourceLimits lowers the new size of the node group so that the cluster doesn't grow
+// beyond context.ResourceLimits. It also returns the limit that lowered it, empty if none did.
+func capSizeToResourceLimits(context *AutoscalingContext, nodeGroup cloudprovider.NodeGroup, currentSize, newSize int,
+	nodeInfos map[string]*schedulercache.NodeInfo) (int, string, error) {
+	limits := context.ResourceLimits
+	if limits == nil || (limits.MaxCores == 0 && limits.MaxMemory == 0) {
+		return newSize, "", nil
+	}
+	nodeCores, nodeMemory, err := newNodeResources(context, nodeGroup, nodeInfos)
+	if err != nil {
+		return currentSize, "", err
+	}
+	cores, memory, err := clusterResources(context, nodeInfos)
+	if err != nil {
+		return currentSize, "", err
+	}
+	limit := ""
+	if limits.MaxCores > 0 && nodeCores > 0 && int64(newSize-currentSize)*nodeCores > limits.MaxCores-cores {
+		limit = fmt.Sprintf("max cluster cores total (%d)", limits.MaxCores)
+		glog.V(1).Infof("Capping size of %s to %s", nodeGroup.Id(), limit)
+		newSize = currentSize + int((limits.MaxCores-cores)/nodeCores)
+	}
+	if limits.MaxMemory > 0 && nodeMemory > 0 && int64(newSize-currentSize)*nodeMemory > limits.MaxMemory-memory {
+		limit = fmt.Sprintf("max cluster memory total (%d)", limits.MaxMemory)
+		glog.V(1).Infof("Capping size of %s to %s", nodeGroup.Id(), limit)
+		newSize = currentSize + int((limits.MaxMemory-memory)/nodeMemory)
+	}
+	if newSize < currentSize {
+		newSize = currentSize
+	}
+	return newSize, limit, nil
+}
+
+// filterOutNodesBelowResourceMin keeps the scale down candidates, in order, as long as removing all
+// of them doesn't shrink the cluster below context.ResourceLimits.
+func filterOutNodesBelowResourceMin(context *AutoscalingContext, candidates []*kube_api.Node) ([]*kube_api.Node, error) {
+	limits := context.ResourceLimits
+	if limits == nil || (limits.MinCores == 0 && limits.MinMemory == 0) {
+		return candidates, nil
+	}
+	cores, memory, err := clusterResources(context, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*kube_api.Node, 0, len(candidates))
+	for _, node := range candidates {
+		nodeCores, nodeMemory := nodeResources(node)
+		if cores-nodeCores < limits.MinCores {
+			glog.V(1).Infof("Skipping %s - min cluster cores total (%d) reached", node.Name, limits.MinCores)
+			continue
+		}
+		if memory-nodeMemory < limits.MinMemory {
+			glog.V(1).Infof("Skipping %s - min cluster memory total (%d) reached", node.Name, limits.MinMemory)
+			continue
+		}
+		cores -= nodeCores
+		memory -= nodeMemory
+		result = append(result, node)
+	}
+	return result, nil
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMinMax(t *testing.T) {
+	min, max, err := ParseMinMax("2:10")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), min)
+	assert.Equal(t, int64(10), max)
+
+	_, max, err = ParseMinMax("2:0")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), max)
+
+	for _, value := range []string{"", "10", "a:10", "2:b", "-1:10", "10:2", "1:2:3"} {
+		_, _, err = ParseMinMax(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestScaleUpCoresTotal(t *testing.T) {
+	n1 := BuildTestNode("n1", 2000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+		ResourceLimits:   &ResourceLimits{MaxCores: 6},
+		ClusterSnapshot:  NewClusterSnapshot(nil, []*kube_api.Node{n1}, nil, nil, provider, time.Now()),
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{"ng1": buildTestNodeInfo(n1)}
+
+	// The node count limits allow 4 new nodes, but only 2 fit in the cores total.
+	pods := []*kube_api.Pod{
+		BuildTestPod("p1", 2000, 0),
+		BuildTestPod("p2", 2000, 0),
+		BuildTestPod("p3", 2000, 0),
+		BuildTestPod("p4", 2000, 0),
+	}
+	scaledUp, err := ScaleUp(context, pods, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 2}, scaledGroups)
+
+	// The cores total is reached, counting the requested nodes that haven't registered yet. That's
+	// not an error, so that scale down still runs.
+	scaledUp, err = ScaleUp(context, pods, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.False(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 2}, scaledGroups)
+}
+
+func TestScaleDownMemoryTotal(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	nodes := []*kube_api.Node{n1, n2, n3}
+
+	deleted := make([]string, 0)
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 0, 10, 3)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	provider.AddNode("ng1", n3)
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete: 10,
+		ResourceLimits:     &ResourceLimits{MinMemory: 3000},
+		ClusterSnapshot:    NewClusterSnapshot(nodes, nodes, nil, nil, provider, time.Now()),
+	}
+	unneeded := map[string]time.Time{
+		"n1": time.Now().Add(-time.Hour),
+		"n2": time.Now().Add(-time.Hour),
+	}
+
+	// The node group min size allows removing both nodes, but the cluster is at its memory total.
+	result, err := ScaleDown(context, nodes, map[string]float64{}, unneeded,
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoNodeDeleted, result)
+	assert.Empty(t, deleted)
+
+	// Only one node can be removed without dropping below the memory total.
+	context.ResourceLimits.MinMemory = 2000
+	result, err = ScaleDown(context, nodes, map[string]float64{}, unneeded,
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+	assert.Equal(t, 1, len(deleted))
+}
+
+func TestClusterResources(t *testing.T) {
+	n1 := BuildTestNode("n1", 2000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	unmanaged := BuildTestNode("unmanaged", 4000, 1000)
+	allNodes := []*kube_api.Node{n1, n2, unmanaged}
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNodeGroup("ng2", 0, 10, 1)
+	provider.AddNodeGroup("ng3", 0, 10, 0)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+
+	// ng1 has one requested node that hasn't registered, ng3 has no template but no nodes either.
+	context := &AutoscalingContext{
+		CloudProvider:   provider,
+		ClusterSnapshot: NewClusterSnapshot(nil, allNodes, nil, nil, provider, time.Now()),
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{"ng1": buildTestNodeInfo(n1)}
+	cores, memory, err := clusterResources(context, nodeInfos)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2+1+4+2), cores)
+	assert.Equal(t, int64(4000), memory)
+
+	// Requested nodes of unknown capacity fail the check instead of being left out.
+	_, _, err = clusterResources(context, nil)
+	assert.Error(t, err)
+	context.NodeTemplates = map[string]*kube_api.Node{"ng1": n1}
+	cores, _, err = clusterResources(context, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9), cores)
+
+	// So does a node group that is scaled up without any known capacity.
+	context.ResourceLimits = &ResourceLimits{MaxCores: 100}
+	for _, nodeGroup := range provider.NodeGroups() {
+		if nodeGroup.Id() == "ng3" {
+			newSize, _, err := capSizeToResourceLimits(context, nodeGroup, 0, 2, nodeInfos)
+			assert.Error(t, err)
+			assert.Equal(t, 0, newSize)
+		}
+	}
+
+	context.ClusterSnapshot = nil
+	_, _, err = clusterResources(context, nodeInfos)
+	assert.Error(t, err)
+}
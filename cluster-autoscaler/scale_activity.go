@@ -0,0 +1,82 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ScaleActivity keeps the time of the last scale up and the last scale down of every node group, so
+// that operators can correlate scaling with load and find node groups that never scale. It is safe
+// for concurrent use and all methods are safe to call on nil ScaleActivity.
+type ScaleActivity struct {
+	mutex         sync.Mutex
+	lastScaleUp   map[string]time.Time
+	lastScaleDown map[string]time.Time
+}
+
+// NewScaleActivity builds ScaleActivity.
+func NewScaleActivity() *ScaleActivity {
+	return &ScaleActivity{
+		lastScaleUp:   make(map[string]time.Time),
+		lastScaleDown: make(map[string]time.Time),
+	}
+}
+
+// RegisterScaleUp records a successful scale up of the node group.
+func (a *ScaleActivity) RegisterScaleUp(nodeGroupId string, now time.Time) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.lastScaleUp[nodeGroupId] = now
+	nodeGroupLastScaleUpTimestamp.WithLabelValues(nodeGroupId).Set(float64(now.Unix()))
+}
+
+// RegisterScaleDown records a node of the node group that was successfully removed.
+func (a *ScaleActivity) RegisterScaleDown(nodeGroupId string, now time.Time) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.lastScaleDown[nodeGroupId] = now
+	nodeGroupLastScaleDownTimestamp.WithLabelValues(nodeGroupId).Set(float64(now.Unix()))
+}
+
+// LastScaleUp returns the time of the last scale up of the node group, zero if it wasn't scaled up yet.
+func (a *ScaleActivity) LastScaleUp(nodeGroupId string) time.Time {
+	if a == nil {
+		return time.Time{}
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.lastScaleUp[nodeGroupId]
+}
+
+// LastScaleDown returns the time of the last scale down of the node group, zero if it wasn't scaled
+// down yet.
+func (a *ScaleActivity) LastScaleDown(nodeGroupId string) time.Time {
+	if a == nil {
+		return time.Time{}
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.lastScaleDown[nodeGroupId]
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaleActivity(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+
+	provider := test.NewTestCloudProvider(func(id string, delta int) error { return nil },
+		func(id string, node string) error { return nil })
+	provider.AddNodeGroup("activity-ng1", 1, 10, 1)
+	provider.AddNodeGroup("activity-ng2", 1, 10, 2)
+	provider.AddNode("activity-ng1", n1)
+	provider.AddNode("activity-ng2", n2)
+	provider.AddNode("activity-ng2", n3)
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           kube_record.NewFakeRecorder(10),
+		EstimatorName:      BinpackingEstimatorName,
+		MaxEmptyBulkDelete: 10,
+		ScaleActivity:      NewScaleActivity(),
+	}
+	scaleUpGauge := nodeGroupLastScaleUpTimestamp.WithLabelValues("activity-ng1")
+	scaleDownGauge := nodeGroupLastScaleDownTimestamp.WithLabelValues("activity-ng2")
+	assert.Equal(t, float64(0), metricValue(t, scaleUpGauge))
+	assert.Equal(t, float64(0), metricValue(t, scaleDownGauge))
+
+	// Only ng1 has a node info, so the pending pod goes there.
+	p1 := BuildTestPod("p1", 500, 0)
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"activity-ng1": buildTestNodeInfo(n1),
+	}
+	before := time.Now()
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1, n2, n3}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	lastScaleUp := context.ScaleActivity.LastScaleUp("activity-ng1")
+	assert.False(t, lastScaleUp.Before(before.Truncate(time.Second)))
+	assert.Equal(t, float64(lastScaleUp.Unix()), metricValue(t, scaleUpGauge))
+	assert.True(t, context.ScaleActivity.LastScaleDown("activity-ng1").IsZero())
+
+	// The empty n3 is removed.
+	result, err := ScaleDown(context, []*kube_api.Node{n1, n2, n3}, map[string]float64{}, map[string]time.Time{"n3": time.Now().Add(-time.Hour)},
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+	lastScaleDown := context.ScaleActivity.LastScaleDown("activity-ng2")
+	assert.False(t, lastScaleDown.IsZero())
+	assert.Equal(t, float64(lastScaleDown.Unix()), metricValue(t, scaleDownGauge))
+	assert.True(t, context.ScaleActivity.LastScaleUp("activity-ng2").IsZero())
+}
@@ -19,14 +19,15 @@ package main
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
 	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
 	"k8s.io/contrib/cluster-autoscaler/simulator"
+	kube_util "k8s.io/contrib/cluster-autoscaler/utils/kubernetes"
 	kube_api "k8s.io/kubernetes/pkg/api"
 	kube_record "k8s.io/kubernetes/pkg/client/record"
-	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/golang/glog"
@@ -44,32 +45,42 @@ const (
 	ScaleDownNoNodeDeleted ScaleDownResult = iota
 	// ScaleDownNodeDeleted - a node was deleted.
 	ScaleDownNodeDeleted ScaleDownResult = iota
+	// ScaleDownNodeDeleteStarted - a node is drained and deleted in the background.
+	ScaleDownNodeDeleteStarted ScaleDownResult = iota
+	// ScaleDownInProgress - a node is still being drained or empty nodes are collected to be deleted
+	// in a batch. Neither a deletion nor a failure.
+	ScaleDownInProgress ScaleDownResult = iota
 )
 
 // FindUnneededNodes calculates which nodes are not needed, i.e. all pods can be scheduled somewhere else,
 // and updates unneededNodes map accordingly. It also returns information where pods can be rescheduld and
-// node utilization level.
+// node utilization level. The decision about every node replaces the previous ones in reports, which may be nil.
 func FindUnneededNodes(nodes []*kube_api.Node,
 	unneededNodes map[string]time.Time,
 	utilizationThreshold float64,
+	ignoreDaemonSetsUtilization bool,
 	pods []*kube_api.Pod,
 	predicateChecker *simulator.PredicateChecker,
 	oldHints map[string]string,
 	tracker *simulator.UsageTracker,
-	timestamp time.Time) (unnededTimeMap map[string]time.Time, podReschedulingHints map[string]string, utilizationMap map[string]float64) {
+	timestamp time.Time,
+	reports *ScaleDownReports) (unnededTimeMap map[string]time.Time, podReschedulingHints map[string]string, utilizationMap map[string]float64) {
 
 	currentlyUnneededNodes := make([]*kube_api.Node, 0)
+	// Completed pods neither use the capacity of their nodes nor have to be moved.
+	pods = kube_util.FilterOutTerminalPods(pods)
 	nodeNameToNodeInfo := schedulercache.CreateNodeNameToInfoMap(pods)
 	utilizationMap = make(map[string]float64)
+	reports.Reset()
 
 	// Phase1 - look at the nodes utilization.
 	for _, node := range nodes {
 		nodeInfo, found := nodeNameToNodeInfo[node.Name]
 		if !found {
-			glog.Errorf("Node info for %s not found", node.Name)
-			continue
+			// The node has no pods.
+			nodeInfo = schedulercache.NewNodeInfo()
 		}
-		utilization, err := simulator.CalculateUtilization(node, nodeInfo)
+		utilization, err := simulator.CalculateUtilization(node, nodeInfo, ignoreDaemonSetsUtilization)
 
 		if err != nil {
 			glog.Warningf("Failed to calculate utilization for %s: %v", node.Name, err)
@@ -79,22 +90,28 @@ func FindUnneededNodes(nodes []*kube_api.Node,
 
 		if utilization >= utilizationThreshold {
 			glog.V(4).Infof("Node %s is not suitable for removal - utilization too big (%f)", node.Name, utilization)
+			reports.Record(node.Name, ScaleDownReport{
+				Time:    timestamp,
+				Reason:  ScaleDownReasonHighUtilization,
+				Message: fmt.Sprintf("utilization %f is not below the threshold %f", utilization, utilizationThreshold),
+			})
 			continue
 		}
 		currentlyUnneededNodes = append(currentlyUnneededNodes, node)
 	}
 
 	// Phase2 - check which nodes can be probably removed using fast drain.
-	nodesToRemove, newHints, err := simulator.FindNodesToRemove(currentlyUnneededNodes, nodes, pods,
+	nodesToRemove, unremovable, newHints, err := simulator.FindNodesToRemove(currentlyUnneededNodes, nodes, pods,
 		nil, predicateChecker,
 		len(currentlyUnneededNodes), true, oldHints, tracker, timestamp)
 	if err != nil {
 		glog.Errorf("Error while simulating node drains: %v", err)
 		return map[string]time.Time{}, oldHints, map[string]float64{}
 	}
+	recordUnremovableNodes(reports, unremovable, timestamp)
 
 	// Update the timestamp map.
-	now := time.Now()
+	now := timestamp
 	result := make(map[string]time.Time)
 	for _, node := range nodesToRemove {
 		name := node.Node.Name
@@ -103,78 +120,211 @@ func FindUnneededNodes(nodes []*kube_api.Node,
 		} else {
 			result[name] = val
 		}
+		reports.Record(name, ScaleDownReport{
+			Time:      timestamp,
+			Removable: true,
+			Reason:    ScaleDownReasonUnneeded,
+			Message:   fmt.Sprintf("unneeded since %s", result[name]),
+		})
 	}
 	return result, newHints, utilizationMap
 }
 
+// recordUnremovableNodes records the reasons of the simulator for not removing the nodes.
+func recordUnremovableNodes(reports *ScaleDownReports, unremovable []simulator.UnremovableNode, now time.Time) {
+	for _, node := range unremovable {
+		reports.Record(node.Node.Name, ScaleDownReport{
+			Time:    now,
+			Reason:  ScaleDownReasonPodsCannotMove,
+			Message: node.Reason,
+		})
+	}
+}
+
+// recordSkippedNode records why an unneeded node is skipped in scale down.
+func recordSkippedNode(context *AutoscalingContext, node *kube_api.Node, reason string, format string, args ...interface{}) {
+	context.ScaleDownReports.Record(node.Name, ScaleDownReport{
+		Time:    context.Now(),
+		Reason:  reason,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
 // ScaleDown tries to scale down the cluster. It returns ScaleDownResult indicating if any node was
 // removed and error if such occured.
 func ScaleDown(
+	context *AutoscalingContext,
 	nodes []*kube_api.Node,
 	lastUtilizationMap map[string]float64,
 	unneededNodes map[string]time.Time,
-	unneededTime time.Duration,
 	pods []*kube_api.Pod,
-	cloudProvider cloudprovider.CloudProvider,
-	client *kube_client.Client,
-	predicateChecker *simulator.PredicateChecker,
 	oldHints map[string]string,
-	usageTracker *simulator.UsageTracker,
-	recorder kube_record.EventRecorder,
-	maxEmptyBulkDelete int) (ScaleDownResult, error) {
+	usageTracker *simulator.UsageTracker) (ScaleDownResult, error) {
 
-	now := time.Now()
-	candidates := make([]*kube_api.Node, 0)
+	pods = kube_util.FilterOutTerminalPods(pods)
+	// Nodes that are being deleted are neither removed again nor can take pods of other nodes.
+	nodes = context.NodeDeletionTracker.FilterOutNodesBeingDeleted(nodes)
+	// Only one node is drained at a time. The result of its removal is reported once it is over.
+	if nodeName := context.NodeDeletionTracker.DrainingNode(); nodeName != "" {
+		glog.V(1).Infof("No scale down - %s is being drained", nodeName)
+		return ScaleDownInProgress, nil
+	}
+	if drain := context.NodeDeletionTracker.TakeDrainResult(); drain != nil {
+		if drain.Result == ScaleDownNodeDeleted {
+			recordNodeRemoval(context, drain)
+		} else {
+			delete(unneededNodes, drain.NodeName)
+		}
+		return drain.Result, drain.Err
+	}
+	now := context.Now()
+	unneededLongEnough := make([]*kube_api.Node, 0)
 	for _, node := range nodes {
 		if val, found := unneededNodes[node.Name]; found {
 
 			glog.V(2).Infof("%s was unneeded for %s", node.Name, now.Sub(val).String())
 
 			// Check how long the node was underutilized.
-			if !val.Add(unneededTime).Before(now) {
-				continue
-			}
-
-			nodeGroup, err := cloudProvider.NodeGroupForNode(node)
-			if err != nil {
-				glog.Errorf("Error while checking node group for %s: %v", node.Name, err)
-				continue
-			}
-			if nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
-				glog.V(4).Infof("Skipping %s - no node group config", node.Name)
+			if !val.Add(context.ScaleDownUnneededTime).Before(now) {
 				continue
 			}
+			unneededLongEnough = append(unneededLongEnough, node)
+		}
+	}
 
-			size, err := nodeGroup.TargetSize()
-			if err != nil {
-				glog.Errorf("Error while checking node group size %s: %v", nodeGroup.Id(), err)
-				continue
-			}
+	nodeGroups, err := context.CloudProvider.NodeGroupsForNodes(unneededLongEnough)
+	if err != nil {
+		return ScaleDownError, fmt.Errorf("failed to get node groups of unneeded nodes: %v", err)
+	}
+	candidates := make([]*kube_api.Node, 0)
+	for _, node := range unneededLongEnough {
+		nodeGroup, found := nodeGroups[node.Name]
+		if !found || nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+			glog.V(4).Infof("Skipping %s - no node group config", node.Name)
+			recordSkippedNode(context, node, ScaleDownReasonNoNodeGroup, "node doesn't belong to a node group")
+			continue
+		}
+		if context.DisabledNodeGroups[nodeGroup.Id()] {
+			glog.V(4).Infof("Skipping %s - node group %s disabled", node.Name, nodeGroup.Id())
+			recordSkippedNode(context, node, ScaleDownReasonNodeGroupDisabled, "node group %s is disabled", nodeGroup.Id())
+			continue
+		}
+		if context.UnreadyNodeGroups[nodeGroup.Id()] {
+			glog.V(4).Infof("Skipping %s - node group %s not ready", node.Name, nodeGroup.Id())
+			recordSkippedNode(context, node, ScaleDownReasonNodeGroupNotReady, "node group %s has unready nodes", nodeGroup.Id())
+			continue
+		}
+		if context.NewNodeGroups[nodeGroup.Id()] {
+			glog.V(4).Infof("Skipping %s - node group %s registered recently", node.Name, nodeGroup.Id())
+			recordSkippedNode(context, node, ScaleDownReasonNodeGroupNew, "node group %s was registered recently", nodeGroup.Id())
+			continue
+		}
 
-			if size <= nodeGroup.MinSize() {
-				glog.V(1).Infof("Skipping %s - node group min size reached", node.Name)
-				continue
-			}
+		size, err := targetSize(context, nodeGroup)
+		if err != nil {
+			glog.Errorf("Error while checking node group size %s: %v", nodeGroup.Id(), err)
+			continue
+		}
 
-			candidates = append(candidates, node)
+		if minSize := scaleDownMinSize(context, nodeGroup); size <= minSize {
+			glog.V(1).Infof("Skipping %s - node group min size reached", node.Name)
+			recordSkippedNode(context, node, ScaleDownReasonMinSizeReached, "node group %s is at its min size %d", nodeGroup.Id(),
+				minSize)
+			continue
 		}
+
+		candidates = append(candidates, node)
 	}
 	if len(candidates) == 0 {
 		glog.Infof("No candidates for scale down")
 		return ScaleDownNoUnneeded, nil
 	}
+	sortNodesForRemoval(candidates, pods)
+
+	allowedCandidates, err := filterOutNodesBelowResourceMin(context, candidates)
+	if err != nil {
+		return ScaleDownError, fmt.Errorf("failed to check cluster resources: %v", err)
+	}
+	if len(allowedCandidates) < len(candidates) {
+		allowed := make(map[string]bool)
+		for _, node := range allowedCandidates {
+			allowed[node.Name] = true
+		}
+		for _, node := range candidates {
+			if !allowed[node.Name] {
+				recordSkippedNode(context, node, ScaleDownReasonMinResourcesReached, "min cluster cores or memory total reached")
+			}
+		}
+	}
+	candidates = allowedCandidates
+	if len(candidates) == 0 {
+		glog.V(1).Infof("No scale down - min cluster cores or memory total reached")
+		return ScaleDownNoNodeDeleted, nil
+	}
+
+	maxEmptyBulkDelete := context.MaxEmptyBulkDelete
+	if context.MinNodesTotal > 0 {
+		totalSize, err := clusterTargetSize(context)
+		if err != nil {
+			return ScaleDownError, fmt.Errorf("failed to get cluster size: %v", err)
+		}
+		if totalSize <= context.MinNodesTotal {
+			glog.V(1).Infof("No scale down - min cluster total size (%d) reached", context.MinNodesTotal)
+			return ScaleDownNoNodeDeleted, nil
+		}
+		if totalSize-context.MinNodesTotal < maxEmptyBulkDelete {
+			maxEmptyBulkDelete = totalSize - context.MinNodesTotal
+		}
+	}
 
 	// Trying to delete empty nodes in bulk. If there are no empty nodes then CA will
 	// try to delete not-so-empty nodes, possibly killing some pods and allowing them
 	// to recreate on other nodes.
-	emptyNodes := getEmptyNodes(candidates, pods, maxEmptyBulkDelete, cloudProvider)
-	if len(emptyNodes) > 0 {
+	emptyNodes := getEmptyNodes(context, candidates, pods, maxEmptyBulkDelete, nodeGroups)
+	if context.ScaleDownVeto != nil && len(emptyNodes) > 0 {
+		vetoed := make(map[string]bool)
+		allowed := make([]*kube_api.Node, 0, len(emptyNodes))
+		for _, node := range emptyNodes {
+			if scaleDownVetoed(context, node, podsOnNode(node, pods)) {
+				recordSkippedNode(context, node, ScaleDownReasonVetoed, "removal vetoed")
+				vetoed[node.Name] = true
+				continue
+			}
+			allowed = append(allowed, node)
+		}
+		emptyNodes = allowed
+		if len(vetoed) > 0 {
+			// Vetoed nodes are skipped in this iteration.
+			remaining := make([]*kube_api.Node, 0, len(candidates))
+			for _, node := range candidates {
+				if !vetoed[node.Name] {
+					remaining = append(remaining, node)
+				}
+			}
+			candidates = remaining
+		}
+	}
+	if context.NodeDeletionBatcher != nil {
+		// Empty nodes waiting in a batch are not drained in the meantime.
+		batches := context.NodeDeletionBatcher.Update(emptyNodes, nodeGroups, now)
+		if len(emptyNodes) > 0 {
+			return deleteNodeBatches(context, batches, lastUtilizationMap, usageTracker, unneededNodes)
+		}
+	} else if len(emptyNodes) > 0 {
 		confirmation := make(chan error, len(emptyNodes))
 		for _, node := range emptyNodes {
 			glog.V(0).Infof("Scale-down: removing empty node %s", node.Name)
 			simulator.RemoveNodeFromTracker(usageTracker, node.Name, unneededNodes)
 			go func(nodeToDelete *kube_api.Node) {
-				confirmation <- deleteNodeFromCloudProvider(nodeToDelete, cloudProvider, recorder)
+				err := deleteNodeFromCloudProvider(nodeToDelete, context.CloudProvider, context.Recorder, context.NodeDeletionTracker,
+					context.Now())
+				context.CircuitBreaker.RecordResult(err, context.Now())
+				if err == nil {
+					registerSizeChange(context, nodeGroups[nodeToDelete.Name], -1)
+					context.ScaleActivity.RegisterScaleDown(nodeGroups[nodeToDelete.Name].Id(), context.Now())
+					recordScaleDownSummary(context, nodeToDelete, nodeGroups[nodeToDelete.Name], lastUtilizationMap[nodeToDelete.Name], 0)
+				}
+				confirmation <- err
 			}(node)
 		}
 		var finalError error
@@ -191,57 +341,183 @@ func ScaleDown(
 	}
 
 	// We look for only 1 node so new hints may be incomplete.
-	nodesToRemove, _, err := simulator.FindNodesToRemove(candidates, nodes, pods, client, predicateChecker, 1, false,
-		oldHints, usageTracker, time.Now())
+	nodesToRemove, unremovable, _, err := simulator.FindNodesToRemove(candidates, nodes, pods, context.ClientSet, context.PredicateChecker, 1, false,
+		oldHints, usageTracker, context.Now())
 
 	if err != nil {
 		return ScaleDownError, fmt.Errorf("Find node to remove failed: %v", err)
 	}
+	recordUnremovableNodes(context.ScaleDownReports, unremovable, context.Now())
 	if len(nodesToRemove) == 0 {
 		glog.V(1).Infof("No node to remove")
 		return ScaleDownNoNodeDeleted, nil
 	}
 	toRemove := nodesToRemove[0]
+	if scaleDownVetoed(context, toRemove.Node, toRemove.PodsToReschedule) {
+		recordSkippedNode(context, toRemove.Node, ScaleDownReasonVetoed, "removal vetoed")
+		return ScaleDownNoNodeDeleted, nil
+	}
 	utilization := lastUtilizationMap[toRemove.Node.Name]
 	podNames := make([]string, 0, len(toRemove.PodsToReschedule))
 	for _, pod := range toRemove.PodsToReschedule {
 		podNames = append(podNames, pod.Namespace+"/"+pod.Name)
 	}
-	glog.V(0).Infof("Scale-down: removing node %s, utilization: %v, pods to reschedule: ", toRemove.Node.Name, utilization,
+	glog.V(0).Infof("Scale-down: removing node %s, utilization: %v, pods to reschedule: %s", toRemove.Node.Name, utilization,
 		strings.Join(podNames, ","))
 
 	simulator.RemoveNodeFromTracker(usageTracker, toRemove.Node.Name, unneededNodes)
-	err = deleteNodeFromCloudProvider(toRemove.Node, cloudProvider, recorder)
+	removal := NodeDrainResult{
+		NodeName:    toRemove.Node.Name,
+		Node:        toRemove.Node,
+		NodeGroup:   nodeGroups[toRemove.Node.Name],
+		Utilization: utilization,
+		Pods:        len(toRemove.PodsToReschedule),
+	}
+	tracker := context.NodeDeletionTracker
+	if tracker == nil {
+		removal.Result, removal.Err = removeNode(context, toRemove.Node, toRemove.PodsToReschedule)
+		removal.FinishTime = context.Now()
+		if removal.Result == ScaleDownNodeDeleted {
+			recordNodeRemoval(context, &removal)
+		} else {
+			delete(unneededNodes, toRemove.Node.Name)
+		}
+		return removal.Result, removal.Err
+	}
+	// Evictions may be retried for up to MaxPodEvictionTime, which must not block the scan. Only the
+	// removal runs in the background, the scan that takes its result records it.
+	tracker.StartDrain(toRemove.Node.Name)
+	go func() {
+		removal.Result, removal.Err = removeNode(context, toRemove.Node, toRemove.PodsToReschedule)
+		removal.FinishTime = context.Now()
+		tracker.FinishDrain(removal)
+	}()
+	return ScaleDownNodeDeleteStarted, nil
+}
+
+// recordNodeRemoval records the deletion of a node in the scan that reports it. The size change is
+// registered in the cluster snapshot only if the node was deleted after the snapshot was taken,
+// otherwise the target size read from the cloud provider already counts it.
+func recordNodeRemoval(context *AutoscalingContext, removal *NodeDrainResult) {
+	if context.ClusterSnapshot != nil && !removal.FinishTime.Before(context.ClusterSnapshot.Time) {
+		registerSizeChange(context, removal.NodeGroup, -1)
+	}
+	context.ScaleActivity.RegisterScaleDown(removal.NodeGroup.Id(), removal.FinishTime)
+	recordScaleDownSummary(context, removal.Node, removal.NodeGroup, removal.Utilization, removal.Pods)
+}
+
+// removeNode cordons and drains the node and deletes it from the cloud provider. If draining or
+// deleting fails the node is uncordoned, so it rejoins the schedulable pool. The caller drops it from
+// the unneeded nodes then, so it has to be unneeded for another ScaleDownUnneededTime before it is
+// retried.
+func removeNode(context *AutoscalingContext, node *kube_api.Node, pods []*kube_api.Pod) (ScaleDownResult, error) {
+	cordoned, err := cordonNode(context, node)
+	if err != nil {
+		return ScaleDownError, fmt.Errorf("Failed to cordon %s: %v", node.Name, err)
+	}
+	rollback := func() {
+		if cordoned {
+			uncordonNode(context, node)
+		}
+	}
+
+	if err := drainNode(context, node, pods); err != nil {
+		glog.Warningf("Skipping scale down of %s: %v", node.Name, err)
+		context.Recorder.Eventf(node, kube_api.EventTypeWarning, ReasonScaleDownFailed, "failed to drain node: %v", err)
+		recordSkippedNode(context, node, ScaleDownReasonDrainFailed, "failed to drain node: %v", err)
+		rollback()
+		skippedScaleDowns.Inc()
+		return ScaleDownNoNodeDeleted, nil
+	}
+	err = deleteNodeFromCloudProvider(node, context.CloudProvider, context.Recorder, context.NodeDeletionTracker, context.Now())
+	context.CircuitBreaker.RecordResult(err, context.Now())
 	if err != nil {
-		return ScaleDownError, fmt.Errorf("Failed to delete %s: %v", toRemove.Node.Name, err)
+		rollback()
+		return ScaleDownError, fmt.Errorf("Failed to delete %s: %v", node.Name, err)
 	}
 	return ScaleDownNodeDeleted, nil
 }
 
+// scaleDownDelays holds how long scale down is suppressed after other autoscaler actions.
+type scaleDownDelays struct {
+	// afterAdd keeps the autoscaler from removing nodes it has just added.
+	afterAdd time.Duration
+	// afterDelete spaces out consecutive node deletions, giving the cluster time to rebalance.
+	afterDelete time.Duration
+	// afterFailure backs off after a failed scale down.
+	afterFailure time.Duration
+}
+
+// scaleDownDelayed returns true if scale down is suppressed because the last scale up, the last
+// node deletion or the last failed scale down happened within its delay.
+func scaleDownDelayed(delays scaleDownDelays, lastScaleUpTime, lastScaleDownDeleteTime, lastScaleDownFailTime, now time.Time) bool {
+	return lastScaleUpTime.Add(delays.afterAdd).After(now) ||
+		lastScaleDownDeleteTime.Add(delays.afterDelete).After(now) ||
+		lastScaleDownFailTime.Add(delays.afterFailure).After(now)
+}
+
+// clusterTargetSize returns the sum of target sizes of all node groups.
+func clusterTargetSize(context *AutoscalingContext) (int, error) {
+	total := 0
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		size, err := targetSize(context, nodeGroup)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get size of %s: %v", nodeGroup.Id(), err)
+		}
+		total += size
+	}
+	return total, nil
+}
+
+// sortNodesForRemoval orders scale down candidates so that nodes running fewer pods come first,
+// to minimize disruption, and among them older nodes come first, so that old nodes get retired.
+func sortNodesForRemoval(nodes []*kube_api.Node, pods []*kube_api.Pod) {
+	podCount := make(map[string]int)
+	for _, pod := range pods {
+		podCount[pod.Spec.NodeName]++
+	}
+	sort.Sort(byPodCountAndAge{nodes: nodes, podCount: podCount})
+}
+
+type byPodCountAndAge struct {
+	nodes    []*kube_api.Node
+	podCount map[string]int
+}
+
+func (a byPodCountAndAge) Len() int      { return len(a.nodes) }
+func (a byPodCountAndAge) Swap(i, j int) { a.nodes[i], a.nodes[j] = a.nodes[j], a.nodes[i] }
+func (a byPodCountAndAge) Less(i, j int) bool {
+	ni, nj := a.nodes[i], a.nodes[j]
+	if a.podCount[ni.Name] != a.podCount[nj.Name] {
+		return a.podCount[ni.Name] < a.podCount[nj.Name]
+	}
+	if !ni.CreationTimestamp.Equal(nj.CreationTimestamp) {
+		return ni.CreationTimestamp.Before(nj.CreationTimestamp)
+	}
+	return ni.Name < nj.Name
+}
+
 // This functions finds empty nodes among passed candidates and returns a list of empty nodes
 // that can be deleted at the same time.
-func getEmptyNodes(candidates []*kube_api.Node, pods []*kube_api.Pod, maxEmptyBulkDelete int, cloudProvider cloudprovider.CloudProvider) []*kube_api.Node {
-	emptyNodes := simulator.FindEmptyNodesToRemove(candidates, pods)
+func getEmptyNodes(context *AutoscalingContext, candidates []*kube_api.Node, pods []*kube_api.Pod, maxEmptyBulkDelete int,
+	nodeGroups map[string]cloudprovider.NodeGroup) []*kube_api.Node {
+	emptyNodes := simulator.FindEmptyNodesToRemove(candidates, FilterOutIgnorablePods(pods, context.IgnorablePods))
 	availabilityMap := make(map[string]int)
 	result := make([]*kube_api.Node, 0)
 	for _, node := range emptyNodes {
-		nodeGroup, err := cloudProvider.NodeGroupForNode(node)
-		if err != nil {
-			glog.Errorf("Failed to get group for %s", node.Name)
-			continue
-		}
-		if nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+		nodeGroup, hasGroup := nodeGroups[node.Name]
+		if !hasGroup || nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
 			continue
 		}
 		var available int
 		var found bool
-		if _, found = availabilityMap[nodeGroup.Id()]; !found {
-			size, err := nodeGroup.TargetSize()
+		if available, found = availabilityMap[nodeGroup.Id()]; !found {
+			size, err := targetSize(context, nodeGroup)
 			if err != nil {
 				glog.Errorf("Failed to get size for %s: %v ", nodeGroup.Id(), err)
 				continue
 			}
-			available = size - nodeGroup.MinSize()
+			available = size - scaleDownMinSize(context, nodeGroup)
 			if available < 0 {
 				available = 0
 			}
@@ -260,17 +536,54 @@ func getEmptyNodes(candidates []*kube_api.Node, pods []*kube_api.Pod, maxEmptyBu
 	return result[:limit]
 }
 
-func deleteNodeFromCloudProvider(node *kube_api.Node, cloudProvider cloudprovider.CloudProvider, recorder kube_record.EventRecorder) error {
+// deleteNodeFromCloudProvider deletes the node from its node group. If tracker is not nil, a node
+// whose deletion is already in progress at the given time is not deleted again.
+func deleteNodeFromCloudProvider(node *kube_api.Node, cloudProvider cloudprovider.CloudProvider, recorder kube_record.EventRecorder,
+	tracker *NodeDeletionTracker, now time.Time) error {
 	nodeGroup, err := cloudProvider.NodeGroupForNode(node)
 	if err != nil {
-		return fmt.Errorf("failed to node group for %s: %v", node.Name, err)
+		err = fmt.Errorf("failed to node group for %s: %v", node.Name, err)
+	} else if nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+		err = fmt.Errorf("picked node that doesn't belong to a node group: %s", node.Name)
+	}
+	if err != nil {
+		recorder.Eventf(node, kube_api.EventTypeWarning, ReasonScaleDownFailed, "failed to remove node: %v", err)
+		return err
+	}
+	_, err = deleteNodesFromNodeGroup(nodeGroup, []*kube_api.Node{node}, recorder, tracker, now)
+	return err
+}
+
+// deleteNodesFromNodeGroup deletes the nodes from the node group with a single DeleteNodes call and
+// returns the deleted nodes. If tracker is not nil, nodes whose deletion is already in progress at
+// the given time are not deleted again.
+func deleteNodesFromNodeGroup(nodeGroup cloudprovider.NodeGroup, nodes []*kube_api.Node, recorder kube_record.EventRecorder,
+	tracker *NodeDeletionTracker, now time.Time) ([]*kube_api.Node, error) {
+	toDelete := make([]*kube_api.Node, 0, len(nodes))
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if tracker != nil && !tracker.StartDeletion(node.Name, now) {
+			glog.V(1).Infof("Skipping deletion of %s - already in progress", node.Name)
+			continue
+		}
+		toDelete = append(toDelete, node)
+		names = append(names, node.Name)
 	}
-	if nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
-		return fmt.Errorf("picked node that doesn't belong to a node group: %s", node.Name)
+	if len(toDelete) == 0 {
+		return toDelete, nil
+	}
+	if err := nodeGroup.DeleteNodes(toDelete); err != nil {
+		err = fmt.Errorf("failed to delete %s: %v", strings.Join(names, ","), err)
+		for _, node := range toDelete {
+			if tracker != nil {
+				tracker.AbortDeletion(node.Name)
+			}
+			recorder.Eventf(node, kube_api.EventTypeWarning, ReasonScaleDownFailed, "failed to remove node: %v", err)
+		}
+		return nil, err
 	}
-	if err = nodeGroup.DeleteNodes([]*kube_api.Node{node}); err != nil {
-		return fmt.Errorf("failed to delete %s: %v", node.Name, err)
+	for _, node := range toDelete {
+		recorder.Eventf(node, kube_api.EventTypeNormal, ReasonScaleDown, "node removed by cluster autoscaler")
 	}
-	recorder.Eventf(node, kube_api.EventTypeNormal, "ScaleDown", "node removed by cluster autoscaler")
-	return nil
+	return toDelete, nil
 }
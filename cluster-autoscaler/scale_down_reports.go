@@ -0,0 +1,160 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Reasons of the scale down decisions recorded in ScaleDownReports.
+const (
+	// ScaleDownReasonUnneeded is recorded for nodes that could be removed, once they are unneeded
+	// for long enough.
+	ScaleDownReasonUnneeded = "Unneeded"
+	// ScaleDownReasonHighUtilization is recorded for nodes whose utilization is not below the
+	// scale down utilization threshold.
+	ScaleDownReasonHighUtilization = "HighUtilization"
+	// ScaleDownReasonPodsCannotMove is recorded for nodes running pods that can't be moved, e.g.
+	// unreplicated or kube-system pods, or that don't fit on any other node.
+	ScaleDownReasonPodsCannotMove = "PodsCannotMove"
+	// ScaleDownReasonNoNodeGroup is recorded for nodes that don't belong to any node group.
+	ScaleDownReasonNoNodeGroup = "NoNodeGroup"
+	// ScaleDownReasonNodeGroupDisabled is recorded for nodes of disabled node groups.
+	ScaleDownReasonNodeGroupDisabled = "NodeGroupDisabled"
+	// ScaleDownReasonNodeGroupNotReady is recorded for nodes of node groups with unready nodes.
+	ScaleDownReasonNodeGroupNotReady = "NodeGroupNotReady"
+	// ScaleDownReasonNodeGroupNew is recorded for nodes of node groups within their grace period
+	// after registration.
+	ScaleDownReasonNodeGroupNew = "NodeGroupNew"
+	// ScaleDownReasonMinSizeReached is recorded for nodes of node groups at their min size.
+	ScaleDownReasonMinSizeReached = "MinSizeReached"
+	// ScaleDownReasonMinResourcesReached is recorded for nodes whose removal would take the
+	// cluster below the min cores or memory total.
+	ScaleDownReasonMinResourcesReached = "MinResourcesReached"
+	// ScaleDownReasonVetoed is recorded for nodes whose removal was vetoed.
+	ScaleDownReasonVetoed = "Vetoed"
+	// ScaleDownReasonDrainFailed is recorded for nodes that couldn't be drained, e.g. because a
+	// PodDisruptionBudget refused the eviction of their pods.
+	ScaleDownReasonDrainFailed = "DrainFailed"
+)
+
+// ScaleDownReport describes the last scale down decision about a node.
+type ScaleDownReport struct {
+	// Time is when the decision was made.
+	Time time.Time `json:"time"`
+	// Removable is true if the node is unneeded and will be removed once it is unneeded for long enough.
+	Removable bool `json:"removable"`
+	// Reason is one of the ScaleDownReason constants.
+	Reason string `json:"reason"`
+	// Message explains the reason, e.g. with the utilization of the node or the pod that can't be moved.
+	Message string `json:"message,omitempty"`
+}
+
+// ScaleDownReports keeps the last ScaleDownReport of every node evaluated for scale down, so that
+// operators can find out why a node is not removed. It is safe for concurrent use and all methods
+// are safe to call on nil ScaleDownReports.
+type ScaleDownReports struct {
+	mutex   sync.Mutex
+	reports map[string]ScaleDownReport
+}
+
+// NewScaleDownReports builds ScaleDownReports.
+func NewScaleDownReports() *ScaleDownReports {
+	return &ScaleDownReports{
+		reports: make(map[string]ScaleDownReport),
+	}
+}
+
+// Reset drops the reports of all nodes. It is called before all nodes are evaluated again, so that
+// removed nodes don't keep their reports.
+func (r *ScaleDownReports) Reset() {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reports = make(map[string]ScaleDownReport)
+}
+
+// Record stores the report of the node, replacing the previous one.
+func (r *ScaleDownReports) Record(nodeName string, report ScaleDownReport) {
+	if r == nil {
+		return
+	}
+	glog.V(4).Infof("Scale down of %s: removable=%v reason=%s %s", nodeName, report.Removable, report.Reason, report.Message)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.reports[nodeName] = report
+}
+
+// Reports returns a copy of the most recent reports, keyed by node name.
+func (r *ScaleDownReports) Reports() map[string]ScaleDownReport {
+	result := make(map[string]ScaleDownReport)
+	if r == nil {
+		return result
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for name, report := range r.reports {
+		result[name] = report
+	}
+	return result
+}
+
+// ReasonCounts returns the number of nodes with each reason in the most recent reports. Unlike the
+// reports themselves, its size doesn't grow with the cluster, so it is written to the status
+// ConfigMap.
+func (r *ScaleDownReports) ReasonCounts() map[string]int {
+	result := make(map[string]int)
+	if r == nil {
+		return result
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, report := range r.reports {
+		result[report.Reason]++
+	}
+	return result
+}
+
+// ServeHTTP responds with the JSON encoded most recent reports, keyed by node name. With the node
+// query parameter only the report of the given node is returned.
+func (r *ScaleDownReports) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	reports := r.Reports()
+	if req.URL != nil {
+		if name := req.URL.Query().Get("node"); name != "" {
+			report, found := reports[name]
+			if !found {
+				http.Error(w, "no scale down report for node "+name, http.StatusNotFound)
+				return
+			}
+			reports = map[string]ScaleDownReport{name: report}
+		}
+	}
+	encoded, err := json.Marshal(reports)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(encoded)
+}
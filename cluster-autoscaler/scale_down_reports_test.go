@@ -0,0 +1,141 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindUnneededNodesRecordsReports(t *testing.T) {
+	replicated := map[string]string{
+		"kubernetes.io/created-by": "{\"kind\":\"SerializedReference\",\"apiVersion\":\"v1\",\"reference\":{\"kind\":\"ReplicaSet\"}}",
+	}
+	// Not replicated, so it can't be moved.
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.Spec.NodeName = "n1"
+	p2 := BuildTestPod("p2", 300, 0)
+	p2.Spec.NodeName = "n2"
+	p2.Annotations = replicated
+	p3 := BuildTestPod("p3", 400, 0)
+	p3.Spec.NodeName = "n3"
+	p3.Annotations = replicated
+	// Doesn't fit on any other node.
+	p4 := BuildTestPod("p4", 2000, 0)
+	p4.Spec.NodeName = "n4"
+	p4.Annotations = replicated
+
+	n1 := BuildTestNode("n1", 1000, 10)
+	n2 := BuildTestNode("n2", 1000, 10)
+	n3 := BuildTestNode("n3", 1000, 10)
+	n4 := BuildTestNode("n4", 10000, 10)
+
+	reports := NewScaleDownReports()
+	reports.Record("removed-node", ScaleDownReport{Reason: ScaleDownReasonUnneeded})
+	now := time.Now()
+	FindUnneededNodes([]*kube_api.Node{n1, n2, n3, n4}, map[string]time.Time{}, 0.35, false,
+		[]*kube_api.Pod{p1, p2, p3, p4}, simulator.NewTestPredicateChecker(), make(map[string]string),
+		simulator.NewUsageTracker(), now, reports)
+
+	result := reports.Reports()
+	assert.Equal(t, 4, len(result))
+	assert.Equal(t, ScaleDownReasonPodsCannotMove, result["n1"].Reason)
+	assert.Contains(t, result["n1"].Message, "p1")
+	assert.False(t, result["n1"].Removable)
+	assert.Equal(t, ScaleDownReasonUnneeded, result["n2"].Reason)
+	assert.True(t, result["n2"].Removable)
+	assert.Equal(t, ScaleDownReasonHighUtilization, result["n3"].Reason)
+	assert.False(t, result["n3"].Removable)
+	assert.Equal(t, ScaleDownReasonPodsCannotMove, result["n4"].Reason)
+	assert.Contains(t, result["n4"].Message, "failed to find place")
+	assert.Equal(t, now, result["n4"].Time)
+}
+
+func TestScaleDownRecordsMinSizeReached(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete: 10,
+		ScaleDownReports:   NewScaleDownReports(),
+	}
+	unneeded := map[string]time.Time{"n1": time.Now().Add(-time.Hour)}
+	result, err := ScaleDown(context, []*kube_api.Node{n1}, map[string]float64{}, unneeded,
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoUnneeded, result)
+	report := context.ScaleDownReports.Reports()["n1"]
+	assert.Equal(t, ScaleDownReasonMinSizeReached, report.Reason)
+	assert.Contains(t, report.Message, "ng1")
+}
+
+func TestNilScaleDownReports(t *testing.T) {
+	var reports *ScaleDownReports
+	reports.Reset()
+	reports.Record("n1", ScaleDownReport{Reason: ScaleDownReasonUnneeded})
+	assert.Empty(t, reports.Reports())
+	assert.Empty(t, reports.ReasonCounts())
+}
+
+func TestScaleDownReportsReasonCountsAndServeHTTP(t *testing.T) {
+	reports := NewScaleDownReports()
+	reports.Record("n1", ScaleDownReport{Removable: true, Reason: ScaleDownReasonUnneeded})
+	reports.Record("n2", ScaleDownReport{Reason: ScaleDownReasonHighUtilization, Message: "utilization 0.9"})
+	reports.Record("n3", ScaleDownReport{Reason: ScaleDownReasonHighUtilization, Message: "utilization 0.8"})
+
+	// The status only counts the nodes with each reason.
+	assert.Equal(t, map[string]int{ScaleDownReasonUnneeded: 1, ScaleDownReasonHighUtilization: 2}, reports.ReasonCounts())
+
+	recorder := httptest.NewRecorder()
+	reports.ServeHTTP(recorder, &http.Request{URL: &url.URL{Path: "/scaledown"}})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	served := make(map[string]ScaleDownReport)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &served))
+	assert.Equal(t, 3, len(served))
+	assert.True(t, served["n1"].Removable)
+
+	recorder = httptest.NewRecorder()
+	reports.ServeHTTP(recorder, &http.Request{URL: &url.URL{Path: "/scaledown", RawQuery: "node=n2"}})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	served = make(map[string]ScaleDownReport)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &served))
+	assert.Equal(t, map[string]ScaleDownReport{"n2": {Reason: ScaleDownReasonHighUtilization, Message: "utilization 0.9"}}, served)
+
+	recorder = httptest.NewRecorder()
+	reports.ServeHTTP(recorder, &http.Request{URL: &url.URL{Path: "/scaledown", RawQuery: "node=unknown"}})
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
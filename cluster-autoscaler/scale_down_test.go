@@ -17,13 +17,29 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
 	"testing"
 	"time"
 
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
 	"k8s.io/contrib/cluster-autoscaler/simulator"
 	. "k8s.io/contrib/cluster-autoscaler/utils/test"
 
 	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/testapi"
+	kube_api_unversioned "k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/pkg/client/restclient"
+	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/client/unversioned/fake"
+	"k8s.io/kubernetes/pkg/kubelet/types"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -55,9 +71,9 @@ func TestFindUnneededNodes(t *testing.T) {
 	n3 := BuildTestNode("n3", 1000, 10)
 	n4 := BuildTestNode("n4", 10000, 10)
 
-	result, hints, utilization := FindUnneededNodes([]*kube_api.Node{n1, n2, n3, n4}, map[string]time.Time{}, 0.35,
+	result, hints, utilization := FindUnneededNodes([]*kube_api.Node{n1, n2, n3, n4}, map[string]time.Time{}, 0.35, false,
 		[]*kube_api.Pod{p1, p2, p3, p4}, simulator.NewTestPredicateChecker(), make(map[string]string),
-		simulator.NewUsageTracker(), time.Now())
+		simulator.NewUsageTracker(), time.Now(), nil)
 
 	assert.Equal(t, 1, len(result))
 	addTime, found := result["n2"]
@@ -66,9 +82,9 @@ func TestFindUnneededNodes(t *testing.T) {
 	assert.Equal(t, 4, len(utilization))
 
 	result["n1"] = time.Now()
-	result2, hints, utilization := FindUnneededNodes([]*kube_api.Node{n1, n2, n3, n4}, result, 0.35,
+	result2, hints, utilization := FindUnneededNodes([]*kube_api.Node{n1, n2, n3, n4}, result, 0.35, false,
 		[]*kube_api.Pod{p1, p2, p3, p4}, simulator.NewTestPredicateChecker(), hints,
-		simulator.NewUsageTracker(), time.Now())
+		simulator.NewUsageTracker(), time.Now(), nil)
 
 	assert.Equal(t, 1, len(result2))
 	addTime2, found := result2["n2"]
@@ -76,3 +92,601 @@ func TestFindUnneededNodes(t *testing.T) {
 	assert.Equal(t, addTime, addTime2)
 	assert.Equal(t, 4, len(utilization))
 }
+
+func TestFindUnneededNodesWithSelector(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 10)
+	n1.Labels = map[string]string{"managed-by": "terraform"}
+	n2 := BuildTestNode("n2", 1000, 10)
+	n2.Labels = map[string]string{"managed-by": "manual"}
+	n3 := BuildTestNode("n3", 1000, 10)
+
+	pods := make([]*kube_api.Pod, 0)
+	for _, node := range []*kube_api.Node{n1, n2, n3} {
+		pod := BuildTestPod("mirror-"+node.Name, 100, 0)
+		pod.Annotations = map[string]string{types.ConfigMirrorAnnotationKey: ""}
+		pod.Spec.NodeName = node.Name
+		pods = append(pods, pod)
+	}
+
+	selector, err := labels.Parse("managed-by=terraform")
+	assert.NoError(t, err)
+	nodes := FilterNodesBySelector([]*kube_api.Node{n1, n2, n3}, selector)
+	assert.Equal(t, []*kube_api.Node{n1}, nodes)
+
+	// All nodes run only mirror pods, but only the managed one can be removed.
+	result, _, _ := FindUnneededNodes(nodes, map[string]time.Time{}, 0.35, false,
+		pods, simulator.NewTestPredicateChecker(), make(map[string]string),
+		simulator.NewUsageTracker(), time.Now(), nil)
+	assert.Equal(t, 1, len(result))
+	assert.Contains(t, result, "n1")
+}
+
+// fakeNodeCordoner keeps the unschedulable flags of nodes in memory.
+type fakeNodeCordoner struct {
+	unschedulable map[string]bool
+}
+
+func (c *fakeNodeCordoner) SetUnschedulable(node *kube_api.Node, unschedulable bool) error {
+	c.unschedulable[node.Name] = unschedulable
+	return nil
+}
+
+func TestRemoveNodeRollback(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.Spec.NodeName = "n1"
+
+	deleted := make([]string, 0)
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		return fmt.Errorf("cloud provider failure")
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	cordoner := &fakeNodeCordoner{unschedulable: make(map[string]bool)}
+	context := &AutoscalingContext{
+		CloudProvider: provider,
+		Recorder:      kube_record.NewFakeRecorder(10),
+		PodEvicter:    &fakePodEvicter{},
+		NodeCordoner:  cordoner,
+	}
+
+	// The node is cordoned and drained, but the cloud provider fails to delete it.
+	result, err := removeNode(context, n1, []*kube_api.Pod{p1})
+	assert.Error(t, err)
+	assert.Equal(t, ScaleDownError, result)
+	assert.Equal(t, map[string]bool{"n1": false}, cordoner.unschedulable)
+
+	// Nodes cordoned by someone else are left cordoned.
+	provider = test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	context.CloudProvider = provider
+	n1.Spec.Unschedulable = true
+	cordoner.unschedulable = make(map[string]bool)
+	result, err = removeNode(context, n1, []*kube_api.Pod{p1})
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+	assert.Empty(t, cordoner.unschedulable)
+	assert.Equal(t, []string{"n1"}, deleted)
+}
+
+func TestScaleDownDelayed(t *testing.T) {
+	now := time.Now()
+	last := now.Add(-time.Minute)
+	never := time.Time{}
+	delays := scaleDownDelays{afterAdd: 10 * time.Minute, afterDelete: 5 * time.Minute, afterFailure: 3 * time.Minute}
+
+	assert.False(t, scaleDownDelayed(delays, never, never, never, now))
+
+	// After scale up.
+	assert.True(t, scaleDownDelayed(delays, last, never, never, now))
+	assert.True(t, scaleDownDelayed(delays, last, never, never, now.Add(8*time.Minute)))
+	assert.False(t, scaleDownDelayed(delays, last, never, never, now.Add(10*time.Minute)))
+	assert.False(t, scaleDownDelayed(scaleDownDelays{}, last, never, never, now))
+
+	// After node deletion.
+	assert.True(t, scaleDownDelayed(delays, never, last, never, now))
+	assert.True(t, scaleDownDelayed(delays, never, last, never, now.Add(3*time.Minute)))
+	assert.False(t, scaleDownDelayed(delays, never, last, never, now.Add(4*time.Minute)))
+
+	// After failed scale down.
+	assert.True(t, scaleDownDelayed(delays, never, never, last, now))
+	assert.True(t, scaleDownDelayed(delays, never, never, last, now.Add(time.Minute)))
+	assert.False(t, scaleDownDelayed(delays, never, never, last, now.Add(2*time.Minute)))
+}
+
+func TestScaleDownPrefersEmptiestOldestNode(t *testing.T) {
+	now := time.Now()
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n1.CreationTimestamp = kube_api_unversioned.NewTime(now.Add(-1 * time.Hour))
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n2.CreationTimestamp = kube_api_unversioned.NewTime(now.Add(-3 * time.Hour))
+	n3 := BuildTestNode("n3", 1000, 1000)
+	n3.CreationTimestamp = kube_api_unversioned.NewTime(now.Add(-2 * time.Hour))
+	n4 := BuildTestNode("n4", 1000, 1000)
+	n4.CreationTimestamp = kube_api_unversioned.NewTime(now.Add(-5 * time.Hour))
+
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.Spec.NodeName = "n4"
+	pods := []*kube_api.Pod{p1}
+
+	candidates := []*kube_api.Node{n1, n2, n3, n4}
+	sortNodesForRemoval(candidates, pods)
+	assert.Equal(t, []*kube_api.Node{n2, n3, n1, n4}, candidates)
+
+	deleted := make([]string, 0)
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 4)
+	for _, node := range []*kube_api.Node{n1, n2, n3, n4} {
+		provider.AddNode("ng1", node)
+	}
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete: 1,
+	}
+	unneeded := map[string]time.Time{
+		"n1": now.Add(-time.Hour),
+		"n2": now.Add(-time.Hour),
+		"n3": now.Add(-time.Hour),
+		"n4": now.Add(-time.Hour),
+	}
+	result, err := ScaleDown(context, []*kube_api.Node{n1, n2, n3, n4}, map[string]float64{}, unneeded,
+		pods, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+	assert.Equal(t, []string{"n2"}, deleted)
+}
+
+func TestScaleDownDisabledNodeGroup(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+
+	deleted := make([]string, 0)
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete: 10,
+		DisabledNodeGroups: map[string]bool{"ng1": true},
+	}
+	unneeded := map[string]time.Time{
+		"n1": time.Now().Add(-time.Hour),
+		"n2": time.Now().Add(-time.Hour),
+	}
+	result, err := ScaleDown(context, []*kube_api.Node{n1, n2}, map[string]float64{}, unneeded,
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoUnneeded, result)
+	assert.Empty(t, deleted)
+}
+
+func TestScaleDownHintedNodeGroup(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	nodes := []*kube_api.Node{n1, n2, n3}
+
+	deleted := make([]string, 0)
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	provider.AddNode("ng1", n3)
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete: 10,
+		MinSizeHints:       map[string]int{"ng1": 3},
+	}
+	unneeded := map[string]time.Time{
+		"n1": time.Now().Add(-time.Hour),
+		"n2": time.Now().Add(-time.Hour),
+		"n3": time.Now().Add(-time.Hour),
+	}
+
+	// The empty nodes were added for the hint, so they are kept.
+	result, err := ScaleDown(context, nodes, map[string]float64{}, unneeded,
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoUnneeded, result)
+	assert.Empty(t, deleted)
+
+	// Nodes above the hinted size are removed, down to the hint.
+	context.MinSizeHints["ng1"] = 2
+	result, err = ScaleDown(context, nodes, map[string]float64{}, unneeded,
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+	assert.Equal(t, 1, len(deleted))
+}
+
+func TestScaleDownMinNodesTotal(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	n4 := BuildTestNode("n4", 1000, 1000)
+	nodes := []*kube_api.Node{n1, n2, n3, n4}
+
+	deleted := make([]string, 0)
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNodeGroup("ng2", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	provider.AddNode("ng2", n3)
+	provider.AddNode("ng2", n4)
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete: 10,
+		MinNodesTotal:      4,
+	}
+	unneeded := map[string]time.Time{
+		"n1": time.Now().Add(-time.Hour),
+		"n3": time.Now().Add(-time.Hour),
+	}
+
+	// Per-group minimums allow removing both n1 and n3, but the cluster is at its minimum.
+	result, err := ScaleDown(context, nodes, map[string]float64{}, unneeded,
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoNodeDeleted, result)
+	assert.Empty(t, deleted)
+
+	// Only one node can be removed without dropping below the cluster minimum.
+	context.MinNodesTotal = 3
+	result, err = ScaleDown(context, nodes, map[string]float64{}, unneeded,
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+	assert.Equal(t, 1, len(deleted))
+}
+
+func TestScaleDownNodeGroupToZero(t *testing.T) {
+	n1 := BuildTestNode("n1", 4000, 1000)
+	n2 := BuildTestNode("n2", 4000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+
+	deleted := make([]string, 0)
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	provider.AddNode("ng2", n3)
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete: 10,
+		EstimatorName:      BinpackingEstimatorName,
+	}
+	templates := make(map[string]*kube_api.Node)
+	assert.NoError(t, UpdateNodeTemplates([]*kube_api.Node{n1, n2, n3}, provider, templates))
+
+	unneeded := map[string]time.Time{
+		"n1": time.Now().Add(-time.Hour),
+		"n2": time.Now().Add(-time.Hour),
+	}
+	result, err := ScaleDown(context, []*kube_api.Node{n1, n2, n3}, map[string]float64{}, unneeded,
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+	sort.Strings(deleted)
+	assert.Equal(t, []string{"n1", "n2"}, deleted)
+	ng1, err := provider.NodeGroupForNode(n1)
+	assert.NoError(t, err)
+	size, err := ng1.TargetSize()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, size)
+
+	// The pod fits only on ng1 nodes, which are known from the template.
+	nodeInfos, err := GetNodeInfosForGroups([]*kube_api.Node{}, provider, nil, templates, nil)
+	assert.NoError(t, err)
+	p1 := BuildTestPod("p1", 2000, 0)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n3}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 1}, scaledGroups)
+}
+
+func TestScaleDownWithCompletedPod(t *testing.T) {
+	// A completed Job pod, using most of the node and without a controller that would recreate it.
+	p1 := BuildTestPod("p1", 800, 0)
+	p1.Spec.NodeName = "n1"
+	p1.Status.Phase = kube_api.PodSucceeded
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	nodes := []*kube_api.Node{n1, n2}
+	pods := []*kube_api.Pod{p1}
+
+	unneeded, _, utilization := FindUnneededNodes(nodes, map[string]time.Time{}, 0.5, false,
+		pods, simulator.NewTestPredicateChecker(), make(map[string]string),
+		simulator.NewUsageTracker(), time.Now(), nil)
+	assert.Contains(t, unneeded, "n1")
+	assert.Equal(t, float64(0), utilization["n1"])
+
+	deleted := make([]string, 0)
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete: 1,
+	}
+	result, err := ScaleDown(context, nodes, utilization, map[string]time.Time{"n1": time.Now().Add(-time.Hour)},
+		pods, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+	assert.Equal(t, []string{"n1"}, deleted)
+}
+
+func TestScaleDownEmptyNodeWithIgnorablePods(t *testing.T) {
+	// Unreplicated pods would block the removal of n1 if they were not ignorable.
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.Spec.NodeName = "n1"
+	p1.Labels = map[string]string{"role": "logging"}
+	p2 := BuildTestPod("p2", 100, 0)
+	p2.Spec.NodeName = "n1"
+	p2.Namespace = "infra"
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	nodes := []*kube_api.Node{n1, n2}
+	pods := []*kube_api.Pod{p1, p2}
+
+	deleted := make([]string, 0)
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete: 10,
+	}
+	unneeded := map[string]time.Time{"n1": time.Now().Add(-time.Hour)}
+	result, err := ScaleDown(context, nodes, map[string]float64{"n1": 0.2}, unneeded,
+		pods, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.NotEqual(t, ScaleDownNodeDeleted, result)
+	assert.Empty(t, deleted)
+
+	for _, value := range []string{":role=logging", "infra:"} {
+		selector, err := ParseIgnorablePodSelector(value)
+		assert.NoError(t, err)
+		context.IgnorablePods = append(context.IgnorablePods, selector)
+	}
+	result, err = ScaleDown(context, nodes, map[string]float64{"n1": 0.2}, unneeded,
+		pods, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+	assert.Equal(t, []string{"n1"}, deleted)
+}
+
+func TestScaleDownRecordsSummaryEvent(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+
+	recorder := kube_record.NewFakeRecorder(10)
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           recorder,
+		MaxEmptyBulkDelete: 10,
+		AutoscalerObject:   &kube_api.ObjectReference{Kind: "Deployment", Namespace: "kube-system", Name: "cluster-autoscaler"},
+	}
+	unneeded := map[string]time.Time{"n1": time.Now().Add(-time.Hour)}
+	result, err := ScaleDown(context, []*kube_api.Node{n1, n2}, map[string]float64{"n1": 0.1}, unneeded,
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+
+	// The node event is followed by the summary event.
+	assert.Equal(t, 2, len(recorder.Events))
+	assert.Contains(t, <-recorder.Events, ReasonScaleDown)
+	assert.Equal(t, "Normal ScaledDownNode node n1 removed from group ng1, utilization: 0.1, pods to reschedule: 0, new size: 1",
+		<-recorder.Events)
+}
+
+// blockingPodEvicter evicts pods once release is closed.
+type blockingPodEvicter struct {
+	fakePodEvicter
+	release chan struct{}
+}
+
+func (e *blockingPodEvicter) EvictPod(pod *kube_api.Pod) error {
+	<-e.release
+	return e.fakePodEvicter.EvictPod(pod)
+}
+
+// replicaSetClient builds a client that finds every ReplicaSet, so that their pods can be moved.
+func replicaSetClient(t *testing.T) *kube_client.Client {
+	header := http.Header{}
+	header.Set("Content-Type", runtime.ContentTypeJSON)
+	rs := &extensions.ReplicaSet{Spec: extensions.ReplicaSetSpec{Replicas: 2}}
+	body := runtime.EncodeOrDie(testapi.Extensions.Codec(), rs)
+	fakeClient := &fake.RESTClient{
+		Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			if req.Method != "GET" {
+				t.Errorf("unexpected request: %v %v", req.Method, req.URL)
+			}
+			return &http.Response{StatusCode: 200, Header: header, Body: ioutil.NopCloser(bytes.NewReader([]byte(body)))}, nil
+		}),
+	}
+	client := kube_client.NewOrDie(&restclient.Config{
+		ContentConfig: restclient.ContentConfig{
+			ContentType:  runtime.ContentTypeJSON,
+			GroupVersion: testapi.Default.GroupVersion(),
+		},
+	})
+	client.Client = fakeClient.Client
+	client.ExtensionsClient.Client = fakeClient.Client
+	return client
+}
+
+func waitForDrain(t *testing.T, tracker *NodeDeletionTracker) {
+	for i := 0; i < 100 && tracker.DrainingNode() != ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, "", tracker.DrainingNode())
+}
+
+func TestScaleDownDrainsInBackground(t *testing.T) {
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.Spec.NodeName = "n1"
+	p1.Annotations = map[string]string{
+		"kubernetes.io/created-by": "{\"kind\":\"SerializedReference\",\"apiVersion\":\"v1\",\"reference\":{\"kind\":\"ReplicaSet\",\"namespace\":\"default\",\"name\":\"rs\"}}",
+	}
+	p2 := BuildTestPod("p2", 100, 0)
+	p2.Spec.NodeName = "n2"
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	nodes := []*kube_api.Node{n1, n2}
+	pods := []*kube_api.Pod{p1, p2}
+
+	deleted := make([]string, 0)
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	evicter := &blockingPodEvicter{release: make(chan struct{})}
+	tracker := NewNodeDeletionTracker()
+	context := &AutoscalingContext{
+		CloudProvider:       provider,
+		ClientSet:           replicaSetClient(t),
+		PredicateChecker:    simulator.NewTestPredicateChecker(),
+		Recorder:            kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete:  10,
+		PodEvicter:          evicter,
+		NodeDeletionTracker: tracker,
+		ScaleActivity:       NewScaleActivity(),
+	}
+	unneeded := map[string]time.Time{"n1": time.Now().Add(-time.Hour)}
+
+	result, err := ScaleDown(context, nodes, map[string]float64{"n1": 0.1}, unneeded,
+		pods, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleteStarted, result)
+	assert.Equal(t, "n1", tracker.DrainingNode())
+
+	// Scans go on while the pods of n1 are evicted.
+	result, err = ScaleDown(context, nodes, map[string]float64{"n1": 0.1}, unneeded,
+		pods, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownInProgress, result)
+
+	// The result of the removal is reported and recorded by the next scan, whose snapshot was taken
+	// after the deletion, so the size of the node group isn't decreased again.
+	close(evicter.release)
+	waitForDrain(t, tracker)
+	assert.Equal(t, []string{"n1"}, deleted)
+	assert.True(t, context.ScaleActivity.LastScaleDown("ng1").IsZero())
+	context.ClusterSnapshot = NewClusterSnapshot(nodes, nodes, pods, nil, provider, time.Now())
+	result, err = ScaleDown(context, nodes, map[string]float64{"n1": 0.1}, unneeded,
+		pods, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleted, result)
+	assert.Nil(t, tracker.TakeDrainResult())
+	assert.False(t, context.ScaleActivity.LastScaleDown("ng1").IsZero())
+	size, err := targetSize(context, provider.NodeGroups()[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+	context.ClusterSnapshot = nil
+
+	// A node that can't be drained has to be unneeded again before it is retried.
+	deleted = make([]string, 0)
+	context.PodEvicter = &fakePodEvicter{protected: map[string]bool{"p1": true}}
+	context.NodeDeletionTracker = NewNodeDeletionTracker()
+	unneeded = map[string]time.Time{"n1": time.Now().Add(-time.Hour)}
+	result, err = ScaleDown(context, nodes, map[string]float64{"n1": 0.1}, unneeded,
+		pods, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNodeDeleteStarted, result)
+	waitForDrain(t, context.NodeDeletionTracker)
+	result, err = ScaleDown(context, nodes, map[string]float64{"n1": 0.1}, unneeded,
+		pods, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	assert.Equal(t, ScaleDownNoNodeDeleted, result)
+	assert.Empty(t, deleted)
+	assert.NotContains(t, unneeded, "n1")
+}
+
+func TestRecordNodeRemoval(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNode("ng1", n1)
+	nodeGroup := provider.NodeGroups()[0]
+	now := time.Now()
+	context := &AutoscalingContext{
+		CloudProvider: provider,
+		Recorder:      kube_record.NewFakeRecorder(10),
+		ScaleActivity: NewScaleActivity(),
+	}
+	removal := &NodeDrainResult{NodeName: "n1", Result: ScaleDownNodeDeleted, Node: n1, NodeGroup: nodeGroup, FinishTime: now}
+
+	// The snapshot was taken before the node was deleted, so it doesn't count the deletion yet.
+	context.ClusterSnapshot = NewClusterSnapshot(nil, nil, nil, nil, provider, now.Add(-time.Second))
+	recordNodeRemoval(context, removal)
+	size, err := targetSize(context, nodeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, size)
+	assert.Equal(t, now, context.ScaleActivity.LastScaleDown("ng1"))
+
+	// The snapshot was taken after the node was deleted, the size read from the cloud provider counts it.
+	context.ClusterSnapshot = NewClusterSnapshot(nil, nil, nil, nil, provider, now.Add(time.Second))
+	recordNodeRemoval(context, removal)
+	size, err = targetSize(context, nodeGroup)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, size)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+
+	"github.com/golang/glog"
+)
+
+// ScaleDownVeto lets a component outside of the autoscaler block the removal of nodes, for
+// example nodes running jobs that must not be interrupted.
+type ScaleDownVeto interface {
+	// Veto returns true and the reason if the node must not be removed.
+	Veto(node *kube_api.Node, pods []*kube_api.Pod) (bool, string, error)
+}
+
+// HttpScaleDownVeto posts the node about to be removed to an http endpoint as a JSON object
+// {"node": "...", "pods": [...]} and expects a JSON object {"veto": true|false, "reason": "..."}.
+type HttpScaleDownVeto struct {
+	url    string
+	client *http.Client
+}
+
+// NewHttpScaleDownVeto builds HttpScaleDownVeto.
+func NewHttpScaleDownVeto(url string, timeout time.Duration) *HttpScaleDownVeto {
+	return &HttpScaleDownVeto{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+type scaleDownVetoRequest struct {
+	Node string `json:"node"`
+	// Pods are namespace/name of the pods running on the node.
+	Pods []string `json:"pods"`
+}
+
+type scaleDownVetoResponse struct {
+	Veto   bool   `json:"veto"`
+	Reason string `json:"reason"`
+}
+
+// Veto returns the decision of the endpoint.
+func (v *HttpScaleDownVeto) Veto(node *kube_api.Node, pods []*kube_api.Pod) (bool, string, error) {
+	request := scaleDownVetoRequest{Node: node.Name, Pods: make([]string, 0, len(pods))}
+	for _, pod := range pods {
+		request.Pods = append(request.Pods, pod.Namespace+"/"+pod.Name)
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return false, "", err
+	}
+	resp, err := v.client.Post(v.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("unexpected status code from %s: %d", v.url, resp.StatusCode)
+	}
+	decision := scaleDownVetoResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return false, "", fmt.Errorf("failed to decode decision from %s: %v", v.url, err)
+	}
+	return decision.Veto, decision.Reason, nil
+}
+
+// scaleDownVetoed checks the removal of the node with context.ScaleDownVeto, if set. If the veto
+// check fails the node is removed only with context.ScaleDownVetoFailOpen.
+func scaleDownVetoed(context *AutoscalingContext, node *kube_api.Node, pods []*kube_api.Pod) bool {
+	if context.ScaleDownVeto == nil {
+		return false
+	}
+	vetoed, reason, err := context.ScaleDownVeto.Veto(node, pods)
+	if err != nil {
+		if context.ScaleDownVetoFailOpen {
+			glog.Warningf("Scale down veto check of %s failed, removing the node anyway: %v", node.Name, err)
+			return false
+		}
+		glog.Warningf("Scale down veto check of %s failed, keeping the node: %v", node.Name, err)
+		return true
+	}
+	if vetoed {
+		glog.V(1).Infof("Removal of %s vetoed: %s", node.Name, reason)
+	}
+	return vetoed
+}
+
+// podsOnNode returns the pods scheduled on the node.
+func podsOnNode(node *kube_api.Node, pods []*kube_api.Pod) []*kube_api.Pod {
+	result := make([]*kube_api.Pod, 0)
+	for _, pod := range pods {
+		if pod.Spec.NodeName == node.Name {
+			result = append(result, pod)
+		}
+	}
+	return result
+}
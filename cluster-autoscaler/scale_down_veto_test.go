@@ -0,0 +1,105 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// scaleDownWithVeto scales down two unneeded empty nodes and returns the deleted ones.
+func scaleDownWithVeto(t *testing.T, veto ScaleDownVeto, failOpen bool) []string {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	deleted := make([]string, 0)
+	provider := test.NewTestCloudProvider(nil, func(nodeGroup string, node string) error {
+		deleted = append(deleted, node)
+		return nil
+	})
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+	context := &AutoscalingContext{
+		CloudProvider:         provider,
+		PredicateChecker:      simulator.NewTestPredicateChecker(),
+		Recorder:              kube_record.NewFakeRecorder(10),
+		MaxEmptyBulkDelete:    10,
+		ScaleDownVeto:         veto,
+		ScaleDownVetoFailOpen: failOpen,
+	}
+	unneeded := map[string]time.Time{
+		"n1": time.Now().Add(-time.Hour),
+		"n2": time.Now().Add(-time.Hour),
+	}
+	_, err := ScaleDown(context, []*kube_api.Node{n1, n2}, map[string]float64{}, unneeded,
+		[]*kube_api.Pod{}, map[string]string{}, simulator.NewUsageTracker())
+	assert.NoError(t, err)
+	sort.Strings(deleted)
+	return deleted
+}
+
+func TestHttpScaleDownVeto(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request scaleDownVetoRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		if request.Node == "n1" {
+			w.Write([]byte(`{"veto": true, "reason": "running a batch job"}`))
+			return
+		}
+		w.Write([]byte(`{"veto": false}`))
+	}))
+	defer server.Close()
+
+	assert.Equal(t, []string{"n1", "n2"}, scaleDownWithVeto(t, nil, false))
+	assert.Equal(t, []string{"n2"}, scaleDownWithVeto(t, NewHttpScaleDownVeto(server.URL, time.Second), false))
+}
+
+func TestHttpScaleDownVetoFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "veto failure", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	assert.Empty(t, scaleDownWithVeto(t, NewHttpScaleDownVeto(server.URL, time.Second), false))
+	assert.Equal(t, []string{"n1", "n2"}, scaleDownWithVeto(t, NewHttpScaleDownVeto(server.URL, time.Second), true))
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"veto": false}`))
+	}))
+	defer slowServer.Close()
+	assert.Empty(t, scaleDownWithVeto(t, NewHttpScaleDownVeto(slowServer.URL, 50*time.Millisecond), false))
+}
+
+func TestPodsOnNode(t *testing.T) {
+	p1 := BuildTestPod("p1", 100, 0)
+	p1.Spec.NodeName = "n1"
+	p2 := BuildTestPod("p2", 100, 0)
+	p2.Spec.NodeName = "n2"
+	assert.Equal(t, []*kube_api.Pod{p1}, podsOnNode(BuildTestNode("n1", 1000, 1000), []*kube_api.Pod{p1, p2}))
+}
@@ -18,53 +18,252 @@ package main
 
 import (
 	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
 
 	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
 	"k8s.io/contrib/cluster-autoscaler/estimator"
-	"k8s.io/contrib/cluster-autoscaler/simulator"
+	kube_util "k8s.io/contrib/cluster-autoscaler/utils/kubernetes"
 	kube_api "k8s.io/kubernetes/pkg/api"
-	kube_record "k8s.io/kubernetes/pkg/client/record"
-	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/api/resource"
+	utilerrors "k8s.io/kubernetes/pkg/util/errors"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/golang/glog"
 )
 
+// SafeToStayPendingAnnotation set to "true" on a pod keeps it from triggering a scale up, so
+// e.g. low priority batch pods can wait until capacity frees up.
+const SafeToStayPendingAnnotation = "cluster-autoscaler.kubernetes.io/safe-to-stay-pending"
+
+// unboundClaimRegexp matches the error reported by the scheduler for pods whose PersistentVolumeClaim
+// is not bound to a volume, capturing the name of the claim.
+var unboundClaimRegexp = regexp.MustCompile(`PersistentVolumeClaim is not bound: "([^"]*)"`)
+
 // ExpansionOption describes an option to expand the cluster.
 type ExpansionOption struct {
 	nodeGroup cloudprovider.NodeGroup
 	nodeCount int
 	debug     string
 	pods      []*kube_api.Pod
+	// estimation is the report of the estimator that computed nodeCount.
+	estimation estimator.EstimationReport
 }
 
 // ScaleUp tries to scale the cluster up. Return true if it found a way to increase the size,
 // false if it didn't and error if an error occured. Assumes that all nodes in the cluster are
-// ready and in sync with instance groups.
-func ScaleUp(unschedulablePods []*kube_api.Pod, nodes []*kube_api.Node, cloudProvider cloudprovider.CloudProvider, kubeClient *kube_client.Client,
-	predicateChecker *simulator.PredicateChecker, recorder kube_record.EventRecorder, maxNodesTotal int,
-	estimatorName string) (bool, error) {
+// ready and in sync with instance groups. Apart from unschedulable pods, the minimum sizes
+// in context.MinSizeHints are honored.
+func ScaleUp(context *AutoscalingContext, unschedulablePods []*kube_api.Pod, nodes []*kube_api.Node,
+	nodeInfos map[string]*schedulercache.NodeInfo) (bool, error) {
+
+	added, err := scaleUpForPods(context, unschedulablePods, nodes, nodeInfos)
+	if err != nil {
+		return added > 0, err
+	}
+	if len(context.MinSizeHints) == 0 {
+		return added > 0, nil
+	}
+	hinted, err := scaleUpToHints(context, len(nodes)+added, nodeInfos)
+	return added > 0 || hinted > 0, err
+}
+
+// scaleUpForPods increases the size of the best node group, and of the node groups similar to it if
+// balancing is enabled, to accommodate the given unschedulable pods. Returns the number of requested
+// nodes.
+func scaleUpForPods(context *AutoscalingContext, unschedulablePods []*kube_api.Pod, nodes []*kube_api.Node,
+	nodeInfos map[string]*schedulercache.NodeInfo) (int, error) {
+
+	expansionOptions, podsRemainUnshedulable := expansionOptionsForPods(context, unschedulablePods, nodes, nodeInfos, false)
+
+	// Pick some expansion option. If the chosen node group fails to scale up, it is backed off and
+	// the next best option is tried.
+	scaleUpErrors := make([]error, 0)
+options:
+	for len(expansionOptions) > 0 {
+		bestOption := bestExpansionOption(context, expansionOptions, nodeInfos)
+		if bestOption == nil || bestOption.nodeCount <= 0 {
+			break
+		}
+		glog.V(1).Infof("Best option to resize: %s", bestOption.nodeGroup.Id())
+		if len(bestOption.debug) > 0 {
+			glog.V(1).Info(bestOption.debug)
+		}
+		glog.V(1).Infof("Estimated %d nodes needed in %s", bestOption.nodeCount, bestOption.nodeGroup.Id())
+		if warm := warmPoolSize(bestOption.nodeGroup); warm > 0 {
+			glog.V(1).Infof("%d warmed instances available in the warm pool of %s", warm, bestOption.nodeGroup.Id())
+		}
+
+		currentSize, err := targetSize(context, bestOption.nodeGroup)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get node group size: %v", err)
+		}
+		newSize := currentSize + bestOption.nodeCount
+		// limit names the last limit that capped newSize. Reaching a limit isn't an error: the
+		// cluster just doesn't grow, and scale down carries on.
+		limit := ""
+		if newSize >= bestOption.nodeGroup.MaxSize() {
+			glog.V(1).Infof("Capping size to MAX (%d)", bestOption.nodeGroup.MaxSize())
+			newSize = bestOption.nodeGroup.MaxSize()
+			limit = fmt.Sprintf("max size of %s (%d)", bestOption.nodeGroup.Id(), bestOption.nodeGroup.MaxSize())
+		}
+
+		if context.MaxNodesTotal > 0 && len(nodes)+(newSize-currentSize) > context.MaxNodesTotal {
+			glog.V(1).Infof("Capping size to max cluster total size (%d)", context.MaxNodesTotal)
+			newSize = context.MaxNodesTotal - len(nodes) + currentSize
+			limit = fmt.Sprintf("max cluster total size (%d)", context.MaxNodesTotal)
+		}
+		if newSize <= currentSize {
+			glog.V(1).Infof("Not scaling up %s: %s already reached", bestOption.nodeGroup.Id(), limit)
+			return 0, nil
+		}
+		newSize, resourceLimit, err := capSizeToResourceLimits(context, bestOption.nodeGroup, currentSize, newSize, nodeInfos)
+		if err != nil {
+			return 0, fmt.Errorf("failed to check cluster resources: %v", err)
+		}
+		if newSize <= currentSize {
+			glog.V(1).Infof("Not scaling up %s: %s already reached", bestOption.nodeGroup.Id(), resourceLimit)
+			return 0, nil
+		}
+
+		// Node groups similar to the best one share the new nodes, so that their sizes stay balanced.
+		nodeGroups := []cloudprovider.NodeGroup{bestOption.nodeGroup}
+		if context.BalanceSimilarNodeGroups {
+			nodeGroups = append(nodeGroups, similarNodeGroups(context, bestOption, expansionOptions, nodeInfos)...)
+		}
+		increases, err := balanceScaleUp(context, nodeGroups, newSize-currentSize)
+		if err != nil {
+			return 0, fmt.Errorf("failed to balance scale up: %v", err)
+		}
+		added := 0
+		// increased describes the size changes of the node groups that were increased, for the
+		// events of the pods.
+		increased := make([]string, 0, len(nodeGroups))
+		for _, nodeGroup := range nodeGroups {
+			delta := increases[nodeGroup.Id()]
+			if delta == 0 {
+				continue
+			}
+			// Every node group that receives nodes is checked against the resource limits, counting
+			// the nodes already added to the previous ones.
+			delta, err := capIncreaseToResourceLimits(context, nodeGroup, delta, nodeInfos)
+			if err != nil {
+				glog.Warningf("Skipping scale up of %s: failed to check cluster resources: %v", nodeGroup.Id(), err)
+				continue
+			}
+			if delta == 0 {
+				glog.V(1).Infof("Skipping scale up of %s: max cluster cores or memory total reached", nodeGroup.Id())
+				continue
+			}
+			groupSize, err := increaseNodeGroup(context, nodeGroup, delta, bestOption.pods)
+			if err == nil {
+				added += delta
+				increased = append(increased, fmt.Sprintf("group: %s, sizes (current/new): %d/%d", nodeGroup.Id(), groupSize-delta, groupSize))
+			} else if added > 0 {
+				// Pods that don't fit on the nodes already requested trigger another scale up.
+				glog.Warningf("Failed to scale up similar node group %s: %v", nodeGroup.Id(), err)
+			} else {
+				glog.Warningf("Failed to scale up node group %s, trying the next expansion option: %v", nodeGroup.Id(), err)
+				if context.ScaleUpTracker != nil {
+					context.ScaleUpTracker.BackOff(nodeGroup.Id(), context.Now())
+				}
+				scaleUpErrors = append(scaleUpErrors, fmt.Errorf("%s: %v", nodeGroup.Id(), err))
+				expansionOptions = withoutExpansionOption(expansionOptions, nodeGroup.Id())
+				continue options
+			}
+		}
+		// Every pod gets one event, listing all node groups increased for it.
+		for _, pod := range bestOption.pods {
+			context.Recorder.Eventf(pod, kube_api.EventTypeNormal, ReasonTriggeredScaleUp,
+				"pod triggered scale-up, %s", strings.Join(increased, "; "))
+		}
+		return added, nil
+	}
+	if len(scaleUpErrors) > 0 {
+		return 0, fmt.Errorf("failed to increase node group size: %v", utilerrors.NewAggregate(scaleUpErrors))
+	}
+	for pod := range podsRemainUnshedulable {
+		context.Recorder.Event(pod, kube_api.EventTypeNormal, ReasonNotTriggerScaleUp,
+			"pod didn't trigger scale-up (it wouldn't fit if a new node is added)")
+	}
+
+	return 0, nil
+}
+
+// expansionOptionsForPods returns an expansion option for every node group that can be scaled up
+// and has room for some of the unschedulable pods, together with the pods that don't fit on a new
+// node of some node group. In dry run neither predicate failures nor estimation reports are recorded.
+func expansionOptionsForPods(context *AutoscalingContext, unschedulablePods []*kube_api.Pod, nodes []*kube_api.Node,
+	nodeInfos map[string]*schedulercache.NodeInfo, dryRun bool) ([]ExpansionOption, map[*kube_api.Pod]struct{}) {
+
+	// Completed pods don't need a node, even if they were never scheduled.
+	unschedulablePods = kube_util.FilterOutTerminalPods(unschedulablePods)
+	unschedulablePods = filterOutPodsSafeToStayPending(unschedulablePods)
 
 	// From now on we only care about unschedulable pods that were marked after the newest
 	// node became available for the scheduler.
 	if len(unschedulablePods) == 0 {
 		glog.V(1).Info("No unschedulable pods")
-		return false, nil
+		return nil, nil
 	}
 
 	for _, pod := range unschedulablePods {
 		glog.V(1).Infof("Pod %s/%s is unschedulable", pod.Namespace, pod.Name)
 	}
 
-	expansionOptions := make([]ExpansionOption, 0)
-	nodeInfos, err := GetNodeInfosForGroups(nodes, cloudProvider, kubeClient)
-	if err != nil {
-		return false, fmt.Errorf("failed to build node infos for node groups: %v", err)
+	// TODO: skip pods with status.nominatedNodeName, which the scheduler is going to place by
+	// preemption, once the vendored Kubernetes api has the field. The scheduler doesn't preempt
+	// pods yet, so no pending pod is nominated to a node.
+	unschedulablePods = filterOutPodsWithUnboundClaims(context, unschedulablePods)
+	if len(unschedulablePods) == 0 {
+		glog.V(1).Info("All unschedulable pods wait for persistent volume claims to be bound")
+		return nil, nil
+	}
+
+	unschedulablePods = filterOutPodsTooLargeForAnyNodeGroup(context, unschedulablePods, nodeInfos)
+	if len(unschedulablePods) == 0 {
+		glog.V(1).Info("No unschedulable pods that could fit on a new node")
+		return nil, nil
+	}
+
+	unschedulablePods = filterOutPodsForUpcomingNodes(context, unschedulablePods, nodes, nodeInfos)
+	if len(unschedulablePods) == 0 {
+		glog.V(1).Info("All unschedulable pods fit on upcoming nodes")
+		return nil, nil
 	}
 
+	expansionOptions := make([]ExpansionOption, 0)
+
 	podsRemainUnshedulable := make(map[*kube_api.Pod]struct{})
-	for _, nodeGroup := range cloudProvider.NodeGroups() {
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		if context.DisabledNodeGroups[nodeGroup.Id()] {
+			glog.V(4).Infof("Skipping node group %s - disabled", nodeGroup.Id())
+			continue
+		}
+		if context.UnreadyNodeGroups[nodeGroup.Id()] {
+			glog.V(4).Infof("Skipping node group %s - not ready", nodeGroup.Id())
+			continue
+		}
+		if context.NewNodeGroups[nodeGroup.Id()] {
+			glog.V(4).Infof("Skipping node group %s - registered recently", nodeGroup.Id())
+			continue
+		}
+		if context.UnhealthyNodeGroups[nodeGroup.Id()] {
+			glog.V(4).Infof("Skipping node group %s - requested nodes keep failing to register", nodeGroup.Id())
+			continue
+		}
+		if context.ScaleUpTracker.IsBackedOff(nodeGroup.Id(), context.Now()) {
+			glog.V(4).Infof("Skipping node group %s - backed off after a failed scale up", nodeGroup.Id())
+			continue
+		}
+
+		if nodeGroup.MaxSize() == 0 {
+			glog.V(1).Infof("Skipping node group %s - max size is 0, it can never be scaled up", nodeGroup.Id())
+			continue
+		}
 
-		currentSize, err := nodeGroup.TargetSize()
+		currentSize, err := targetSize(context, nodeGroup)
 		if err != nil {
 			glog.Errorf("Failed to get node group size: %v", err)
 			continue
@@ -87,75 +286,375 @@ func ScaleUp(unschedulablePods []*kube_api.Pod, nodes []*kube_api.Node, cloudPro
 		}
 
 		for _, pod := range unschedulablePods {
-			err = predicateChecker.CheckPredicates(pod, nodeInfo)
+			err = context.PredicateChecker.CheckPredicates(pod, nodeInfo)
 			if err == nil {
 				option.pods = append(option.pods, pod)
 			} else {
 				glog.V(2).Infof("Scale-up predicate failed: %v", err)
+				if !dryRun {
+					recordPredicateFailure(err)
+				}
 				podsRemainUnshedulable[pod] = struct{}{}
 			}
 		}
 		if len(option.pods) > 0 {
-			if estimatorName == BinpackingEstimatorName {
-				binpackingEstimator := estimator.NewBinpackingNodeEstimator(predicateChecker)
-				option.nodeCount = binpackingEstimator.Estimate(option.pods, nodeInfo)
-			} else if estimatorName == BasicEstimatorName {
+			estimationPods := estimator.PodsForResourceMode(option.pods, context.EstimatorResourceMode)
+			var report estimator.EstimationReport
+			if context.EstimatorName == BinpackingEstimatorName {
+				binpackingEstimator := estimator.NewBinpackingNodeEstimator(context.PredicateChecker)
+				option.nodeCount, report = binpackingEstimator.Estimate(estimationPods, nodeInfo)
+			} else if context.EstimatorName == BasicEstimatorName {
 				basicEstimator := estimator.NewBasicNodeEstimator()
-				for _, pod := range option.pods {
+				for _, pod := range estimationPods {
 					basicEstimator.Add(pod)
 				}
-				option.nodeCount, option.debug = basicEstimator.Estimate(nodeInfo.Node())
+				option.nodeCount, report = basicEstimator.Estimate(nodeInfo.Node())
 			} else {
-				glog.Fatalf("Unrecognized estimator: %s", estimatorName)
+				glog.Fatalf("Unrecognized estimator: %s", context.EstimatorName)
+			}
+			if context.EstimatorResourceMode == estimator.LimitsResourceMode {
+				report.Assumptions = append(report.Assumptions, "pods request their resource limits")
+			}
+			option.debug = report.String()
+			option.estimation = report
+			if !dryRun {
+				context.EstimationReports.Record(nodeGroup.Id(), EstimationReport{
+					Time:       context.Now(),
+					Pods:       len(option.pods),
+					NodeCount:  option.nodeCount,
+					Report:     option.debug,
+					Estimation: report,
+				})
 			}
 			expansionOptions = append(expansionOptions, option)
 		}
 	}
+	return expansionOptions, podsRemainUnshedulable
+}
 
-	// Pick some expansion option.
-	bestOption := BestExpansionOption(expansionOptions)
-	if bestOption != nil && bestOption.nodeCount > 0 {
-		glog.V(1).Infof("Best option to resize: %s", bestOption.nodeGroup.Id())
-		if len(bestOption.debug) > 0 {
-			glog.V(1).Info(bestOption.debug)
+// capIncreaseToResourceLimits lowers the increase of the node group so that the cluster doesn't grow
+// beyond context.ResourceLimits.
+func capIncreaseToResourceLimits(context *AutoscalingContext, nodeGroup cloudprovider.NodeGroup, delta int,
+	nodeInfos map[string]*schedulercache.NodeInfo) (int, error) {
+	currentSize, err := targetSize(context, nodeGroup)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get node group size: %v", err)
+	}
+	newSize, _, err := capSizeToResourceLimits(context, nodeGroup, currentSize, currentSize+delta, nodeInfos)
+	if err != nil {
+		return 0, err
+	}
+	return newSize - currentSize, nil
+}
+
+// increaseNodeGroup increases the target size of the node group by delta and records the scale up
+// triggered by the pods. It returns the new target size. The events of the pods are left to the
+// caller, as the pods may trigger the scale up of several node groups.
+func increaseNodeGroup(context *AutoscalingContext, nodeGroup cloudprovider.NodeGroup, delta int, pods []*kube_api.Pod) (int, error) {
+	currentSize, err := targetSize(context, nodeGroup)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get node group size: %v", err)
+	}
+	newSize := currentSize + delta
+	warm := warmPoolSize(nodeGroup)
+	if warm > delta {
+		warm = delta
+	}
+	glog.V(0).Infof("Scale-up: setting group %s size to %d, %d nodes from the warm pool", nodeGroup.Id(), newSize, warm)
+
+	err = nodeGroup.IncreaseSize(delta)
+	context.CircuitBreaker.RecordResult(err, context.Now())
+	if err != nil {
+		recordSummaryEvent(context, kube_api.EventTypeWarning, ReasonFailedToScaleUpGroup,
+			"failed to scale up group %s, sizes (current/new): %d/%d: %v", nodeGroup.Id(), currentSize, newSize, err)
+		return 0, err
+	}
+	if context.ScaleUpTracker != nil {
+		context.ScaleUpTracker.RegisterScaleUp(nodeGroup.Id(), delta, warm, context.Now())
+	}
+	registerSizeChange(context, nodeGroup, delta)
+	context.ScaleActivity.RegisterScaleUp(nodeGroup.Id(), context.Now())
+	if context.ScaleUpHistory != nil {
+		context.ScaleUpHistory.RegisterScaleUp(nodeGroup.Id())
+	}
+	recordSummaryEvent(context, kube_api.EventTypeNormal, ReasonScaledUpGroup, "group %s scaled up, sizes (current/new): %d/%d, pods: %d",
+		nodeGroup.Id(), currentSize, newSize, len(pods))
+	return newSize, nil
+}
+
+// withoutExpansionOption returns the options other than the one of the given node group.
+func withoutExpansionOption(options []ExpansionOption, nodeGroupId string) []ExpansionOption {
+	result := make([]ExpansionOption, 0, len(options))
+	for _, option := range options {
+		if option.nodeGroup.Id() != nodeGroupId {
+			result = append(result, option)
 		}
-		glog.V(1).Infof("Estimated %d nodes needed in %s", bestOption.nodeCount, bestOption.nodeGroup.Id())
+	}
+	return result
+}
 
-		currentSize, err := bestOption.nodeGroup.TargetSize()
-		if err != nil {
-			return false, fmt.Errorf("failed to get node group size: %v", err)
+// filterOutPodsSafeToStayPending removes pods annotated with SafeToStayPendingAnnotation.
+func filterOutPodsSafeToStayPending(pods []*kube_api.Pod) []*kube_api.Pod {
+	result := make([]*kube_api.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if pod.Annotations[SafeToStayPendingAnnotation] == "true" {
+			glog.V(2).Infof("Pod %s/%s is safe to stay pending", pod.Namespace, pod.Name)
+			continue
 		}
-		newSize := currentSize + bestOption.nodeCount
-		if newSize >= bestOption.nodeGroup.MaxSize() {
-			glog.V(1).Infof("Capping size to MAX (%d)", bestOption.nodeGroup.MaxSize())
-			newSize = bestOption.nodeGroup.MaxSize()
+		result = append(result, pod)
+	}
+	return result
+}
+
+// filterOutPodsWithUnboundClaims removes pods waiting for a PersistentVolumeClaim to be bound, e.g.
+// because there is no provisioner or no capacity for the volume. New nodes don't help such pods, so
+// they get a warning event instead.
+// TODO: keep pods whose claims use a storage class with delayed volume binding, which are bound only
+// once the pod is scheduled, when the vendored api has the volume binding mode.
+func filterOutPodsWithUnboundClaims(context *AutoscalingContext, pods []*kube_api.Pod) []*kube_api.Pod {
+	result := make([]*kube_api.Pod, 0, len(pods))
+	for _, pod := range pods {
+		claim, unbound := unboundClaim(context, pod)
+		if !unbound {
+			result = append(result, pod)
+			continue
 		}
+		glog.V(1).Infof("Pod %s/%s waits for PersistentVolumeClaim %s to be bound", pod.Namespace, pod.Name, claim)
+		context.Recorder.Eventf(pod, kube_api.EventTypeWarning, ReasonNotTriggerScaleUpUnboundClaim,
+			"pod didn't trigger scale-up, its PersistentVolumeClaim %s is not bound", claim)
+	}
+	return result
+}
 
-		if maxNodesTotal > 0 && len(nodes)+(newSize-currentSize) > maxNodesTotal {
-			glog.V(1).Infof("Capping size to max cluster total size (%d)", maxNodesTotal)
-			newSize = maxNodesTotal - len(nodes) + currentSize
-			if newSize < currentSize {
-				return false, fmt.Errorf("max node total count already reached")
+// unboundClaim returns the name of a PersistentVolumeClaim of the pod that is not bound, either
+// according to context.PersistentVolumeClaims or to the unschedulable reason of the pod, kept in the
+// message of its PodScheduled condition. Claims missing from context.PersistentVolumeClaims are
+// assumed to be bound.
+func unboundClaim(context *AutoscalingContext, pod *kube_api.Pod) (string, bool) {
+	if context.PersistentVolumeClaims != nil {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim == nil {
+				continue
+			}
+			name := volume.PersistentVolumeClaim.ClaimName
+			claim, err := context.PersistentVolumeClaims.GetPersistentVolumeClaimInfo(pod.Namespace, name)
+			if err != nil {
+				glog.V(4).Infof("Failed to get PersistentVolumeClaim %s/%s: %v", pod.Namespace, name, err)
+				continue
+			}
+			if claim.Status.Phase != kube_api.ClaimBound {
+				return name, true
 			}
 		}
+	}
+	_, condition := kube_api.GetPodCondition(&pod.Status, kube_api.PodScheduled)
+	if condition == nil || condition.Status != kube_api.ConditionFalse {
+		return "", false
+	}
+	if match := unboundClaimRegexp.FindStringSubmatch(condition.Message); match != nil {
+		return match[1], true
+	}
+	return "", false
+}
 
-		glog.V(0).Infof("Scale-up: setting group %s size to %d", bestOption.nodeGroup.Id(), newSize)
+// filterOutPodsTooLargeForAnyNodeGroup removes the pods whose cpu or memory requests exceed the
+// allocatable resources of the template node of every node group. Such pods can never be helped
+// by a scale up, so an event is recorded for them and they are not considered any further.
+func filterOutPodsTooLargeForAnyNodeGroup(context *AutoscalingContext, pods []*kube_api.Pod,
+	nodeInfos map[string]*schedulercache.NodeInfo) []*kube_api.Pod {
 
-		if err := bestOption.nodeGroup.IncreaseSize(newSize - currentSize); err != nil {
-			return false, fmt.Errorf("failed to increase node group size: %v", err)
+	templates := make([]*kube_api.Node, 0)
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		if nodeInfo, found := nodeInfos[nodeGroup.Id()]; found && nodeInfo.Node() != nil {
+			templates = append(templates, nodeInfo.Node())
 		}
+	}
+	if len(templates) == 0 {
+		return pods
+	}
 
-		for _, pod := range bestOption.pods {
-			recorder.Eventf(pod, kube_api.EventTypeNormal, "TriggeredScaleUp",
-				"pod triggered scale-up, group: %s, sizes (current/new): %d/%d", bestOption.nodeGroup.Id(), currentSize, newSize)
+	result := make([]*kube_api.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if fitsAnyTemplate(pod, templates) {
+			result = append(result, pod)
+			continue
 		}
+		glog.V(1).Infof("Pod %s/%s is too large for any node group", pod.Namespace, pod.Name)
+		context.Recorder.Event(pod, kube_api.EventTypeWarning, ReasonPodTooLargeForAnyNodeGroup,
+			"pod requests more resources than a node of any node group can provide")
+	}
+	return result
+}
+
+// filterOutPodsForUpcomingNodes removes the pods that fit on nodes requested from unready node groups
+// that didn't register in Kubernetes yet. The pods will be scheduled there once the nodes show up, so
+// they shouldn't trigger a scale up of another node group in the meantime.
+func filterOutPodsForUpcomingNodes(context *AutoscalingContext, pods []*kube_api.Pod, nodes []*kube_api.Node,
+	nodeInfos map[string]*schedulercache.NodeInfo) []*kube_api.Pod {
 
-		return true, nil
+	if len(context.UnreadyNodeGroups) == 0 {
+		return pods
 	}
-	for pod := range podsRemainUnshedulable {
-		recorder.Event(pod, kube_api.EventTypeNormal, "NotTriggerScaleUp",
-			"pod didn't trigger scale-up (it wouldn't fit if a new node is added)")
+	registered := make(map[string]int)
+	for _, node := range nodes {
+		nodeGroup, err := context.CloudProvider.NodeGroupForNode(node)
+		if err != nil {
+			glog.Errorf("Failed to get node group for %s: %v", node.Name, err)
+			return pods
+		}
+		if nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+			continue
+		}
+		registered[nodeGroup.Id()]++
 	}
 
-	return false, nil
+	upcomingNodes := make([]*schedulercache.NodeInfo, 0)
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		if !context.UnreadyNodeGroups[nodeGroup.Id()] {
+			continue
+		}
+		nodeInfo, found := nodeInfos[nodeGroup.Id()]
+		if !found {
+			continue
+		}
+		size, err := targetSize(context, nodeGroup)
+		if err != nil {
+			glog.Errorf("Failed to get node group size: %v", err)
+			continue
+		}
+		for i := registered[nodeGroup.Id()]; i < size; i++ {
+			upcomingNodes = append(upcomingNodes, nodeInfo)
+		}
+	}
+
+	result := make([]*kube_api.Pod, 0, len(pods))
+	for _, pod := range pods {
+		found := false
+		for i, nodeInfo := range upcomingNodes {
+			if err := context.PredicateChecker.CheckPredicates(pod, nodeInfo); err == nil {
+				upcomingNodes[i] = nodeInfoWithPod(nodeInfo, pod)
+				found = true
+				break
+			}
+		}
+		if found {
+			glog.V(2).Infof("Pod %s/%s fits on an upcoming node", pod.Namespace, pod.Name)
+			continue
+		}
+		result = append(result, pod)
+	}
+	return result
+}
+
+// warmPoolSize returns the number of pre-initialized instances of the node group, 0 if it doesn't
+// implement cloudprovider.WarmPoolNodeGroup.
+func warmPoolSize(nodeGroup cloudprovider.NodeGroup) int {
+	if warm, ok := nodeGroup.(cloudprovider.WarmPoolNodeGroup); ok {
+		return warm.WarmPoolSize()
+	}
+	return 0
+}
+
+// warmPoolOptions returns the options whose nodes can all be taken from the warm pool of their node
+// group, or all options if there are none. Nodes from warm pools are ready almost instantly, so
+// fewer new instances have to be launched.
+func warmPoolOptions(options []ExpansionOption) []ExpansionOption {
+	result := make([]ExpansionOption, 0, len(options))
+	for _, option := range options {
+		if warm := warmPoolSize(option.nodeGroup); warm > 0 && option.nodeCount <= warm {
+			result = append(result, option)
+		}
+	}
+	if len(result) == 0 {
+		return options
+	}
+	return result
+}
+
+// nodeInfoWithPod returns a copy of nodeInfo with an additional pod scheduled on it.
+func nodeInfoWithPod(nodeInfo *schedulercache.NodeInfo, pod *kube_api.Pod) *schedulercache.NodeInfo {
+	podsOnNode := make([]*kube_api.Pod, 0, len(nodeInfo.Pods())+1)
+	podsOnNode = append(podsOnNode, nodeInfo.Pods()...)
+	podsOnNode = append(podsOnNode, pod)
+	newNodeInfo := schedulercache.NewNodeInfo(podsOnNode...)
+	newNodeInfo.SetNode(nodeInfo.Node())
+	return newNodeInfo
+}
+
+// fitsAnyTemplate checks whether cpu and memory requests of the pod fit within the allocatable
+// resources of at least one of the template nodes.
+func fitsAnyTemplate(pod *kube_api.Pod, templates []*kube_api.Node) bool {
+	cpu := resource.Quantity{}
+	mem := resource.Quantity{}
+	for _, container := range pod.Spec.Containers {
+		if request, ok := container.Resources.Requests[kube_api.ResourceCPU]; ok {
+			cpu.Add(request)
+		}
+		if request, ok := container.Resources.Requests[kube_api.ResourceMemory]; ok {
+			mem.Add(request)
+		}
+	}
+	for _, node := range templates {
+		cpuAllocatable := node.Status.Allocatable[kube_api.ResourceCPU]
+		memAllocatable := node.Status.Allocatable[kube_api.ResourceMemory]
+		if cpu.Cmp(cpuAllocatable) <= 0 && mem.Cmp(memAllocatable) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// scaleUpToHints increases node groups to the minimum sizes in context.MinSizeHints.
+// Groups that are already at or above the hinted size are left untouched, so combined with
+// scaleUpForPods each group ends up at the max of both requirements. Returns the number of
+// requested nodes.
+func scaleUpToHints(context *AutoscalingContext, nodeCount int, nodeInfos map[string]*schedulercache.NodeInfo) (int, error) {
+	added := 0
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		hint, found := context.MinSizeHints[nodeGroup.Id()]
+		if !found || context.DisabledNodeGroups[nodeGroup.Id()] || context.UnreadyNodeGroups[nodeGroup.Id()] ||
+			context.NewNodeGroups[nodeGroup.Id()] || context.UnhealthyNodeGroups[nodeGroup.Id()] ||
+			context.ScaleUpTracker.IsBackedOff(nodeGroup.Id(), context.Now()) {
+			continue
+		}
+		currentSize, err := targetSize(context, nodeGroup)
+		if err != nil {
+			return added, fmt.Errorf("failed to get node group size: %v", err)
+		}
+		newSize := hint
+		if newSize > nodeGroup.MaxSize() {
+			glog.V(1).Infof("Capping hinted size of %s to MAX (%d)", nodeGroup.Id(), nodeGroup.MaxSize())
+			newSize = nodeGroup.MaxSize()
+		}
+		if context.MaxNodesTotal > 0 && nodeCount+added+(newSize-currentSize) > context.MaxNodesTotal {
+			glog.V(1).Infof("Capping hinted size of %s to max cluster total size (%d)", nodeGroup.Id(), context.MaxNodesTotal)
+			newSize = context.MaxNodesTotal - nodeCount - added + currentSize
+		}
+		newSize, _, err = capSizeToResourceLimits(context, nodeGroup, currentSize, newSize, nodeInfos)
+		if err != nil {
+			return added, fmt.Errorf("failed to check cluster resources: %v", err)
+		}
+		if newSize <= currentSize {
+			continue
+		}
+
+		glog.V(0).Infof("Scale-up: setting group %s size to %d (hint)", nodeGroup.Id(), newSize)
+		warm := warmPoolSize(nodeGroup)
+		err = nodeGroup.IncreaseSize(newSize - currentSize)
+		context.CircuitBreaker.RecordResult(err, context.Now())
+		if err != nil {
+			recordSummaryEvent(context, kube_api.EventTypeWarning, ReasonFailedToScaleUpGroup,
+				"failed to scale up group %s to hinted size, sizes (current/new): %d/%d: %v", nodeGroup.Id(), currentSize, newSize, err)
+			return added, fmt.Errorf("failed to increase node group size: %v", err)
+		}
+		if context.ScaleUpTracker != nil {
+			context.ScaleUpTracker.RegisterScaleUp(nodeGroup.Id(), newSize-currentSize, warm, context.Now())
+		}
+		registerSizeChange(context, nodeGroup, newSize-currentSize)
+		context.ScaleActivity.RegisterScaleUp(nodeGroup.Id(), context.Now())
+		recordSummaryEvent(context, kube_api.EventTypeNormal, ReasonScaledUpGroup, "group %s scaled up to hinted size, sizes (current/new): %d/%d",
+			nodeGroup.Id(), currentSize, newSize)
+		added += newSize - currentSize
+	}
+	return added, nil
 }
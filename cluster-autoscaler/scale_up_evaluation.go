@@ -0,0 +1,171 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/estimator"
+	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+)
+
+// scaleUpEvaluationTimeout is how long a request to ScaleUpEvaluationEndpoint waits for the main loop.
+const scaleUpEvaluationTimeout = time.Minute
+
+// errScaleUpEvaluationTimeout is returned when the main loop didn't evaluate a scale up in time,
+// e.g. because this replica is not the leader.
+var errScaleUpEvaluationTimeout = errors.New("the main loop didn't evaluate the scale up in time")
+
+// ScaleUpOptionEvaluation describes an expansion option found by a dry scale up evaluation.
+type ScaleUpOptionEvaluation struct {
+	// Rank is the position of the option in the order the expander would try the options, starting
+	// at 1. Options after the first one are tried if the previous ones fail to scale up.
+	Rank        int    `json:"rank"`
+	NodeGroupId string `json:"nodeGroupId"`
+	// NodeCount is the estimated number of nodes needed in the node group.
+	NodeCount int `json:"nodeCount"`
+	// FittingPods is the number of pending pods that fit on a new node of the node group.
+	FittingPods int                        `json:"fittingPods"`
+	Estimation  estimator.EstimationReport `json:"estimation"`
+}
+
+// ScaleUpEvaluation is the result of a dry scale up evaluation of the pending pods.
+type ScaleUpEvaluation struct {
+	Time time.Time `json:"time"`
+	// PendingPods is the number of unschedulable pods the evaluation started from.
+	PendingPods int `json:"pendingPods"`
+	// UnfittingPods is the number of pods that don't fit on a new node of some node group.
+	UnfittingPods int                       `json:"unfittingPods"`
+	Options       []ScaleUpOptionEvaluation `json:"options"`
+}
+
+// EvaluateScaleUp ranks the expansion options for the unschedulable pods like ScaleUp would try
+// them, without scaling up. No events, estimation reports or scale ups are recorded. Every rank is
+// chosen by the configured expanders, so the http expander is asked once per option.
+func EvaluateScaleUp(context *AutoscalingContext, unschedulablePods []*kube_api.Pod, nodes []*kube_api.Node,
+	nodeInfos map[string]*schedulercache.NodeInfo) ScaleUpEvaluation {
+	dryContext := *context
+	dryContext.Recorder = noopRecorder{}
+
+	options, unfitting := expansionOptionsForPods(&dryContext, unschedulablePods, nodes, nodeInfos, true)
+	evaluation := ScaleUpEvaluation{
+		Time:          context.Now(),
+		PendingPods:   len(unschedulablePods),
+		UnfittingPods: len(unfitting),
+		Options:       make([]ScaleUpOptionEvaluation, 0, len(options)),
+	}
+	for len(options) > 0 {
+		best := bestExpansionOption(&dryContext, options, nodeInfos)
+		if best == nil {
+			break
+		}
+		evaluation.Options = append(evaluation.Options, ScaleUpOptionEvaluation{
+			Rank:        len(evaluation.Options) + 1,
+			NodeGroupId: best.nodeGroup.Id(),
+			NodeCount:   best.nodeCount,
+			FittingPods: len(best.pods),
+			Estimation:  best.estimation,
+		})
+		options = withoutExpansionOption(options, best.nodeGroup.Id())
+	}
+	return evaluation
+}
+
+// ScaleUpEvaluationRequest asks the main loop for a scale up evaluation of the live cluster state.
+type ScaleUpEvaluationRequest struct {
+	// Result receives the answer of the main loop, it must be buffered.
+	Result chan<- ScaleUpEvaluationResult
+}
+
+// ScaleUpEvaluationResult is the answer of the main loop to ScaleUpEvaluationRequest.
+type ScaleUpEvaluationResult struct {
+	Evaluation ScaleUpEvaluation
+	Err        error
+}
+
+// Reply sends the result of the request. Only the first reply is delivered, later ones are dropped.
+func (r ScaleUpEvaluationRequest) Reply(evaluation ScaleUpEvaluation, err error) {
+	select {
+	case r.Result <- ScaleUpEvaluationResult{Evaluation: evaluation, Err: err}:
+	default:
+	}
+}
+
+// ScaleUpEvaluationEndpoint serves dry scale up evaluations of the currently pending pods as JSON.
+// The evaluations are handed over to the main loop, so they never run concurrently with a scan.
+type ScaleUpEvaluationEndpoint struct {
+	requests chan ScaleUpEvaluationRequest
+	timeout  time.Duration
+}
+
+// NewScaleUpEvaluationEndpoint builds ScaleUpEvaluationEndpoint.
+func NewScaleUpEvaluationEndpoint() *ScaleUpEvaluationEndpoint {
+	return &ScaleUpEvaluationEndpoint{
+		requests: make(chan ScaleUpEvaluationRequest),
+		timeout:  scaleUpEvaluationTimeout,
+	}
+}
+
+// Requests returns the channel the main loop receives evaluation requests from.
+func (e *ScaleUpEvaluationEndpoint) Requests() <-chan ScaleUpEvaluationRequest {
+	return e.requests
+}
+
+// evaluate hands a request over to the main loop and waits for its result.
+func (e *ScaleUpEvaluationEndpoint) evaluate() (ScaleUpEvaluation, error) {
+	timeout := time.After(e.timeout)
+	results := make(chan ScaleUpEvaluationResult, 1)
+	select {
+	case e.requests <- ScaleUpEvaluationRequest{Result: results}:
+	case <-timeout:
+		return ScaleUpEvaluation{}, errScaleUpEvaluationTimeout
+	}
+	select {
+	case result := <-results:
+		return result.Evaluation, result.Err
+	case <-timeout:
+		return ScaleUpEvaluation{}, errScaleUpEvaluationTimeout
+	}
+}
+
+// ServeHTTP responds to GET requests with the JSON encoded evaluation.
+func (e *ScaleUpEvaluationEndpoint) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "" && req.Method != "GET" {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	evaluation, err := e.evaluate()
+	if err == errScaleUpEvaluationTimeout {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encoded, err := json.Marshal(evaluation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(encoded)
+}
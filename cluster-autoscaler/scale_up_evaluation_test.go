@@ -0,0 +1,122 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/estimator"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildScaleUpEvaluationTest returns a context with two node groups that can take the returned pods:
+// ng1 with a single larger node and ng2, preferred by priority, with two smaller nodes.
+func buildScaleUpEvaluationTest(t *testing.T) (*AutoscalingContext, []*kube_api.Pod, []*kube_api.Node,
+	map[string]*schedulercache.NodeInfo, *kube_record.FakeRecorder) {
+	n1 := BuildTestNode("n1", 4000, 1000)
+	n2 := BuildTestNode("n2", 2500, 1000)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		t.Errorf("unexpected scale up of %s by %d", id, delta)
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+
+	recorder := kube_record.NewFakeRecorder(10)
+	context := &AutoscalingContext{
+		CloudProvider:     provider,
+		PredicateChecker:  simulator.NewTestPredicateChecker(),
+		Recorder:          recorder,
+		EstimatorName:     BinpackingEstimatorName,
+		PriorityExpander:  NewPriorityExpander(map[string]int{"ng2": 10}),
+		EstimationReports: NewEstimationReports(),
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+		"ng2": buildTestNodeInfo(n2),
+	}
+	// p3 doesn't fit on a new node of any node group.
+	pods := []*kube_api.Pod{BuildTestPod("p1", 2000, 0), BuildTestPod("p2", 2000, 0), BuildTestPod("p3", 5000, 0)}
+	return context, pods, []*kube_api.Node{n1, n2}, nodeInfos, recorder
+}
+
+func TestEvaluateScaleUp(t *testing.T) {
+	context, pods, nodes, nodeInfos, recorder := buildScaleUpEvaluationTest(t)
+
+	evaluation := EvaluateScaleUp(context, pods, nodes, nodeInfos)
+	assert.Equal(t, 3, evaluation.PendingPods)
+	assert.Equal(t, 2, len(evaluation.Options))
+	assert.Equal(t, ScaleUpOptionEvaluation{Rank: 1, NodeGroupId: "ng2", NodeCount: 2, FittingPods: 2},
+		withoutEstimation(evaluation.Options[0]))
+	assert.Equal(t, ScaleUpOptionEvaluation{Rank: 2, NodeGroupId: "ng1", NodeCount: 1, FittingPods: 2},
+		withoutEstimation(evaluation.Options[1]))
+	assert.Equal(t, 2, evaluation.Options[0].Estimation.NodeCount)
+
+	// Nothing is recorded by the evaluation.
+	assert.Empty(t, recorder.Events)
+	assert.Empty(t, context.EstimationReports.Reports())
+}
+
+func TestScaleUpEvaluationEndpoint(t *testing.T) {
+	context, pods, nodes, nodeInfos, _ := buildScaleUpEvaluationTest(t)
+	endpoint := NewScaleUpEvaluationEndpoint()
+	go func() {
+		request := <-endpoint.Requests()
+		request.Reply(EvaluateScaleUp(context, pods, nodes, nodeInfos), nil)
+	}()
+
+	w := httptest.NewRecorder()
+	endpoint.ServeHTTP(w, &http.Request{Method: "GET"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var evaluation ScaleUpEvaluation
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &evaluation))
+	ranked := make([]string, 0)
+	for _, option := range evaluation.Options {
+		ranked = append(ranked, option.NodeGroupId)
+	}
+	assert.Equal(t, []string{"ng2", "ng1"}, ranked)
+	assert.Equal(t, 2, evaluation.Options[0].FittingPods)
+
+	// Without a main loop, e.g. on a replica that is not the leader, the request times out.
+	endpoint.timeout = 10 * time.Millisecond
+	w = httptest.NewRecorder()
+	endpoint.ServeHTTP(w, &http.Request{Method: "GET"})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	endpoint.ServeHTTP(w, &http.Request{Method: "POST"})
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func withoutEstimation(option ScaleUpOptionEvaluation) ScaleUpOptionEvaluation {
+	option.Estimation = estimator.EstimationReport{}
+	return option
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+
+	"github.com/golang/glog"
+)
+
+// ScaleUpHintProvider supplies minimum sizes for node groups computed outside of the autoscaler,
+// for example from a queue depth, so that the cluster can grow before pods become pending.
+type ScaleUpHintProvider interface {
+	// MinSizeHints returns a map from node group id to the requested minimum size of the group.
+	MinSizeHints() (map[string]int, error)
+}
+
+// HttpScaleUpHintProvider fetches minimum size hints from an http endpoint. The endpoint
+// is expected to return a JSON object mapping node group ids to minimum sizes.
+type HttpScaleUpHintProvider struct {
+	url    string
+	client *http.Client
+}
+
+// NewHttpScaleUpHintProvider builds HttpScaleUpHintProvider.
+func NewHttpScaleUpHintProvider(url string, timeout time.Duration) *HttpScaleUpHintProvider {
+	return &HttpScaleUpHintProvider{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// UpdateMinSizeHints fetches the minimum size hints of context.ScaleUpHintProvider, if set, into
+// context.MinSizeHints. If the hints can't be fetched the error is logged and the last hints are
+// kept, so that the nodes added for them are not scaled down while the provider is unavailable.
+func UpdateMinSizeHints(context *AutoscalingContext) {
+	if context.ScaleUpHintProvider == nil {
+		return
+	}
+	hints, err := context.ScaleUpHintProvider.MinSizeHints()
+	if err != nil {
+		glog.Warningf("Failed to get scale-up hints, using the last ones: %v", err)
+		return
+	}
+	context.MinSizeHints = hints
+}
+
+// scaleDownMinSize returns the size below which the node group is not scaled down: its min size, or
+// its hint in context.MinSizeHints if higher. Otherwise the empty nodes added for a hint would be
+// removed after the scale down unneeded time, only to be added again by the next scale up.
+func scaleDownMinSize(context *AutoscalingContext, nodeGroup cloudprovider.NodeGroup) int {
+	minSize := nodeGroup.MinSize()
+	if hint, found := context.MinSizeHints[nodeGroup.Id()]; found && hint > minSize {
+		return hint
+	}
+	return minSize
+}
+
+// MinSizeHints returns the minimum size hints served by the endpoint.
+func (p *HttpScaleUpHintProvider) MinSizeHints() (map[string]int, error) {
+	resp, err := p.client.Get(p.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from %s: %d", p.url, resp.StatusCode)
+	}
+	hints := make(map[string]int)
+	if err := json.NewDecoder(resp.Body).Decode(&hints); err != nil {
+		return nil, fmt.Errorf("failed to decode hints from %s: %v", p.url, err)
+	}
+	return hints, nil
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+const (
+	// scaleUpHistoryDecay is the factor by which all counters are multiplied on every scale up.
+	scaleUpHistoryDecay = 0.5
+	// scaleUpHistoryMinCount is the value below which a counter is dropped.
+	scaleUpHistoryMinCount = 0.01
+)
+
+// ScaleUpHistory keeps a decaying counter of recent scale ups per node group id. It is used to
+// spread consecutive scale ups across node groups that are otherwise equally good options.
+type ScaleUpHistory struct {
+	counters map[string]float64
+}
+
+// NewScaleUpHistory builds new ScaleUpHistory.
+func NewScaleUpHistory() *ScaleUpHistory {
+	return &ScaleUpHistory{
+		counters: make(map[string]float64),
+	}
+}
+
+// RegisterScaleUp decays the counters of all node groups and bumps the counter of the given one.
+func (history *ScaleUpHistory) RegisterScaleUp(nodeGroupId string) {
+	for id, count := range history.counters {
+		count *= scaleUpHistoryDecay
+		if count < scaleUpHistoryMinCount {
+			delete(history.counters, id)
+		} else {
+			history.counters[id] = count
+		}
+	}
+	history.counters[nodeGroupId]++
+}
+
+// Count returns the decayed number of recent scale ups of the given node group. It is safe to
+// call on a nil history.
+func (history *ScaleUpHistory) Count(nodeGroupId string) float64 {
+	if history == nil {
+		return 0
+	}
+	return history.counters[nodeGroupId]
+}
@@ -0,0 +1,655 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	"k8s.io/contrib/cluster-autoscaler/simulator"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// staticScaleUpHintProvider returns the given hints, or err if set.
+type staticScaleUpHintProvider struct {
+	hints map[string]int
+	err   error
+}
+
+func (p *staticScaleUpHintProvider) MinSizeHints() (map[string]int, error) {
+	return p.hints, p.err
+}
+
+func buildTestNodeInfo(node *kube_api.Node) *schedulercache.NodeInfo {
+	nodeInfo := schedulercache.NewNodeInfo()
+	nodeInfo.SetNode(node)
+	return nodeInfo
+}
+
+func TestScaleUpOk(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 4000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+		"ng2": buildTestNodeInfo(n2),
+	}
+
+	p1 := BuildTestPod("p1", 2000, 0)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1, n2}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng2": 1}, scaledGroups)
+}
+
+func TestScaleUpMaxNodesTotal(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+		MaxNodesTotal:    2,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{"ng1": buildTestNodeInfo(n1)}
+
+	// Reaching the cluster total size is not an error, so that scale down still runs.
+	p1 := BuildTestPod("p1", 1000, 0)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1, n2}, nodeInfos)
+	assert.NoError(t, err)
+	assert.False(t, scaledUp)
+	assert.Empty(t, scaledGroups)
+}
+
+func TestScaleUpIgnoresCompletedPods(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+	}
+
+	// Job pods that completed or failed before they were scheduled.
+	p1 := BuildTestPod("p1", 800, 0)
+	p1.Status.Phase = kube_api.PodSucceeded
+	p2 := BuildTestPod("p2", 800, 0)
+	p2.Status.Phase = kube_api.PodFailed
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1, p2}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.False(t, scaledUp)
+	assert.Empty(t, scaledGroups)
+}
+
+func TestScaleUpWithMinSizeHint(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+		MinSizeHints:     map[string]int{"ng1": 4},
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{"ng1": buildTestNodeInfo(n1)}
+
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 3}, scaledGroups)
+
+	// The group already satisfies the hint.
+	scaledUp, err = ScaleUp(context, []*kube_api.Pod{}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.False(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 3}, scaledGroups)
+}
+
+func TestUpdateMinSizeHints(t *testing.T) {
+	provider := &staticScaleUpHintProvider{hints: map[string]int{"ng1": 4}}
+	context := &AutoscalingContext{ScaleUpHintProvider: provider}
+	UpdateMinSizeHints(context)
+	assert.Equal(t, map[string]int{"ng1": 4}, context.MinSizeHints)
+
+	// The last hints are kept while the provider fails.
+	provider.hints = nil
+	provider.err = fmt.Errorf("connection refused")
+	UpdateMinSizeHints(context)
+	assert.Equal(t, map[string]int{"ng1": 4}, context.MinSizeHints)
+
+	provider.hints = map[string]int{"ng1": 2}
+	provider.err = nil
+	UpdateMinSizeHints(context)
+	assert.Equal(t, map[string]int{"ng1": 2}, context.MinSizeHints)
+}
+
+func TestScaleUpDisabledNodeGroup(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+
+	context := &AutoscalingContext{
+		CloudProvider:      provider,
+		PredicateChecker:   simulator.NewTestPredicateChecker(),
+		Recorder:           kube_record.NewFakeRecorder(10),
+		EstimatorName:      BinpackingEstimatorName,
+		MinSizeHints:       map[string]int{"ng1": 3, "ng2": 3},
+		DisabledNodeGroups: map[string]bool{"ng1": true},
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+		"ng2": buildTestNodeInfo(n2),
+	}
+
+	p1 := BuildTestPod("p1", 800, 0)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1, n2}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng2": 2}, scaledGroups)
+}
+
+func TestScaleUpZeroMaxSizeNodeGroup(t *testing.T) {
+	n1 := BuildTestNode("n1", 4000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 0, 0, 0)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng2", n2)
+	assert.Equal(t, []string{"ng1"}, ZeroMaxSizeNodeGroups(provider))
+
+	context := &AutoscalingContext{
+		CloudProvider:     provider,
+		PredicateChecker:  simulator.NewTestPredicateChecker(),
+		Recorder:          kube_record.NewFakeRecorder(10),
+		EstimatorName:     BinpackingEstimatorName,
+		EstimationReports: NewEstimationReports(),
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+		"ng2": buildTestNodeInfo(n2),
+	}
+
+	// The pod would fit a node of ng1, but ng1 is not considered.
+	p1 := BuildTestPod("p1", 800, 0)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n2}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng2": 1}, scaledGroups)
+	assert.NotContains(t, context.EstimationReports.Reports(), "ng1")
+}
+
+func TestScaleUpPodTooLargeForAnyNodeGroup(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 4000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+
+	recorder := kube_record.NewFakeRecorder(10)
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         recorder,
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+		"ng2": buildTestNodeInfo(n2),
+	}
+
+	p1 := BuildTestPod("p1", 100000, 0)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1, n2}, nodeInfos)
+	assert.NoError(t, err)
+	assert.False(t, scaledUp)
+	assert.Empty(t, scaledGroups)
+	assert.Equal(t, 1, len(recorder.Events))
+	assert.Contains(t, <-recorder.Events, "PodTooLargeForAnyNodeGroup")
+
+	// A pod fitting only on the largest node group still triggers a scale up.
+	p2 := BuildTestPod("p2", 3000, 0)
+	scaledUp, err = ScaleUp(context, []*kube_api.Pod{p1, p2}, []*kube_api.Node{n1, n2}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng2": 1}, scaledGroups)
+}
+
+func TestScaleUpIgnoresPodsSafeToStayPending(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+	}
+
+	p1 := BuildTestPod("p1", 800, 0)
+	p1.Annotations = map[string]string{SafeToStayPendingAnnotation: "true"}
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.False(t, scaledUp)
+	assert.Empty(t, scaledGroups)
+
+	p2 := BuildTestPod("p2", 800, 0)
+	scaledUp, err = ScaleUp(context, []*kube_api.Pod{p1, p2}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 1}, scaledGroups)
+}
+
+func TestScaleUpIgnoresPodsWithUnboundClaims(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	claims := &cache.StoreToPVCFetcher{Store: cache.NewStore(cache.MetaNamespaceKeyFunc)}
+	claims.Add(&kube_api.PersistentVolumeClaim{
+		ObjectMeta: kube_api.ObjectMeta{Name: "unbound", Namespace: "default"},
+		Status:     kube_api.PersistentVolumeClaimStatus{Phase: kube_api.ClaimPending},
+	})
+	claims.Add(&kube_api.PersistentVolumeClaim{
+		ObjectMeta: kube_api.ObjectMeta{Name: "bound", Namespace: "default"},
+		Status:     kube_api.PersistentVolumeClaimStatus{Phase: kube_api.ClaimBound},
+	})
+
+	recorder := kube_record.NewFakeRecorder(10)
+	context := &AutoscalingContext{
+		CloudProvider:          provider,
+		PredicateChecker:       simulator.NewTestPredicateChecker(),
+		Recorder:               recorder,
+		EstimatorName:          BinpackingEstimatorName,
+		PersistentVolumeClaims: claims,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+	}
+
+	p1 := BuildTestPod("p1", 800, 0)
+	p1.Spec.Volumes = []kube_api.Volume{{
+		Name: "data",
+		VolumeSource: kube_api.VolumeSource{
+			PersistentVolumeClaim: &kube_api.PersistentVolumeClaimVolumeSource{ClaimName: "unbound"},
+		},
+	}}
+	p2 := BuildTestPod("p2", 800, 0)
+	p2.Status.Conditions = []kube_api.PodCondition{{
+		Type:    kube_api.PodScheduled,
+		Status:  kube_api.ConditionFalse,
+		Reason:  "Unschedulable",
+		Message: `PersistentVolumeClaim is not bound: "other"`,
+	}}
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1, p2}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.False(t, scaledUp)
+	assert.Empty(t, scaledGroups)
+	assert.Equal(t, 2, len(recorder.Events))
+	assert.Contains(t, <-recorder.Events, "NotTriggerScaleUpUnboundClaim")
+	assert.Contains(t, <-recorder.Events, "PersistentVolumeClaim other is not bound")
+
+	// Once the claim is bound the pod triggers a scale up.
+	p1.Spec.Volumes[0].PersistentVolumeClaim.ClaimName = "bound"
+	scaledUp, err = ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 1}, scaledGroups)
+}
+
+func TestScaleUpSpreadsAcrossNodeGroups(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+		ScaleUpHistory:   NewScaleUpHistory(),
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+		"ng2": buildTestNodeInfo(n2),
+	}
+
+	p1 := BuildTestPod("p1", 800, 0)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1, n2}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 1}, scaledGroups)
+
+	p2 := BuildTestPod("p2", 800, 0)
+	scaledUp, err = ScaleUp(context, []*kube_api.Pod{p2}, []*kube_api.Node{n1, n2}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 1, "ng2": 1}, scaledGroups)
+
+	// Once both groups were scaled up the older scale up has decayed more.
+	p3 := BuildTestPod("p3", 800, 0)
+	scaledUp, err = ScaleUp(context, []*kube_api.Pod{p3}, []*kube_api.Node{n1, n2}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 2, "ng2": 1}, scaledGroups)
+}
+
+func TestScaleUpRecordsSummaryEvent(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+
+	recorder := kube_record.NewFakeRecorder(10)
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         recorder,
+		EstimatorName:    BinpackingEstimatorName,
+		AutoscalerObject: &kube_api.ObjectReference{Kind: "Deployment", Namespace: "kube-system", Name: "cluster-autoscaler"},
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+	}
+
+	p1 := BuildTestPod("p1", 800, 0)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+
+	// The summary event is followed by the pod event.
+	assert.Equal(t, 2, len(recorder.Events))
+	assert.Equal(t, "Normal ScaledUpGroup group ng1 scaled up, sizes (current/new): 1/2, pods: 1", <-recorder.Events)
+	assert.Contains(t, <-recorder.Events, "TriggeredScaleUp")
+}
+
+func TestScaleUpWithUnreadyNodeGroup(t *testing.T) {
+	n1 := BuildTestNode("n1", 500, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	// ng1 is at max and still provisioning 2 of its nodes.
+	provider.AddNodeGroup("ng1", 1, 3, 3)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+
+	nodes := []*kube_api.Node{n1, n2}
+	unready, err := CheckGroupsAndNodes(nodes, provider)
+	assert.NoError(t, err)
+
+	context := &AutoscalingContext{
+		CloudProvider:     provider,
+		PredicateChecker:  simulator.NewTestPredicateChecker(),
+		Recorder:          kube_record.NewFakeRecorder(10),
+		EstimatorName:     BinpackingEstimatorName,
+		UnreadyNodeGroups: unready,
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+		"ng2": buildTestNodeInfo(n2),
+	}
+
+	// p1 doesn't fit on the upcoming ng1 nodes, so ng2 is scaled up.
+	p1 := BuildTestPod("p1", 800, 0)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, nodes, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng2": 1}, scaledGroups)
+
+	// p2 and p3 wait for the upcoming ng1 nodes.
+	p2 := BuildTestPod("p2", 400, 0)
+	p3 := BuildTestPod("p3", 400, 0)
+	scaledUp, err = ScaleUp(context, []*kube_api.Pod{p2, p3}, nodes, nodeInfos)
+	assert.NoError(t, err)
+	assert.False(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng2": 1}, scaledGroups)
+}
+
+// warmPoolNodeGroup is a node group with a warm pool of the given size.
+type warmPoolNodeGroup struct {
+	cloudprovider.NodeGroup
+	warm int
+}
+
+func (ng *warmPoolNodeGroup) WarmPoolSize() int {
+	return ng.warm
+}
+
+// warmPoolCloudProvider wraps node groups of the test cloud provider in warmPoolNodeGroups.
+type warmPoolCloudProvider struct {
+	*test.TestCloudProvider
+	warm map[string]int
+}
+
+func (p *warmPoolCloudProvider) NodeGroups() []cloudprovider.NodeGroup {
+	result := make([]cloudprovider.NodeGroup, 0)
+	for _, nodeGroup := range p.TestCloudProvider.NodeGroups() {
+		result = append(result, &warmPoolNodeGroup{NodeGroup: nodeGroup, warm: p.warm[nodeGroup.Id()]})
+	}
+	return result
+}
+
+func TestScaleUpWithWarmPool(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	p1 := BuildTestPod("p1", 800, 0)
+	p2 := BuildTestPod("p2", 800, 0)
+
+	for _, tc := range []struct {
+		warm     int
+		expected map[string]int
+	}{
+		// Without a warm pool large enough both node groups need 2 new instances, ng1 is picked.
+		{warm: 1, expected: map[string]int{"ng1": 2}},
+		// The warm pool of ng2 covers both nodes, so no new instances are launched.
+		{warm: 2, expected: map[string]int{"ng2": 2}},
+	} {
+		scaledGroups := make(map[string]int)
+		provider := &warmPoolCloudProvider{
+			TestCloudProvider: test.NewTestCloudProvider(func(id string, delta int) error {
+				scaledGroups[id] += delta
+				return nil
+			}, nil),
+			warm: map[string]int{"ng2": tc.warm},
+		}
+		provider.AddNodeGroup("ng1", 1, 10, 1)
+		provider.AddNodeGroup("ng2", 1, 10, 1)
+		provider.AddNode("ng1", n1)
+		provider.AddNode("ng2", n2)
+
+		context := &AutoscalingContext{
+			CloudProvider:    provider,
+			PredicateChecker: simulator.NewTestPredicateChecker(),
+			Recorder:         kube_record.NewFakeRecorder(10),
+			EstimatorName:    BinpackingEstimatorName,
+		}
+		nodeInfos := map[string]*schedulercache.NodeInfo{
+			"ng1": buildTestNodeInfo(n1),
+			"ng2": buildTestNodeInfo(n2),
+		}
+		scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1, p2}, []*kube_api.Node{n1, n2}, nodeInfos)
+		assert.NoError(t, err)
+		assert.True(t, scaledUp)
+		assert.Equal(t, tc.expected, scaledGroups)
+	}
+}
+
+func TestScaleUpFallsBackOnFailedNodeGroup(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	failing := map[string]bool{"ng1": true}
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		if failing[id] {
+			return fmt.Errorf("failed to set size of %s", id)
+		}
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+		ScaleUpTracker:   NewScaleUpTracker(time.Minute, 0, 5*time.Minute),
+	}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"ng1": buildTestNodeInfo(n1),
+		"ng2": buildTestNodeInfo(n2),
+	}
+	nodes := []*kube_api.Node{n1, n2}
+
+	// ng1 is the best option, but fails to scale up.
+	p1 := BuildTestPod("p1", 800, 0)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, nodes, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng2": 1}, scaledGroups)
+	assert.True(t, context.ScaleUpTracker.IsBackedOff("ng1", context.Now()))
+
+	// ng1 is backed off, so it isn't tried again even though it works now.
+	failing["ng1"] = false
+	context.Recorder = kube_record.NewFakeRecorder(10)
+	scaledUp, err = ScaleUp(context, []*kube_api.Pod{p1}, nodes, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng2": 2}, scaledGroups)
+
+	// The scale up fails only when every node group fails.
+	failing["ng2"] = true
+	context.ScaleUpTracker = NewScaleUpTracker(time.Minute, 0, 5*time.Minute)
+	failing["ng1"] = true
+	context.Recorder = kube_record.NewFakeRecorder(10)
+	_, err = ScaleUp(context, []*kube_api.Pod{p1}, nodes, nodeInfos)
+	assert.Error(t, err)
+	assert.Equal(t, map[string]int{"ng2": 2}, scaledGroups)
+}
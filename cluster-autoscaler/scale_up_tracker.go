@@ -0,0 +1,211 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	kube_api "k8s.io/kubernetes/pkg/api"
+
+	"github.com/golang/glog"
+)
+
+// ScaleUpRequest contains information about a node group scale up that is waiting for new nodes.
+type ScaleUpRequest struct {
+	// NodeGroupId is the id of the node group that was scaled up.
+	NodeGroupId string
+	// Increase is the number of requested nodes.
+	Increase int
+	// Warm is the number of requested nodes that are taken from a warm pool of pre-provisioned instances.
+	Warm int
+	// Time is the time of the (last) scale up.
+	Time time.Time
+	// ExpectedAddTime is the time by which the nodes should be registered in Kubernetes.
+	ExpectedAddTime time.Time
+}
+
+// ScaleUpTracker keeps track of scale ups that are waiting for new nodes to register. If the nodes
+// don't show up within maxNodeProvisionTime (maxWarmNodeProvisionTime if all of them are taken from
+// a warm pool), or the cloud provider reports that they failed to
+// launch, the request is considered failed and the target size of the node group is decreased back,
+// so that the group doesn't sit with phantom capacity. The node group is then backed off, so that
+// other node groups are scaled up instead.
+type ScaleUpTracker struct {
+	maxNodeProvisionTime     time.Duration
+	maxWarmNodeProvisionTime time.Duration
+	backoff                  time.Duration
+	requests                 map[string]*ScaleUpRequest
+	// backoffMutex guards backedOffUntil, which is also read by scale up evaluations served over http.
+	backoffMutex sync.Mutex
+	// backedOffUntil holds the time until which each node group with a failed scale up is backed off.
+	backedOffUntil map[string]time.Time
+}
+
+// NewScaleUpTracker builds new ScaleUpTracker. A maxWarmNodeProvisionTime of 0 waits for nodes taken
+// from a warm pool as long as for any other node.
+func NewScaleUpTracker(maxNodeProvisionTime, maxWarmNodeProvisionTime, backoff time.Duration) *ScaleUpTracker {
+	if maxWarmNodeProvisionTime <= 0 || maxWarmNodeProvisionTime > maxNodeProvisionTime {
+		maxWarmNodeProvisionTime = maxNodeProvisionTime
+	}
+	return &ScaleUpTracker{
+		maxNodeProvisionTime:     maxNodeProvisionTime,
+		maxWarmNodeProvisionTime: maxWarmNodeProvisionTime,
+		backoff:                  backoff,
+		requests:                 make(map[string]*ScaleUpRequest),
+		backedOffUntil:           make(map[string]time.Time),
+	}
+}
+
+// BackOff keeps the node group from being scaled up for the backoff duration.
+func (tracker *ScaleUpTracker) BackOff(nodeGroupId string, now time.Time) {
+	if tracker.backoff <= 0 {
+		return
+	}
+	glog.Warningf("Backing off scale up of %s for %v", nodeGroupId, tracker.backoff)
+	tracker.backoffMutex.Lock()
+	defer tracker.backoffMutex.Unlock()
+	tracker.backedOffUntil[nodeGroupId] = now.Add(tracker.backoff)
+}
+
+// IsBackedOff returns true if a scale up of the node group failed within the backoff duration. It
+// is safe to call on a nil tracker.
+func (tracker *ScaleUpTracker) IsBackedOff(nodeGroupId string, now time.Time) bool {
+	if tracker == nil {
+		return false
+	}
+	tracker.backoffMutex.Lock()
+	defer tracker.backoffMutex.Unlock()
+	until, found := tracker.backedOffUntil[nodeGroupId]
+	return found && now.Before(until)
+}
+
+// RegisterScaleUp records that the given node group was increased by delta at the given time, warm
+// of the new nodes being taken from a warm pool. The nodes are expected within the max warm node
+// provision time only if all nodes requested from the group are warm.
+func (tracker *ScaleUpTracker) RegisterScaleUp(nodeGroupId string, delta, warm int, now time.Time) {
+	request, found := tracker.requests[nodeGroupId]
+	if !found {
+		request = &ScaleUpRequest{NodeGroupId: nodeGroupId}
+		tracker.requests[nodeGroupId] = request
+	}
+	if warm > delta {
+		warm = delta
+	}
+	request.Increase += delta
+	request.Warm += warm
+	request.Time = now
+	if request.Warm == request.Increase {
+		request.ExpectedAddTime = now.Add(tracker.maxWarmNodeProvisionTime)
+	} else {
+		request.ExpectedAddTime = now.Add(tracker.maxNodeProvisionTime)
+	}
+}
+
+// Requests returns the scale up requests that are still waiting for nodes.
+func (tracker *ScaleUpTracker) Requests() map[string]*ScaleUpRequest {
+	return tracker.requests
+}
+
+// Update removes fulfilled scale up requests and decreases the target size of node groups whose
+// requested nodes didn't register by the time they were expected, or failed to launch according to
+// node groups implementing cloudprovider.ScaleUpFailureNodeGroup. Such node groups are backed off.
+// It returns the number of nodes given up in each of these node groups.
+func (tracker *ScaleUpTracker) Update(nodes []*kube_api.Node, cloudProvider cloudprovider.CloudProvider, now time.Time) (map[string]int, error) {
+	tracker.backoffMutex.Lock()
+	for id, until := range tracker.backedOffUntil {
+		if !now.Before(until) {
+			delete(tracker.backedOffUntil, id)
+		}
+	}
+	tracker.backoffMutex.Unlock()
+
+	timedOut := make(map[string]int)
+	if len(tracker.requests) == 0 {
+		return timedOut, nil
+	}
+	registered := make(map[string]int)
+	for _, node := range nodes {
+		nodeGroup, err := cloudProvider.NodeGroupForNode(node)
+		if err != nil {
+			return timedOut, err
+		}
+		if nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+			continue
+		}
+		registered[nodeGroup.Id()]++
+	}
+
+	for _, nodeGroup := range cloudProvider.NodeGroups() {
+		request, found := tracker.requests[nodeGroup.Id()]
+		if !found {
+			continue
+		}
+		size, err := nodeGroup.TargetSize()
+		if err != nil {
+			return timedOut, err
+		}
+		missing := size - registered[nodeGroup.Id()]
+		if missing <= 0 {
+			glog.V(4).Infof("Scale up in %s fulfilled", nodeGroup.Id())
+			delete(tracker.requests, nodeGroup.Id())
+			continue
+		}
+		if missing > request.Increase {
+			missing = request.Increase
+		}
+		if now.Before(request.ExpectedAddTime) {
+			failure := scaleUpFailure(nodeGroup, request.Time)
+			if failure == "" {
+				continue
+			}
+			glog.Warningf("Scale up in %s failed: %s, decreasing target size to %d", nodeGroup.Id(), failure, size-missing)
+		} else {
+			glog.Warningf("Scale up in %s timed out: %d nodes didn't register within %v, decreasing target size to %d",
+				nodeGroup.Id(), missing, request.ExpectedAddTime.Sub(request.Time), size-missing)
+		}
+		tracker.BackOff(nodeGroup.Id(), now)
+		if err := nodeGroup.DecreaseTargetSize(-missing); err != nil {
+			return timedOut, err
+		}
+		timedOutScaleUps.WithLabelValues(nodeGroup.Id()).Add(float64(missing))
+		timedOut[nodeGroup.Id()] = missing
+		delete(tracker.requests, nodeGroup.Id())
+	}
+	return timedOut, nil
+}
+
+// scaleUpFailure returns the reason why instances of the node group requested since the given time
+// failed to launch, or an empty string if none failed or the node group doesn't report failures.
+// Failures that can't be checked are logged and the scale up is left to time out.
+func scaleUpFailure(nodeGroup cloudprovider.NodeGroup, since time.Time) string {
+	reporter, ok := nodeGroup.(cloudprovider.ScaleUpFailureNodeGroup)
+	if !ok {
+		return ""
+	}
+	failure, err := reporter.ScaleUpFailure(since)
+	if err != nil {
+		glog.Warningf("Failed to check scale up of %s: %v", nodeGroup.Id(), err)
+		return ""
+	}
+	if failure != "" {
+		failedScaleUps.WithLabelValues(nodeGroup.Id()).Inc()
+	}
+	return failure
+}
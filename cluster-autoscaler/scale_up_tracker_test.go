@@ -0,0 +1,180 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaleUpTrackerTimeout(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	nodeGroup := provider.NodeGroups()[0]
+
+	now := time.Now()
+	tracker := NewScaleUpTracker(time.Minute, 0, 0)
+	assert.NoError(t, nodeGroup.IncreaseSize(2))
+	tracker.RegisterScaleUp("ng1", 2, 0, now)
+
+	// Still within the provisioning window.
+	timedOut, err := tracker.Update([]*kube_api.Node{n1}, provider, now.Add(30*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(timedOut))
+	size, _ := nodeGroup.TargetSize()
+	assert.Equal(t, 3, size)
+	assert.Equal(t, 1, len(tracker.Requests()))
+
+	// The nodes never appeared.
+	timedOut, err = tracker.Update([]*kube_api.Node{n1}, provider, now.Add(2*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"ng1": 2}, timedOut)
+	size, _ = nodeGroup.TargetSize()
+	assert.Equal(t, 1, size)
+	assert.Equal(t, 0, len(tracker.Requests()))
+	assert.False(t, tracker.IsBackedOff("ng1", now.Add(2*time.Minute)))
+}
+
+func TestScaleUpTrackerFulfilled(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+
+	now := time.Now()
+	tracker := NewScaleUpTracker(time.Minute, 0, 0)
+	tracker.RegisterScaleUp("ng1", 1, 0, now)
+
+	timedOut, err := tracker.Update([]*kube_api.Node{n1, n2}, provider, now.Add(2*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(timedOut))
+	size, _ := provider.NodeGroups()[0].TargetSize()
+	assert.Equal(t, 2, size)
+	assert.Equal(t, 0, len(tracker.Requests()))
+}
+
+// failedLaunchNodeGroup reports that the instances of the wrapped node group failed to launch.
+type failedLaunchNodeGroup struct {
+	cloudprovider.NodeGroup
+	failure string
+}
+
+func (ng *failedLaunchNodeGroup) ScaleUpFailure(since time.Time) (string, error) {
+	return ng.failure, nil
+}
+
+// failedLaunchCloudProvider wraps the node groups of the test cloud provider in failedLaunchNodeGroups
+// with the failures of failures.
+type failedLaunchCloudProvider struct {
+	*test.TestCloudProvider
+	failures map[string]string
+}
+
+func (p *failedLaunchCloudProvider) wrap(nodeGroup cloudprovider.NodeGroup) cloudprovider.NodeGroup {
+	if nodeGroup == nil {
+		return nil
+	}
+	return &failedLaunchNodeGroup{NodeGroup: nodeGroup, failure: p.failures[nodeGroup.Id()]}
+}
+
+func (p *failedLaunchCloudProvider) NodeGroups() []cloudprovider.NodeGroup {
+	result := make([]cloudprovider.NodeGroup, 0)
+	for _, nodeGroup := range p.TestCloudProvider.NodeGroups() {
+		result = append(result, p.wrap(nodeGroup))
+	}
+	return result
+}
+
+func (p *failedLaunchCloudProvider) NodeGroupForNode(node *kube_api.Node) (cloudprovider.NodeGroup, error) {
+	nodeGroup, err := p.TestCloudProvider.NodeGroupForNode(node)
+	return p.wrap(nodeGroup), err
+}
+
+func TestScaleUpTrackerFailedLaunch(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	testProvider := test.NewTestCloudProvider(nil, nil)
+	testProvider.AddNodeGroup("ng1", 1, 10, 1)
+	testProvider.AddNode("ng1", n1)
+	provider := &failedLaunchCloudProvider{TestCloudProvider: testProvider, failures: map[string]string{}}
+	nodeGroup := provider.NodeGroups()[0]
+
+	now := time.Now()
+	tracker := NewScaleUpTracker(15*time.Minute, 0, 5*time.Minute)
+	assert.NoError(t, nodeGroup.IncreaseSize(2))
+	tracker.RegisterScaleUp("ng1", 2, 0, now)
+
+	timedOut, err := tracker.Update([]*kube_api.Node{n1}, provider, now.Add(30*time.Second))
+	assert.NoError(t, err)
+	assert.Empty(t, timedOut)
+	assert.False(t, tracker.IsBackedOff("ng1", now.Add(30*time.Second)))
+
+	// The failure is given up long before max node provision time.
+	provider.failures["ng1"] = "InsufficientInstanceCapacity"
+	timedOut, err = tracker.Update([]*kube_api.Node{n1}, provider, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"ng1": 2}, timedOut)
+	size, _ := nodeGroup.TargetSize()
+	assert.Equal(t, 1, size)
+	assert.Empty(t, tracker.Requests())
+
+	assert.True(t, tracker.IsBackedOff("ng1", now.Add(5*time.Minute)))
+	assert.False(t, tracker.IsBackedOff("ng1", now.Add(6*time.Minute)))
+	tracker.Update([]*kube_api.Node{n1}, provider, now.Add(6*time.Minute))
+	assert.Empty(t, tracker.backedOffUntil)
+}
+
+func TestScaleUpTrackerWarmPool(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	nodeGroup := provider.NodeGroups()[0]
+
+	now := time.Now()
+	tracker := NewScaleUpTracker(15*time.Minute, 2*time.Minute, 0)
+	assert.NoError(t, nodeGroup.IncreaseSize(2))
+	tracker.RegisterScaleUp("ng1", 2, 2, now)
+	assert.Equal(t, now.Add(2*time.Minute), tracker.Requests()["ng1"].ExpectedAddTime)
+
+	// Nodes taken from a warm pool are given up after max warm node provision time.
+	timedOut, err := tracker.Update([]*kube_api.Node{n1}, provider, now.Add(3*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"ng1": 2}, timedOut)
+	size, _ := nodeGroup.TargetSize()
+	assert.Equal(t, 1, size)
+
+	// Nodes are waited for as long as any other once a node is launched outside of the warm pool.
+	assert.NoError(t, nodeGroup.IncreaseSize(2))
+	tracker.RegisterScaleUp("ng1", 1, 1, now)
+	tracker.RegisterScaleUp("ng1", 1, 0, now)
+	assert.Equal(t, now.Add(15*time.Minute), tracker.Requests()["ng1"].ExpectedAddTime)
+	timedOut, err = tracker.Update([]*kube_api.Node{n1}, provider, now.Add(3*time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, timedOut)
+}
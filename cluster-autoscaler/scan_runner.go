@@ -0,0 +1,76 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+)
+
+// errScanAbandoned is returned by ScanRunner.Run when the scan exceeded its timeout.
+var errScanAbandoned = errors.New("scan abandoned after timeout")
+
+// ScanRunner runs scan iterations, bounding every iteration with a timeout. A scan that exceeds
+// the timeout is abandoned: its context is cancelled and Run returns, so that the main loop goes on.
+// The abandoned scan may keep running until a call it is blocked in returns, so the scans that
+// follow it shouldn't share its state.
+type ScanRunner struct {
+	timeout time.Duration
+}
+
+// NewScanRunner builds ScanRunner. Timeout 0 means that scans are never abandoned.
+func NewScanRunner(timeout time.Duration) *ScanRunner {
+	return &ScanRunner{timeout: timeout}
+}
+
+// Run runs a single scan and waits until it returns or its timeout elapses, in which case it
+// returns errScanAbandoned.
+func (r *ScanRunner) Run(scan func(ctx context.Context)) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if r.timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), r.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scan(ctx)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errScanAbandoned
+	}
+}
+
+// scanAbandoned returns true if the scan of the context timed out. Scans check it before starting
+// scale operations, so that an abandoned scan doesn't change the cluster.
+func scanAbandoned(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		glog.Warningf("Scan abandoned: %v", ctx.Err())
+		return true
+	}
+	return false
+}
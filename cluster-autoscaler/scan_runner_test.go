@@ -0,0 +1,65 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestScanRunnerTimeout(t *testing.T) {
+	runner := NewScanRunner(50 * time.Millisecond)
+
+	// A scan stuck in a slow step, which ignores the context like a hanging api call would.
+	release := make(chan struct{})
+	finished := make(chan error, 1)
+	err := runner.Run(func(ctx context.Context) {
+		<-release
+		finished <- ctx.Err()
+	})
+	assert.Equal(t, errScanAbandoned, err)
+
+	// The next scan runs while the abandoned one is still blocked.
+	called := false
+	err = runner.Run(func(ctx context.Context) { called = true })
+	assert.NoError(t, err)
+	assert.True(t, called)
+
+	close(release)
+	assert.Equal(t, context.DeadlineExceeded, <-finished)
+}
+
+func TestScanRunnerWithoutTimeout(t *testing.T) {
+	runner := NewScanRunner(0)
+	var scanErr error
+	err := runner.Run(func(ctx context.Context) {
+		time.Sleep(10 * time.Millisecond)
+		scanErr = ctx.Err()
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, scanErr)
+}
+
+func TestScanAbandoned(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.False(t, scanAbandoned(ctx))
+	cancel()
+	assert.True(t, scanAbandoned(ctx))
+}
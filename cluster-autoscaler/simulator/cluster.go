@@ -23,6 +23,7 @@ import (
 	"math/rand"
 	"time"
 
+	"k8s.io/contrib/cluster-autoscaler/utils/drain"
 	kube_api "k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/api/resource"
 	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
@@ -50,20 +51,34 @@ type NodeToBeRemoved struct {
 	PodsToReschedule []*kube_api.Pod
 }
 
-// FindNodesToRemove finds nodes that can be removed. Returns also an information about good
-// rescheduling location for each of the pods.
+// UnremovableNode contains information about a node that can't be removed.
+type UnremovableNode struct {
+	// Node that can't be removed.
+	Node *kube_api.Node
+	// Reason explains why the node can't be removed.
+	Reason string
+}
+
+// FindNodesToRemove finds nodes that can be removed. Returns also the evaluated candidates that can't
+// be removed and an information about good rescheduling location for each of the pods.
 func FindNodesToRemove(candidates []*kube_api.Node, allNodes []*kube_api.Node, pods []*kube_api.Pod,
 	client *kube_client.Client, predicateChecker *PredicateChecker, maxCount int,
 	fastCheck bool, oldHints map[string]string, usageTracker *UsageTracker,
-	timestamp time.Time) (nodesToRemove []NodeToBeRemoved, podReschedulingHints map[string]string, finalError error) {
+	timestamp time.Time) (nodesToRemove []NodeToBeRemoved, unremovableNodes []UnremovableNode, podReschedulingHints map[string]string,
+	finalError error) {
 
 	nodeNameToNodeInfo := schedulercache.CreateNodeNameToInfoMap(pods)
 	for _, node := range allNodes {
-		if nodeInfo, found := nodeNameToNodeInfo[node.Name]; found {
-			nodeInfo.SetNode(node)
+		nodeInfo, found := nodeNameToNodeInfo[node.Name]
+		if !found {
+			// Nodes without pods, e.g. ones whose pods all completed.
+			nodeInfo = schedulercache.NewNodeInfo()
+			nodeNameToNodeInfo[node.Name] = nodeInfo
 		}
+		nodeInfo.SetNode(node)
 	}
 	result := make([]NodeToBeRemoved, 0)
+	unremovable := make([]UnremovableNode, 0)
 
 	evaluationType := "Detailed evaluation"
 	if fastCheck {
@@ -86,10 +101,12 @@ candidateloop:
 			}
 			if err != nil {
 				glog.V(2).Infof("%s: node %s cannot be removed: %v", evaluationType, node.Name, err)
+				unremovable = append(unremovable, UnremovableNode{Node: node, Reason: err.Error()})
 				continue candidateloop
 			}
 		} else {
 			glog.V(2).Infof("%s: nodeInfo for %s not found", evaluationType, node.Name)
+			unremovable = append(unremovable, UnremovableNode{Node: node, Reason: "node info not found"})
 			continue candidateloop
 		}
 		findProblems := findPlaceFor(node.Name, podsToRemove, allNodes, nodeNameToNodeInfo, predicateChecker, oldHints, newHints,
@@ -105,10 +122,11 @@ candidateloop:
 				break candidateloop
 			}
 		} else {
-			glog.V(2).Infof("%s: node %s is not suitable for removal %v", evaluationType, node.Name, err)
+			glog.V(2).Infof("%s: node %s is not suitable for removal %v", evaluationType, node.Name, findProblems)
+			unremovable = append(unremovable, UnremovableNode{Node: node, Reason: findProblems.Error()})
 		}
 	}
-	return result, newHints, nil
+	return result, unremovable, newHints, nil
 }
 
 // FindEmptyNodesToRemove finds empty nodes that can be removed.
@@ -136,35 +154,50 @@ func FindEmptyNodesToRemove(candidates []*kube_api.Node, pods []*kube_api.Pod) [
 }
 
 // CalculateUtilization calculates utilization of a node, defined as total amount of requested resources divided by capacity.
-func CalculateUtilization(node *kube_api.Node, nodeInfo *schedulercache.NodeInfo) (float64, error) {
-	cpu, err := calculateUtilizationOfResource(node, nodeInfo, kube_api.ResourceCPU)
+// If skipDaemonSetPods is set, requests of DaemonSet and mirror pods are not counted. Such pods run on every node and
+// don't need to be moved anywhere when the node is removed, so counting them only makes small nodes look busier than
+// they effectively are.
+func CalculateUtilization(node *kube_api.Node, nodeInfo *schedulercache.NodeInfo, skipDaemonSetPods bool) (float64, error) {
+	cpu, err := calculateUtilizationOfResource(node, nodeInfo, kube_api.ResourceCPU, skipDaemonSetPods)
 	if err != nil {
 		return 0, err
 	}
-	mem, err := calculateUtilizationOfResource(node, nodeInfo, kube_api.ResourceMemory)
+	mem, err := calculateUtilizationOfResource(node, nodeInfo, kube_api.ResourceMemory, skipDaemonSetPods)
 	if err != nil {
 		return 0, err
 	}
 	return math.Max(cpu, mem), nil
 }
 
-func calculateUtilizationOfResource(node *kube_api.Node, nodeInfo *schedulercache.NodeInfo, resourceName kube_api.ResourceName) (float64, error) {
-	nodeCapacity, found := node.Status.Capacity[resourceName]
+func calculateUtilizationOfResource(node *kube_api.Node, nodeInfo *schedulercache.NodeInfo, resourceName kube_api.ResourceName,
+	skipDaemonSetPods bool) (float64, error) {
+	nodeAllocatable, found := NodeAllocatable(node)[resourceName]
 	if !found {
 		return 0, fmt.Errorf("Failed to get %v from %s", resourceName, node.Name)
 	}
-	if nodeCapacity.MilliValue() == 0 {
+	if nodeAllocatable.MilliValue() == 0 {
 		return 0, fmt.Errorf("%v is 0 at %s", resourceName, node.Name)
 	}
 	podsRequest := resource.MustParse("0")
 	for _, pod := range nodeInfo.Pods() {
+		if skipDaemonSetPods && isDaemonSetOrMirrorPod(pod) {
+			continue
+		}
 		for _, container := range pod.Spec.Containers {
 			if resourceValue, found := container.Resources.Requests[resourceName]; found {
 				podsRequest.Add(resourceValue)
 			}
 		}
 	}
-	return float64(podsRequest.MilliValue()) / float64(nodeCapacity.MilliValue()), nil
+	return float64(podsRequest.MilliValue()) / float64(nodeAllocatable.MilliValue()), nil
+}
+
+func isDaemonSetOrMirrorPod(pod *kube_api.Pod) bool {
+	if drain.IsMirrorPod(pod) {
+		return true
+	}
+	creatorRef, err := drain.CreatorRef(pod)
+	return err == nil && creatorRef != nil && creatorRef.Reference.Kind == "DaemonSet"
 }
 
 // TODO: We don't need to pass list of nodes here as they are already available in nodeInfos.
@@ -191,7 +224,7 @@ func findPlaceFor(removedNode string, pods []*kube_api.Pod, nodes []*kube_api.No
 				glog.Warningf("No node in nodeInfo %s -> %v", nodename, nodeInfo)
 				return false
 			}
-			nodeInfo.Node().Status.Allocatable = nodeInfo.Node().Status.Capacity
+			nodeInfo.Node().Status.Allocatable = NodeAllocatable(nodeInfo.Node())
 			err := predicateChecker.CheckPredicates(pod, nodeInfo)
 			glog.V(4).Infof("Evaluation %s for %s/%s -> %v", nodename, pod.Namespace, pod.Name, err)
 			if err == nil {
@@ -241,7 +274,7 @@ func findPlaceFor(removedNode string, pods []*kube_api.Pod, nodes []*kube_api.No
 				}
 			}
 			if !foundPlace {
-				return fmt.Errorf("failed to find place for %s", podKey)
+				return fmt.Errorf("failed to find place for %s", podKey(pod))
 			}
 		}
 
@@ -17,13 +17,18 @@ limitations under the License.
 package simulator
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
 	. "k8s.io/contrib/cluster-autoscaler/utils/test"
 
 	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+	"k8s.io/kubernetes/pkg/api/unversioned"
 	"k8s.io/kubernetes/pkg/kubelet/types"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm/predicates"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/stretchr/testify/assert"
@@ -36,16 +41,153 @@ func TestUtilization(t *testing.T) {
 	nodeInfo := schedulercache.NewNodeInfo(pod, pod, pod2)
 	node := BuildTestNode("node1", 2000, 2000000)
 
-	utilization, err := CalculateUtilization(node, nodeInfo)
+	utilization, err := CalculateUtilization(node, nodeInfo, false)
 	assert.NoError(t, err)
 	assert.InEpsilon(t, 2.0/10, utilization, 0.01)
 
 	node2 := BuildTestNode("node1", 2000, -1)
 
-	_, err = CalculateUtilization(node2, nodeInfo)
+	_, err = CalculateUtilization(node2, nodeInfo, false)
 	assert.Error(t, err)
 }
 
+func TestUtilizationOfAllocatable(t *testing.T) {
+	pod := BuildTestPod("p1", 500, 200000)
+	nodeInfo := schedulercache.NewNodeInfo(pod)
+
+	// Half of the cpu is reserved for the system.
+	node := BuildTestNode("node1", 2000, 2000000)
+	node.Status.Allocatable = kube_api.ResourceList{
+		kube_api.ResourceCPU:    *resource.NewMilliQuantity(1000, resource.DecimalSI),
+		kube_api.ResourceMemory: *resource.NewQuantity(2000000, resource.DecimalSI),
+		kube_api.ResourcePods:   *resource.NewQuantity(100, resource.DecimalSI),
+	}
+	utilization, err := CalculateUtilization(node, nodeInfo, false)
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 5.0/10, utilization, 0.01)
+
+	// Nodes not reporting allocatable resources fall back to their capacity.
+	node.Status.Allocatable = nil
+	utilization, err = CalculateUtilization(node, nodeInfo, false)
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 2.5/10, utilization, 0.01)
+}
+
+func TestFindPlaceRespectsAllocatable(t *testing.T) {
+	pod1 := BuildTestPod("p1", 300, 500000)
+	new1 := BuildTestPod("p2", 600, 500000)
+
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"n1": schedulercache.NewNodeInfo(pod1),
+	}
+	node1 := BuildTestNode("n1", 1000, 2000000)
+	node1.Status.Allocatable = kube_api.ResourceList{
+		kube_api.ResourceCPU:    *resource.NewMilliQuantity(800, resource.DecimalSI),
+		kube_api.ResourceMemory: *resource.NewQuantity(2000000, resource.DecimalSI),
+		kube_api.ResourcePods:   *resource.NewQuantity(100, resource.DecimalSI),
+	}
+	nodeInfos["n1"].SetNode(node1)
+
+	// The pod fits into the capacity of n1 but not into its allocatable cpu.
+	err := findPlaceFor(
+		"x",
+		[]*kube_api.Pod{new1},
+		[]*kube_api.Node{node1},
+		nodeInfos, NewTestPredicateChecker(),
+		make(map[string]string), make(map[string]string), NewUsageTracker(), time.Now())
+	assert.Error(t, err)
+}
+
+// fakeVolumeInfo serves persistent volumes and claims from memory.
+type fakeVolumeInfo struct {
+	volumes map[string]*kube_api.PersistentVolume
+	claims  map[string]*kube_api.PersistentVolumeClaim
+}
+
+func (f *fakeVolumeInfo) GetPersistentVolumeInfo(name string) (*kube_api.PersistentVolume, error) {
+	return f.volumes[name], nil
+}
+
+func (f *fakeVolumeInfo) GetPersistentVolumeClaimInfo(namespace string, name string) (*kube_api.PersistentVolumeClaim, error) {
+	return f.claims[namespace+"/"+name], nil
+}
+
+func TestFindPlaceRespectsVolumeZone(t *testing.T) {
+	volumeInfo := &fakeVolumeInfo{
+		volumes: map[string]*kube_api.PersistentVolume{
+			"pv1": {ObjectMeta: kube_api.ObjectMeta{
+				Name:   "pv1",
+				Labels: map[string]string{unversioned.LabelZoneFailureDomain: "zone-a"},
+			}},
+		},
+		claims: map[string]*kube_api.PersistentVolumeClaim{
+			"default/pvc1": {
+				ObjectMeta: kube_api.ObjectMeta{Name: "pvc1", Namespace: "default"},
+				Spec:       kube_api.PersistentVolumeClaimSpec{VolumeName: "pv1"},
+			},
+		},
+	}
+	predicateChecker := &PredicateChecker{
+		predicates: map[string]algorithm.FitPredicate{
+			"default":              predicates.GeneralPredicates,
+			"NoVolumeZoneConflict": predicates.NewVolumeZonePredicate(volumeInfo, volumeInfo),
+		},
+	}
+
+	pod := BuildTestPod("p1", 300, 500000)
+	pod.Namespace = "default"
+	pod.Spec.Volumes = []kube_api.Volume{{
+		Name: "data",
+		VolumeSource: kube_api.VolumeSource{
+			PersistentVolumeClaim: &kube_api.PersistentVolumeClaimVolumeSource{ClaimName: "pvc1"},
+		},
+	}}
+	node1 := BuildTestNode("n1", 1000, 2000000)
+	node1.Labels = map[string]string{unversioned.LabelZoneFailureDomain: "zone-b"}
+	node2 := BuildTestNode("n2", 1000, 2000000)
+	node2.Labels = map[string]string{unversioned.LabelZoneFailureDomain: "zone-a"}
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"n1": schedulercache.NewNodeInfo(),
+		"n2": schedulercache.NewNodeInfo(),
+	}
+	nodeInfos["n1"].SetNode(node1)
+	nodeInfos["n2"].SetNode(node2)
+
+	// The pod can't follow its volume to another zone.
+	err := findPlaceFor("x", []*kube_api.Pod{pod}, []*kube_api.Node{node1}, nodeInfos, predicateChecker,
+		make(map[string]string), make(map[string]string), NewUsageTracker(), time.Now())
+	assert.Error(t, err)
+
+	newHints := make(map[string]string)
+	err = findPlaceFor("x", []*kube_api.Pod{pod}, []*kube_api.Node{node1, node2}, nodeInfos, predicateChecker,
+		make(map[string]string), newHints, NewUsageTracker(), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "n2", newHints[pod.Namespace+"/"+pod.Name])
+}
+
+func TestUtilizationWithoutDaemonSetPods(t *testing.T) {
+	pod := BuildTestPod("p1", 100, 200000)
+	daemonSetPod := BuildTestPod("ds", 200, 200000)
+	daemonSetPod.Annotations = map[string]string{
+		"kubernetes.io/created-by": "{\"kind\":\"SerializedReference\",\"apiVersion\":\"v1\",\"reference\":{\"kind\":\"DaemonSet\"}}",
+	}
+	mirrorPod := BuildTestPod("mirror", 300, 200000)
+	mirrorPod.Annotations = map[string]string{
+		types.ConfigMirrorAnnotationKey: "",
+	}
+
+	nodeInfo := schedulercache.NewNodeInfo(pod, daemonSetPod, mirrorPod)
+	node := BuildTestNode("node1", 2000, 2000000)
+
+	utilization, err := CalculateUtilization(node, nodeInfo, false)
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 6.0/20, utilization, 0.01)
+
+	utilization, err = CalculateUtilization(node, nodeInfo, true)
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 2.0/20, utilization, 0.01)
+}
+
 func TestFindPlaceAllOk(t *testing.T) {
 	pod1 := BuildTestPod("p1", 300, 500000)
 	new1 := BuildTestPod("p2", 600, 500000)
@@ -112,6 +254,53 @@ func TestFindPlaceAllBas(t *testing.T) {
 	assert.Contains(t, newHints, new2.Namespace+"/"+new2.Name)
 }
 
+func TestFindPlaceRespectsTaints(t *testing.T) {
+	taints, _ := json.Marshal([]kube_api.Taint{{Key: "dedicated", Value: "gpu", Effect: kube_api.TaintEffectNoSchedule}})
+	otherTaints, _ := json.Marshal([]kube_api.Taint{{Key: "dedicated", Value: "db", Effect: kube_api.TaintEffectNoSchedule}})
+	tolerations, _ := json.Marshal([]kube_api.Toleration{{Key: "dedicated", Operator: kube_api.TolerationOpEqual, Value: "gpu", Effect: kube_api.TaintEffectNoSchedule}})
+
+	pod1 := BuildTestPod("p1", 300, 500000)
+	pod1.Annotations = map[string]string{kube_api.TolerationsAnnotationKey: string(tolerations)}
+
+	node1 := BuildTestNode("n1", 1000, 2000000)
+	node1.Annotations = map[string]string{kube_api.TaintsAnnotationKey: string(taints)}
+	node2 := BuildTestNode("n2", 1000, 2000000)
+	node2.Annotations = map[string]string{kube_api.TaintsAnnotationKey: string(otherTaints)}
+	node3 := BuildTestNode("n3", 1000, 2000000)
+	node3.Annotations = map[string]string{kube_api.TaintsAnnotationKey: string(taints)}
+
+	nodeInfos := map[string]*schedulercache.NodeInfo{
+		"n1": schedulercache.NewNodeInfo(pod1),
+		"n2": schedulercache.NewNodeInfo(),
+		"n3": schedulercache.NewNodeInfo(),
+	}
+	nodeInfos["n1"].SetNode(node1)
+	nodeInfos["n2"].SetNode(node2)
+	nodeInfos["n3"].SetNode(node3)
+
+	// The only other node has a taint that p1 doesn't tolerate.
+	newHints := make(map[string]string)
+	err := findPlaceFor(
+		"n1",
+		[]*kube_api.Pod{pod1},
+		[]*kube_api.Node{node1, node2},
+		nodeInfos, NewTestPredicateChecker(),
+		make(map[string]string), newHints, NewUsageTracker(), time.Now())
+	assert.Error(t, err)
+	assert.Empty(t, newHints)
+
+	// Another tolerated node exists.
+	newHints = make(map[string]string)
+	err = findPlaceFor(
+		"n1",
+		[]*kube_api.Pod{pod1},
+		[]*kube_api.Node{node1, node2, node3},
+		nodeInfos, NewTestPredicateChecker(),
+		make(map[string]string), newHints, NewUsageTracker(), time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{pod1.Namespace + "/" + pod1.Name: "n3"}, newHints)
+}
+
 func TestFindNone(t *testing.T) {
 	pod1 := BuildTestPod("p1", 300, 500000)
 
@@ -0,0 +1,63 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"fmt"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+)
+
+// ResourceEphemeralStorage is the name of the local ephemeral storage resource. The vendored api
+// and scheduler predate it, so requests of it are checked by EphemeralStoragePredicate.
+const ResourceEphemeralStorage kube_api.ResourceName = "ephemeral-storage"
+
+// PodEphemeralStorageRequest returns the sum of ephemeral-storage requests of the pod containers.
+// Requests are summed as quantities, so requests in different units, e.g. 1Gi and 500M, are exact.
+func PodEphemeralStorageRequest(pod *kube_api.Pod) resource.Quantity {
+	result := resource.Quantity{}
+	for _, container := range pod.Spec.Containers {
+		if request, ok := container.Resources.Requests[ResourceEphemeralStorage]; ok {
+			result.Add(request)
+		}
+	}
+	return result
+}
+
+// EphemeralStoragePredicate checks whether the ephemeral-storage requests of the pod, together with
+// the requests of the pods already on the node, fit within the allocatable ephemeral storage of the
+// node. Nodes that don't report ephemeral storage are not checked.
+func EphemeralStoragePredicate(pod *kube_api.Pod, nodeInfo *schedulercache.NodeInfo) (bool, error) {
+	node := nodeInfo.Node()
+	if node == nil {
+		return false, fmt.Errorf("node not found")
+	}
+	allocatable, found := NodeAllocatable(node)[ResourceEphemeralStorage]
+	if !found {
+		return true, nil
+	}
+	requested := PodEphemeralStorageRequest(pod)
+	if requested.IsZero() {
+		return true, nil
+	}
+	for _, existing := range nodeInfo.Pods() {
+		requested.Add(PodEphemeralStorageRequest(existing))
+	}
+	return requested.Cmp(allocatable) <= 0, nil
+}
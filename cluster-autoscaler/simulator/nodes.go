@@ -17,6 +17,7 @@ limitations under the License.
 package simulator
 
 import (
+	kube_util "k8s.io/contrib/cluster-autoscaler/utils/kubernetes"
 	kube_api "k8s.io/kubernetes/pkg/api"
 	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/fields"
@@ -47,7 +48,7 @@ func GetRequiredPodsForNode(nodename string, client *kube_client.Client) ([]*kub
 
 	podsOnNewNode := make([]*kube_api.Pod, 0)
 	for i, pod := range allPodList.Items {
-		if _, found := podsToRemoveMap[pod.SelfLink]; !found {
+		if _, found := podsToRemoveMap[pod.SelfLink]; !found && !kube_util.IsPodTerminal(&pod) {
 			podsOnNewNode = append(podsOnNewNode, &allPodList.Items[i])
 		}
 	}
@@ -55,14 +56,31 @@ func GetRequiredPodsForNode(nodename string, client *kube_client.Client) ([]*kub
 }
 
 // BuildNodeInfoForNode build a NodeInfo structure for the given node as if the node was just created.
+// Pods are fitted against the allocatable resources of the node, see NodeAllocatable.
 func BuildNodeInfoForNode(node *kube_api.Node, client *kube_client.Client) (*schedulercache.NodeInfo, error) {
 	requiredPods, err := GetRequiredPodsForNode(node.Name, client)
 	if err != nil {
 		return nil, err
 	}
 	result := schedulercache.NewNodeInfo(requiredPods...)
+	if len(node.Status.Allocatable) == 0 {
+		nodeCopy := *node
+		nodeCopy.Status.Allocatable = NodeAllocatable(node)
+		node = &nodeCopy
+	}
 	if err := result.SetNode(node); err != nil {
 		return nil, err
 	}
 	return result, nil
 }
+
+// NodeAllocatable returns the resources of the node available for pods, i.e. its capacity minus
+// the resources reserved for the system and Kubernetes daemons. Nodes that don't report
+// allocatable resources, e.g. ones run by old kubelets, are assumed to have their whole capacity
+// available.
+func NodeAllocatable(node *kube_api.Node) kube_api.ResourceList {
+	if len(node.Status.Allocatable) == 0 {
+		return node.Status.Capacity
+	}
+	return node.Status.Allocatable
+}
@@ -19,16 +19,37 @@ package simulator
 import (
 	"fmt"
 
+	kube_util "k8s.io/contrib/cluster-autoscaler/utils/kubernetes"
 	kube_api "k8s.io/kubernetes/pkg/api"
 	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/util/sets"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm/predicates"
 	// We need to import provider to intialize default scheduler.
 	_ "k8s.io/kubernetes/plugin/pkg/scheduler/algorithmprovider"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/factory"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
+
+	"github.com/golang/glog"
 )
 
+// requiredPredicates are the predicates that are always checked, even if the scheduler algorithm
+// provider doesn't list them. In particular pods must not be assumed to fit on nodes whose taints
+// they don't tolerate, nor pods with zonal persistent volumes on nodes in other zones, otherwise
+// scale down would remove nodes whose pods have nowhere to go.
+var requiredPredicates = sets.NewString("GeneralPredicates", "PodToleratesNodeTaints", "NoVolumeZoneConflict")
+
+// PredicateError is returned by CheckPredicates when a predicate rejects the pod.
+type PredicateError struct {
+	// PredicateName is the name of the predicate that rejected the pod.
+	PredicateName string
+	message       string
+}
+
+func (e *PredicateError) Error() string {
+	return e.message
+}
+
 // PredicateChecker checks whether all required predicates are matched for given Pod and Node
 type PredicateChecker struct {
 	predicates map[string]algorithm.FitPredicate
@@ -41,10 +62,11 @@ func NewPredicateChecker(kubeClient *kube_client.Client) (*PredicateChecker, err
 		return nil, err
 	}
 	schedulerConfigFactory := factory.NewConfigFactory(kubeClient, "", kube_api.DefaultHardPodAffinitySymmetricWeight, kube_api.DefaultFailureDomains)
-	predicates, err := schedulerConfigFactory.GetPredicates(provider.FitPredicateKeys)
+	predicates, err := schedulerConfigFactory.GetPredicates(provider.FitPredicateKeys.Union(requiredPredicates))
 	if err != nil {
 		return nil, err
 	}
+	predicates["EphemeralStorage"] = EphemeralStoragePredicate
 	schedulerConfigFactory.Run()
 	return &PredicateChecker{
 		predicates: predicates,
@@ -55,18 +77,25 @@ func NewPredicateChecker(kubeClient *kube_client.Client) (*PredicateChecker, err
 func NewTestPredicateChecker() *PredicateChecker {
 	return &PredicateChecker{
 		predicates: map[string]algorithm.FitPredicate{
-			"default": predicates.GeneralPredicates,
+			"GeneralPredicates":      predicates.GeneralPredicates,
+			"PodToleratesNodeTaints": predicates.NewTolerationMatchPredicate(nil),
+			"EphemeralStorage":       EphemeralStoragePredicate,
 		},
 	}
 }
 
-// FitsAny checks if the given pod can be place on any of the given nodes.
+// FitsAny checks if the given pod can be place on any of the given nodes. Nodes under disk pressure,
+// or under memory pressure for BestEffort pods, are skipped, as their kubelets reject such pods.
 func (p *PredicateChecker) FitsAny(pod *kube_api.Pod, nodeInfos map[string]*schedulercache.NodeInfo) (string, error) {
 	for name, nodeInfo := range nodeInfos {
 		// Be sure that the node is schedulable.
 		if nodeInfo.Node().Spec.Unschedulable {
 			continue
 		}
+		if pressure, found := kube_util.NodePressure(nodeInfo.Node(), pod); found {
+			glog.V(4).Infof("Skipping node %s for pod %s - %s", name, pod.Name, pressure)
+			continue
+		}
 		if err := p.CheckPredicates(pod, nodeInfo); err == nil {
 			return name, nil
 		}
@@ -74,19 +103,26 @@ func (p *PredicateChecker) FitsAny(pod *kube_api.Pod, nodeInfos map[string]*sche
 	return "", fmt.Errorf("cannot put pod %s on any node", pod.Name)
 }
 
-// CheckPredicates checks if the given pod can be placed on the given node.
+// CheckPredicates checks if the given pod can be placed on the given node. If not, the returned
+// error is a PredicateError.
 func (p *PredicateChecker) CheckPredicates(pod *kube_api.Pod, nodeInfo *schedulercache.NodeInfo) error {
-	for _, predicate := range p.predicates {
+	for name, predicate := range p.predicates {
 		match, err := predicate(pod, nodeInfo)
 		nodename := "unknown"
 		if nodeInfo.Node() != nil {
 			nodename = nodeInfo.Node().Name
 		}
 		if err != nil {
-			return fmt.Errorf("cannot put %s on %s due to %v", pod.Name, nodename, err)
+			return &PredicateError{
+				PredicateName: name,
+				message:       fmt.Sprintf("cannot put %s on %s due to %v", pod.Name, nodename, err),
+			}
 		}
 		if !match {
-			return fmt.Errorf("cannot put %s on %s", pod.Name, nodename)
+			return &PredicateError{
+				PredicateName: name,
+				message:       fmt.Sprintf("cannot put %s on %s", pod.Name, nodename),
+			}
 		}
 	}
 	return nil
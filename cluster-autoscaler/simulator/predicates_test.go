@@ -20,6 +20,8 @@ import (
 	"testing"
 
 	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/stretchr/testify/assert"
@@ -57,3 +59,38 @@ func TestPredicates(t *testing.T) {
 	assert.NoError(t, predicateChecker.CheckPredicates(p4, ni2))
 	assert.Error(t, predicateChecker.CheckPredicates(p3, ni2))
 }
+
+func TestEphemeralStoragePredicate(t *testing.T) {
+	buildPod := func(name string, storage int64) *kube_api.Pod {
+		pod := BuildTestPod(name, 100, 0)
+		pod.Spec.Containers[0].Resources.Requests[ResourceEphemeralStorage] = *resource.NewQuantity(storage, resource.BinarySI)
+		return pod
+	}
+	p1 := buildPod("p1", 6000)
+	p2 := buildPod("p2", 5000)
+	p3 := BuildTestPod("p3", 100, 0)
+
+	node := BuildTestNode("n1", 1000, 2000000)
+	nodeInfo := schedulercache.NewNodeInfo(p1)
+	nodeInfo.SetNode(node)
+
+	// The node doesn't report ephemeral storage.
+	fits, err := EphemeralStoragePredicate(p2, nodeInfo)
+	assert.NoError(t, err)
+	assert.True(t, fits)
+
+	node.Status.Capacity[ResourceEphemeralStorage] = *resource.NewQuantity(10000, resource.BinarySI)
+	fits, err = EphemeralStoragePredicate(p2, nodeInfo)
+	assert.NoError(t, err)
+	assert.False(t, fits)
+
+	fits, err = EphemeralStoragePredicate(p3, nodeInfo)
+	assert.NoError(t, err)
+	assert.True(t, fits)
+
+	err = NewTestPredicateChecker().CheckPredicates(p2, nodeInfo)
+	assert.Error(t, err)
+	predicateErr, ok := err.(*PredicateError)
+	assert.True(t, ok)
+	assert.Equal(t, "EphemeralStorage", predicateErr.PredicateName)
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_errors "k8s.io/kubernetes/pkg/api/errors"
+	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
+)
+
+const (
+	// StatusConfigMapName is the name of the ConfigMap to which cluster autoscaler writes its status.
+	StatusConfigMapName = "cluster-autoscaler-status"
+	// StatusConfigMapKey is the key under which the JSON encoded status is stored in the ConfigMap.
+	StatusConfigMapKey = "status"
+)
+
+// ClusterStatus is a machine-readable snapshot of the autoscaler state written on every scan.
+type ClusterStatus struct {
+	// LastScanTime is the time when the scan that produced this status started.
+	LastScanTime time.Time `json:"lastScanTime"`
+	// LastScaleUpTime is the time of the last successful scale up.
+	LastScaleUpTime time.Time `json:"lastScaleUpTime"`
+	// LastScaleDownTime is the time of the last scale down that deleted a node.
+	LastScaleDownTime time.Time `json:"lastScaleDownTime"`
+	// PendingPods is the number of pods that the scheduler marked as unschedulable.
+	PendingPods int `json:"pendingPods"`
+	// NodeGroups contains the status of every node group.
+	NodeGroups []NodeGroupStatus `json:"nodeGroups"`
+	// EstimationReports contains the last scale up estimation of node groups, keyed by node group id.
+	EstimationReports map[string]EstimationReport `json:"estimationReports,omitempty"`
+	// ScaleDownReasons contains the number of nodes with each reason of the last scale down decision
+	// about them, keyed by reason. The decisions about single nodes are served over http instead, as
+	// they would make the ConfigMap of a large cluster exceed its size limit.
+	ScaleDownReasons map[string]int `json:"scaleDownReasons,omitempty"`
+}
+
+// NodeGroupStatus contains the sizes of a single node group.
+type NodeGroupStatus struct {
+	Id string `json:"id"`
+	// Current is the number of nodes of the node group registered in Kubernetes.
+	Current int `json:"current"`
+	// Target is the target size of the node group in the cloud provider.
+	Target  int `json:"target"`
+	MinSize int `json:"minSize"`
+	MaxSize int `json:"maxSize"`
+	// LastScaleUpTime is the time of the last successful scale up of the node group.
+	LastScaleUpTime time.Time `json:"lastScaleUpTime"`
+	// LastScaleDownTime is the time of the last node removed from the node group in scale down.
+	LastScaleDownTime time.Time `json:"lastScaleDownTime"`
+}
+
+// BuildNodeGroupStatuses returns the status of all node groups of the cloud provider. Scale times are
+// taken from activity, which may be nil.
+func BuildNodeGroupStatuses(nodes []*kube_api.Node, cloudProvider cloudprovider.CloudProvider,
+	activity *ScaleActivity) ([]NodeGroupStatus, error) {
+	registered := make(map[string]int)
+	for _, node := range nodes {
+		nodeGroup, err := cloudProvider.NodeGroupForNode(node)
+		if err != nil {
+			return nil, err
+		}
+		if nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+			continue
+		}
+		registered[nodeGroup.Id()]++
+	}
+
+	result := make([]NodeGroupStatus, 0)
+	for _, nodeGroup := range cloudProvider.NodeGroups() {
+		size, err := nodeGroup.TargetSize()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, NodeGroupStatus{
+			Id:                nodeGroup.Id(),
+			Current:           registered[nodeGroup.Id()],
+			Target:            size,
+			MinSize:           nodeGroup.MinSize(),
+			MaxSize:           nodeGroup.MaxSize(),
+			LastScaleUpTime:   activity.LastScaleUp(nodeGroup.Id()),
+			LastScaleDownTime: activity.LastScaleDown(nodeGroup.Id()),
+		})
+	}
+	return result, nil
+}
+
+// WriteStatusConfigMap stores the JSON encoded status in the StatusConfigMapName ConfigMap in the given
+// namespace, creating the ConfigMap if it doesn't exist yet.
+func WriteStatusConfigMap(client kube_client.ConfigMapsNamespacer, namespace string, status *ClusterStatus) error {
+	encoded, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	configMaps := client.ConfigMaps(namespace)
+	configMap, err := configMaps.Get(StatusConfigMapName)
+	if err != nil {
+		if !kube_errors.IsNotFound(err) {
+			return err
+		}
+		configMap = &kube_api.ConfigMap{
+			ObjectMeta: kube_api.ObjectMeta{
+				Namespace: namespace,
+				Name:      StatusConfigMapName,
+			},
+			Data: map[string]string{StatusConfigMapKey: string(encoded)},
+		}
+		_, err = configMaps.Create(configMap)
+		return err
+	}
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+	configMap.Data[StatusConfigMapKey] = string(encoded)
+	_, err = configMaps.Update(configMap)
+	return err
+}
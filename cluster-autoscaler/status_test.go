@@ -0,0 +1,122 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
+	. "k8s.io/contrib/cluster-autoscaler/utils/test"
+
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_errors "k8s.io/kubernetes/pkg/api/errors"
+	kube_api_unversioned "k8s.io/kubernetes/pkg/api/unversioned"
+	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/watch"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConfigMaps keeps ConfigMaps of a single namespace in memory.
+type fakeConfigMaps struct {
+	configMaps map[string]*kube_api.ConfigMap
+	updates    int
+}
+
+func (f *fakeConfigMaps) ConfigMaps(namespace string) kube_client.ConfigMapsInterface {
+	return f
+}
+
+func (f *fakeConfigMaps) Get(name string) (*kube_api.ConfigMap, error) {
+	configMap, found := f.configMaps[name]
+	if !found {
+		return nil, kube_errors.NewNotFound(kube_api_unversioned.GroupResource{Resource: "configmaps"}, name)
+	}
+	configMapCopy := *configMap
+	return &configMapCopy, nil
+}
+
+func (f *fakeConfigMaps) List(opts kube_api.ListOptions) (*kube_api.ConfigMapList, error) {
+	return &kube_api.ConfigMapList{}, nil
+}
+
+func (f *fakeConfigMaps) Create(configMap *kube_api.ConfigMap) (*kube_api.ConfigMap, error) {
+	f.configMaps[configMap.Name] = configMap
+	return configMap, nil
+}
+
+func (f *fakeConfigMaps) Delete(name string) error {
+	delete(f.configMaps, name)
+	return nil
+}
+
+func (f *fakeConfigMaps) Update(configMap *kube_api.ConfigMap) (*kube_api.ConfigMap, error) {
+	f.updates++
+	f.configMaps[configMap.Name] = configMap
+	return configMap, nil
+}
+
+func (f *fakeConfigMaps) Watch(opts kube_api.ListOptions) (watch.Interface, error) {
+	return watch.NewFake(), nil
+}
+
+func TestWriteStatusConfigMap(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 3)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng1", n2)
+
+	now := time.Date(2016, 10, 1, 12, 0, 0, 0, time.UTC)
+	activity := NewScaleActivity()
+	activity.RegisterScaleUp("ng1", now.Add(-time.Minute))
+
+	nodeGroupStatuses, err := BuildNodeGroupStatuses([]*kube_api.Node{n1, n2}, provider, activity)
+	assert.NoError(t, err)
+	assert.Equal(t, []NodeGroupStatus{{Id: "ng1", Current: 2, Target: 3, MinSize: 1, MaxSize: 10,
+		LastScaleUpTime: now.Add(-time.Minute)}}, nodeGroupStatuses)
+
+	status := &ClusterStatus{
+		LastScanTime:    now,
+		LastScaleUpTime: now.Add(-time.Minute),
+		PendingPods:     4,
+		NodeGroups:      nodeGroupStatuses,
+	}
+	client := &fakeConfigMaps{configMaps: make(map[string]*kube_api.ConfigMap)}
+
+	// The ConfigMap is created on the first write.
+	assert.NoError(t, WriteStatusConfigMap(client, "kube-system", status))
+	configMap, found := client.configMaps[StatusConfigMapName]
+	assert.True(t, found)
+	assert.Equal(t, "kube-system", configMap.Namespace)
+	assert.Equal(t, 0, client.updates)
+
+	var written ClusterStatus
+	assert.NoError(t, json.Unmarshal([]byte(configMap.Data[StatusConfigMapKey]), &written))
+	assert.Equal(t, *status, written)
+
+	// And updated afterwards.
+	status.LastScanTime = now.Add(10 * time.Second)
+	status.PendingPods = 0
+	assert.NoError(t, WriteStatusConfigMap(client, "kube-system", status))
+	assert.Equal(t, 1, client.updates)
+	assert.NoError(t, json.Unmarshal([]byte(client.configMaps[StatusConfigMapName].Data[StatusConfigMapKey]), &written))
+	assert.Equal(t, *status, written)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	kube_api "k8s.io/kubernetes/pkg/api"
+	kube_api_unversioned "k8s.io/kubernetes/pkg/api/unversioned"
+	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/labels"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// PodNameEnv is the env variable that should contain the name of the autoscaler pod,
+	// set with the downward api.
+	PodNameEnv = "POD_NAME"
+	// PodNamespaceEnv is the env variable that should contain the namespace of the autoscaler pod,
+	// set with the downward api.
+	PodNamespaceEnv = "POD_NAMESPACE"
+)
+
+// GetAutoscalerObjectReference returns the object on which the summaries of scaling decisions are
+// recorded: the deployment running the autoscaler or, if there is none, the autoscaler pod itself.
+// The pod is identified by PodNameEnv and PodNamespaceEnv. Returns nil if they are not set.
+func GetAutoscalerObjectReference(kubeClient *kube_client.Client) (*kube_api.ObjectReference, error) {
+	name := os.Getenv(PodNameEnv)
+	namespace := os.Getenv(PodNamespaceEnv)
+	if name == "" || namespace == "" {
+		return nil, nil
+	}
+	pod, err := kubeClient.Pods(namespace).Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get autoscaler pod %s/%s: %v", namespace, name, err)
+	}
+	deployments, err := kubeClient.Extensions().Deployments(namespace).List(kube_api.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments in %s: %v", namespace, err)
+	}
+	for _, deployment := range deployments.Items {
+		selector, err := kube_api_unversioned.LabelSelectorAsSelector(deployment.Spec.Selector)
+		if err != nil {
+			glog.Warningf("Failed to parse selector of deployment %s/%s: %v", namespace, deployment.Name, err)
+			continue
+		}
+		if !selector.Empty() && selector.Matches(labels.Set(pod.Labels)) {
+			return &kube_api.ObjectReference{
+				Kind:            "Deployment",
+				APIVersion:      "extensions/v1beta1",
+				Namespace:       namespace,
+				Name:            deployment.Name,
+				UID:             deployment.UID,
+				ResourceVersion: deployment.ResourceVersion,
+			}, nil
+		}
+	}
+	return &kube_api.ObjectReference{
+		Kind:            "Pod",
+		APIVersion:      "v1",
+		Namespace:       namespace,
+		Name:            pod.Name,
+		UID:             pod.UID,
+		ResourceVersion: pod.ResourceVersion,
+	}, nil
+}
+
+// recordSummaryEvent records a scaling decision on the autoscaler object, if it is known.
+func recordSummaryEvent(context *AutoscalingContext, eventType, reason, messageFmt string, args ...interface{}) {
+	if context.AutoscalerObject == nil {
+		return
+	}
+	context.Recorder.Eventf(context.AutoscalerObject, eventType, reason, messageFmt, args...)
+}
+
+// recordScaleDownSummary records the removal of a node on the autoscaler object, together with its
+// utilization, the number of its pods that were rescheduled and the resulting size of its node
+// group, and counts the node in the scaled down nodes metric.
+func recordScaleDownSummary(context *AutoscalingContext, node *kube_api.Node, nodeGroup cloudprovider.NodeGroup,
+	utilization float64, pods int) {
+	scaledDownNodes.WithLabelValues(nodeGroup.Id()).Inc()
+	newSize := "unknown"
+	if size, err := targetSize(context, nodeGroup); err != nil {
+		glog.Warningf("Failed to get size of node group %s: %v", nodeGroup.Id(), err)
+	} else {
+		newSize = fmt.Sprint(size)
+	}
+	recordSummaryEvent(context, kube_api.EventTypeNormal, ReasonScaledDownNode,
+		"node %s removed from group %s, utilization: %v, pods to reschedule: %d, new size: %s",
+		node.Name, nodeGroup.Id(), utilization, pods, newSize)
+}
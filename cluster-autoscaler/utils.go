@@ -17,22 +17,157 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"reflect"
+	"sort"
 	"time"
 
 	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
 	"k8s.io/contrib/cluster-autoscaler/simulator"
+	"k8s.io/contrib/cluster-autoscaler/utils/clock"
+	kube_util "k8s.io/contrib/cluster-autoscaler/utils/kubernetes"
 
 	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
 	kube_api_unversioned "k8s.io/kubernetes/pkg/api/unversioned"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
 	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/plugin/pkg/scheduler/algorithm/predicates"
 	"k8s.io/kubernetes/plugin/pkg/scheduler/schedulercache"
 
 	"github.com/golang/glog"
 )
 
+// AutoscalingContext contains user-configurable constant and configuration-related objects passed to
+// scale up/scale down functions.
+type AutoscalingContext struct {
+	// CloudProvider used in CA.
+	CloudProvider cloudprovider.CloudProvider
+	// ClientSet interface.
+	ClientSet *kube_client.Client
+	// Recorder for recording events.
+	Recorder kube_record.EventRecorder
+	// PredicateChecker to check if a pod can fit into a node.
+	PredicateChecker *simulator.PredicateChecker
+	// MaxEmptyBulkDelete is a number of empty nodes that can be removed at the same time.
+	MaxEmptyBulkDelete int
+	// ScaleDownUnneededTime sets the duration CA expects a node to be unneeded/eligible for removal
+	// before scaling down the node.
+	ScaleDownUnneededTime time.Duration
+	// MaxNodesTotal sets the maximum number of nodes in the whole cluster
+	MaxNodesTotal int
+	// MinNodesTotal sets the minimum number of nodes in the whole cluster, counted as the sum of
+	// node group target sizes. 0 if disabled.
+	MinNodesTotal int
+	// ResourceLimits bounds the total cores and memory of the cluster. Nil if disabled.
+	ResourceLimits *ResourceLimits
+	// NodeTemplates contains a sample node of every node group that had nodes, keyed by node group id.
+	NodeTemplates map[string]*kube_api.Node
+	// EstimatorName is the estimator used to estimate the number of needed nodes in scale up.
+	EstimatorName string
+	// ExpanderRandomTieBreak makes scale up pick a random node group among equally good options.
+	ExpanderRandomTieBreak bool
+	// BalanceSimilarNodeGroups makes scale up split new nodes between the best node group and the
+	// node groups similar to it, keeping their sizes balanced.
+	BalanceSimilarNodeGroups bool
+	// BalancingIgnoredLabels contains keys of labels ignored when comparing node groups for balancing.
+	BalancingIgnoredLabels map[string]bool
+	// ExternalExpander picks the node group to scale up instead of BestExpansionOption. Nil if disabled.
+	ExternalExpander ExternalExpander
+	// PriorityExpander limits scale up to the node groups with the highest priority. Nil if disabled.
+	PriorityExpander *PriorityExpander
+	// PodClassExpanders picks the expander from the classes of the pods triggering a scale up,
+	// overriding ExternalExpander and PriorityExpander. Nil if disabled.
+	PodClassExpanders *PodClassExpanders
+	// EstimationReports keeps the last estimation report of every node group. Nil if disabled.
+	EstimationReports *EstimationReports
+	// ScaleDownReports keeps the last scale down decision about every node. Nil if disabled.
+	ScaleDownReports *ScaleDownReports
+	// EstimatorResourceMode defines whether pod requests or limits are used in the estimation.
+	EstimatorResourceMode string
+	// ScaleUpTracker tracks scale ups waiting for new nodes. Nil if disabled.
+	ScaleUpTracker *ScaleUpTracker
+	// ScaleUpHintProvider supplies external minimum size hints for node groups. Nil if disabled.
+	ScaleUpHintProvider ScaleUpHintProvider
+	// MinSizeHints contains the minimum sizes supplied by ScaleUpHintProvider, keyed by node group id.
+	// Scale up grows node groups to them and scale down doesn't shrink node groups below them.
+	// Updated on every scan, the last hints are kept while the provider fails.
+	MinSizeHints map[string]int
+	// PersistentVolumeClaims looks up the persistent volume claims of unschedulable pods, so that pods
+	// waiting for a claim to be bound don't trigger scale up. Nil if claims are not watched, then only
+	// the unschedulable reason of the pod is checked.
+	PersistentVolumeClaims predicates.PersistentVolumeClaimInfo
+	// DisabledNodeGroups contains ids of node groups that are neither scaled up nor down.
+	DisabledNodeGroups map[string]bool
+	// UnreadyNodeGroups contains ids of node groups whose nodes are not in sync with their target size.
+	// They are neither scaled up nor down until they are ready. Updated on every scan.
+	UnreadyNodeGroups map[string]bool
+	// NewNodeGroups contains ids of node groups registered less than --new-node-group-grace-period
+	// ago. They are neither scaled up nor down until the grace period elapses. Updated on every scan.
+	NewNodeGroups map[string]bool
+	// UnhealthyNodeGroups contains ids of node groups whose requested nodes kept failing to register
+	// for --max-divergent-scans scans. They are not scaled up until a new node registers. Updated on
+	// every scan.
+	UnhealthyNodeGroups map[string]bool
+	// ScaleUpHistory records recent scale ups to spread them across equally good node groups.
+	// Nil if disabled.
+	ScaleUpHistory *ScaleUpHistory
+	// AutoscalerObject is the deployment or pod of the autoscaler on which summaries of scaling
+	// decisions are recorded. Nil if disabled.
+	AutoscalerObject *kube_api.ObjectReference
+	// PodEvicter evicts pods from nodes before they are removed. Nil if pods are not evicted.
+	PodEvicter PodEvicter
+	// NodeCordoner cordons nodes before they are drained. Nil if nodes are not cordoned.
+	NodeCordoner NodeCordoner
+	// MaxPodEvictionTime is the maximum time to retry evictions refused for a node that is scaled down.
+	MaxPodEvictionTime time.Duration
+	// ForceDrain makes scale down delete pods that couldn't be evicted within MaxPodEvictionTime
+	// instead of leaving the node in place.
+	ForceDrain bool
+	// NodeDeletionTracker keeps nodes that are being deleted from being deleted again. Nil if disabled.
+	NodeDeletionTracker *NodeDeletionTracker
+	// ScaleDownVeto can block the removal of nodes in scale down. Nil if disabled.
+	ScaleDownVeto ScaleDownVeto
+	// ScaleDownVetoFailOpen makes scale down remove nodes whose veto check failed.
+	ScaleDownVetoFailOpen bool
+	// ClusterSnapshot is the state of the cluster observed at the start of the current scan. Nil if
+	// node group sizes are read from the cloud provider directly.
+	ClusterSnapshot *ClusterSnapshot
+	// CircuitBreaker stops scale operations after repeated cloud provider failures. Nil if disabled.
+	CircuitBreaker *CircuitBreaker
+	// IgnorablePods select pods that, like DaemonSet and mirror pods, don't keep a node from being
+	// removed as empty in scale down.
+	IgnorablePods []IgnorablePodSelector
+	// ScaleActivity keeps the time of the last scale up and scale down of every node group. Nil if disabled.
+	ScaleActivity *ScaleActivity
+	// NodeDeletionBatcher collects empty nodes to delete them in batches per node group. Nil if every
+	// empty node is deleted separately.
+	NodeDeletionBatcher *NodeDeletionBatcher
+	// Clock tells the time used by all time-based decisions of scale up and scale down, and waits
+	// between the pod eviction retries of drains. Nil if the wall-clock time is used.
+	Clock clock.Clock
+}
+
+// Now returns the current time of the context's clock.
+func (context *AutoscalingContext) Now() time.Time {
+	if context.Clock == nil {
+		return clock.RealClock{}.Now()
+	}
+	return context.Clock.Now()
+}
+
+// Sleep waits with the context's clock until the given duration has passed.
+func (context *AutoscalingContext) Sleep(d time.Duration) {
+	if context.Clock == nil {
+		clock.RealClock{}.Sleep(d)
+		return
+	}
+	context.Clock.Sleep(d)
+}
+
 // GetAllNodesAvailableTime returns time when the newest node became available for scheduler.
 // TODO: This function should use LastTransitionTime from NodeReady condition.
 func GetAllNodesAvailableTime(nodes []*kube_api.Node) time.Time {
@@ -125,38 +260,291 @@ func createNodeNameToInfoMap(pods []*kube_api.Pod, nodes []*kube_api.Node) map[s
 	return nodeNameToNodeInfo
 }
 
-// CheckGroupsAndNodes checks if all node groups have all required nodes.
-func CheckGroupsAndNodes(nodes []*kube_api.Node, cloudProvider cloudprovider.CloudProvider) error {
-	groupCount := make(map[string]int)
+// CheckGroupsAndNodes returns the ids of node groups whose target size doesn't match the number of their
+// nodes registered in Kubernetes. Such groups are still provisioning or removing nodes and shouldn't be
+// scaled until they are in sync, while the other node groups can be scaled as usual. Node groups whose
+// target size can't be read are not ready either.
+func CheckGroupsAndNodes(nodes []*kube_api.Node, cloudProvider cloudprovider.CloudProvider) (map[string]bool, error) {
+	groupCount, err := countNodesInGroups(nodes, cloudProvider)
+	if err != nil {
+		return nil, err
+	}
+	unready := make(map[string]bool)
+	for _, nodeGroup := range cloudProvider.NodeGroups() {
+		size, err := nodeGroup.TargetSize()
+		if err != nil {
+			glog.Warningf("Node group %s is not ready for autoscaling: failed to get its size: %v", nodeGroup.Id(), err)
+			unready[nodeGroup.Id()] = true
+			continue
+		}
+		count := groupCount[nodeGroup.Id()]
+		if size != count {
+			glog.Warningf("Node group %s is not ready for autoscaling: wrong number of nodes, expected: %d actual: %d",
+				nodeGroup.Id(), size, count)
+			unready[nodeGroup.Id()] = true
+		}
+	}
+	return unready, nil
+}
+
+// FilterNodesBySelector returns the nodes whose labels match the selector.
+func FilterNodesBySelector(nodes []*kube_api.Node, selector labels.Selector) []*kube_api.Node {
+	if selector.Empty() {
+		return nodes
+	}
+	result := make([]*kube_api.Node, 0, len(nodes))
 	for _, node := range nodes {
+		if selector.Matches(labels.Set(node.Labels)) {
+			result = append(result, node)
+		}
+	}
+	return result
+}
 
+// NodeGroupSize holds the target size of a node group and the number of its registered nodes.
+type NodeGroupSize struct {
+	Target     int
+	Registered int
+}
+
+// GetNodeGroupSizes returns the target size and the number of registered nodes of every node group,
+// keyed by node group id. Target sizes are read with targetSize, so from context.ClusterSnapshot if
+// it is set. Node groups whose target size can't be read are left out. Nodes being deleted shouldn't
+// be passed, as they are no longer part of the target size.
+func GetNodeGroupSizes(context *AutoscalingContext, nodes []*kube_api.Node) (map[string]NodeGroupSize, error) {
+	groupCount, err := countNodesInGroups(nodes, context.CloudProvider)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]NodeGroupSize)
+	for _, nodeGroup := range context.CloudProvider.NodeGroups() {
+		size, err := targetSize(context, nodeGroup)
+		if err != nil {
+			glog.Errorf("Skipping size of %s: %v", nodeGroup.Id(), err)
+			continue
+		}
+		sizes[nodeGroup.Id()] = NodeGroupSize{Target: size, Registered: groupCount[nodeGroup.Id()]}
+	}
+	return sizes, nil
+}
+
+// GetSizeDiscrepancies returns, for every node group in sizes, the difference between its target
+// size on the cloud provider side and the number of its nodes registered in Kubernetes. A positive
+// discrepancy that doesn't go away means instances that failed to register or leaked, a negative one
+// nodes whose instances are gone.
+func GetSizeDiscrepancies(sizes map[string]NodeGroupSize) map[string]int {
+	discrepancies := make(map[string]int)
+	for id, size := range sizes {
+		discrepancies[id] = size.Target - size.Registered
+	}
+	return discrepancies
+}
+
+// countNodesInGroups returns the number of registered nodes of every node group, keyed by node group id.
+func countNodesInGroups(nodes []*kube_api.Node, cloudProvider cloudprovider.CloudProvider) (map[string]int, error) {
+	groupCount := make(map[string]int)
+	for _, node := range nodes {
 		group, err := cloudProvider.NodeGroupForNode(node)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if group == nil || reflect.ValueOf(group).IsNil() {
 			continue
 		}
-		id := group.Id()
-		count, _ := groupCount[id]
-		groupCount[id] = count + 1
+		groupCount[group.Id()]++
+	}
+	return groupCount, nil
+}
+
+// GetUnmanagedNodes returns the nodes that don't belong to any configured node group, for example
+// because their ASG or MIG is missing from the node group specs. The autoscaler neither scales nor
+// removes such nodes. Nodes without a ProviderID are skipped: it is set shortly after a node
+// registers, and until then the cloud provider can't find the node group of the node.
+func GetUnmanagedNodes(nodes []*kube_api.Node, cloudProvider cloudprovider.CloudProvider) ([]*kube_api.Node, error) {
+	withProviderID := make([]*kube_api.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Spec.ProviderID != "" {
+			withProviderID = append(withProviderID, node)
+		}
+	}
+	nodeGroups, err := cloudProvider.NodeGroupsForNodes(withProviderID)
+	if err != nil {
+		return nil, err
+	}
+	unmanaged := make([]*kube_api.Node, 0)
+	for _, node := range withProviderID {
+		group, found := nodeGroups[node.Name]
+		if !found || group == nil || reflect.ValueOf(group).IsNil() {
+			unmanaged = append(unmanaged, node)
+		}
+	}
+	return unmanaged, nil
+}
+
+// ResourceGPU is the resource advertised by the nvidia device plugin. The vendored api only knows
+// kube_api.ResourceNvidiaGPU, advertised by kubelet itself.
+const ResourceGPU kube_api.ResourceName = "nvidia.com/gpu"
+
+// FilterOutNodesWithUnreadyGpus returns the nodes without those labeled with gpuLabel that don't
+// advertise any allocatable GPUs yet. Such nodes are ready before the GPU driver or device plugin
+// registers the GPUs, so counting them would make GPU pods look schedulable when they aren't. The
+// unready GPU nodes are returned separately. An empty gpuLabel disables the filtering.
+func FilterOutNodesWithUnreadyGpus(nodes []*kube_api.Node, gpuLabel string) ([]*kube_api.Node, []*kube_api.Node) {
+	if gpuLabel == "" {
+		return nodes, []*kube_api.Node{}
+	}
+	ready := make([]*kube_api.Node, 0, len(nodes))
+	unready := make([]*kube_api.Node, 0)
+	for _, node := range nodes {
+		if _, found := node.Labels[gpuLabel]; found && !hasAllocatableGpus(node) {
+			unready = append(unready, node)
+			continue
+		}
+		ready = append(ready, node)
+	}
+	return ready, unready
+}
+
+func hasAllocatableGpus(node *kube_api.Node) bool {
+	for _, name := range []kube_api.ResourceName{ResourceGPU, kube_api.ResourceNvidiaGPU} {
+		if gpus, found := node.Status.Allocatable[name]; found && gpus.Value() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// IsClusterHealthy returns false, together with the number of unready nodes, if more than
+// maxUnreadyPercentage percent of all nodes are unready. Up to okUnreadyCount unready nodes are
+// always tolerated so that small clusters aren't considered unhealthy because of a single node.
+func IsClusterHealthy(allNodes []*kube_api.Node, maxUnreadyPercentage float64, okUnreadyCount int) (bool, int) {
+	unready := 0
+	for _, node := range allNodes {
+		if !kube_util.IsNodeReady(node) {
+			unready++
+		}
+	}
+	if unready <= okUnreadyCount {
+		return true, unready
 	}
+	return float64(unready) <= maxUnreadyPercentage*float64(len(allNodes))/100, unready
+}
+
+// ZeroMaxSizeNodeGroups returns the ids of node groups with max size 0. Such groups are most likely
+// misconfigured, as they can never be scaled up.
+func ZeroMaxSizeNodeGroups(cloudProvider cloudprovider.CloudProvider) []string {
+	result := make([]string, 0)
 	for _, nodeGroup := range cloudProvider.NodeGroups() {
-		size, err := nodeGroup.TargetSize()
+		if nodeGroup.MaxSize() == 0 {
+			result = append(result, nodeGroup.Id())
+		}
+	}
+	return result
+}
+
+// hasNodeGroup returns true if the cloud provider has a node group with the given id.
+func hasNodeGroup(cloudProvider cloudprovider.CloudProvider, id string) bool {
+	for _, nodeGroup := range cloudProvider.NodeGroups() {
+		if nodeGroup.Id() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateNodeTemplates records a sample node for every node group that has nodes. The samples are
+// used as templates of node groups that were scaled down to zero.
+func UpdateNodeTemplates(nodes []*kube_api.Node, cloudProvider cloudprovider.CloudProvider, templates map[string]*kube_api.Node) error {
+	for _, node := range nodes {
+		nodeGroup, err := cloudProvider.NodeGroupForNode(node)
 		if err != nil {
 			return err
 		}
-		count := groupCount[nodeGroup.Id()]
-		if size != count {
-			return fmt.Errorf("wrong number of nodes for node group: %s expected: %d actual: %d", nodeGroup.Id(), size, count)
+		if nodeGroup == nil || reflect.ValueOf(nodeGroup).IsNil() {
+			continue
 		}
+		logTemplateAllocatable(nodeGroup, node, templates[nodeGroup.Id()])
+		templates[nodeGroup.Id()] = node
 	}
 	return nil
 }
 
+// templateAllocatable returns the allocatable part of the capacity declared by a node group,
+// corrected with the observations of its sampled node: whatever the sample reserves of a declared
+// resource, e.g. for kube-reserved or hugepages, is not allocatable on new nodes either.
+func templateAllocatable(sample *kube_api.Node, declared kube_api.ResourceList) kube_api.ResourceList {
+	allocatable := simulator.NodeAllocatable(sample)
+	result := make(kube_api.ResourceList, len(declared))
+	for name, quantity := range declared {
+		corrected := *quantity.Copy()
+		observedCapacity, foundCapacity := sample.Status.Capacity[name]
+		observedAllocatable, foundAllocatable := allocatable[name]
+		if foundCapacity && foundAllocatable && observedAllocatable.Cmp(observedCapacity) < 0 {
+			corrected.Sub(observedCapacity)
+			corrected.Add(observedAllocatable)
+			if corrected.Sign() < 0 {
+				corrected = *resource.NewQuantity(0, quantity.Format)
+			}
+		}
+		result[name] = corrected
+	}
+	return result
+}
+
+// logTemplateAllocatable logs the declared resources of a templated node group that are only partly
+// allocatable on its sampled node. Samples correcting the declared capacity like the previous
+// sample are not logged again.
+func logTemplateAllocatable(nodeGroup cloudprovider.NodeGroup, sample, previous *kube_api.Node) {
+	templated, ok := nodeGroup.(cloudprovider.TemplatedNodeGroup)
+	if !ok {
+		return
+	}
+	declared := templateCapacity(templated, sample)
+	if len(declared) == 0 {
+		return
+	}
+	allocatable := templateAllocatable(sample, declared)
+	if previous != nil && resourceListsEqual(allocatable, templateAllocatable(previous, templateCapacity(templated, previous))) {
+		return
+	}
+	for name, quantity := range declared {
+		if corrected := allocatable[name]; corrected.Cmp(quantity) != 0 {
+			glog.V(1).Infof("Node %s of %s has less %s allocatable than capacity, new nodes of %s get %s allocatable instead of the declared %s",
+				sample.Name, nodeGroup.Id(), name, nodeGroup.Id(), corrected.String(), quantity.String())
+		}
+	}
+}
+
+// sampledResources are the resources of template nodes taken from their sampled node, if it reports
+// them, rather than from the capacity declared by the node group: the capacity a node reports is
+// more precise than the nominal one of its instance type.
+var sampledResources = []kube_api.ResourceName{kube_api.ResourceCPU, kube_api.ResourceMemory}
+
+// templateCapacity returns the capacity declared by the node group, without the sampledResources
+// reported by the sampled node.
+func templateCapacity(templated cloudprovider.TemplatedNodeGroup, sample *kube_api.Node) kube_api.ResourceList {
+	capacity := templated.TemplateCapacity()
+	for _, name := range sampledResources {
+		if _, found := capacity[name]; !found {
+			continue
+		}
+		if _, found := sample.Status.Capacity[name]; found {
+			capacity = copyResourceList(capacity)
+			delete(capacity, name)
+		}
+	}
+	return capacity
+}
+
 // GetNodeInfosForGroups finds NodeInfos for all node groups used to manage the given nodes. It also returns a node group to sample node mapping.
+// Node groups without nodes get a NodeInfo built from their template, if there is one, or else from
+// the labels, taints and capacity they declare, see buildNodeGroupTemplate. Pods of the template
+// node are not known, so such NodeInfos contain no pods. Labels, taints and capacity declared by
+// node groups implementing cloudprovider.TemplatedNodeGroup are added to their templates, and
+// taints with keys in ignoredTaints or with the PreferNoSchedule effect are removed from them.
 // TODO(mwielgus): This returns map keyed by url, while most code (including scheduler) uses node.Name for a key.
-func GetNodeInfosForGroups(nodes []*kube_api.Node, cloudProvider cloudprovider.CloudProvider, kubeClient *kube_client.Client) (map[string]*schedulercache.NodeInfo, error) {
+func GetNodeInfosForGroups(nodes []*kube_api.Node, cloudProvider cloudprovider.CloudProvider, kubeClient *kube_client.Client,
+	templates map[string]*kube_api.Node, ignoredTaints map[string]bool) (map[string]*schedulercache.NodeInfo, error) {
 	result := make(map[string]*schedulercache.NodeInfo)
 	for _, node := range nodes {
 
@@ -176,14 +564,241 @@ func GetNodeInfosForGroups(nodes []*kube_api.Node, cloudProvider cloudprovider.C
 			result[id] = nodeInfo
 		}
 	}
+	for _, nodeGroup := range cloudProvider.NodeGroups() {
+		id := nodeGroup.Id()
+		if _, found := result[id]; found {
+			continue
+		}
+		var template *kube_api.Node
+		var err error
+		if sample, found := templates[id]; found {
+			template, err = applyNodeGroupTemplate(sample, nodeGroup)
+		} else {
+			template, err = buildNodeGroupTemplate(nodeGroup)
+		}
+		if err != nil {
+			return map[string]*schedulercache.NodeInfo{}, err
+		}
+		if template == nil {
+			continue
+		}
+		template, err = withoutIgnoredTaints(template, ignoredTaints)
+		if err != nil {
+			return map[string]*schedulercache.NodeInfo{}, err
+		}
+		template = withDefaultMaxPods(template)
+		nodeInfo := schedulercache.NewNodeInfo()
+		if err := nodeInfo.SetNode(template); err != nil {
+			return map[string]*schedulercache.NodeInfo{}, err
+		}
+		result[id] = nodeInfo
+	}
 	return result, nil
 }
 
-// BestExpansionOption picks the best cluster expansion option.
-func BestExpansionOption(expansionOptions []ExpansionOption) *ExpansionOption {
-	if len(expansionOptions) > 0 {
-		pos := rand.Int31n(int32(len(expansionOptions)))
+// buildNodeGroupTemplate builds the template node of a node group that was never sampled, e.g. after
+// a restart while it's scaled down to zero, from the labels, taints and capacity it declares as a
+// cloudprovider.TemplatedNodeGroup. It returns nil if the node group doesn't declare the CPU and
+// memory of its nodes, as pods can't be fitted on a template without them.
+func buildNodeGroupTemplate(nodeGroup cloudprovider.NodeGroup) (*kube_api.Node, error) {
+	templated, ok := nodeGroup.(cloudprovider.TemplatedNodeGroup)
+	if !ok {
+		return nil, nil
+	}
+	capacity := templated.TemplateCapacity()
+	for _, name := range sampledResources {
+		if _, found := capacity[name]; !found {
+			return nil, nil
+		}
+	}
+	name := fmt.Sprintf("template-node-for-%s", nodeGroup.Id())
+	node := &kube_api.Node{
+		ObjectMeta: kube_api.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{kube_api_unversioned.LabelHostname: name},
+			Annotations: map[string]string{},
+		},
+		Status: kube_api.NodeStatus{
+			Capacity:    copyResourceList(capacity),
+			Allocatable: copyResourceList(capacity),
+			Conditions: []kube_api.NodeCondition{
+				{Type: kube_api.NodeReady, Status: kube_api.ConditionTrue},
+			},
+		},
+	}
+	for key, value := range templated.TemplateLabels() {
+		node.Labels[key] = value
+	}
+	if taints := templated.TemplateTaints(); len(taints) > 0 {
+		serialized, err := json.Marshal(taints)
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize taints of %s: %v", nodeGroup.Id(), err)
+		}
+		node.Annotations[kube_api.TaintsAnnotationKey] = string(serialized)
+	}
+	return node, nil
+}
+
+// applyNodeGroupTemplate returns a copy of the template node with the labels, taints and capacity
+// declared by the node group, if it implements cloudprovider.TemplatedNodeGroup. Declared values
+// replace the sampled ones with the same key, apart from the sampledResources. Declared capacity is
+// also allocatable, apart from what the sampled node reserves of it, see templateAllocatable.
+func applyNodeGroupTemplate(template *kube_api.Node, nodeGroup cloudprovider.NodeGroup) (*kube_api.Node, error) {
+	templated, ok := nodeGroup.(cloudprovider.TemplatedNodeGroup)
+	if !ok {
+		return template, nil
+	}
+	labels := templated.TemplateLabels()
+	declared := templated.TemplateTaints()
+	capacity := templateCapacity(templated, template)
+	if len(labels) == 0 && len(declared) == 0 && len(capacity) == 0 {
+		return template, nil
+	}
+
+	node := *template
+	node.Labels = make(map[string]string, len(template.Labels)+len(labels))
+	for key, value := range template.Labels {
+		node.Labels[key] = value
+	}
+	for key, value := range labels {
+		node.Labels[key] = value
+	}
+	node.Annotations = make(map[string]string, len(template.Annotations)+1)
+	for key, value := range template.Annotations {
+		node.Annotations[key] = value
+	}
+	if len(capacity) > 0 {
+		allocatable := templateAllocatable(template, capacity)
+		node.Status.Capacity = copyResourceList(template.Status.Capacity)
+		node.Status.Allocatable = copyResourceList(simulator.NodeAllocatable(template))
+		for name, quantity := range capacity {
+			node.Status.Capacity[name] = quantity
+			node.Status.Allocatable[name] = allocatable[name]
+		}
+	}
+	if len(declared) == 0 {
+		return &node, nil
+	}
+
+	sampled, err := kube_api.GetTaintsFromNodeAnnotations(template.Annotations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get taints of template %s: %v", template.Name, err)
+	}
+	taints := make([]kube_api.Taint, 0, len(sampled)+len(declared))
+	declaredKeys := make(map[string]bool)
+	for _, taint := range declared {
+		declaredKeys[taint.Key] = true
+	}
+	for _, taint := range sampled {
+		if !declaredKeys[taint.Key] {
+			taints = append(taints, taint)
+		}
+	}
+	taints = append(taints, declared...)
+	serialized, err := json.Marshal(taints)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize taints of %s: %v", nodeGroup.Id(), err)
+	}
+	node.Annotations[kube_api.TaintsAnnotationKey] = string(serialized)
+	return &node, nil
+}
+
+// withoutIgnoredTaints returns a copy of the template node without the taints whose keys are in
+// ignoredTaints, e.g. startup taints that are removed once the node is ready. PreferNoSchedule
+// taints are removed as well: they only lower the scheduling priority of the node, but the
+// predicates reject pods without any tolerations on a node that has them.
+func withoutIgnoredTaints(template *kube_api.Node, ignoredTaints map[string]bool) (*kube_api.Node, error) {
+	if template.Annotations[kube_api.TaintsAnnotationKey] == "" {
+		return template, nil
+	}
+	taints, err := kube_api.GetTaintsFromNodeAnnotations(template.Annotations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get taints of template %s: %v", template.Name, err)
+	}
+	kept := make([]kube_api.Taint, 0, len(taints))
+	for _, taint := range taints {
+		if !ignoredTaints[taint.Key] && taint.Effect != kube_api.TaintEffectPreferNoSchedule {
+			kept = append(kept, taint)
+		}
+	}
+	if len(kept) == len(taints) {
+		return template, nil
+	}
+	serialized, err := json.Marshal(kept)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize taints of template %s: %v", template.Name, err)
+	}
+	node := *template
+	node.Annotations = make(map[string]string, len(template.Annotations))
+	for key, value := range template.Annotations {
+		node.Annotations[key] = value
+	}
+	node.Annotations[kube_api.TaintsAnnotationKey] = string(serialized)
+	return &node, nil
+}
+
+// DefaultMaxPods is the kubelet default of --max-pods. It's the pods allocatable of template nodes
+// that don't report one, as the predicates don't fit any pod on a node without it.
+const DefaultMaxPods = 110
+
+// withDefaultMaxPods returns a copy of the template node with DefaultMaxPods pods allocatable, if
+// it has none.
+func withDefaultMaxPods(template *kube_api.Node) *kube_api.Node {
+	if _, found := simulator.NodeAllocatable(template)[kube_api.ResourcePods]; found {
+		return template
+	}
+	node := *template
+	node.Status.Capacity = copyResourceList(template.Status.Capacity)
+	node.Status.Allocatable = copyResourceList(simulator.NodeAllocatable(template))
+	node.Status.Capacity[kube_api.ResourcePods] = *resource.NewQuantity(DefaultMaxPods, resource.DecimalSI)
+	node.Status.Allocatable[kube_api.ResourcePods] = *resource.NewQuantity(DefaultMaxPods, resource.DecimalSI)
+	return &node
+}
+
+func copyResourceList(resources kube_api.ResourceList) kube_api.ResourceList {
+	result := make(kube_api.ResourceList, len(resources))
+	for name, quantity := range resources {
+		result[name] = quantity
+	}
+	return result
+}
+
+// BestExpansionOption picks the best cluster expansion option. All options are considered equally
+// good, apart from recent scale ups recorded in history (which may be nil): the node groups that
+// were scaled up least recently are preferred. Among those the one with the smallest node group id
+// is picked to make the choice repeatable for the same cluster state. If randomTieBreak is set a
+// random one is picked instead.
+func BestExpansionOption(expansionOptions []ExpansionOption, randomTieBreak bool, history *ScaleUpHistory) *ExpansionOption {
+	if len(expansionOptions) == 0 {
+		return nil
+	}
+	sort.Sort(byScaleUpHistoryAndNodeGroupId{options: expansionOptions, history: history})
+	if randomTieBreak {
+		leastScaled := 1
+		for leastScaled < len(expansionOptions) &&
+			history.Count(expansionOptions[leastScaled].nodeGroup.Id()) == history.Count(expansionOptions[0].nodeGroup.Id()) {
+			leastScaled++
+		}
+		pos := rand.Int31n(int32(leastScaled))
 		return &expansionOptions[pos]
 	}
-	return nil
+	return &expansionOptions[0]
+}
+
+type byScaleUpHistoryAndNodeGroupId struct {
+	options []ExpansionOption
+	history *ScaleUpHistory
+}
+
+func (a byScaleUpHistoryAndNodeGroupId) Len() int { return len(a.options) }
+func (a byScaleUpHistoryAndNodeGroupId) Swap(i, j int) {
+	a.options[i], a.options[j] = a.options[j], a.options[i]
+}
+func (a byScaleUpHistoryAndNodeGroupId) Less(i, j int) bool {
+	countI := a.history.Count(a.options[i].nodeGroup.Id())
+	countJ := a.history.Count(a.options[j].nodeGroup.Id())
+	if countI != countJ {
+		return countI < countJ
+	}
+	return a.options[i].nodeGroup.Id() < a.options[j].nodeGroup.Id()
 }
@@ -0,0 +1,81 @@
+/*
+Copyright 2016 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time. Time-based decisions of the autoscaler read the time from a Clock, and
+// wait with it, so tests can control it.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Sleep waits until the given duration has passed.
+	Sleep(d time.Duration)
+}
+
+// RealClock is a Clock returning the wall-clock time.
+type RealClock struct{}
+
+// Now returns time.Now().
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep calls time.Sleep().
+func (RealClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// FakeClock is a Clock whose time only changes when it is set or stepped.
+type FakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+// NewFakeClock returns a FakeClock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the time the clock is set to.
+func (f *FakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.now
+}
+
+// Sleep returns at once, moving the clock forward by the given duration as if it had passed.
+func (f *FakeClock) Sleep(d time.Duration) {
+	f.Step(d)
+}
+
+// Step moves the clock forward by the given duration.
+func (f *FakeClock) Step(d time.Duration) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// SetTime sets the clock to the given time.
+func (f *FakeClock) SetTime(now time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.now = now
+}
@@ -23,6 +23,7 @@ import (
 	"k8s.io/kubernetes/pkg/client/cache"
 	kube_client "k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/fields"
+	qos_util "k8s.io/kubernetes/pkg/kubelet/qos/util"
 	"k8s.io/kubernetes/pkg/labels"
 )
 
@@ -96,20 +97,81 @@ type ReadyNodeLister struct {
 
 // List returns ready nodes.
 func (readyNodeLister *ReadyNodeLister) List() ([]*kube_api.Node, error) {
+	nodes, err := readyNodeLister.ListAll()
+	if err != nil {
+		return []*kube_api.Node{}, err
+	}
+	readyNodes := make([]*kube_api.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if IsNodeReady(node) {
+			readyNodes = append(readyNodes, node)
+		}
+	}
+	return readyNodes, nil
+}
+
+// ListAll returns all nodes, ready or not.
+func (readyNodeLister *ReadyNodeLister) ListAll() ([]*kube_api.Node, error) {
 	nodes, err := readyNodeLister.nodeLister.List()
 	if err != nil {
 		return []*kube_api.Node{}, err
 	}
-	readyNodes := make([]*kube_api.Node, 0, len(nodes.Items))
-	for i, node := range nodes.Items {
+	allNodes := make([]*kube_api.Node, 0, len(nodes.Items))
+	for i := range nodes.Items {
+		allNodes = append(allNodes, &nodes.Items[i])
+	}
+	return allNodes, nil
+}
+
+// IsNodeReady returns true if the node reports the NodeReady condition.
+func IsNodeReady(node *kube_api.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == kube_api.NodeReady && condition.Status == kube_api.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// NodeDiskPressure is the condition of nodes whose kubelet is short of disk space. Kubelets report it
+// instead of OutOfDisk since Kubernetes 1.4, but the vendored api doesn't define it yet.
+const NodeDiskPressure kube_api.NodeConditionType = "DiskPressure"
+
+// pressureConditions are the node conditions under which the kubelet rejects new pods. Under
+// MemoryPressure only BestEffort pods are rejected.
+var pressureConditions = []kube_api.NodeConditionType{kube_api.NodeMemoryPressure, NodeDiskPressure, kube_api.NodeOutOfDisk}
+
+// NodePressure returns the first condition of the node under which its kubelet rejects the pod, e.g.
+// DiskPressure, or false if there is none. Such nodes can be ready but have no room for the pod.
+func NodePressure(node *kube_api.Node, pod *kube_api.Pod) (kube_api.NodeConditionType, bool) {
+	for _, pressure := range pressureConditions {
+		if pressure == kube_api.NodeMemoryPressure && qos_util.GetPodQos(pod) != qos_util.BestEffort {
+			continue
+		}
 		for _, condition := range node.Status.Conditions {
-			if condition.Type == kube_api.NodeReady && condition.Status == kube_api.ConditionTrue {
-				readyNodes = append(readyNodes, &nodes.Items[i])
-				break
+			if condition.Type == pressure && condition.Status == kube_api.ConditionTrue {
+				return pressure, true
 			}
 		}
 	}
-	return readyNodes, nil
+	return "", false
+}
+
+// IsPodTerminal returns true if all containers of the pod terminated for good, i.e. the pod is in
+// the Succeeded or Failed phase, like completed Job pods. Such pods don't need a node anymore.
+func IsPodTerminal(pod *kube_api.Pod) bool {
+	return pod.Status.Phase == kube_api.PodSucceeded || pod.Status.Phase == kube_api.PodFailed
+}
+
+// FilterOutTerminalPods returns the pods that are not terminal, see IsPodTerminal.
+func FilterOutTerminalPods(pods []*kube_api.Pod) []*kube_api.Pod {
+	result := make([]*kube_api.Pod, 0, len(pods))
+	for _, pod := range pods {
+		if !IsPodTerminal(pod) {
+			result = append(result, pod)
+		}
+	}
+	return result
 }
 
 // NewNodeLister builds a node lister.
@@ -122,3 +184,12 @@ func NewNodeLister(kubeClient *kube_client.Client) *ReadyNodeLister {
 		nodeLister: nodeLister,
 	}
 }
+
+// NewPersistentVolumeClaimLister builds a lister of persistent volume claims in all namespaces.
+func NewPersistentVolumeClaimLister(kubeClient *kube_client.Client) *cache.StoreToPVCFetcher {
+	listWatcher := cache.NewListWatchFromClient(kubeClient, "persistentvolumeclaims", kube_api.NamespaceAll, fields.Everything())
+	claimLister := &cache.StoreToPVCFetcher{Store: cache.NewStore(cache.MetaNamespaceKeyFunc)}
+	reflector := cache.NewReflector(listWatcher, &kube_api.PersistentVolumeClaim{}, claimLister.Store, time.Hour)
+	reflector.Run()
+	return claimLister
+}
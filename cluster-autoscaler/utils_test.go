@@ -17,12 +17,20 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
 	"testing"
 
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider"
+	"k8s.io/contrib/cluster-autoscaler/cloudprovider/test"
 	"k8s.io/contrib/cluster-autoscaler/simulator"
+	kube_util "k8s.io/contrib/cluster-autoscaler/utils/kubernetes"
 	. "k8s.io/contrib/cluster-autoscaler/utils/test"
 
 	kube_api "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
+	kube_record "k8s.io/kubernetes/pkg/client/record"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -51,3 +59,591 @@ func TestFilterOutSchedulable(t *testing.T) {
 	assert.Equal(t, p1, res2[0])
 	assert.Equal(t, p2, res2[1])
 }
+
+func TestFilterOutSchedulableSkipsNodesUnderPressure(t *testing.T) {
+	p1 := BuildTestPod("p1", 500, 200000)
+	scheduled := BuildTestPod("s1", 100, 200000)
+	scheduled.Spec.NodeName = "node1"
+
+	node := BuildTestNode("node1", 2000, 2000000)
+	node.Status.Conditions = []kube_api.NodeCondition{
+		{Type: kube_api.NodeReady, Status: kube_api.ConditionTrue},
+		{Type: kube_util.NodeDiskPressure, Status: kube_api.ConditionFalse},
+	}
+	predicateChecker := simulator.NewTestPredicateChecker()
+
+	res := FilterOutSchedulable([]*kube_api.Pod{p1}, []*kube_api.Node{node}, []*kube_api.Pod{scheduled}, predicateChecker)
+	assert.Equal(t, 0, len(res))
+
+	// The ready node under disk pressure is not available capacity, p1 still needs a scale up.
+	node.Status.Conditions[1].Status = kube_api.ConditionTrue
+	res = FilterOutSchedulable([]*kube_api.Pod{p1}, []*kube_api.Node{node}, []*kube_api.Pod{scheduled}, predicateChecker)
+	assert.Equal(t, []*kube_api.Pod{p1}, res)
+
+	// Under memory pressure the kubelet rejects only BestEffort pods.
+	node.Status.Conditions[1] = kube_api.NodeCondition{Type: kube_api.NodeMemoryPressure, Status: kube_api.ConditionTrue}
+	res = FilterOutSchedulable([]*kube_api.Pod{p1}, []*kube_api.Node{node}, []*kube_api.Pod{scheduled}, predicateChecker)
+	assert.Equal(t, 0, len(res))
+	p2 := BuildTestPod("p2", -1, -1)
+	res = FilterOutSchedulable([]*kube_api.Pod{p2}, []*kube_api.Node{node}, []*kube_api.Pod{scheduled}, predicateChecker)
+	assert.Equal(t, []*kube_api.Pod{p2}, res)
+}
+
+func TestBestExpansionOptionDeterministic(t *testing.T) {
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+
+	for i := 0; i < 10; i++ {
+		nodeGroups := provider.NodeGroups()
+		options := make([]ExpansionOption, 0)
+		for _, pos := range rand.Perm(len(nodeGroups)) {
+			options = append(options, ExpansionOption{nodeGroup: nodeGroups[pos], nodeCount: 1})
+		}
+		best := BestExpansionOption(options, false, nil)
+		assert.Equal(t, "ng1", best.nodeGroup.Id())
+	}
+	assert.Nil(t, BestExpansionOption([]ExpansionOption{}, false, nil))
+}
+
+func TestIsClusterHealthy(t *testing.T) {
+	nodes := make([]*kube_api.Node, 0)
+	for i := 0; i < 10; i++ {
+		node := BuildTestNode(fmt.Sprintf("n%d", i), 1000, 1000)
+		node.Status.Conditions = []kube_api.NodeCondition{
+			{Type: kube_api.NodeReady, Status: kube_api.ConditionTrue},
+		}
+		nodes = append(nodes, node)
+	}
+	healthy, unready := IsClusterHealthy(nodes, 45, 3)
+	assert.True(t, healthy)
+	assert.Equal(t, 0, unready)
+
+	// 4 of 10 unready nodes exceed the tolerated count but not the percentage.
+	for i := 0; i < 4; i++ {
+		nodes[i].Status.Conditions[0].Status = kube_api.ConditionFalse
+	}
+	healthy, unready = IsClusterHealthy(nodes, 45, 3)
+	assert.True(t, healthy)
+	assert.Equal(t, 4, unready)
+
+	// 5 of 10 unready nodes engage the brake.
+	nodes[4].Status.Conditions = nil
+	healthy, unready = IsClusterHealthy(nodes, 45, 3)
+	assert.False(t, healthy)
+	assert.Equal(t, 5, unready)
+
+	// Unless that many unready nodes are tolerated.
+	healthy, _ = IsClusterHealthy(nodes, 45, 5)
+	assert.True(t, healthy)
+}
+
+func TestCheckGroupsAndNodes(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 2)
+	provider.AddNodeGroup("ng2", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+
+	unready, err := CheckGroupsAndNodes([]*kube_api.Node{n1, n2}, provider)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"ng1": true}, unready)
+}
+
+func TestGetSizeDiscrepancies(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 2)
+	provider.AddNodeGroup("ng2", 0, 10, 1)
+	provider.AddNodeGroup("ng3", 0, 10, 0)
+	provider.AddNode("ng1", n1)
+	provider.AddNode("ng2", n2)
+	provider.AddNode("ng3", n3)
+
+	sizes, err := GetNodeGroupSizes(&AutoscalingContext{CloudProvider: provider}, []*kube_api.Node{n1, n2, n3})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]NodeGroupSize{
+		"ng1": {Target: 2, Registered: 1},
+		"ng2": {Target: 1, Registered: 1},
+		"ng3": {Target: 0, Registered: 1},
+	}, sizes)
+	assert.Equal(t, map[string]int{"ng1": 1, "ng2": 0, "ng3": -1}, GetSizeDiscrepancies(sizes))
+}
+
+func TestGetUnmanagedNodes(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	n4 := BuildTestNode("n4", 1000, 1000)
+	for _, node := range []*kube_api.Node{n1, n2, n3} {
+		node.Spec.ProviderID = "test:///" + node.Name
+	}
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 1)
+	provider.AddNode("ng1", n1)
+	// The group of n2 isn't configured, n3 isn't known to the cloud provider at all. n4 has no
+	// ProviderID yet, so its node group can't be known.
+	provider.AddNode("ng-unknown", n2)
+
+	unmanaged, err := GetUnmanagedNodes([]*kube_api.Node{n1, n2, n3, n4}, provider)
+	assert.NoError(t, err)
+	assert.Equal(t, []*kube_api.Node{n2, n3}, unmanaged)
+}
+
+// templatedNodeGroup is a node group declaring template labels and taints on the cloud provider side.
+type templatedNodeGroup struct {
+	cloudprovider.NodeGroup
+	labels   map[string]string
+	taints   []kube_api.Taint
+	capacity kube_api.ResourceList
+}
+
+func (ng *templatedNodeGroup) TemplateLabels() map[string]string {
+	return ng.labels
+}
+
+func (ng *templatedNodeGroup) TemplateCapacity() kube_api.ResourceList {
+	return ng.capacity
+}
+
+func (ng *templatedNodeGroup) TemplateTaints() []kube_api.Taint {
+	return ng.taints
+}
+
+// templatedCloudProvider wraps node groups of the test cloud provider in templatedNodeGroups.
+type templatedCloudProvider struct {
+	*test.TestCloudProvider
+	labels   map[string]map[string]string
+	taints   map[string][]kube_api.Taint
+	capacity map[string]kube_api.ResourceList
+}
+
+func (p *templatedCloudProvider) NodeGroups() []cloudprovider.NodeGroup {
+	result := make([]cloudprovider.NodeGroup, 0)
+	for _, nodeGroup := range p.TestCloudProvider.NodeGroups() {
+		result = append(result, &templatedNodeGroup{
+			NodeGroup: nodeGroup,
+			labels:    p.labels[nodeGroup.Id()],
+			taints:    p.taints[nodeGroup.Id()],
+			capacity:  p.capacity[nodeGroup.Id()],
+		})
+	}
+	return result
+}
+
+func TestGetNodeInfosForGroupsTemplateTaints(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	provider := &templatedCloudProvider{
+		TestCloudProvider: test.NewTestCloudProvider(nil, nil),
+		taints: map[string][]kube_api.Taint{
+			"ng1": {{Key: "dedicated", Value: "gpu", Effect: kube_api.TaintEffectNoSchedule}},
+		},
+	}
+	provider.AddNodeGroup("ng1", 0, 10, 0)
+	templates := map[string]*kube_api.Node{"ng1": n1}
+
+	nodeInfos, err := GetNodeInfosForGroups([]*kube_api.Node{}, provider, nil, templates, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, nodeInfos["ng1"])
+	// The sampled template is left untouched.
+	assert.Empty(t, n1.Annotations[kube_api.TaintsAnnotationKey])
+
+	predicateChecker := simulator.NewTestPredicateChecker()
+	p1 := BuildTestPod("p1", 100, 0)
+	assert.Error(t, predicateChecker.CheckPredicates(p1, nodeInfos["ng1"]))
+
+	p2 := BuildTestPod("p2", 100, 0)
+	p2.Annotations = map[string]string{
+		kube_api.TolerationsAnnotationKey: `[{"key": "dedicated", "operator": "Equal", "value": "gpu", "effect": "NoSchedule"}]`,
+	}
+	assert.NoError(t, predicateChecker.CheckPredicates(p2, nodeInfos["ng1"]))
+}
+
+func TestScaleUpWithIgnoredTemplateTaint(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	taints, err := json.Marshal([]kube_api.Taint{
+		{Key: "node.example.com/starting", Effect: kube_api.TaintEffectNoSchedule},
+		{Key: "dedicated", Value: "batch", Effect: kube_api.TaintEffectPreferNoSchedule},
+	})
+	assert.NoError(t, err)
+	n1.Annotations = map[string]string{kube_api.TaintsAnnotationKey: string(taints)}
+
+	scaledGroups := make(map[string]int)
+	provider := test.NewTestCloudProvider(func(id string, delta int) error {
+		scaledGroups[id] += delta
+		return nil
+	}, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 0)
+	templates := map[string]*kube_api.Node{"ng1": n1}
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	p1 := BuildTestPod("p1", 500, 0)
+
+	// The startup taint sampled while the node was booting keeps the pod from fitting.
+	nodeInfos, err := GetNodeInfosForGroups([]*kube_api.Node{}, provider, nil, templates, nil)
+	assert.NoError(t, err)
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{}, nodeInfos)
+	assert.NoError(t, err)
+	assert.False(t, scaledUp)
+
+	nodeInfos, err = GetNodeInfosForGroups([]*kube_api.Node{}, provider, nil, templates,
+		map[string]bool{"node.example.com/starting": true})
+	assert.NoError(t, err)
+	// The PreferNoSchedule taint doesn't keep the pod without tolerations from fitting either.
+	remaining, err := kube_api.GetTaintsFromNodeAnnotations(nodeInfos["ng1"].Node().Annotations)
+	assert.NoError(t, err)
+	assert.Empty(t, remaining)
+	// The sampled template is left untouched.
+	assert.Equal(t, string(taints), n1.Annotations[kube_api.TaintsAnnotationKey])
+
+	scaledUp, err = ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 1}, scaledGroups)
+}
+
+func TestScaleUpWithTemplateLabels(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n2 := BuildTestNode("n2", 1000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := &templatedCloudProvider{
+		TestCloudProvider: test.NewTestCloudProvider(func(id string, delta int) error {
+			scaledGroups[id] += delta
+			return nil
+		}, nil),
+		labels: map[string]map[string]string{"ng2": {"accelerator": "gpu"}},
+	}
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNodeGroup("ng2", 0, 10, 0)
+	provider.AddNode("ng1", n1)
+	templates := map[string]*kube_api.Node{"ng1": n1, "ng2": n2}
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	nodeInfos, err := GetNodeInfosForGroups([]*kube_api.Node{}, provider, nil, templates, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "gpu", nodeInfos["ng2"].Node().Labels["accelerator"])
+	assert.Empty(t, nodeInfos["ng1"].Node().Labels["accelerator"])
+
+	// Only the empty ng2 has the label, known from its template.
+	p1 := BuildTestPod("p1", 500, 0)
+	p1.Spec.NodeSelector = map[string]string{"accelerator": "gpu"}
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{n1}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng2": 1}, scaledGroups)
+}
+
+func TestScaleUpWithTemplateArch(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n1.Labels = map[string]string{"kubernetes.io/arch": "amd64"}
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n2.Labels = map[string]string{"kubernetes.io/arch": "amd64"}
+
+	scaledGroups := make(map[string]int)
+	provider := &templatedCloudProvider{
+		TestCloudProvider: test.NewTestCloudProvider(func(id string, delta int) error {
+			scaledGroups[id] += delta
+			return nil
+		}, nil),
+		// The instances of ng-arm were switched to an ARM instance type since n2 was sampled.
+		labels: map[string]map[string]string{
+			"ng-amd": {"kubernetes.io/arch": "amd64", "kubernetes.io/os": "linux"},
+			"ng-arm": {"kubernetes.io/arch": "arm64", "kubernetes.io/os": "linux"},
+		},
+	}
+	provider.AddNodeGroup("ng-amd", 0, 10, 0)
+	provider.AddNodeGroup("ng-arm", 0, 10, 0)
+	templates := map[string]*kube_api.Node{"ng-amd": n1, "ng-arm": n2}
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	nodeInfos, err := GetNodeInfosForGroups([]*kube_api.Node{}, provider, nil, templates, nil)
+	assert.NoError(t, err)
+
+	p1 := BuildTestPod("p1", 500, 0)
+	p1.Spec.NodeSelector = map[string]string{"kubernetes.io/arch": "arm64"}
+	scaledUp, err := ScaleUp(context, []*kube_api.Pod{p1}, []*kube_api.Node{}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng-arm": 1}, scaledGroups)
+}
+
+func TestScaleUpWithTemplateEphemeralStorage(t *testing.T) {
+	n1 := BuildTestNode("n1", 4000, 1000)
+
+	scaledGroups := make(map[string]int)
+	provider := &templatedCloudProvider{
+		TestCloudProvider: test.NewTestCloudProvider(func(id string, delta int) error {
+			scaledGroups[id] += delta
+			return nil
+		}, nil),
+		capacity: map[string]kube_api.ResourceList{
+			"ng1": {simulator.ResourceEphemeralStorage: *resource.NewQuantity(10000, resource.BinarySI)},
+		},
+	}
+	provider.AddNodeGroup("ng1", 0, 10, 0)
+	templates := map[string]*kube_api.Node{"ng1": n1}
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	nodeInfos, err := GetNodeInfosForGroups([]*kube_api.Node{}, provider, nil, templates, nil)
+	assert.NoError(t, err)
+
+	// Cpu of a single node is enough, but only one pod fits in its ephemeral storage.
+	pods := make([]*kube_api.Pod, 0)
+	for _, name := range []string{"p1", "p2"} {
+		pod := BuildTestPod(name, 500, 0)
+		pod.Spec.Containers[0].Resources.Requests[simulator.ResourceEphemeralStorage] = *resource.NewQuantity(6000, resource.BinarySI)
+		pods = append(pods, pod)
+	}
+	scaledUp, err := ScaleUp(context, pods, []*kube_api.Node{}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 2}, scaledGroups)
+}
+
+func TestScaleUpWithObservedTemplateAllocatable(t *testing.T) {
+	n1 := BuildTestNode("n1", 4000, 1000)
+	// n2 registered later and reserves 2000 of the ephemeral storage of its root volume.
+	n2 := BuildTestNode("n2", 4000, 1000)
+	n2.Status.Capacity[simulator.ResourceEphemeralStorage] = *resource.NewQuantity(10000, resource.BinarySI)
+	n2.Status.Allocatable = copyResourceList(n2.Status.Capacity)
+	n2.Status.Allocatable[simulator.ResourceEphemeralStorage] = *resource.NewQuantity(8000, resource.BinarySI)
+
+	scaledGroups := make(map[string]int)
+	provider := &templatedCloudProvider{
+		TestCloudProvider: test.NewTestCloudProvider(func(id string, delta int) error {
+			scaledGroups[id] += delta
+			return nil
+		}, nil),
+		capacity: map[string]kube_api.ResourceList{
+			"ng1": {simulator.ResourceEphemeralStorage: *resource.NewQuantity(10000, resource.BinarySI)},
+		},
+	}
+	provider.AddNodeGroup("ng1", 0, 10, 0)
+	templates := map[string]*kube_api.Node{"ng1": n1}
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(10),
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	pods := make([]*kube_api.Pod, 0)
+	for _, name := range []string{"p1", "p2"} {
+		pod := BuildTestPod(name, 500, 0)
+		pod.Spec.Containers[0].Resources.Requests[simulator.ResourceEphemeralStorage] = *resource.NewQuantity(4500, resource.BinarySI)
+		pods = append(pods, pod)
+	}
+
+	// Without observations the whole declared ephemeral storage is allocatable, both pods fit on a node.
+	nodeInfos, err := GetNodeInfosForGroups([]*kube_api.Node{}, provider, nil, templates, nil)
+	assert.NoError(t, err)
+	scaledUp, err := ScaleUp(context, pods, []*kube_api.Node{}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 1}, scaledGroups)
+
+	// Once n2 is observed, the template of the empty group only has its allocatable ephemeral storage.
+	provider.AddNode("ng1", n2)
+	assert.NoError(t, UpdateNodeTemplates([]*kube_api.Node{n2}, provider, templates))
+	nodeInfos, err = GetNodeInfosForGroups([]*kube_api.Node{}, provider, nil, templates, nil)
+	assert.NoError(t, err)
+	template := nodeInfos["ng1"].Node()
+	capacity := template.Status.Capacity[simulator.ResourceEphemeralStorage]
+	allocatable := template.Status.Allocatable[simulator.ResourceEphemeralStorage]
+	assert.Equal(t, int64(10000), capacity.Value())
+	assert.Equal(t, int64(8000), allocatable.Value())
+
+	scaledGroups = make(map[string]int)
+	scaledUp, err = ScaleUp(context, pods, []*kube_api.Node{}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 2}, scaledGroups)
+}
+
+func TestTemplateAllocatable(t *testing.T) {
+	declared := kube_api.ResourceList{
+		kube_api.ResourcePods:              *resource.NewQuantity(4, resource.DecimalSI),
+		simulator.ResourceEphemeralStorage: *resource.NewQuantity(10000, resource.BinarySI),
+	}
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n1.Status.Allocatable = copyResourceList(n1.Status.Capacity)
+	n1.Status.Capacity[simulator.ResourceEphemeralStorage] = *resource.NewQuantity(20000, resource.BinarySI)
+	n1.Status.Allocatable[simulator.ResourceEphemeralStorage] = *resource.NewQuantity(5000, resource.BinarySI)
+
+	// Pods are not reserved on n1, while more ephemeral storage is reserved than declared.
+	allocatable := templateAllocatable(n1, declared)
+	pods := allocatable[kube_api.ResourcePods]
+	storage := allocatable[simulator.ResourceEphemeralStorage]
+	assert.Equal(t, int64(4), pods.Value())
+	assert.Equal(t, int64(0), storage.Value())
+	declaredStorage := declared[simulator.ResourceEphemeralStorage]
+	assert.Equal(t, int64(10000), declaredStorage.Value())
+}
+
+func TestScaleUpWithTemplateMaxPods(t *testing.T) {
+	n1 := BuildTestNode("n1", 4000, 1000000)
+
+	scaledGroups := make(map[string]int)
+	provider := &templatedCloudProvider{
+		TestCloudProvider: test.NewTestCloudProvider(func(id string, delta int) error {
+			scaledGroups[id] += delta
+			return nil
+		}, nil),
+		capacity: map[string]kube_api.ResourceList{
+			"ng1": {kube_api.ResourcePods: *resource.NewQuantity(4, resource.DecimalSI)},
+		},
+	}
+	provider.AddNodeGroup("ng1", 0, 10, 0)
+	templates := map[string]*kube_api.Node{"ng1": n1}
+
+	context := &AutoscalingContext{
+		CloudProvider:    provider,
+		PredicateChecker: simulator.NewTestPredicateChecker(),
+		Recorder:         kube_record.NewFakeRecorder(20),
+		EstimatorName:    BinpackingEstimatorName,
+	}
+	nodeInfos, err := GetNodeInfosForGroups([]*kube_api.Node{}, provider, nil, templates, nil)
+	assert.NoError(t, err)
+
+	// Cpu and memory of a single node are enough, but only 4 pods fit on a node.
+	pods := make([]*kube_api.Pod, 0)
+	for i := 0; i < 10; i++ {
+		pods = append(pods, BuildTestPod(fmt.Sprintf("p%d", i), 10, 10))
+	}
+	scaledUp, err := ScaleUp(context, pods, []*kube_api.Node{}, nodeInfos)
+	assert.NoError(t, err)
+	assert.True(t, scaledUp)
+	assert.Equal(t, map[string]int{"ng1": 3}, scaledGroups)
+}
+
+func TestGetNodeInfosForGroupsDefaultMaxPods(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	delete(n1.Status.Capacity, kube_api.ResourcePods)
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 0, 10, 0)
+
+	nodeInfos, err := GetNodeInfosForGroups([]*kube_api.Node{}, provider, nil, map[string]*kube_api.Node{"ng1": n1}, nil)
+	assert.NoError(t, err)
+	pods := simulator.NodeAllocatable(nodeInfos["ng1"].Node())[kube_api.ResourcePods]
+	assert.Equal(t, int64(DefaultMaxPods), pods.Value())
+	_, found := n1.Status.Capacity[kube_api.ResourcePods]
+	assert.False(t, found)
+}
+
+func TestGetNodeInfosForGroupsWithoutSample(t *testing.T) {
+	provider := &templatedCloudProvider{
+		TestCloudProvider: test.NewTestCloudProvider(nil, nil),
+		labels:            map[string]map[string]string{"ng1": {"pool": "gpu"}},
+		taints: map[string][]kube_api.Taint{
+			"ng1": {{Key: "dedicated", Value: "gpu", Effect: kube_api.TaintEffectNoSchedule}},
+		},
+		capacity: map[string]kube_api.ResourceList{
+			"ng1": {
+				kube_api.ResourceCPU:    *resource.NewQuantity(2, resource.DecimalSI),
+				kube_api.ResourceMemory: *resource.NewQuantity(8*1024*1024*1024, resource.BinarySI),
+			},
+			// Without the memory of its nodes no pods can be fitted on a template of ng2.
+			"ng2": {kube_api.ResourceCPU: *resource.NewQuantity(2, resource.DecimalSI)},
+		},
+	}
+	provider.AddNodeGroup("ng1", 0, 10, 0)
+	provider.AddNodeGroup("ng2", 0, 10, 0)
+
+	// No node of the node groups was sampled, e.g. after a restart.
+	nodeInfos, err := GetNodeInfosForGroups([]*kube_api.Node{}, provider, nil, map[string]*kube_api.Node{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, nodeInfos["ng2"])
+	template := nodeInfos["ng1"].Node()
+	assert.Equal(t, "gpu", template.Labels["pool"])
+	pods := simulator.NodeAllocatable(template)[kube_api.ResourcePods]
+	assert.Equal(t, int64(DefaultMaxPods), pods.Value())
+
+	predicateChecker := simulator.NewTestPredicateChecker()
+	p1 := BuildTestPod("p1", 1500, 4*1024*1024*1024)
+	p1.Spec.NodeSelector = map[string]string{"pool": "gpu"}
+	p1.Annotations = map[string]string{
+		kube_api.TolerationsAnnotationKey: `[{"key": "dedicated", "operator": "Equal", "value": "gpu", "effect": "NoSchedule"}]`,
+	}
+	assert.NoError(t, predicateChecker.CheckPredicates(p1, nodeInfos["ng1"]))
+	p2 := BuildTestPod("p2", 1500, 0)
+	p2.Spec.NodeSelector = map[string]string{"pool": "gpu"}
+	assert.Error(t, predicateChecker.CheckPredicates(p2, nodeInfos["ng1"]))
+	p3 := BuildTestPod("p3", 2500, 0)
+	p3.Annotations = p1.Annotations
+	assert.Error(t, predicateChecker.CheckPredicates(p3, nodeInfos["ng1"]))
+}
+
+func TestApplyNodeGroupTemplateKeepsSampledCpuAndMemory(t *testing.T) {
+	n1 := BuildTestNode("n1", 1000, 1000)
+	nodeGroup := &templatedNodeGroup{
+		capacity: kube_api.ResourceList{
+			kube_api.ResourceCPU:    *resource.NewQuantity(2, resource.DecimalSI),
+			kube_api.ResourceMemory: *resource.NewQuantity(2000, resource.BinarySI),
+			kube_api.ResourcePods:   *resource.NewQuantity(17, resource.DecimalSI),
+		},
+	}
+
+	template, err := applyNodeGroupTemplate(n1, nodeGroup)
+	assert.NoError(t, err)
+	cpu := template.Status.Capacity[kube_api.ResourceCPU]
+	assert.Equal(t, int64(1000), cpu.MilliValue())
+	memory := template.Status.Capacity[kube_api.ResourceMemory]
+	assert.Equal(t, int64(1000), memory.Value())
+	pods := template.Status.Capacity[kube_api.ResourcePods]
+	assert.Equal(t, int64(17), pods.Value())
+}
+
+func TestFilterOutNodesWithUnreadyGpus(t *testing.T) {
+	// n1 is a GPU node whose device plugin hasn't registered the GPUs yet.
+	n1 := BuildTestNode("n1", 1000, 1000)
+	n1.Labels = map[string]string{"accelerator": "nvidia-tesla-k80"}
+	n2 := BuildTestNode("n2", 1000, 1000)
+	n2.Labels = map[string]string{"accelerator": "nvidia-tesla-k80"}
+	n2.Status.Allocatable[ResourceGPU] = *resource.NewQuantity(1, resource.DecimalSI)
+	n3 := BuildTestNode("n3", 1000, 1000)
+	n3.Labels = map[string]string{"accelerator": "nvidia-tesla-k80"}
+	n3.Status.Allocatable[kube_api.ResourceNvidiaGPU] = *resource.NewQuantity(2, resource.DecimalSI)
+	n4 := BuildTestNode("n4", 1000, 1000)
+	nodes := []*kube_api.Node{n1, n2, n3, n4}
+
+	ready, unready := FilterOutNodesWithUnreadyGpus(nodes, "")
+	assert.Equal(t, nodes, ready)
+	assert.Empty(t, unready)
+
+	ready, unready = FilterOutNodesWithUnreadyGpus(nodes, "accelerator")
+	assert.Equal(t, []*kube_api.Node{n2, n3, n4}, ready)
+	assert.Equal(t, []*kube_api.Node{n1}, unready)
+
+	// The node group of n1 is not ready for scale up until the GPUs show up.
+	provider := test.NewTestCloudProvider(nil, nil)
+	provider.AddNodeGroup("ng1", 1, 10, 1)
+	provider.AddNode("ng1", n1)
+	unreadyGroups, err := CheckGroupsAndNodes(ready, provider)
+	assert.NoError(t, err)
+	assert.True(t, unreadyGroups["ng1"])
+}